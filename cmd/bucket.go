@@ -0,0 +1,162 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mgit-at/arti/store"
+
+	"github.com/spf13/cobra"
+)
+
+var bucketCmd = &cobra.Command{
+	Use:   "bucket",
+	Short: "manage the buckets of S3 stores",
+	Long: `Manages buckets explicitly instead of having them created by the first
+upload. New buckets get the bucket-policy and versioning of the store.`,
+}
+
+var bucketListCmd = &cobra.Command{
+	Use:     "ls <store>",
+	Aliases: []string{"list"},
+	Short:   "list the buckets of a store",
+	Run:     bucketListRun,
+}
+
+var bucketCreateCmd = &cobra.Command{
+	Use:   "create <store>/<bucket>",
+	Short: "create a bucket and apply bucket-policy and versioning of the store",
+	Run:   bucketCreateRun,
+}
+
+var bucketDeleteCmd = &cobra.Command{
+	Use:     "delete <store>/<bucket>",
+	Aliases: []string{"del"},
+	Short:   "delete an empty bucket",
+	Run:     bucketDeleteRun,
+}
+
+var bucketInfoCmd = &cobra.Command{
+	Use:   "info <store>/<bucket>",
+	Short: "show location, policy and versioning of a bucket",
+	Run:   bucketInfoRun,
+}
+
+var bucketPolicyCmd = &cobra.Command{
+	Use:   "policy <store>/<bucket> <" + strings.Join(store.BucketPolicies(), "|") + ">",
+	Short: "set the policy of a bucket",
+	Run:   bucketPolicyRun,
+}
+
+var bucketVersioningCmd = &cobra.Command{
+	Use:   "versioning <store>/<bucket> <on|off>",
+	Short: "enable or suspend versioning of a bucket",
+	Run:   bucketVersioningRun,
+}
+
+var bucketDeleteForce bool
+
+func init() {
+	RootCmd.AddCommand(bucketCmd)
+	bucketCmd.AddCommand(bucketListCmd)
+	bucketCmd.AddCommand(bucketCreateCmd)
+	bucketCmd.AddCommand(bucketDeleteCmd)
+	bucketCmd.AddCommand(bucketInfoCmd)
+	bucketCmd.AddCommand(bucketPolicyCmd)
+	bucketCmd.AddCommand(bucketVersioningCmd)
+
+	bucketDeleteCmd.Flags().BoolVarP(&bucketDeleteForce, "force", "f", false, "delete all files of the bucket, including all versions, as well")
+}
+
+// selectBucket opens the store and returns its bucket manager.
+func selectBucket(cmd *cobra.Command, args []string, nargs int) store.BucketManager {
+	if len(args) != nargs {
+		cmd.Help()
+		os.Exit(1)
+	}
+	s := store.Backend(selectStore(args[0]))
+	bm, ok := s.(store.BucketManager)
+	if !ok {
+		log.Fatalf("the store %s does not support managing buckets", args[0])
+	}
+	return bm
+}
+
+func bucketListRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	buckets, err := store.ListBuckets(storesFor(args[0]), args[0])
+	if err != nil {
+		log.Fatalln("listing buckets failed:", err)
+	}
+	for _, b := range buckets {
+		log.Printf("%s\t%s", b.Created.Format("2006-01-02 15:04:05"), b.Name)
+	}
+}
+
+func bucketCreateRun(cmd *cobra.Command, args []string) {
+	if err := selectBucket(cmd, args, 1).CreateBucket(); err != nil {
+		log.Fatalln("creating bucket failed:", err)
+	}
+	log.Printf("successfully created bucket '%s'", args[0])
+}
+
+func bucketDeleteRun(cmd *cobra.Command, args []string) {
+	if err := selectBucket(cmd, args, 1).DeleteBucket(bucketDeleteForce); err != nil {
+		log.Fatalln("deleting bucket failed:", err)
+	}
+	log.Printf("successfully deleted bucket '%s'", args[0])
+}
+
+func bucketInfoRun(cmd *cobra.Command, args []string) {
+	info, err := selectBucket(cmd, args, 1).BucketInfo()
+	if err != nil {
+		log.Fatalln("fetching bucket info failed:", err)
+	}
+	keys := []string{}
+	for k := range info {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		log.Printf("%s\t%s", k, info[k])
+	}
+}
+
+func bucketPolicyRun(cmd *cobra.Command, args []string) {
+	bm := selectBucket(cmd, args, 2)
+	if err := bm.SetBucketPolicy(strings.ToLower(args[1])); err != nil {
+		log.Fatalln("setting bucket policy failed:", err)
+	}
+	log.Printf("bucket '%s' is %s now", args[0], strings.ToLower(args[1]))
+}
+
+func bucketVersioningRun(cmd *cobra.Command, args []string) {
+	if len(args) == 2 && args[1] != "on" && args[1] != "off" {
+		cmd.Help()
+		os.Exit(1)
+	}
+	bm := selectBucket(cmd, args, 2)
+	if err := bm.SetBucketVersioning(args[1] == "on"); err != nil {
+		log.Fatalln("setting bucket versioning failed:", err)
+	}
+	log.Printf("versioning of bucket '%s' is %s now", args[0], map[string]string{"on": "enabled", "off": "suspended"}[args[1]])
+}
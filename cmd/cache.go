@@ -0,0 +1,158 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "manage the local cache of downloaded artifacts",
+	Long: `Stores configured with a cache section keep downloaded artifacts in a local
+directory, by default $XDG_CACHE_HOME/arti or ~/.cache/arti. Files are kept by
+their checksum, so artifacts with the same content are cached only once.`,
+}
+
+var cacheLsCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "list the cached files, the most recently used first",
+	Run:     cacheLsRun,
+}
+
+var cacheCleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "remove cached files",
+	Long: `Removes all cached files or, if --max-size is given, the least recently used
+ones until the cache is no larger than that.`,
+	Run: cacheCleanRun,
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "check the checksums of all cached files and remove corrupted ones",
+	Run:   cacheVerifyRun,
+}
+
+var (
+	cacheDir     string
+	cacheMaxSize string
+)
+
+func init() {
+	RootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheLsCmd)
+	cacheCmd.AddCommand(cacheCleanCmd)
+	cacheCmd.AddCommand(cacheVerifyCmd)
+
+	cacheCmd.PersistentFlags().StringVar(&cacheDir, "dir", store.DefaultCacheDir(), "the cache directory")
+	cacheCleanCmd.Flags().StringVar(&cacheMaxSize, "max-size", "", "only remove files until the cache is no larger than this (e.g. 10GB)")
+}
+
+// parseSize parses sizes like 512, 100kB, 10MB or 2GB using binary units.
+func parseSize(s string) (int64, error) {
+	units := []string{"K", "M", "G", "T", "P"}
+	n := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	n = strings.TrimSuffix(n, "I")
+	mult := int64(1)
+	for i, u := range units {
+		if strings.HasSuffix(n, u) {
+			n = strings.TrimSuffix(n, u)
+			mult = 1 << (10 * uint(i+1))
+			break
+		}
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return size * mult, nil
+}
+
+func formatSize(size int64) string {
+	if size == 0 {
+		return "0B"
+	}
+	s, mult := humanizeBytes(size)
+	return fmt.Sprintf("%.1f%sB", s, mult)
+}
+
+func cacheCheckArgs(cmd *cobra.Command, args []string) *store.Cache {
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	return &store.Cache{Dir: cacheDir}
+}
+
+func cacheLsRun(cmd *cobra.Command, args []string) {
+	c := cacheCheckArgs(cmd, args)
+
+	entries, err := c.Entries()
+	if err != nil {
+		log.Fatalln("listing cache failed:", err)
+	}
+	var total int64
+	for _, e := range entries {
+		log.Printf("%s %8s %s", e.LastUsed.Format("2006-01-02 15:04:05"), formatSize(e.Size), e.CSum)
+		total += e.Size
+	}
+	log.Printf("%d files, %s in %s", len(entries), formatSize(total), c.Dir)
+}
+
+func cacheCleanRun(cmd *cobra.Command, args []string) {
+	c := cacheCheckArgs(cmd, args)
+
+	var maxSize int64
+	if cacheMaxSize != "" {
+		var err error
+		if maxSize, err = parseSize(cacheMaxSize); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	removed, err := c.Evict(maxSize)
+	var total int64
+	for _, e := range removed {
+		total += e.Size
+	}
+	log.Printf("removed %d files, %s", len(removed), formatSize(total))
+	if err != nil {
+		log.Fatalln("cleaning cache failed:", err)
+	}
+}
+
+func cacheVerifyRun(cmd *cobra.Command, args []string) {
+	c := cacheCheckArgs(cmd, args)
+
+	corrupted, err := c.Verify()
+	for _, e := range corrupted {
+		log.Printf("removed corrupted file %s", e.CSum)
+	}
+	if err != nil {
+		log.Fatalln("verifying cache failed:", err)
+	}
+	if len(corrupted) > 0 {
+		log.Fatalf("%d corrupted files found", len(corrupted))
+	}
+	log.Println("all files are valid")
+}
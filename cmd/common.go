@@ -17,8 +17,8 @@ package cmd
 import (
 	"log"
 	"math"
-	"strings"
 
+	"github.com/mgit-at/arti/repo"
 	"github.com/mgit-at/arti/store"
 
 	"github.com/spf13/viper"
@@ -38,25 +38,34 @@ var (
 	artifactVersion string
 )
 
-func selectStore(nameAndPath string) store.Store {
+// configuredStores returns the stores section of the config file.
+func configuredStores() *viper.Viper {
 	stores := viper.Sub("stores")
 	if stores == nil {
+		if cfgFileErr != nil {
+			log.Fatalln("reading config file:", cfgFileErr)
+		}
 		log.Fatal("no stores specified!")
 	}
+	return stores
+}
 
-	np := strings.SplitN(nameAndPath, "/", 2)
-	if len(np) < 2 {
-		np = append(np, "")
-	}
-	cfg := stores.Sub(np[0])
-	if cfg == nil {
-		log.Fatalln("no such store:", np[0])
+// storesFor returns the configured stores needed to open nameAndPath.
+func storesFor(nameAndPath string) *viper.Viper {
+	// URLs don't need a config file
+	if store.IsURL(nameAndPath) {
+		return viper.Sub("stores")
 	}
+	return configuredStores()
+}
 
-	cfg.SetEnvPrefix("arti_stores__" + np[0] + "_")
-	cfg.SetEnvKeyReplacer(strings.NewReplacer(".", "__", "-", "_"))
-	cfg.AutomaticEnv()
-	s, err := store.NewStore(cfg, np[1])
+// openStore opens the store named by nameAndPath, which may be a URL.
+func openStore(nameAndPath string) (store.Store, error) {
+	return store.Open(storesFor(nameAndPath), nameAndPath)
+}
+
+func selectStore(nameAndPath string) store.Store {
+	s, err := openStore(nameAndPath)
 	if err != nil {
 		log.Fatalln("unable to initialize store:", err)
 	}
@@ -64,6 +73,22 @@ func selectStore(nameAndPath string) store.Store {
 	return s
 }
 
+// repoSigner loads the key used to sign the metadata of package
+// repositories. If keyFile is empty <section>.sign-key is used.
+func repoSigner(keyFile, section string) *repo.Signer {
+	if keyFile == "" {
+		keyFile = viper.GetString(section + ".sign-key")
+	}
+	if keyFile == "" {
+		return nil
+	}
+	s, err := repo.LoadSigner(keyFile, viper.GetString(section+".sign-passphrase"))
+	if err != nil {
+		log.Fatalln("loading signing key failed:", err)
+	}
+	return s
+}
+
 func logn(n, b float64) float64 {
 	return math.Log(n) / math.Log(b)
 }
@@ -0,0 +1,372 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "manage the stores of the config file",
+	Long: `Adds, removes and shows stores of the config file. Changes are written back
+to the file arti loaded, keeping its format (YAML, TOML or JSON). Comments of
+the file are lost.`,
+}
+
+var configAddStoreCmd = &cobra.Command{
+	Use:   "add-store <name> [<key>=<value>...]",
+	Short: "add a store to the config file",
+	Long: `Adds a store with the given settings. Nested settings are written as
+cache.max-size=10GB, settings given more than once become lists, e.g.
+members=a members=b. Secrets should be references like env:VAR, file:/path
+or exec:command rather than the secret itself.`,
+	Example: `  arti config add-store minio --type s3 endpoint=127.0.0.1:9000 access-key-id=arti secret-access-key=env:MINIO_SECRET`,
+	Run:     configAddStoreRun,
+}
+
+var configRemoveStoreCmd = &cobra.Command{
+	Use:   "remove-store <name>",
+	Short: "remove a store from the config file",
+	Run:   configRemoveStoreRun,
+}
+
+var configListStoresCmd = &cobra.Command{
+	Use:   "list-stores",
+	Short: "list the configured stores, secrets are masked",
+	Run:   configListStoresRun,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show <store>",
+	Short: "show the settings of a store including environment overrides",
+	Run:   configShowRun,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [<store>[/<bucket>]...]",
+	Short: "check the settings of all or the given stores",
+	Run:   configValidateRun,
+}
+
+var (
+	configStoreType   string
+	configForce       bool
+	configShowSecrets bool
+)
+
+func init() {
+	RootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configAddStoreCmd)
+	configCmd.AddCommand(configRemoveStoreCmd)
+	configCmd.AddCommand(configListStoresCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+
+	configAddStoreCmd.Flags().StringVarP(&configStoreType, "type", "t", "", "the type of the store ("+strings.Join(store.Types(), ", ")+")")
+	configAddStoreCmd.Flags().BoolVarP(&configForce, "force", "f", false, "replace an existing store and write invalid settings")
+	configRemoveStoreCmd.Flags().BoolVarP(&configForce, "force", "f", false, "remove the store even if other stores use it")
+	configShowCmd.Flags().BoolVar(&configShowSecrets, "show-secrets", false, "don't mask secrets")
+}
+
+// isSecretKey guesses whether a setting holds a secret.
+func isSecretKey(key string) bool {
+	k := strings.ToLower(key[strings.LastIndex(key, ".")+1:])
+	for _, suffix := range []string{"-env", "-file", "-files", "-id"} {
+		if strings.HasSuffix(k, suffix) {
+			return false
+		}
+	}
+	for _, s := range []string{"secret", "password", "token", "passphrase"} {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return k == "key" || k == "keys" || strings.HasSuffix(k, "-key") || strings.HasSuffix(k, "-keys")
+}
+
+// formatSetting formats a value for display. Secrets and passwords within
+// URLs are masked unless secrets are references like env:VAR.
+func formatSetting(key string, value interface{}, showSecrets bool) string {
+	format := func(v interface{}) string {
+		if showSecrets {
+			return fmt.Sprint(v)
+		}
+		return store.MaskPassword(fmt.Sprint(v))
+	}
+	s := format(value)
+	if l, ok := value.([]interface{}); ok {
+		parts := make([]string, len(l))
+		for i, e := range l {
+			parts[i] = format(e)
+		}
+		s = "[" + strings.Join(parts, ", ") + "]"
+	}
+	if showSecrets || !isSecretKey(key) {
+		return s
+	}
+	for _, prefix := range []string{"env:", "file:", "exec:"} {
+		if strings.HasPrefix(s, prefix) {
+			return s
+		}
+	}
+	return "********"
+}
+
+// storeNames returns the sorted names of the configured stores.
+func storeNames(stores *viper.Viper) []string {
+	names := []string{}
+	if stores == nil {
+		return names
+	}
+	for name := range stores.AllSettings() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupKey returns the key of m matching name case insensitively, as
+// viper does.
+func lookupKey(m map[string]interface{}, name string) (string, bool) {
+	for k := range m {
+		if strings.ToLower(k) == strings.ToLower(name) {
+			return k, true
+		}
+	}
+	return name, false
+}
+
+func parseSettingValue(s string) interface{} {
+	if s == "true" || s == "false" {
+		return s == "true"
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return s
+}
+
+// parseSettings converts key=value arguments to the settings of a store.
+func parseSettings(args []string) (map[string]interface{}, error) {
+	settings := make(map[string]interface{})
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid setting '%s', expected <key>=<value>", arg)
+		}
+		path := strings.Split(strings.ToLower(kv[0]), ".")
+		m := settings
+		for _, p := range path[:len(path)-1] {
+			switch sub := m[p].(type) {
+			case nil:
+				n := make(map[string]interface{})
+				m[p] = n
+				m = n
+			case map[string]interface{}:
+				m = sub
+			default:
+				return nil, fmt.Errorf("setting %s conflicts with %s", kv[0], p)
+			}
+		}
+		key, value := path[len(path)-1], parseSettingValue(kv[1])
+		switch old := m[key].(type) {
+		case nil:
+			m[key] = value
+		case []interface{}:
+			m[key] = append(old, value)
+		case map[string]interface{}:
+			return nil, fmt.Errorf("setting %s conflicts with %s.*", kv[0], kv[0])
+		default:
+			m[key] = []interface{}{old, value}
+		}
+	}
+	return settings, nil
+}
+
+// configStores returns the stores section of the settings of a config file.
+func configStores(m map[string]interface{}) (map[string]interface{}, error) {
+	key, found := lookupKey(m, "stores")
+	if !found {
+		stores := make(map[string]interface{})
+		m[key] = stores
+		return stores, nil
+	}
+	stores, ok := m[key].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("stores is not a section")
+	}
+	return stores, nil
+}
+
+func configAddStoreRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 || configStoreType == "" {
+		cmd.Help()
+		os.Exit(1)
+	}
+	name := args[0]
+	if strings.ContainsAny(name, "/.") || store.IsURL(name) {
+		log.Fatalf("invalid store name '%s'", name)
+	}
+	settings, err := parseSettings(args[1:])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	settings["type"] = configStoreType
+
+	fn := configFileName()
+	m, err := readConfigFile(fn)
+	if err != nil {
+		log.Fatalln("reading config file failed:", err)
+	}
+	stores, err := configStores(m)
+	if err != nil {
+		log.Fatalln("reading config file failed:", err)
+	}
+	key, exists := lookupKey(stores, name)
+	if exists && !configForce {
+		log.Fatalf("store %s exists already, use --force to replace it", name)
+	}
+	stores[key] = settings
+
+	v := viper.New()
+	for n, s := range stores {
+		v.Set(n, s)
+	}
+	if problems := store.Validate(v, key, ""); len(problems) > 0 {
+		for _, p := range problems {
+			log.Printf("%s: %v", name, p)
+		}
+		if !configForce {
+			log.Fatalln("not adding invalid store, use --force to add it anyway")
+		}
+	}
+
+	if err := writeConfigFile(fn, m); err != nil {
+		log.Fatalln("writing config file failed:", err)
+	}
+	log.Printf("added store %s to %s", name, fn)
+}
+
+func configRemoveStoreRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	name := args[0]
+
+	fn := configFileName()
+	m, err := readConfigFile(fn)
+	if err != nil {
+		log.Fatalln("reading config file failed:", err)
+	}
+	stores, err := configStores(m)
+	if err != nil {
+		log.Fatalln("reading config file failed:", err)
+	}
+	key, exists := lookupKey(stores, name)
+	if !exists {
+		log.Fatalf("no such store: %s", name)
+	}
+
+	for other, s := range stores {
+		settings, ok := s.(map[string]interface{})
+		if !ok || other == key {
+			continue
+		}
+		membersKey, _ := lookupKey(settings, "members")
+		members, _ := settings[membersKey].([]interface{})
+		for _, member := range members {
+			mn := strings.SplitN(fmt.Sprint(member), "/", 2)[0]
+			if strings.ToLower(mn) == strings.ToLower(name) && !configForce {
+				log.Fatalf("store %s is a member of %s, use --force to remove it anyway", name, other)
+			}
+		}
+	}
+
+	delete(stores, key)
+	if err := writeConfigFile(fn, m); err != nil {
+		log.Fatalln("writing config file failed:", err)
+	}
+	log.Printf("removed store %s from %s", name, fn)
+}
+
+func configListStoresRun(cmd *cobra.Command, args []string) {
+	stores := viper.Sub("stores")
+	for _, name := range storeNames(stores) {
+		cfg := store.Config(stores, name)
+		keys := cfg.AllKeys()
+		sort.Strings(keys)
+		settings := []string{}
+		for _, k := range keys {
+			if k != "type" {
+				settings = append(settings, k+"="+formatSetting(k, cfg.Get(k), false))
+			}
+		}
+		log.Printf("%s\t%s\t%s", name, strings.ToLower(cfg.GetString("type")), strings.Join(settings, " "))
+	}
+}
+
+func configShowRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	stores := configuredStores()
+	cfg := store.Config(stores, args[0])
+	if cfg == nil {
+		log.Fatalf("no such store: %s", args[0])
+	}
+	keys := cfg.AllKeys()
+	sort.Strings(keys)
+	for _, k := range keys {
+		log.Printf("%s\t%s", k, formatSetting(k, cfg.Get(k), configShowSecrets))
+	}
+}
+
+func configValidateRun(cmd *cobra.Command, args []string) {
+	stores := configuredStores()
+	if len(args) == 0 {
+		args = storeNames(stores)
+	}
+
+	failed := 0
+	for _, snp := range args {
+		np := strings.SplitN(snp, "/", 2)
+		if len(np) < 2 {
+			np = append(np, "")
+		}
+		problems := store.Validate(stores, np[0], np[1])
+		for _, p := range problems {
+			log.Printf("%s: %v", snp, p)
+		}
+		if len(problems) > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d stores are invalid", failed, len(args))
+	}
+	log.Printf("all %d stores are valid", len(args))
+}
@@ -0,0 +1,153 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// configFileName returns the config file viper loaded or, if there is
+// none, the one given by --config or $HOME/.arti.toml.
+func configFileName() string {
+	if fn := viper.ConfigFileUsed(); fn != "" {
+		return fn
+	}
+	if cfgFile != "" {
+		return cfgFile
+	}
+	return filepath.Join(os.Getenv("HOME"), ".arti.toml")
+}
+
+func configFormat(filename string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if ext == "yml" {
+		return "yaml"
+	}
+	return ext
+}
+
+// readConfigFile reads the settings of a config file without applying
+// defaults or environment variables. A missing file has no settings.
+func readConfigFile(filename string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return make(map[string]interface{}), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	switch f := configFormat(filename); f {
+	case "yaml":
+		err = yaml.Unmarshal(data, &m)
+	case "json":
+		err = json.Unmarshal(data, &m)
+	case "toml":
+		var tree *toml.TomlTree
+		if tree, err = toml.Load(string(data)); err == nil {
+			m = tree.ToMap()
+		}
+	default:
+		return nil, fmt.Errorf("modifying %s config files is not supported", f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", filename, err)
+	}
+	if m == nil {
+		m = make(map[string]interface{})
+	}
+	return normalizeConfig(m).(map[string]interface{}), nil
+}
+
+// normalizeConfig converts the maps decoded from YAML to maps with string
+// keys, which all encoders support.
+func normalizeConfig(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalizeConfig(e)
+		}
+		return m
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = normalizeConfig(e)
+		}
+		return v
+	case []interface{}:
+		for i, e := range v {
+			v[i] = normalizeConfig(e)
+		}
+		return v
+	}
+	return v
+}
+
+// writeConfigFile writes the settings in the format of the file. Existing
+// files keep their permissions, new ones are only readable by the user as
+// they may contain secrets.
+func writeConfigFile(filename string, m map[string]interface{}) error {
+	var data []byte
+	var err error
+	switch f := configFormat(filename); f {
+	case "yaml":
+		data, err = yaml.Marshal(m)
+	case "json":
+		if data, err = json.MarshalIndent(m, "", "  "); err == nil {
+			data = append(data, '\n')
+		}
+	case "toml":
+		data = []byte(toml.TreeFromMap(m).ToString())
+	default:
+		return fmt.Errorf("modifying %s config files is not supported", f)
+	}
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".arti-config-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Error writing %s: %v", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/repo/deb"
+	"github.com/spf13/cobra"
+)
+
+var debCmd = &cobra.Command{
+	Use:   "deb",
+	Short: "maintain APT repositories",
+	Long: `This maintains a debian package repository inside a store which can be
+used as APT source, e.g.:
+
+  deb https://<bucket>.s3.amazonaws.com/ stable main
+
+If a signing key is given, InRelease and Release.gpg are created. The
+passphrase of the key is read from the config (deb.sign-passphrase) or the
+environment variable ARTI_DEB__SIGN_PASSPHRASE.`,
+}
+
+var debUploadCmd = &cobra.Command{
+	Use:   "upload <store>/<bucket> <file.deb> [ <file.deb> ... ]",
+	Short: "add packages to an APT repository",
+	Run:   debUploadRun,
+}
+
+var debRemoveCmd = &cobra.Command{
+	Use:     "remove <store>/<bucket> <package> [ <version> ]",
+	Aliases: []string{"rm"},
+	Short:   "remove packages from an APT repository",
+	Run:     debRemoveRun,
+}
+
+var (
+	debCodename    string
+	debComponent   string
+	debArchs       []string
+	debOrigin      string
+	debLabel       string
+	debSignKey     string
+	debRemoveArch  string
+	debRemovePurge bool
+)
+
+func init() {
+	RootCmd.AddCommand(debCmd)
+	debCmd.AddCommand(debUploadCmd)
+	debCmd.AddCommand(debRemoveCmd)
+
+	debCmd.PersistentFlags().StringVar(&debCodename, "codename", "stable", "the codename of the distribution")
+	debCmd.PersistentFlags().StringVar(&debComponent, "component", "main", "the component of the distribution")
+	debCmd.PersistentFlags().StringSliceVar(&debArchs, "arch", nil, "architectures to publish, in addition to the existing ones")
+	debCmd.PersistentFlags().StringVar(&debOrigin, "origin", "", "the origin written to the Release file")
+	debCmd.PersistentFlags().StringVar(&debLabel, "label", "", "the label written to the Release file")
+	debCmd.PersistentFlags().StringVar(&debSignKey, "sign-key", "", "OpenPGP secret key used to sign the Release file (default deb.sign-key from config)")
+
+	debRemoveCmd.Flags().StringVar(&debRemoveArch, "only-arch", "", "only remove packages of this architecture")
+	debRemoveCmd.Flags().BoolVar(&debRemovePurge, "purge", false, "also delete package files no longer referenced")
+}
+
+func debRepository(snp string) *deb.Repository {
+	objects, err := repo.ObjectStore(selectStore(snp))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	r := &deb.Repository{
+		Store:         objects,
+		Codename:      debCodename,
+		Component:     debComponent,
+		Architectures: debArchs,
+		Origin:        debOrigin,
+		Label:         debLabel,
+	}
+	r.Signer = repoSigner(debSignKey, "deb")
+	return r
+}
+
+func debUploadRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		cmd.Help()
+		os.Exit(1)
+	}
+
+	r := debRepository(args[0])
+	if err := r.Add(args[1:]...); err != nil {
+		log.Fatalln("upload failed:", err)
+	}
+}
+
+func debRemoveRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 || len(args) > 3 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	version := ""
+	if len(args) == 3 {
+		version = args[2]
+	}
+
+	r := debRepository(args[0])
+	removed, err := r.Remove(args[1], version, debRemoveArch, debRemovePurge)
+	if err != nil {
+		log.Fatalln("removal failed:", err)
+	}
+	if len(removed) == 0 {
+		log.Fatalln("no such package:", args[1])
+	}
+	for _, p := range removed {
+		log.Printf("removed %s %s (%s)", p.Name(), p.Version(), p.Architecture())
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor <store>/<bucket>",
+	Short: "check connectivity, credentials and permissions of a store",
+	Long: `Checks whether the store works: the configuration, DNS and TLS of the
+server, the clock, the credentials, the bucket and finally the permissions
+to list, upload, download and delete using a scratch file.`,
+	Run: doctorRun,
+}
+
+func init() {
+	RootCmd.AddCommand(doctorCmd)
+}
+
+func doctorRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		cmd.Help()
+		os.Exit(1)
+	}
+
+	var results []store.CheckResult
+	if !store.IsURL(args[0]) {
+		np := strings.SplitN(args[0], "/", 2)
+		if len(np) < 2 {
+			np = append(np, "")
+		}
+		problems := store.Validate(configuredStores(), np[0], np[1])
+		for _, p := range problems {
+			results = append(results, store.CheckResult{Name: "configuration", Status: store.CheckFailed, Detail: p.Error()})
+		}
+		if len(problems) == 0 {
+			results = append(results, store.CheckResult{Name: "configuration", Status: store.CheckPassed, Detail: "valid"})
+		}
+	}
+
+	s, err := openStore(args[0])
+	if err != nil {
+		results = append(results, store.CheckResult{Name: "open", Status: store.CheckFailed, Detail: err.Error()})
+	} else {
+		results = append(results, store.Diagnose(s)...)
+	}
+
+	failed := 0
+	for _, r := range results {
+		log.Printf("%s\t%s\t%s", r.Status, r.Name, r.Detail)
+		if r.Status == store.CheckFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d checks failed", failed, len(results))
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/mgit-at/arti/repo/goproxy"
+	"github.com/spf13/cobra"
+)
+
+var goCmd = &cobra.Command{
+	Use:   "go",
+	Short: "store Go modules",
+	Long: `Go modules are stored as artifacts named after the module path, the
+only file of a version is the module zip. go.mod and the version info are
+kept next to it if the store supports it. arti serve answers GOPROXY requests
+for these artifacts, e.g.:
+
+  GOPROXY=http://localhost:8080/<store>/<bucket>,https://proxy.golang.org`,
+}
+
+var goUploadCmd = &cobra.Command{
+	Use:   "upload <store>/<bucket> [ <directory> ]",
+	Short: "create the zip of a module and upload it",
+	Long: `This creates the module zip of the source tree in directory (default the
+current directory) the way the go command expects it and uploads it.
+The module path is read from go.mod unless --name is given.`,
+	Run: goUploadRun,
+}
+
+func init() {
+	RootCmd.AddCommand(goCmd)
+	goCmd.AddCommand(goUploadCmd)
+
+	goUploadCmd.Flags().StringVarP(&artifactName, "name", "n", "", "the module path (default from go.mod)")
+	goUploadCmd.Flags().StringVarP(&artifactVersion, "version", "v", "", "the version of the module, e.g. v1.2.3")
+	goUploadCmd.MarkFlagRequired("version")
+}
+
+func goUploadRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	if artifactVersion == "" {
+		log.Println("please specifiy the module version")
+		cmd.Help()
+		os.Exit(1)
+	}
+	dir := "."
+	if len(args) == 2 {
+		dir = args[1]
+	}
+
+	module := artifactName
+	if module == "" {
+		var err error
+		if module, err = goproxy.ModulePath(dir); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	s := selectStore(args[0])
+	if err := goproxy.Upload(s, module, artifactVersion, dir); err != nil {
+		log.Fatalln("upload failed:", err)
+	}
+	log.Printf("uploaded %s@%s", module, artifactVersion)
+}
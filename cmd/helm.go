@@ -0,0 +1,105 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/mgit-at/arti/repo/helm"
+	"github.com/spf13/cobra"
+)
+
+var helmCmd = &cobra.Command{
+	Use:   "helm",
+	Short: "maintain Helm chart repositories",
+	Long: `Packaged charts are stored as artifacts using the name and version of
+their Chart.yaml. The index.yaml at the root of the bucket is updated on
+every push and delete, so the bucket (or arti serve) may be added using
+
+  helm repo add <name> <url of the bucket>`,
+}
+
+var helmPushCmd = &cobra.Command{
+	Use:   "push <store>/<bucket> <chart.tgz> [ <chart.tgz> ... ]",
+	Short: "upload charts and add them to the index",
+	Run:   helmPushRun,
+}
+
+var helmDeleteCmd = &cobra.Command{
+	Use:     "delete <store>/<bucket>",
+	Aliases: []string{"del"},
+	Short:   "delete a chart and remove it from the index",
+	Run:     helmDeleteRun,
+}
+
+var helmReindexCmd = &cobra.Command{
+	Use:   "reindex <store>/<bucket>",
+	Short: "recreate the index from all charts in the store",
+	Run:   helmReindexRun,
+}
+
+var (
+	helmBaseURL string
+)
+
+func init() {
+	RootCmd.AddCommand(helmCmd)
+	helmCmd.AddCommand(helmPushCmd)
+	helmCmd.AddCommand(helmDeleteCmd)
+	helmCmd.AddCommand(helmReindexCmd)
+
+	helmCmd.PersistentFlags().StringVar(&helmBaseURL, "url", "", "absolute URL of the repository used for the chart URLs (default relative URLs)")
+
+	helmDeleteCmd.Flags().StringVarP(&artifactName, "name", "n", "", "the name of the chart")
+	helmDeleteCmd.Flags().StringVarP(&artifactVersion, "version", "v", "", "the version of the chart")
+}
+
+func helmPushRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		cmd.Help()
+		os.Exit(1)
+	}
+
+	r := &helm.Repository{Store: selectStore(args[0]), BaseURL: helmBaseURL}
+	for _, chart := range args[1:] {
+		a, err := r.Put(chart)
+		if err != nil {
+			log.Fatalln("upload failed:", err)
+		}
+		log.Printf("pushed %s %s", a.Name, a.Version)
+	}
+}
+
+func helmDeleteRun(cmd *cobra.Command, args []string) {
+	snp, a := deleteCheckFlagsAndArgs(cmd, args)
+
+	r := &helm.Repository{Store: selectStore(snp), BaseURL: helmBaseURL}
+	if err := r.Del(a); err != nil {
+		log.Fatalln("deletion failed:", err)
+	}
+}
+
+func helmReindexRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(1)
+	}
+
+	r := &helm.Repository{Store: selectStore(args[0]), BaseURL: helmBaseURL}
+	if err := r.Reindex(); err != nil {
+		log.Fatalln("reindex failed:", err)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"os"
+	"sort"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/spf13/cobra"
+)
+
+var infoCmd = &cobra.Command{
+	Use:   "info <store>/<bucket>",
+	Short: "show details about the store",
+	Run:   infoRun,
+}
+
+func init() {
+	RootCmd.AddCommand(infoCmd)
+}
+
+func infoRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		cmd.Help()
+		os.Exit(1)
+	}
+
+	s := selectStore(args[0])
+
+	d, ok := s.(store.Describer)
+	if !ok {
+		log.Printf("type\t%T", s)
+		return
+	}
+	info := d.Describe()
+	keys := []string{}
+	for k := range info {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		log.Printf("%s\t%s", k, info[k])
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/spf13/cobra"
+)
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "generate keys for client-side encryption",
+	Long: `This prints a new random key to be used as encryption key of a store.
+Using --x25519 a private key (identity) and the matching public key
+(recipient) are generated instead. Machines which only upload artifacts
+need to know the recipients but not the identity.`,
+	Run: keygenRun,
+}
+
+var (
+	keygenX25519 bool
+)
+
+func init() {
+	RootCmd.AddCommand(keygenCmd)
+
+	keygenCmd.Flags().BoolVar(&keygenX25519, "x25519", false, "generate a X25519 key pair instead of a symmetric key")
+}
+
+func keygenRun(cmd *cobra.Command, args []string) {
+	if !keygenX25519 {
+		k, err := store.GenerateKey()
+		if err != nil {
+			log.Fatalln("generating key failed:", err)
+		}
+		fmt.Println(store.EncodeKey(k))
+		return
+	}
+
+	identity, recipient, err := store.GenerateIdentity()
+	if err != nil {
+		log.Fatalln("generating key failed:", err)
+	}
+	fmt.Printf("# recipient: %s\n", store.EncodeKey(recipient))
+	fmt.Println(store.EncodeKey(identity))
+}
@@ -0,0 +1,98 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/repo/maven"
+	"github.com/spf13/cobra"
+)
+
+var mavenCmd = &cobra.Command{
+	Use:   "maven",
+	Short: "maintain Maven repositories",
+	Long: `Artifacts are stored using the layout of a Maven repository, the name of
+the artifact is groupId:artifactId. Every file gets .md5, .sha1, .sha256 and
+.sha512 checksum files and maven-metadata.xml lists the versions, so the
+bucket (or arti serve) may be used as Maven or Gradle repository.`,
+}
+
+var mavenDeployCmd = &cobra.Command{
+	Use:   "deploy <store>/<bucket> <file> [ <file> ... ]",
+	Short: "deploy the files of a version",
+	Long: `This deploys the given files as version of an artifact. Files named
+<artifactId>-<version>-<classifier>.<ext>, e.g. foo-1.0-sources.jar, are
+deployed using this classifier. A minimal pom is created unless a .pom file
+is given.`,
+	Run: mavenDeployRun,
+}
+
+var mavenDeleteCmd = &cobra.Command{
+	Use:     "delete <store>/<bucket>",
+	Aliases: []string{"del"},
+	Short:   "delete a version",
+	Run:     mavenDeleteRun,
+}
+
+func init() {
+	RootCmd.AddCommand(mavenCmd)
+	mavenCmd.AddCommand(mavenDeployCmd)
+	mavenCmd.AddCommand(mavenDeleteCmd)
+
+	mavenCmd.PersistentFlags().StringVarP(&artifactName, "name", "n", "", "the name of the artifact (groupId:artifactId)")
+	mavenCmd.PersistentFlags().StringVarP(&artifactVersion, "version", "v", "", "the version of the artifact")
+}
+
+func mavenCheckFlagsAndArgs(cmd *cobra.Command, args []string, minArgs int) (*maven.Repository, maven.Coordinates) {
+	if len(args) < minArgs {
+		cmd.Help()
+		os.Exit(1)
+	}
+	c, err := maven.ParseCoordinates(artifactName, artifactVersion)
+	if err != nil {
+		log.Println(err)
+		cmd.Help()
+		os.Exit(1)
+	}
+
+	objects, err := repo.ObjectStore(selectStore(args[0]))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return &maven.Repository{Store: objects}, c
+}
+
+func mavenDeployRun(cmd *cobra.Command, args []string) {
+	r, c := mavenCheckFlagsAndArgs(cmd, args, 2)
+
+	var files []maven.File
+	for _, f := range args[1:] {
+		files = append(files, c.ClassifyFile(f))
+	}
+	if err := r.Deploy(c, files); err != nil {
+		log.Fatalln("deploy failed:", err)
+	}
+}
+
+func mavenDeleteRun(cmd *cobra.Command, args []string) {
+	r, c := mavenCheckFlagsAndArgs(cmd, args, 1)
+
+	if err := r.Delete(c); err != nil {
+		log.Fatalln("deletion failed:", err)
+	}
+}
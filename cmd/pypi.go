@@ -0,0 +1,103 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/repo/pypi"
+	"github.com/spf13/cobra"
+)
+
+var pypiCmd = &cobra.Command{
+	Use:   "pypi",
+	Short: "maintain Python package indexes",
+	Long: `Wheels and source distributions are stored below packages/<project>/
+together with their checksum. A PEP 503 simple index is kept below simple/,
+so the bucket (or arti serve) may be used with
+
+  pip install --index-url <url of the bucket>/simple/ <project>`,
+}
+
+var pypiUploadCmd = &cobra.Command{
+	Use:   "upload <store>/<bucket> <file> [ <file> ... ]",
+	Short: "add wheels or source distributions to a package index",
+	Long: `This uploads the files and updates the index pages. Files which are part
+of the index already are only replaced if --force is given.`,
+	Run: pypiUploadRun,
+}
+
+var pypiRemoveCmd = &cobra.Command{
+	Use:     "remove <store>/<bucket> <project> [ <version> ]",
+	Aliases: []string{"rm"},
+	Short:   "remove distributions from a package index",
+	Run:     pypiRemoveRun,
+}
+
+var pypiForce bool
+
+func init() {
+	RootCmd.AddCommand(pypiCmd)
+	pypiCmd.AddCommand(pypiUploadCmd)
+	pypiCmd.AddCommand(pypiRemoveCmd)
+
+	pypiUploadCmd.Flags().BoolVarP(&pypiForce, "force", "f", false, "replace files which exist already")
+}
+
+func pypiRepository(snp string) *pypi.Repository {
+	objects, err := repo.ObjectStore(selectStore(snp))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return &pypi.Repository{Store: objects}
+}
+
+func pypiUploadRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		cmd.Help()
+		os.Exit(1)
+	}
+
+	r := pypiRepository(args[0])
+	r.Force = pypiForce
+	if err := r.Add(args[1:]...); err != nil {
+		log.Fatalln("upload failed:", err)
+	}
+}
+
+func pypiRemoveRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 || len(args) > 3 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	version := ""
+	if len(args) == 3 {
+		version = args[2]
+	}
+
+	r := pypiRepository(args[0])
+	removed, err := r.Remove(args[1], version)
+	if err != nil {
+		log.Fatalln("removal failed:", err)
+	}
+	if len(removed) == 0 {
+		log.Fatalln("no such project:", args[1])
+	}
+	for _, f := range removed {
+		log.Printf("removed %s", f)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"os"
+	"sort"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/spf13/cobra"
+)
+
+var reencryptCmd = &cobra.Command{
+	Use:   "reencrypt <store>/<bucket>",
+	Short: "re-encrypt artifacts using the current key",
+	Long: `This re-encrypts all artifacts of an encrypted store which have
+not yet been encrypted using the currently configured key or recipients.
+Keys which have been rotated out must still be listed as old-keys or
+old-key-files for the duration of this command.
+
+Every affected artifact gets downloaded, deleted and uploaded again.
+If the upload fails the decrypted file is kept in the temporary directory.`,
+	Run: reencryptRun,
+}
+
+func init() {
+	RootCmd.AddCommand(reencryptCmd)
+
+	reencryptCmd.Flags().StringVarP(&artifactName, "name", "n", "", "only re-encrypt versions of this artifact")
+	reencryptCmd.Flags().StringVarP(&artifactVersion, "version", "v", "", "range of versions to re-encrypt")
+}
+
+func reencryptRun(cmd *cobra.Command, args []string) {
+	snp, versions := listCheckFlagsAndArgs(cmd, args)
+
+	// the cache and the compression are configured around the encryption
+	s := store.EncryptedBackend(selectStore(snp))
+	if s == nil {
+		log.Fatalln("store is not configured for encryption")
+	}
+
+	artifacts, err := s.List(artifactName, versions)
+	if err != nil {
+		log.Fatalln("listing artifacts failed:", err)
+	}
+
+	names := []string{}
+	for name := range artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := 0
+	for _, name := range names {
+		for _, v := range artifacts[name] {
+			a := store.Artifact{Name: name, Version: v.Version}
+			changed, err := s.Reencrypt(a)
+			switch {
+			case err != nil:
+				log.Printf("%s %v: re-encryption failed: %v", name, v.Version, err)
+				failed++
+			case changed:
+				log.Printf("%s %v: re-encrypted", name, v.Version)
+			}
+		}
+	}
+	if failed > 0 {
+		log.Printf("%d artifacts could not be re-encrypted", failed)
+		os.Exit(1)
+	}
+}
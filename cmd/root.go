@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"log"
+	"os"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -24,6 +25,10 @@ import (
 
 var cfgFile string
 
+// cfgFileErr is set if the config file given by --config doesn't exist,
+// which is only an error if stores are used.
+var cfgFileErr error
+
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "arti",
@@ -68,6 +73,11 @@ func initConfig() {
 		switch err.(type) {
 		case viper.ConfigFileNotFoundError:
 		default:
+			if cfgFile != "" && os.IsNotExist(err) {
+				// arti config add-store creates it
+				cfgFileErr = err
+				return
+			}
 			log.Fatalln("reading config file:", err)
 		}
 	}
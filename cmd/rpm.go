@@ -0,0 +1,115 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/repo/rpm"
+	"github.com/spf13/cobra"
+)
+
+var rpmCmd = &cobra.Command{
+	Use:   "rpm",
+	Short: "maintain YUM/DNF repositories",
+	Long: `This maintains a rpm package repository inside a store. Packages are
+uploaded below Packages/ and the metadata in repodata/ is updated, so the
+bucket (or --path within it) may be used as baseurl of a repository.
+
+If a signing key is given, repodata/repomd.xml.asc is created. The
+passphrase of the key is read from the config (rpm.sign-passphrase) or the
+environment variable ARTI_RPM__SIGN_PASSPHRASE.`,
+}
+
+var rpmUploadCmd = &cobra.Command{
+	Use:   "upload <store>/<bucket> <file.rpm> [ <file.rpm> ... ]",
+	Short: "add packages to a YUM/DNF repository",
+	Run:   rpmUploadRun,
+}
+
+var rpmRemoveCmd = &cobra.Command{
+	Use:     "remove <store>/<bucket> <package> [ <version> ]",
+	Aliases: []string{"rm"},
+	Short:   "remove packages from a YUM/DNF repository",
+	Run:     rpmRemoveRun,
+}
+
+var (
+	rpmPath        string
+	rpmSignKey     string
+	rpmRemoveArch  string
+	rpmRemovePurge bool
+)
+
+func init() {
+	RootCmd.AddCommand(rpmCmd)
+	rpmCmd.AddCommand(rpmUploadCmd)
+	rpmCmd.AddCommand(rpmRemoveCmd)
+
+	rpmCmd.PersistentFlags().StringVar(&rpmPath, "path", "", "location of the repository within the bucket")
+	rpmCmd.PersistentFlags().StringVar(&rpmSignKey, "sign-key", "", "OpenPGP secret key used to sign repomd.xml (default rpm.sign-key from config)")
+
+	rpmRemoveCmd.Flags().StringVar(&rpmRemoveArch, "only-arch", "", "only remove packages of this architecture")
+	rpmRemoveCmd.Flags().BoolVar(&rpmRemovePurge, "purge", false, "also delete the package files")
+}
+
+func rpmRepository(snp string) *rpm.Repository {
+	objects, err := repo.ObjectStore(selectStore(snp))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return &rpm.Repository{
+		Store:  objects,
+		Path:   rpmPath,
+		Signer: repoSigner(rpmSignKey, "rpm"),
+	}
+}
+
+func rpmUploadRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		cmd.Help()
+		os.Exit(1)
+	}
+
+	r := rpmRepository(args[0])
+	if err := r.Add(args[1:]...); err != nil {
+		log.Fatalln("upload failed:", err)
+	}
+}
+
+func rpmRemoveRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 || len(args) > 3 {
+		cmd.Help()
+		os.Exit(1)
+	}
+	version := ""
+	if len(args) == 3 {
+		version = args[2]
+	}
+
+	r := rpmRepository(args[0])
+	removed, err := r.Remove(args[1], version, rpmRemoveArch, rpmRemovePurge)
+	if err != nil {
+		log.Fatalln("removal failed:", err)
+	}
+	if len(removed) == 0 {
+		log.Fatalln("no such package:", args[1])
+	}
+	for _, href := range removed {
+		log.Printf("removed %s", href)
+	}
+}
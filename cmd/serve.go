@@ -0,0 +1,89 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/mgit-at/arti/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve <store>/<bucket> [ <store>/<bucket> ... ]",
+	Short: "serve stores via HTTP",
+	Long: `This runs a HTTP server which makes the given stores available
+below /<store>/<bucket>/. Artifacts, versions and files may be browsed as
+HTML or JSON (using ?format=json) and files may be downloaded using
+
+  /<store>/<bucket>/<name>/<version>/<filename>
+
+Downloads support range requests and carry the checksum as ETag and
+Digest header.
+
+If users are configured, they may upload artifacts using HTTP PUT requests
+with basic authentication:
+
+  serve:
+    users:
+      ci: "very-secret"
+    max-upload-size: 1GB
+
+Uploads are limited to 1GB unless max-upload-size is set.`,
+	Run: serveRun,
+}
+
+var (
+	serveListen  string
+	serveTLSCert string
+	serveTLSKey  string
+)
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVarP(&serveListen, "listen", "l", ":8080", "address to listen on")
+	serveCmd.Flags().StringVar(&serveTLSCert, "tls-cert", "", "certificate file to enable HTTPS")
+	serveCmd.Flags().StringVar(&serveTLSKey, "tls-key", "", "private key file of the certificate")
+}
+
+func serveRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		cmd.Help()
+		os.Exit(1)
+	}
+
+	mounts := []server.Mount{}
+	for _, snp := range args {
+		mounts = append(mounts, server.Mount{Name: snp, Store: selectStore(snp)})
+	}
+	opts := server.Options{
+		Users:         viper.GetStringMapString("serve.users"),
+		MaxUploadSize: int64(viper.GetSizeInBytes("serve.max-upload-size")),
+	}
+
+	srv := &http.Server{Addr: serveListen, Handler: server.New(mounts, opts)}
+	log.Printf("listening on %s", serveListen)
+	var err error
+	if serveTLSCert != "" {
+		err = srv.ListenAndServeTLS(serveTLSCert, serveTLSKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	log.Fatalln("server failed:", err)
+}
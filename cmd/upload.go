@@ -28,10 +28,17 @@ var uploadCmd = &cobra.Command{
 	Aliases: []string{"put"},
 	Short:   "upload artifacts to the store",
 	Long: `This uploads an artifact to the store. It is an error if the
-artifact name/version tuple already exists.`,
+artifact name/version tuple already exists.
+
+Using --compress the file gets compressed before uploading it. Downloading
+the artifact will decompress it again.`,
 	Run: uploadRun,
 }
 
+var (
+	uploadCompress string
+)
+
 func init() {
 	RootCmd.AddCommand(uploadCmd)
 
@@ -39,6 +46,7 @@ func init() {
 	uploadCmd.MarkFlagRequired("name")
 	uploadCmd.Flags().StringVarP(&artifactVersion, "version", "v", "", "the version of the artifact (must adhere to the semantic versioning scheme)")
 	uploadCmd.MarkFlagRequired("version")
+	uploadCmd.Flags().StringVar(&uploadCompress, "compress", "", "compress the file before uploading it (gzip or zstd)")
 }
 
 func uploadCheckFlagsAndArgs(cmd *cobra.Command, args []string) (string, string, store.Artifact) {
@@ -69,6 +77,13 @@ func uploadRun(cmd *cobra.Command, args []string) {
 	snp, fn, a := uploadCheckFlagsAndArgs(cmd, args)
 
 	s := selectStore(snp)
+	if uploadCompress != "" {
+		cs, err := store.NewCompressedStore(s, uploadCompress)
+		if err != nil {
+			log.Fatalln("unable to enable compression:", err)
+		}
+		s = cs
+	}
 
 	if err := s.Put(a, fn); err != nil {
 		log.Fatalln("upload failed:", err)
@@ -0,0 +1,104 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/spf13/cobra"
+)
+
+var urlCmd = &cobra.Command{
+	Use:   "url <store>/<bucket> [ <filename> ]",
+	Short: "print pre-signed URLs for an artifact",
+	Long: `This prints a time-limited URL which may be used to download an
+artifact without any credentials. Using --checksum the URL of the checksum
+file is printed as well.
+
+Using --upload URLs to upload the artifact are printed instead. In this case
+the filename which should be used for the artifact must be supplied. The
+first URL is used to upload the file itself, the second one to upload the
+checksum file which must contain "sha256:" followed by the hex encoded
+SHA-256 hash of the file.
+
+URLs may be valid for at most 7 days.`,
+	Run: urlRun,
+}
+
+var (
+	urlExpires  time.Duration
+	urlUpload   bool
+	urlChecksum bool
+)
+
+func init() {
+	RootCmd.AddCommand(urlCmd)
+
+	urlCmd.Flags().StringVarP(&artifactName, "name", "n", "", "the name of the artifact")
+	urlCmd.MarkFlagRequired("name")
+	urlCmd.Flags().StringVarP(&artifactVersion, "version", "v", "", "the version of the artifact (must adhere to the semantic versioning scheme)")
+	urlCmd.MarkFlagRequired("version")
+	urlCmd.Flags().DurationVar(&urlExpires, "expires", 24*time.Hour, "how long the URLs are valid")
+	urlCmd.Flags().BoolVar(&urlUpload, "upload", false, "print URLs to upload the artifact")
+	urlCmd.Flags().BoolVar(&urlChecksum, "checksum", false, "also print the URL of the checksum file")
+}
+
+func urlRun(cmd *cobra.Command, args []string) {
+	snp, fn, a := downloadCheckFlagsAndArgs(cmd, args)
+	if urlUpload && fn == "" {
+		log.Println("please specifiy the filename to upload")
+		cmd.Help()
+		os.Exit(1)
+	}
+
+	s := selectStore(snp)
+	p, ok := store.Backend(s).(store.Presigner)
+	if !ok {
+		log.Fatalln("store does not support pre-signed URLs")
+	}
+	// the URLs point to the files of the backend as they are stored
+	if store.EncryptedBackend(s) != nil {
+		log.Fatalln("pre-signed URLs are not supported for encrypted stores")
+	}
+	if d, ok := s.(store.Describer); ok && urlUpload && d.Describe()["compression"] != "" {
+		log.Println("warning: the file is uploaded as it is, the compression of the store is bypassed")
+	}
+
+	if urlUpload {
+		pa, err := p.PresignPut(a, fn, urlExpires)
+		if err != nil {
+			log.Fatalln("pre-signing failed:", err)
+		}
+		fmt.Println(pa.URL)
+		fmt.Println(pa.ChecksumURL)
+		return
+	}
+
+	pa, err := p.PresignGet(a, urlExpires)
+	if err != nil {
+		log.Fatalln("pre-signing failed:", err)
+	}
+	if pa.Encoding != "" {
+		log.Printf("warning: the file is compressed using %s", pa.Encoding)
+	}
+	fmt.Println(pa.URL)
+	if urlChecksum {
+		fmt.Println(pa.ChecksumURL)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Paragraph is a stanza of a debian control file. The order of the fields
+// is preserved.
+type Paragraph struct {
+	keys   []string
+	values map[string]string
+}
+
+func NewParagraph() *Paragraph {
+	return &Paragraph{values: make(map[string]string)}
+}
+
+func canonicalKey(key string) string {
+	return strings.ToLower(key)
+}
+
+// Get returns the value of a field. Multi-line values contain the
+// continuation lines without the leading space.
+func (p *Paragraph) Get(key string) string {
+	return p.values[canonicalKey(key)]
+}
+
+// Set replaces the value of a field or appends the field if it does not
+// exist yet.
+func (p *Paragraph) Set(key, value string) {
+	ck := canonicalKey(key)
+	if _, exists := p.values[ck]; !exists {
+		p.keys = append(p.keys, key)
+	}
+	p.values[ck] = value
+}
+
+func (p *Paragraph) Del(key string) {
+	ck := canonicalKey(key)
+	if _, exists := p.values[ck]; !exists {
+		return
+	}
+	delete(p.values, ck)
+	for i, k := range p.keys {
+		if canonicalKey(k) == ck {
+			p.keys = append(p.keys[:i], p.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+func (p *Paragraph) Keys() []string {
+	return p.keys
+}
+
+func (p *Paragraph) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, k := range p.keys {
+		lines := strings.Split(p.values[canonicalKey(k)], "\n")
+		if lines[0] == "" {
+			fmt.Fprintf(&buf, "%s:\n", k)
+		} else {
+			fmt.Fprintf(&buf, "%s: %s\n", k, lines[0])
+		}
+		for _, l := range lines[1:] {
+			if l == "" {
+				l = "."
+			}
+			fmt.Fprintf(&buf, " %s\n", l)
+		}
+	}
+	return buf.Bytes()
+}
+
+// ParseParagraphs parses all stanzas of a control file.
+func ParseParagraphs(data []byte) ([]*Paragraph, error) {
+	var paragraphs []*Paragraph
+	var p *Paragraph
+	var last string
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			p = nil
+			continue
+		}
+		if line[0] == '#' {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if p == nil || last == "" {
+				return nil, fmt.Errorf("line %d: continuation line without field", n+1)
+			}
+			// only the first space is syntax, further indentation is
+			// part of the value (e.g. verbatim lines of descriptions)
+			l := strings.TrimRight(line[1:], " \t")
+			if l == "." {
+				l = ""
+			}
+			p.values[canonicalKey(last)] += "\n" + l
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: invalid field '%s'", n+1, line)
+		}
+		if p == nil {
+			p = NewParagraph()
+			paragraphs = append(paragraphs, p)
+		}
+		last = kv[0]
+		p.Set(last, strings.TrimSpace(kv[1]))
+	}
+	return paragraphs, nil
+}
@@ -0,0 +1,268 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deb_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/repo/deb"
+	"github.com/mgit-at/arti/store"
+)
+
+func TestParseParagraphs(t *testing.T) {
+	data := "# comment\n" +
+		"Package: hello\n" +
+		"Version: 1.0-1\n" +
+		"Description: greeting program\n" +
+		" The program prints a greeting:\n" +
+		" .\n" +
+		"   $ hello\n" +
+		"\tHello, world!  \n" +
+		"\n\n" +
+		"Package: world\r\n" +
+		"Depends:\r\n" +
+		" hello (>= 1.0),\r\n" +
+		" libc6\r\n"
+	paragraphs, err := deb.ParseParagraphs([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paragraphs) != 2 {
+		t.Fatalf("expected 2 paragraphs, got %d", len(paragraphs))
+	}
+	p := paragraphs[0]
+	if keys := p.Keys(); !reflect.DeepEqual(keys, []string{"Package", "Version", "Description"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	expected := "greeting program\nThe program prints a greeting:\n\n  $ hello\nHello, world!"
+	if d := p.Get("description"); d != expected {
+		t.Errorf("expected description %q, got %q", expected, d)
+	}
+	if d := paragraphs[1].Get("Depends"); d != "\nhello (>= 1.0),\nlibc6" {
+		t.Errorf("unexpected depends %q", d)
+	}
+
+	expected = "Package: hello\n" +
+		"Version: 1.0-1\n" +
+		"Description: greeting program\n" +
+		" The program prints a greeting:\n" +
+		" .\n" +
+		"   $ hello\n" +
+		" Hello, world!\n"
+	if b := string(p.Bytes()); b != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, b)
+	}
+	reparsed, err := deb.ParseParagraphs(p.Bytes())
+	if err != nil || len(reparsed) != 1 || reparsed[0].Get("Description") != p.Get("Description") {
+		t.Errorf("formatted paragraph doesn't parse to the same value: %v", err)
+	}
+
+	p.Set("version", "2.0-1")
+	p.Del("Description")
+	p.Set("Architecture", "all")
+	if b := string(p.Bytes()); b != "Package: hello\nVersion: 2.0-1\nArchitecture: all\n" {
+		t.Errorf("unexpected paragraph after modifications:\n%s", b)
+	}
+
+	for _, invalid := range []string{" continuation\n", "Package: hello\nno field\n", "Package: hello\n\n continuation\n"} {
+		if _, err := deb.ParseParagraphs([]byte(invalid)); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+// writeDeb creates a debian package which contains only a control file.
+func writeDeb(t *testing.T, dir, name, control string) string {
+	var ctl bytes.Buffer
+	zw := gzip.NewWriter(&ctl)
+	tw := tar.NewWriter(zw)
+	if err := tw.WriteHeader(&tar.Header{Name: "./control", Mode: 0644, Size: int64(len(control))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(control))
+	tw.Close()
+	zw.Close()
+
+	var buf bytes.Buffer
+	buf.WriteString("!<arch>\n")
+	for _, m := range []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", ctl.Bytes()},
+		{"data.tar.gz", repo.Gzip(nil)},
+	} {
+		fmt.Fprintf(&buf, "%-16s%-12s%-6s%-6s%-8s%-10d`\n", m.name, "0", "0", "0", "100644", len(m.data))
+		buf.Write(m.data)
+		if len(m.data)%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func readParagraphs(t *testing.T, st store.ObjectStore, key string) ([]*deb.Paragraph, []byte) {
+	data, found, err := repo.ReadObject(st, key)
+	if err != nil || !found {
+		t.Fatalf("%s: found %t, %v", key, found, err)
+	}
+	paragraphs, err := deb.ParseParagraphs(data)
+	if err != nil {
+		t.Fatalf("%s: %v", key, err)
+	}
+	return paragraphs, data
+}
+
+// checkRelease verifies the sizes and SHA256 checksums of all files listed
+// in the Release file.
+func checkRelease(t *testing.T, st store.ObjectStore, dist string, expected ...string) {
+	release, _ := readParagraphs(t, st, dist+"/Release")
+	listed := []string{}
+	for _, line := range strings.Split(release[0].Get("SHA256"), "\n") {
+		f := strings.Fields(line)
+		if len(f) != 3 {
+			continue
+		}
+		listed = append(listed, f[2])
+		data, found, err := repo.ReadObject(st, dist+"/"+f[2])
+		if err != nil || !found {
+			t.Errorf("%s: found %t, %v", f[2], found, err)
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if size := strconv.Itoa(len(data)); f[0] != hex.EncodeToString(sum[:]) || f[1] != size {
+			t.Errorf("%s: Release lists %s %s, expected %s %s", f[2], f[0], f[1], hex.EncodeToString(sum[:]), size)
+		}
+	}
+	if !reflect.DeepEqual(listed, expected) {
+		t.Errorf("expected Release to list %v, got %v", expected, listed)
+	}
+}
+
+func packageNames(t *testing.T, st store.ObjectStore, key string) []string {
+	paragraphs, data := readParagraphs(t, st, key)
+	gz, _, _ := repo.ReadObject(st, key+".gz")
+	if unzipped, err := repo.Gunzip(gz); err != nil || !bytes.Equal(unzipped, data) {
+		t.Errorf("%s.gz doesn't match %s: %v", key, key, err)
+	}
+	names := []string{}
+	for _, p := range paragraphs {
+		names = append(names, p.Get("Package")+"_"+p.Get("Version")+"_"+p.Get("Architecture"))
+	}
+	return names
+}
+
+func TestRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-deb-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hello := writeDeb(t, dir, "hello.deb", "Package: hello\nVersion: 1:1.0-1\nArchitecture: amd64\n"+
+		"Description: greeting program\n Prints:\n .\n   Hello, world!\n")
+	libhello := writeDeb(t, dir, "libhello.deb", "Package: libhello1\nSource: libhello (1.0-1)\nVersion: 1.0-1\nArchitecture: amd64\n")
+	data := writeDeb(t, dir, "data.deb", "Package: hello-data\nSource: hello\nVersion: 1:1.0-1\nArchitecture: all\n")
+
+	st := store.NewMemStore()
+	r := &deb.Repository{
+		Store:         st,
+		Codename:      "stable",
+		Component:     "main",
+		Architectures: []string{"amd64"},
+		Origin:        "arti",
+	}
+	if err = r.Add(hello, libhello, data); err != nil {
+		t.Fatal(err)
+	}
+
+	dist := "dists/stable"
+	index := dist + "/main/binary-amd64/Packages"
+	if names := packageNames(t, st, index); !reflect.DeepEqual(names, []string{"hello_1:1.0-1_amd64", "hello-data_1:1.0-1_all", "libhello1_1.0-1_amd64"}) {
+		t.Errorf("unexpected packages %v", names)
+	}
+	checkRelease(t, st, dist, "main/binary-amd64/Packages", "main/binary-amd64/Packages.gz")
+	release, _ := readParagraphs(t, st, dist+"/Release")
+	for k, v := range map[string]string{"Origin": "arti", "Codename": "stable", "Suite": "stable", "Architectures": "amd64", "Components": "main"} {
+		if got := release[0].Get(k); got != v {
+			t.Errorf("Release: expected %s %q, got %q", k, v, got)
+		}
+	}
+
+	packages, _ := readParagraphs(t, st, index)
+	p := deb.Package{Paragraph: packages[0]}
+	if p.Filename() != "pool/main/h/hello/hello_1.0-1_amd64.deb" {
+		t.Errorf("unexpected filename %s", p.Filename())
+	}
+	if p.Get("Description") != "greeting program\nPrints:\n\n  Hello, world!" {
+		t.Errorf("description has been changed: %q", p.Get("Description"))
+	}
+	content, _ := ioutil.ReadFile(hello)
+	sum := sha256.Sum256(content)
+	if p.Get("SHA256") != hex.EncodeToString(sum[:]) || p.Get("Size") != strconv.Itoa(len(content)) {
+		t.Errorf("unexpected size %s and checksum %s", p.Get("Size"), p.Get("SHA256"))
+	}
+	for _, fn := range []string{p.Filename(), "pool/main/libh/libhello/libhello1_1.0-1_amd64.deb", "pool/main/h/hello/hello-data_1.0-1_all.deb"} {
+		if _, found, err := repo.ReadObject(st, fn); err != nil || !found {
+			t.Errorf("%s: found %t, %v", fn, found, err)
+		}
+	}
+
+	// packages of architecture all are added to new architectures
+	arm := writeDeb(t, dir, "hello-arm.deb", "Package: hello\nVersion: 1:1.0-1\nArchitecture: arm64\n")
+	if err = r.Add(arm); err != nil {
+		t.Fatal(err)
+	}
+	if names := packageNames(t, st, dist+"/main/binary-arm64/Packages"); !reflect.DeepEqual(names, []string{"hello_1:1.0-1_arm64", "hello-data_1:1.0-1_all"}) {
+		t.Errorf("unexpected arm64 packages %v", names)
+	}
+	checkRelease(t, st, dist, "main/binary-amd64/Packages", "main/binary-amd64/Packages.gz", "main/binary-arm64/Packages", "main/binary-arm64/Packages.gz")
+	if release, _ = readParagraphs(t, st, dist+"/Release"); release[0].Get("Architectures") != "amd64 arm64" {
+		t.Errorf("unexpected architectures %q", release[0].Get("Architectures"))
+	}
+
+	removed, err := r.Remove("hello", "", "amd64", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Architecture() != "amd64" {
+		t.Errorf("unexpected removed packages %v", removed)
+	}
+	if names := packageNames(t, st, index); !reflect.DeepEqual(names, []string{"hello-data_1:1.0-1_all", "libhello1_1.0-1_amd64"}) {
+		t.Errorf("unexpected packages after remove %v", names)
+	}
+	if _, err = st.GetObject("pool/main/h/hello/hello_1.0-1_amd64.deb"); err != store.ErrObjectNotFound {
+		t.Errorf("removed package has not been purged: %v", err)
+	}
+	checkRelease(t, st, dist, "main/binary-amd64/Packages", "main/binary-amd64/Packages.gz", "main/binary-arm64/Packages", "main/binary-arm64/Packages.gz")
+}
@@ -0,0 +1,209 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deb
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+var errNoControl = errors.New("package contains no control file")
+
+// Package is a binary package as listed in a Packages index.
+type Package struct {
+	*Paragraph
+}
+
+func (p Package) Name() string {
+	return p.Get("Package")
+}
+
+func (p Package) Version() string {
+	return p.Get("Version")
+}
+
+func (p Package) Architecture() string {
+	return p.Get("Architecture")
+}
+
+func (p Package) Filename() string {
+	return p.Get("Filename")
+}
+
+// Source returns the name of the source package, which defaults to the
+// name of the package.
+func (p Package) Source() string {
+	if src := strings.Fields(p.Get("Source")); len(src) > 0 {
+		return src[0]
+	}
+	return p.Name()
+}
+
+// PoolPath returns the location of the package file within the pool of
+// the given component.
+func (p Package) PoolPath(component string) string {
+	src := p.Source()
+	prefix := src[:1]
+	if strings.HasPrefix(src, "lib") && len(src) > 3 {
+		prefix = src[:4]
+	}
+	version := p.Version()
+	if i := strings.Index(version, ":"); i >= 0 {
+		version = version[i+1:]
+	}
+	filename := fmt.Sprintf("%s_%s_%s.deb", p.Name(), version, p.Architecture())
+	return path.Join("pool", component, prefix, src, filename)
+}
+
+// ReadPackage extracts the control data of a .deb file and adds size and
+// checksums of the file.
+func ReadPackage(filename string) (*Package, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening package: %v", err)
+	}
+	defer f.Close()
+
+	control, err := readControl(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	paragraphs, err := ParseParagraphs(control)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid control file: %v", filename, err)
+	}
+	if len(paragraphs) != 1 {
+		return nil, fmt.Errorf("%s: invalid control file", filename)
+	}
+	p := &Package{paragraphs[0]}
+	for _, k := range []string{"Package", "Version", "Architecture"} {
+		if p.Get(k) == "" {
+			return nil, fmt.Errorf("%s: control file misses field %s", filename, k)
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	hMD5, hSHA1, hSHA256 := md5.New(), sha1.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(hMD5, hSHA1, hSHA256), f)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading package: %v", err)
+	}
+	p.Set("Size", strconv.FormatInt(size, 10))
+	p.Set("MD5sum", hex.EncodeToString(hMD5.Sum(nil)))
+	p.Set("SHA1", hex.EncodeToString(hSHA1.Sum(nil)))
+	p.Set("SHA256", hex.EncodeToString(hSHA256.Sum(nil)))
+	return p, nil
+}
+
+// readControl returns the control file contained in the control.tar member
+// of the ar archive r.
+func readControl(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != "!<arch>\n" {
+		return nil, errors.New("not a debian package")
+	}
+
+	hdr := make([]byte, 60)
+	for {
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			if err == io.EOF {
+				return nil, errNoControl
+			}
+			return nil, fmt.Errorf("invalid ar archive: %v", err)
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(hdr[0:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil || string(hdr[58:60]) != "`\n" {
+			return nil, errors.New("invalid ar archive: broken header")
+		}
+		member := io.LimitReader(br, size)
+		if strings.HasPrefix(name, "control.tar") {
+			return readControlTar(member, strings.TrimPrefix(name, "control.tar"))
+		}
+		if _, err := io.Copy(ioutil.Discard, member); err != nil {
+			return nil, err
+		}
+		if size%2 == 1 {
+			br.ReadByte()
+		}
+	}
+}
+
+func readControlTar(r io.Reader, ext string) ([]byte, error) {
+	switch ext {
+	case "":
+	case ".gz":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case ".xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = xr
+	case ".zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("unsupported compression of control.tar%s", ext)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, errNoControl
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid control archive: %v", err)
+		}
+		if path.Clean(h.Name) != "control" {
+			continue
+		}
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, tr); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
@@ -0,0 +1,417 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package deb maintains APT repositories within a store.
+package deb
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/store"
+)
+
+// Repository is a distribution (codename) of an APT repository.
+type Repository struct {
+	Store     store.ObjectStore
+	Codename  string
+	Component string
+
+	// Architectures are always published, in addition to the ones already
+	// listed in the Release file.
+	Architectures []string
+
+	Origin      string
+	Label       string
+	Description string
+
+	// Signer creates InRelease and Release.gpg if set.
+	Signer *repo.Signer
+
+	release *Paragraph
+	indices map[string][]*Package
+	changed map[string]bool
+}
+
+var hashFields = []string{"MD5Sum", "SHA1", "SHA256"}
+
+type fileHashes struct {
+	size   int64
+	hashes map[string]string
+}
+
+func (r *Repository) distPath(elem ...string) string {
+	return path.Join(append([]string{"dists", r.Codename}, elem...)...)
+}
+
+func (r *Repository) indexPath(arch string) string {
+	return path.Join(r.Component, "binary-"+arch, "Packages")
+}
+
+func (r *Repository) load() error {
+	r.indices = make(map[string][]*Package)
+	r.changed = make(map[string]bool)
+	r.release = NewParagraph()
+
+	data, found, err := repo.ReadObject(r.Store, r.distPath("Release"))
+	if err != nil {
+		return err
+	}
+	if found {
+		paragraphs, err := ParseParagraphs(data)
+		if err != nil {
+			return fmt.Errorf("invalid Release file: %v", err)
+		}
+		if len(paragraphs) != 1 {
+			return errors.New("invalid Release file")
+		}
+		r.release = paragraphs[0]
+	}
+
+	archs := append(strings.Fields(r.release.Get("Architectures")), r.Architectures...)
+	for _, arch := range archs {
+		if _, loaded := r.indices[arch]; loaded || arch == "all" {
+			continue
+		}
+		if err := r.loadIndex(arch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) loadIndex(arch string) error {
+	data, found, err := repo.ReadObject(r.Store, r.distPath(r.indexPath(arch)))
+	if err != nil {
+		return err
+	}
+	r.indices[arch] = []*Package{}
+	if !found {
+		r.changed[arch] = true
+		return nil
+	}
+	paragraphs, err := ParseParagraphs(data)
+	if err != nil {
+		return fmt.Errorf("invalid Packages file for %s: %v", arch, err)
+	}
+	for _, p := range paragraphs {
+		r.indices[arch] = append(r.indices[arch], &Package{p})
+	}
+	return nil
+}
+
+func (r *Repository) archs() []string {
+	archs := []string{}
+	for arch := range r.indices {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+	return archs
+}
+
+// Add uploads the given package files to the pool and adds them to the
+// indices. Packages with architecture all are added to every architecture
+// of the repository. An existing package with the same name, version and
+// architecture is replaced.
+func (r *Repository) Add(files ...string) error {
+	if err := r.load(); err != nil {
+		return err
+	}
+
+	for _, filename := range files {
+		p, err := ReadPackage(filename)
+		if err != nil {
+			return err
+		}
+		archs := []string{p.Architecture()}
+		if p.Architecture() == "all" {
+			if archs = r.archs(); len(archs) == 0 {
+				return fmt.Errorf("%s: unable to add package of architecture all to a repository without architectures", filename)
+			}
+		} else if _, loaded := r.indices[p.Architecture()]; !loaded {
+			if err := r.loadIndex(p.Architecture()); err != nil {
+				return err
+			}
+			r.addArchAll(p.Architecture())
+		}
+
+		poolPath := p.PoolPath(r.Component)
+		p.Set("Filename", poolPath)
+		if err := repo.UploadFile(r.Store, poolPath, filename); err != nil {
+			return err
+		}
+		for _, arch := range archs {
+			r.indices[arch] = append(removePackages(r.indices[arch], p.Name(), p.Version(), p.Architecture()), p)
+			r.changed[arch] = true
+		}
+	}
+	return r.publish()
+}
+
+// addArchAll adds the packages of architecture all to a newly created index.
+func (r *Repository) addArchAll(arch string) {
+	if len(r.indices[arch]) > 0 {
+		return
+	}
+	for a, packages := range r.indices {
+		if a == arch {
+			continue
+		}
+		for _, p := range packages {
+			if p.Architecture() == "all" {
+				r.indices[arch] = append(r.indices[arch], p)
+			}
+		}
+		break
+	}
+}
+
+// Remove deletes all packages matching name, and if not empty version,
+// from the indices. If arch is not empty only the index of this architecture
+// is changed. If purge is set, package files which are no longer referenced
+// are deleted from the pool.
+func (r *Repository) Remove(name, version, arch string, purge bool) ([]*Package, error) {
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	var removed []*Package
+	for a, packages := range r.indices {
+		if arch != "" && a != arch {
+			continue
+		}
+		kept := removePackages(packages, name, version, "")
+		if len(kept) == len(packages) {
+			continue
+		}
+		for _, p := range packages {
+			if matchPackage(p, name, version, "") {
+				removed = append(removed, p)
+			}
+		}
+		r.indices[a] = kept
+		r.changed[a] = true
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	if err := r.publish(); err != nil {
+		return nil, err
+	}
+
+	if purge {
+		referenced := make(map[string]bool)
+		for _, packages := range r.indices {
+			for _, p := range packages {
+				referenced[p.Filename()] = true
+			}
+		}
+		for _, p := range removed {
+			if referenced[p.Filename()] {
+				continue
+			}
+			referenced[p.Filename()] = true
+			if err := r.Store.DelObject(p.Filename()); err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+func matchPackage(p *Package, name, version, arch string) bool {
+	return p.Name() == name && (version == "" || p.Version() == version) && (arch == "" || p.Architecture() == arch)
+}
+
+func removePackages(packages []*Package, name, version, arch string) []*Package {
+	kept := []*Package{}
+	for _, p := range packages {
+		if !matchPackage(p, name, version, arch) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+type packagesByName []*Package
+
+func (p packagesByName) Len() int      { return len(p) }
+func (p packagesByName) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p packagesByName) Less(i, j int) bool {
+	if p[i].Name() != p[j].Name() {
+		return p[i].Name() < p[j].Name()
+	}
+	return p[i].Version() < p[j].Version()
+}
+
+// publish writes the changed indices as well as the Release files.
+func (r *Repository) publish() error {
+	files := parseReleaseFiles(r.release)
+
+	for _, arch := range r.archs() {
+		if !r.changed[arch] {
+			continue
+		}
+		packages := r.indices[arch]
+		sort.Stable(packagesByName(packages))
+		var index []byte
+		for i, p := range packages {
+			if i > 0 {
+				index = append(index, '\n')
+			}
+			index = append(index, p.Bytes()...)
+		}
+
+		name := r.indexPath(arch)
+		for fn, data := range map[string][]byte{name: index, name + ".gz": repo.Gzip(index)} {
+			if err := repo.WriteObject(r.Store, r.distPath(fn), data); err != nil {
+				return err
+			}
+			files[fn] = hashFile(data)
+		}
+	}
+
+	components := strings.Fields(r.release.Get("Components"))
+	if !contains(components, r.Component) {
+		components = append(components, r.Component)
+	}
+	archs := strings.Fields(r.release.Get("Architectures"))
+	for _, arch := range r.archs() {
+		if !contains(archs, arch) {
+			archs = append(archs, arch)
+		}
+	}
+	sort.Strings(archs)
+
+	if r.Origin != "" {
+		r.release.Set("Origin", r.Origin)
+	}
+	if r.Label != "" {
+		r.release.Set("Label", r.Label)
+	}
+	r.release.Set("Codename", r.Codename)
+	if r.release.Get("Suite") == "" {
+		r.release.Set("Suite", r.Codename)
+	}
+	r.release.Set("Date", time.Now().UTC().Format(time.RFC1123))
+	r.release.Set("Architectures", strings.Join(archs, " "))
+	r.release.Set("Components", strings.Join(components, " "))
+	if r.Description != "" {
+		r.release.Set("Description", r.Description)
+	}
+	setReleaseFiles(r.release, files)
+
+	release := r.release.Bytes()
+	if err := repo.WriteObject(r.Store, r.distPath("Release"), release); err != nil {
+		return err
+	}
+	if r.Signer == nil {
+		// stale signatures would make clients reject the repository
+		for _, fn := range []string{"InRelease", "Release.gpg"} {
+			if err := r.Store.DelObject(r.distPath(fn)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	inRelease, err := r.Signer.ClearSign(release)
+	if err != nil {
+		return fmt.Errorf("signing Release failed: %v", err)
+	}
+	signature, err := r.Signer.DetachSign(release)
+	if err != nil {
+		return fmt.Errorf("signing Release failed: %v", err)
+	}
+	if err := repo.WriteObject(r.Store, r.distPath("InRelease"), inRelease); err != nil {
+		return err
+	}
+	if err := repo.WriteObject(r.Store, r.distPath("Release.gpg"), signature); err != nil {
+		return err
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hashFile(data []byte) fileHashes {
+	m, s1, s256 := md5.Sum(data), sha1.Sum(data), sha256.Sum256(data)
+	return fileHashes{
+		size: int64(len(data)),
+		hashes: map[string]string{
+			"MD5Sum": hex.EncodeToString(m[:]),
+			"SHA1":   hex.EncodeToString(s1[:]),
+			"SHA256": hex.EncodeToString(s256[:]),
+		},
+	}
+}
+
+// parseReleaseFiles returns the files listed in the checksum fields of a
+// Release file.
+func parseReleaseFiles(release *Paragraph) map[string]fileHashes {
+	files := make(map[string]fileHashes)
+	for _, field := range hashFields {
+		for _, line := range strings.Split(release.Get(field), "\n") {
+			f := strings.Fields(line)
+			if len(f) != 3 {
+				continue
+			}
+			size, err := strconv.ParseInt(f[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			fh, exists := files[f[2]]
+			if !exists {
+				fh = fileHashes{size: size, hashes: make(map[string]string)}
+				files[f[2]] = fh
+			}
+			fh.hashes[field] = f[0]
+		}
+	}
+	return files
+}
+
+func setReleaseFiles(release *Paragraph, files map[string]fileHashes) {
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, field := range hashFields {
+		value := ""
+		for _, name := range names {
+			if h := files[name].hashes[field]; h != "" {
+				value += fmt.Sprintf("\n%s %16d %s", h, files[name].size, name)
+			}
+		}
+		release.Del(field)
+		release.Set(field, value)
+	}
+}
@@ -0,0 +1,405 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package goproxy implements the GOPROXY protocol on top of a store. A
+// module version is stored as artifact <module path>/<version> whose only
+// file is the module zip. Stores which keep objects also get go.mod and the
+// version info as <escaped module path>/@v/<version>.mod and .info so they
+// can be served without downloading the zip.
+package goproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/blang/semver"
+	"github.com/mgit-at/arti/store"
+)
+
+var errInvalidPath = errors.New("invalid escaped module path")
+
+// EscapePath converts upper case letters to ! followed by the lower case
+// letter, as the go command does for paths and versions in URLs.
+func EscapePath(p string) string {
+	var b bytes.Buffer
+	for _, r := range p {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// UnescapePath reverses EscapePath.
+func UnescapePath(p string) (string, error) {
+	var b bytes.Buffer
+	bang := false
+	for _, r := range p {
+		switch {
+		case bang:
+			if r < 'a' || r > 'z' {
+				return "", errInvalidPath
+			}
+			b.WriteRune(unicode.ToUpper(r))
+			bang = false
+		case r == '!':
+			bang = true
+		case unicode.IsUpper(r):
+			return "", errInvalidPath
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if bang {
+		return "", errInvalidPath
+	}
+	return b.String(), nil
+}
+
+// ZipName returns the filename used for the module zip of a version.
+func ZipName(version string) string {
+	return version + ".zip"
+}
+
+// Artifact maps a module version onto an artifact.
+func Artifact(module, version string) (store.Artifact, error) {
+	if !strings.HasPrefix(version, "v") {
+		return store.Artifact{}, fmt.Errorf("invalid module version '%s'", version)
+	}
+	v, err := semver.Parse(version[1:])
+	if err != nil {
+		return store.Artifact{}, fmt.Errorf("invalid module version '%s': %v", version, err)
+	}
+	return store.Artifact{Name: module, Version: v}, nil
+}
+
+// IsRequest reports whether p is a path of the GOPROXY protocol.
+func IsRequest(p string) bool {
+	return strings.Contains(p, "/@v/") || strings.HasSuffix(p, "/@latest")
+}
+
+type info struct {
+	Version string
+	Time    time.Time
+}
+
+// Serve answers a GOPROXY request for path p relative to the store.
+func Serve(w http.ResponseWriter, r *http.Request, st store.Store, p string) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var escaped, file string
+	if strings.HasSuffix(p, "/@latest") {
+		escaped = strings.TrimSuffix(p, "/@latest")
+	} else if i := strings.LastIndex(p, "/@v/"); i >= 0 {
+		escaped, file = p[:i], p[i+len("/@v/"):]
+	} else {
+		http.NotFound(w, r)
+		return
+	}
+	module, err := UnescapePath(escaped)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !validModule(module) {
+		http.Error(w, "invalid module path", http.StatusNotFound)
+		return
+	}
+
+	if file == "list" {
+		serveList(w, st, module)
+		return
+	}
+	if file == "" {
+		version, err := latest(st, module)
+		if err != nil {
+			serveError(w, err)
+			return
+		}
+		serveInfo(w, st, module, version)
+		return
+	}
+
+	ext := filepath.Ext(file)
+	version, err := UnescapePath(strings.TrimSuffix(file, ext))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	switch ext {
+	case ".info":
+		serveInfo(w, st, module, version)
+	case ".mod":
+		serveMod(w, st, module, version)
+	case ".zip":
+		serveZip(w, r, st, module, version)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// validModule reports whether p is usable as module path, i.e. it is not
+// empty and contains no empty, . or .. elements. Otherwise a request could
+// list the whole store.
+func validModule(p string) bool {
+	if p == "" {
+		return false
+	}
+	for _, e := range strings.Split(p, "/") {
+		if e == "" || e == "." || e == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func serveError(w http.ResponseWriter, err error) {
+	if err == store.ErrArtifactNotFound {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	log.Println("error:", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+type byVersion []semver.Version
+
+func (v byVersion) Len() int           { return len(v) }
+func (v byVersion) Less(i, j int) bool { return v[i].LT(v[j]) }
+func (v byVersion) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+// versions returns the sorted versions of a module. List also returns
+// artifacts below the module path, e.g. major version suffixes, which are
+// skipped.
+func versions(st store.Store, module string) ([]semver.Version, error) {
+	list, err := st.List(module, nil)
+	if err != nil {
+		return nil, err
+	}
+	var vs []semver.Version
+	for _, v := range list[module] {
+		vs = append(vs, v.Version)
+	}
+	sort.Sort(byVersion(vs))
+	return vs, nil
+}
+
+func serveList(w http.ResponseWriter, st store.Store, module string) {
+	vs, err := versions(st, module)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, v := range vs {
+		fmt.Fprintf(w, "v%s\n", v)
+	}
+}
+
+// latest returns the highest release version or the highest pre-release
+// if there are no releases.
+func latest(st store.Store, module string) (string, error) {
+	vs, err := versions(st, module)
+	if err != nil {
+		return "", err
+	}
+	if len(vs) == 0 {
+		return "", store.ErrArtifactNotFound
+	}
+	for i := len(vs) - 1; i >= 0; i-- {
+		if len(vs[i].Pre) == 0 {
+			return "v" + vs[i].String(), nil
+		}
+	}
+	return "v" + vs[len(vs)-1].String(), nil
+}
+
+// fetch downloads the module zip into a temporary directory which must be
+// removed by the caller.
+func fetch(st store.Store, module, version string) (tmpdir, filename string, err error) {
+	a, err := Artifact(module, version)
+	if err != nil {
+		return "", "", store.ErrArtifactNotFound
+	}
+	if tmpdir, err = ioutil.TempDir("", "arti-goproxy-"); err != nil {
+		return "", "", err
+	}
+	filename = filepath.Join(tmpdir, ZipName(version))
+	if _, err = store.Fetch(st, a, filename); err != nil {
+		os.RemoveAll(tmpdir)
+		return "", "", err
+	}
+	return tmpdir, filename, nil
+}
+
+// sidecarKey returns the key of the object holding go.mod (ext .mod) or
+// the version info (ext .info) of a module version.
+func sidecarKey(module, version, ext string) string {
+	return EscapePath(module) + "/@v/" + EscapePath(version) + ext
+}
+
+func encodeInfo(version string, t time.Time) ([]byte, error) {
+	return json.Marshal(info{Version: version, Time: t})
+}
+
+// readMetadata returns go.mod or the version info of a module version. It
+// uses the objects stored by Upload and only falls back to the module zip
+// if they are missing, e.g. for versions uploaded by older releases.
+func readMetadata(st store.Store, module, version, ext string) ([]byte, error) {
+	if objects, ok := st.(store.ObjectStore); ok {
+		a, err := Artifact(module, version)
+		if err != nil {
+			return nil, store.ErrArtifactNotFound
+		}
+		// the objects outlive artifacts deleted using arti delete
+		if exists, _, err := st.Has(a); err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, store.ErrArtifactNotFound
+		}
+		r, err := objects.GetObject(sidecarKey(module, version, ext))
+		if err == nil {
+			defer r.Close()
+			return ioutil.ReadAll(r)
+		} else if err != store.ErrObjectNotFound {
+			return nil, err
+		}
+	}
+
+	tmpdir, filename, err := fetch(st, module, version)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	mod, t, err := readZip(filename, module, version)
+	if err != nil {
+		return nil, err
+	}
+	if ext == ".mod" {
+		return mod, nil
+	}
+	return encodeInfo(version, t)
+}
+
+func serveInfo(w http.ResponseWriter, st store.Store, module, version string) {
+	data, err := readMetadata(st, module, version, ".info")
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func serveMod(w http.ResponseWriter, st store.Store, module, version string) {
+	data, err := readMetadata(st, module, version, ".mod")
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+func serveZip(w http.ResponseWriter, r *http.Request, st store.Store, module, version string) {
+	tmpdir, filename, err := fetch(st, module, version)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	f, err := os.Open(filename)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/zip")
+	http.ServeContent(w, r, ZipName(version), time.Time{}, f)
+}
+
+// Upload creates the module zip of dir and stores it as artifact along with
+// go.mod and the version info if the store keeps objects.
+func Upload(st store.Store, module, version, dir string) error {
+	a, err := Artifact(module, version)
+	if err != nil {
+		return err
+	}
+	if a.Version.String() != version[1:] {
+		return fmt.Errorf("module version '%s' is not canonical", version)
+	}
+	tmpdir, err := ioutil.TempDir("", "arti-goproxy-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	filename := filepath.Join(tmpdir, ZipName(version))
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = CreateZip(f, module, version, dir, time.Now())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("creating module zip failed: %v", err)
+	}
+	mod, t, err := readZip(filename, module, version)
+	if err != nil {
+		return err
+	}
+	info, err := encodeInfo(version, t)
+	if err != nil {
+		return err
+	}
+	if err = st.Put(a, filename); err != nil {
+		return err
+	}
+
+	objects, ok := st.(store.ObjectStore)
+	if !ok {
+		return nil
+	}
+	for _, ext := range []string{".mod", ".info"} {
+		data := mod
+		if ext == ".info" {
+			data = info
+		}
+		// Serve derives missing objects from the module zip
+		if err = objects.PutObject(sidecarKey(module, version, ext), bytes.NewReader(data)); err != nil {
+			log.Printf("warning: storing %s%s failed: %v", version, ext, err)
+		}
+	}
+	return nil
+}
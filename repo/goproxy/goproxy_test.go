@@ -0,0 +1,261 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goproxy_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgit-at/arti/repo/goproxy"
+	"github.com/mgit-at/arti/store"
+)
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		path, escaped string
+	}{
+		{"github.com/mgit-at/arti", "github.com/mgit-at/arti"},
+		{"github.com/Azure/azure-sdk-for-go", "github.com/!azure/azure-sdk-for-go"},
+		{"example.com/BigCorp/ABC", "example.com/!big!corp/!a!b!c"},
+		{"v1.0.0-RC1", "v1.0.0-!r!c1"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if escaped := goproxy.EscapePath(test.path); escaped != test.escaped {
+			t.Errorf("EscapePath(%q): expected %q, got %q", test.path, test.escaped, escaped)
+		}
+		if p, err := goproxy.UnescapePath(test.escaped); err != nil || p != test.path {
+			t.Errorf("UnescapePath(%q): expected %q, got %q (%v)", test.escaped, test.path, p, err)
+		}
+	}
+
+	for _, invalid := range []string{"github.com/Azure", "example.com/!", "example.com/!!a", "example.com/!1", "example.com/!A"} {
+		if p, err := goproxy.UnescapePath(invalid); err == nil {
+			t.Errorf("UnescapePath(%q): expected an error, got %q", invalid, p)
+		}
+	}
+}
+
+// writeModule creates the source tree of a module in a temporary directory.
+func writeModule(t *testing.T, module string) string {
+	dir, err := ioutil.TempDir("", "arti-goproxy-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"go.mod":              "module " + module + "\n",
+		"main.go":             "package main\n",
+		"pkg/pkg.go":          "package pkg\n",
+		".git/config":         "[core]\n",
+		"pkg/.hg/hgrc":        "[ui]\n",
+		"vendor/modules.txt":  "# vendored\n",
+		"vendor/dep/dep.go":   "package dep\n",
+		"nested/go.mod":       "module " + module + "/nested\n",
+		"nested/nested.go":    "package nested\n",
+		"nested/sub/other.go": "package sub\n",
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCreateZip(t *testing.T) {
+	module := "example.com/Foo/bar"
+	dir := writeModule(t, module)
+	defer os.RemoveAll(dir)
+
+	modTime := time.Date(2016, 11, 23, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	if err := goproxy.CreateZip(&buf, module, "v1.2.3", dir, modTime); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if !f.ModTime().Equal(modTime) {
+			t.Errorf("%s: expected modification time %v, got %v", f.Name, modTime, f.ModTime())
+		}
+	}
+	sort.Strings(names)
+	expected := []string{
+		module + "@v1.2.3/go.mod",
+		module + "@v1.2.3/main.go",
+		module + "@v1.2.3/pkg/pkg.go",
+		module + "@v1.2.3/vendor/modules.txt",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected files %v, got %v", expected, names)
+	}
+}
+
+type response struct {
+	status int
+	body   string
+}
+
+func get(t *testing.T, url string) response {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response{resp.StatusCode, string(body)}
+}
+
+func TestServe(t *testing.T) {
+	module := "example.com/Foo/bar"
+	dir := writeModule(t, module)
+	defer os.RemoveAll(dir)
+
+	st := store.NewMemStore()
+	for _, version := range []string{"v1.0.0", "v1.1.0", "v1.2.0-rc1"} {
+		if err := goproxy.Upload(st, module, version, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := goproxy.Upload(st, module, "v1.01.0", dir); err == nil {
+		t.Error("expected non-canonical version to fail")
+	}
+	if err := goproxy.Upload(st, module+"/v2", "v2.0.0", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		goproxy.Serve(w, r, st, strings.TrimPrefix(r.URL.Path, "/"))
+	}))
+	defer srv.Close()
+
+	escaped := srv.URL + "/example.com/!foo/bar"
+	if r := get(t, escaped+"/@v/list"); r.status != http.StatusOK || r.body != "v1.0.0\nv1.1.0\nv1.2.0-rc1\n" {
+		t.Errorf("list: unexpected response %+v", r)
+	}
+	if r := get(t, escaped+"/@v/v1.1.0.mod"); r.status != http.StatusOK || r.body != "module "+module+"\n" {
+		t.Errorf("mod: unexpected response %+v", r)
+	}
+
+	var latest struct{ Version string }
+	r := get(t, escaped+"/@latest")
+	if err := json.Unmarshal([]byte(r.body), &latest); err != nil || latest.Version != "v1.1.0" {
+		t.Errorf("latest: unexpected response %+v", r)
+	}
+	var info struct {
+		Version string
+		Time    time.Time
+	}
+	r = get(t, escaped+"/@v/v1.2.0-rc1.info")
+	if err := json.Unmarshal([]byte(r.body), &info); err != nil || info.Version != "v1.2.0-rc1" || info.Time.IsZero() {
+		t.Errorf("info: unexpected response %+v", r)
+	}
+
+	r = get(t, escaped+"/@v/v1.0.0.zip")
+	if r.status != http.StatusOK {
+		t.Fatalf("zip: unexpected response %+v", r)
+	}
+	if _, err := zip.NewReader(strings.NewReader(r.body), int64(len(r.body))); err != nil {
+		t.Errorf("zip: %v", err)
+	}
+
+	for _, p := range []string{
+		"/example.com/Foo/bar/@v/list",
+		"/example.com/!foo/bar/@v/v3.0.0.info",
+		"/example.com/!foo/bar/@v/v1.0.0.txt",
+		"/example.com/!foo/bar/@v/1.0.0.mod",
+		"/example.com/!foo/baz/@latest",
+		"/@v/list",
+		"/@latest",
+		"//@v/list",
+		"/example.com//@v/list",
+		"/example.com/../@v/list",
+	} {
+		if r := get(t, srv.URL+p); r.status != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, got %+v", p, r)
+		}
+	}
+}
+
+// TestServeWithoutSidecars checks that go.mod and the version info are
+// derived from the module zip if they haven't been stored next to it, and
+// that they are not served for deleted versions.
+func TestServeWithoutSidecars(t *testing.T) {
+	module := "example.com/foo"
+	dir := writeModule(t, module)
+	defer os.RemoveAll(dir)
+
+	st := store.NewMemStore()
+	for _, version := range []string{"v1.0.0", "v1.1.0"} {
+		if err := goproxy.Upload(st, module, version, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	objects, err := st.ListObjects(module + "/@v/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 4 {
+		t.Fatalf("expected 4 objects, got %v", objects)
+	}
+	for _, ext := range []string{".mod", ".info"} {
+		if err = st.DelObject(module + "/@v/v1.0.0" + ext); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, _ := goproxy.Artifact(module, "v1.1.0")
+	if err = st.Del(a); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		goproxy.Serve(w, r, st, strings.TrimPrefix(r.URL.Path, "/"))
+	}))
+	defer srv.Close()
+
+	if r := get(t, srv.URL+"/"+module+"/@v/v1.0.0.mod"); r.status != http.StatusOK || r.body != "module "+module+"\n" {
+		t.Errorf("mod: unexpected response %+v", r)
+	}
+	if r := get(t, srv.URL+"/"+module+"/@v/v1.0.0.info"); r.status != http.StatusOK || !strings.Contains(r.body, `"Version":"v1.0.0"`) {
+		t.Errorf("info: unexpected response %+v", r)
+	}
+	for _, ext := range []string{".mod", ".info"} {
+		if r := get(t, srv.URL+"/"+module+"/@v/v1.1.0"+ext); r.status != http.StatusNotFound {
+			t.Errorf("%s of deleted version: expected status 404, got %+v", ext, r)
+		}
+	}
+}
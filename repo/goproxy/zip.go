@@ -0,0 +1,162 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxZipSize is the limit of the go command for module zip files.
+const maxZipSize = 500 << 20
+
+var moduleRE = regexp.MustCompile(`(?m)^module\s+"?([^"\s]+)"?\s*$`)
+
+// ModulePath returns the module path declared in go.mod of dir.
+func ModulePath(dir string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return "", fmt.Errorf("Error reading go.mod: %v", err)
+	}
+	m := moduleRE.FindSubmatch(data)
+	if m == nil {
+		return "", fmt.Errorf("go.mod of %s contains no module directive", dir)
+	}
+	return string(m[1]), nil
+}
+
+func isVCSDir(name string) bool {
+	switch name {
+	case ".bzr", ".git", ".hg", ".svn":
+		return true
+	}
+	return false
+}
+
+// isVendored reports whether a file is part of a vendored package, which
+// the go command omits from module zips.
+func isVendored(name string) bool {
+	var i int
+	if strings.HasPrefix(name, "vendor/") {
+		i += len("vendor/")
+	} else if j := strings.Index(name, "/vendor/"); j >= 0 {
+		i += j + len("/vendor/")
+	} else {
+		return false
+	}
+	return strings.Contains(name[i:], "/")
+}
+
+// CreateZip writes the module zip of the source tree dir the same way the
+// go command expects it: all files prefixed with <module>@<version>/,
+// without VCS directories, vendored packages and nested modules.
+func CreateZip(w io.Writer, module, version, dir string, modTime time.Time) error {
+	zw := zip.NewWriter(w)
+	prefix := module + "@" + version + "/"
+	var size int64
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			if rel == "." {
+				return nil
+			}
+			if isVCSDir(fi.Name()) {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(p, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() || isVendored(rel) {
+			return nil
+		}
+		if size += fi.Size(); size > maxZipSize {
+			return fmt.Errorf("module is larger than %d bytes", maxZipSize)
+		}
+
+		h := &zip.FileHeader{Name: prefix + rel, Method: zip.Deflate}
+		h.SetModTime(modTime)
+		f, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(f, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// readZip returns go.mod and the modification time of a module zip. If
+// the module has no go.mod, a minimal one is created as the go command does.
+func readZip(filename, module, version string) (mod []byte, modTime time.Time, err error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, modTime, fmt.Errorf("invalid module zip: %v", err)
+	}
+	defer zr.Close()
+
+	prefix := module + "@" + version + "/"
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, prefix) {
+			return nil, modTime, fmt.Errorf("invalid module zip: unexpected file %s", f.Name)
+		}
+		if t := f.ModTime(); t.After(modTime) {
+			modTime = t
+		}
+		if path.Clean(strings.TrimPrefix(f.Name, prefix)) != "go.mod" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, modTime, err
+		}
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, r)
+		r.Close()
+		if err != nil {
+			return nil, modTime, err
+		}
+		mod = buf.Bytes()
+	}
+	if mod == nil {
+		mod = []byte(fmt.Sprintf("module %s\n", module))
+	}
+	return mod, modTime.UTC(), nil
+}
@@ -0,0 +1,456 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package helm maintains a Helm chart repository within a store. Charts are
+// stored as artifacts, the index.yaml at the root of the bucket lists them.
+// The index entry of every chart is kept as object below index.d/ as well,
+// the index is rebuilt from these objects on every change. Concurrent
+// uploads therefore don't lose entries and an index which failed to be
+// written gets repaired by the next change.
+package helm
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/store"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	IndexFile = "index.yaml"
+	// EntriesPrefix is the prefix of the objects holding the index
+	// entries of single charts.
+	EntriesPrefix = "index.d/"
+
+	// maxIndexUpdates limits how often the index is rebuilt because
+	// entries have been added or removed concurrently.
+	maxIndexUpdates = 10
+)
+
+// Chart is the content of Chart.yaml.
+type Chart map[string]interface{}
+
+func (c Chart) field(key string) string {
+	s, _ := c[key].(string)
+	return s
+}
+
+func (c Chart) Name() string {
+	return c.field("name")
+}
+
+func (c Chart) Version() string {
+	return c.field("version")
+}
+
+// ReadChart extracts Chart.yaml from a packaged chart.
+func ReadChart(filename string) (Chart, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening chart: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a packaged chart: %v", filename, err)
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s: archive contains no Chart.yaml", filename)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid archive: %v", filename, err)
+		}
+		parts := strings.Split(path.Clean(h.Name), "/")
+		if len(parts) != 2 || parts[1] != "Chart.yaml" {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		c := Chart{}
+		if err := yaml.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("%s: invalid Chart.yaml: %v", filename, err)
+		}
+		if c.Name() == "" || c.Version() == "" {
+			return nil, fmt.Errorf("%s: Chart.yaml misses name or version", filename)
+		}
+		return c, nil
+	}
+}
+
+// Index is the index.yaml of a chart repository.
+type Index struct {
+	APIVersion string                              `yaml:"apiVersion"`
+	Entries    map[string][]map[string]interface{} `yaml:"entries"`
+	Generated  string                              `yaml:"generated"`
+}
+
+type entriesByVersion []map[string]interface{}
+
+func (e entriesByVersion) Len() int      { return len(e) }
+func (e entriesByVersion) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e entriesByVersion) Less(i, j int) bool {
+	vi, _ := semver.ParseTolerant(fmt.Sprint(e[i]["version"]))
+	vj, _ := semver.ParseTolerant(fmt.Sprint(e[j]["version"]))
+	return vi.GT(vj)
+}
+
+// Repository is a chart repository within a store.
+type Repository struct {
+	Store store.Store
+	// BaseURL is prepended to the URLs of the charts. Relative URLs, which
+	// helm resolves against the URL of the repository, are used if empty.
+	BaseURL string
+}
+
+func (r *Repository) objects() (store.ObjectStore, error) {
+	return repo.ObjectStore(r.Store)
+}
+
+func (r *Repository) readIndex() (*Index, error) {
+	objects, err := r.objects()
+	if err != nil {
+		return nil, err
+	}
+	idx := &Index{APIVersion: "v1", Entries: make(map[string][]map[string]interface{})}
+	data, found, err := repo.ReadObject(objects, IndexFile)
+	if err != nil || !found {
+		return idx, err
+	}
+	if err := yaml.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", IndexFile, err)
+	}
+	if idx.Entries == nil {
+		idx.Entries = make(map[string][]map[string]interface{})
+	}
+	return idx, nil
+}
+
+// writeIndex replaces index.yaml using a single upload, so clients either
+// see the old or the new index.
+func (r *Repository) writeIndex(idx *Index) error {
+	objects, err := r.objects()
+	if err != nil {
+		return err
+	}
+	for _, entries := range idx.Entries {
+		sort.Stable(entriesByVersion(entries))
+	}
+	idx.Generated = time.Now().UTC().Format(time.RFC3339)
+	data, err := yaml.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return repo.WriteObject(objects, IndexFile, data)
+}
+
+func (r *Repository) entry(c Chart, a store.Artifact, filename, csum string) map[string]interface{} {
+	e := make(map[string]interface{}, len(c)+3)
+	for k, v := range c {
+		e[k] = v
+	}
+	url := path.Join(a.Name, a.Version.String(), filename)
+	if r.BaseURL != "" {
+		url = strings.TrimSuffix(r.BaseURL, "/") + "/" + url
+	}
+	e["urls"] = []string{url}
+	e["created"] = time.Now().UTC().Format(time.RFC3339)
+	if i := strings.Index(csum, store.CSumAlgoSeperator); i >= 0 && csum[:i] == store.CSumAlgoSHA256 {
+		e["digest"] = csum[i+1:]
+	}
+	return e
+}
+
+// entryKey returns the key of the object holding the index entry of a
+// chart version.
+func entryKey(name string, version semver.Version) string {
+	return EntriesPrefix + name + "@" + version.String() + ".yaml"
+}
+
+// keyOf returns the entry key of an index entry or "" if it is invalid.
+func keyOf(e map[string]interface{}) string {
+	name, _ := e["name"].(string)
+	v, err := semver.ParseTolerant(fmt.Sprint(e["version"]))
+	if name == "" || err != nil {
+		return ""
+	}
+	return entryKey(name, v)
+}
+
+func (r *Repository) writeEntry(objects store.ObjectStore, e map[string]interface{}) error {
+	data, err := yaml.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return repo.WriteObject(objects, keyOf(e), data)
+}
+
+// entryKeys returns the keys of all entry objects.
+func entryKeys(objects store.ObjectStore) (map[string]bool, error) {
+	list, err := objects.ListObjects(EntriesPrefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool)
+	for _, o := range list {
+		if strings.HasSuffix(o.Key, ".yaml") {
+			keys[o.Key] = true
+		}
+	}
+	return keys, nil
+}
+
+func sameKeys(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// migrate creates the entry objects of an index which has been written by
+// an older release.
+func (r *Repository) migrate(objects store.ObjectStore) error {
+	keys, err := entryKeys(objects)
+	if err != nil || len(keys) > 0 {
+		return err
+	}
+	idx, err := r.readIndex()
+	if err != nil {
+		return err
+	}
+	for _, entries := range idx.Entries {
+		for _, e := range entries {
+			if keyOf(e) == "" {
+				continue
+			}
+			if err = r.writeEntry(objects, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// updateIndex rebuilds the index from the entry objects. Entries passed in
+// known and those of the current index are used as they are, only entries
+// added by others are downloaded. If entry objects have been added or
+// removed while the index was written, it gets rebuilt.
+func (r *Repository) updateIndex(objects store.ObjectStore, known map[string]map[string]interface{}) error {
+	keys, err := entryKeys(objects)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < maxIndexUpdates; i++ {
+		// the current index only saves downloading the entries, it
+		// may as well be missing or be written concurrently
+		cached := make(map[string]map[string]interface{})
+		if old, err := r.readIndex(); err == nil {
+			for _, entries := range old.Entries {
+				for _, e := range entries {
+					cached[keyOf(e)] = e
+				}
+			}
+		}
+		for k, e := range known {
+			cached[k] = e
+		}
+
+		idx := &Index{APIVersion: "v1", Entries: make(map[string][]map[string]interface{})}
+		complete := true
+		for k := range keys {
+			e, found := cached[k]
+			if !found {
+				data, exists, err := repo.ReadObject(objects, k)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					continue
+				}
+				// entries being written aren't complete yet
+				if err = yaml.Unmarshal(data, &e); err != nil || keyOf(e) != k {
+					complete = false
+					continue
+				}
+			}
+			name, _ := e["name"].(string)
+			idx.Entries[name] = append(idx.Entries[name], e)
+		}
+		if err = r.writeIndex(idx); err != nil {
+			return err
+		}
+
+		current, err := entryKeys(objects)
+		if err != nil {
+			return err
+		}
+		if complete && sameKeys(keys, current) {
+			return nil
+		}
+		keys = current
+	}
+	return fmt.Errorf("%s keeps being modified, giving up", IndexFile)
+}
+
+// checksum returns the checksum of an artifact as stored by arti.
+func (r *Repository) checksum(a store.Artifact, filename string) (string, error) {
+	if rs, ok := r.Store.(store.RawStore); ok {
+		info, err := rs.Stat(a)
+		return info.CSum, err
+	}
+	return store.CalcCSum(filename)
+}
+
+// Put uploads a packaged chart as artifact <name>/<version> and adds it to
+// the index.
+func (r *Repository) Put(filename string) (store.Artifact, error) {
+	c, err := ReadChart(filename)
+	if err != nil {
+		return store.Artifact{}, err
+	}
+	a, err := store.MakeArtifact(c.Name(), c.Version())
+	if err != nil {
+		return a, err
+	}
+	objects, err := r.objects()
+	if err != nil {
+		return a, err
+	}
+	if err = r.migrate(objects); err != nil {
+		return a, err
+	}
+
+	if err := r.Store.Put(a, filename); err != nil {
+		return a, err
+	}
+	csum, err := r.checksum(a, filename)
+	if err != nil {
+		return a, err
+	}
+	e := r.entry(c, a, filepath.Base(filename), csum)
+	if err = r.writeEntry(objects, e); err != nil {
+		return a, err
+	}
+	return a, r.updateIndex(objects, map[string]map[string]interface{}{keyOf(e): e})
+}
+
+// Del removes a chart from the index and deletes the artifact.
+func (r *Repository) Del(a store.Artifact) error {
+	objects, err := r.objects()
+	if err != nil {
+		return err
+	}
+	if err = r.migrate(objects); err != nil {
+		return err
+	}
+	if err = objects.DelObject(entryKey(a.Name, a.Version)); err != nil {
+		return err
+	}
+	if err = r.updateIndex(objects, nil); err != nil {
+		return err
+	}
+	return r.Store.Del(a)
+}
+
+// Reindex recreates the index and its entry objects from all charts in the
+// store. Every artifact is downloaded to read its Chart.yaml, artifacts
+// which are no charts are skipped.
+func (r *Repository) Reindex() error {
+	objects, err := r.objects()
+	if err != nil {
+		return err
+	}
+	list, err := r.Store.List("", nil)
+	if err != nil {
+		return err
+	}
+	tmpdir, err := ioutil.TempDir("", "arti-helm")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	old, err := r.readIndex()
+	if err != nil {
+		return err
+	}
+	known := make(map[string]map[string]interface{})
+	for name, versions := range list {
+		for _, v := range versions {
+			if !strings.HasSuffix(v.Filename, ".tgz") {
+				continue
+			}
+			a := store.Artifact{Name: name, Version: v.Version}
+			filename := filepath.Join(tmpdir, v.Filename)
+			info, err := store.Fetch(r.Store, a, filename)
+			if err != nil {
+				return err
+			}
+			c, err := ReadChart(filename)
+			if err == nil && info.CSum == "" {
+				info.CSum, err = store.CalcCSum(filename)
+			}
+			os.Remove(filename)
+			if err != nil || c.Name() != name {
+				continue
+			}
+			e := r.entry(c, a, v.Filename, info.CSum)
+			for _, oe := range old.Entries[name] {
+				if ov, err := semver.ParseTolerant(fmt.Sprint(oe["version"])); err == nil && ov.Equals(v.Version) && oe["created"] != nil {
+					e["created"] = oe["created"]
+				}
+			}
+			if err = r.writeEntry(objects, e); err != nil {
+				return err
+			}
+			known[keyOf(e)] = e
+		}
+	}
+
+	keys, err := entryKeys(objects)
+	if err != nil {
+		return err
+	}
+	for k := range keys {
+		if known[k] == nil {
+			if err = objects.DelObject(k); err != nil {
+				return err
+			}
+		}
+	}
+	return r.updateIndex(objects, known)
+}
@@ -0,0 +1,248 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helm_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mgit-at/arti/repo/helm"
+	"github.com/mgit-at/arti/store"
+	"gopkg.in/yaml.v2"
+)
+
+// writeChart creates a packaged chart in dir containing only Chart.yaml.
+func writeChart(t *testing.T, dir, name, version string) string {
+	filename := filepath.Join(dir, fmt.Sprintf("%s-%s.tgz", name, version))
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	data := fmt.Sprintf("apiVersion: v1\nname: %s\nversion: %s\ndescription: test chart\n", name, version)
+	tw.WriteHeader(&tar.Header{Name: name + "/Chart.yaml", Mode: 0644, Size: int64(len(data))})
+	tw.Write([]byte(data))
+	tw.Close()
+	zw.Close()
+	return filename
+}
+
+func readIndex(t *testing.T, st *store.FSStore) *helm.Index {
+	r, err := st.GetObject(helm.IndexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := &helm.Index{}
+	if err = yaml.Unmarshal(data, idx); err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+// versions returns the indexed versions of every chart.
+func versions(idx *helm.Index) map[string][]string {
+	vs := make(map[string][]string)
+	for name, entries := range idx.Entries {
+		for _, e := range entries {
+			vs[name] = append(vs[name], fmt.Sprint(e["version"]))
+		}
+	}
+	return vs
+}
+
+func TestReadChart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-helm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := helm.ReadChart(writeChart(t, dir, "app", "1.2.3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name() != "app" || c.Version() != "1.2.3" || c["description"] != "test chart" {
+		t.Errorf("unexpected chart %v", c)
+	}
+
+	invalid := filepath.Join(dir, "invalid.tgz")
+	ioutil.WriteFile(invalid, []byte("no chart"), 0644)
+	if _, err = helm.ReadChart(invalid); err == nil {
+		t.Error("expected an error for an invalid archive")
+	}
+}
+
+func TestRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-helm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	st := store.NewMemStore()
+	r := &helm.Repository{Store: st, BaseURL: "https://charts.example.com/"}
+	for _, c := range []struct{ name, version string }{{"app", "1.0.0"}, {"app", "1.1.0"}, {"db", "0.1.0"}} {
+		if _, err = r.Put(writeChart(t, dir, c.name, c.version)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	idx := readIndex(t, st)
+	expected := map[string][]string{"app": {"1.1.0", "1.0.0"}, "db": {"0.1.0"}}
+	if vs := versions(idx); !reflect.DeepEqual(vs, expected) {
+		t.Fatalf("expected versions %v, got %v", expected, vs)
+	}
+	e := idx.Entries["app"][1]
+	if urls := fmt.Sprint(e["urls"]); urls != "[https://charts.example.com/app/1.0.0/app-1.0.0.tgz]" {
+		t.Errorf("unexpected urls %s", urls)
+	}
+	a, _ := store.MakeArtifact("app", "1.0.0")
+	info, err := st.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest := fmt.Sprint(e["digest"]); !strings.HasSuffix(info.CSum, ":"+digest) {
+		t.Errorf("digest %s doesn't match checksum %s", digest, info.CSum)
+	}
+
+	if err = r.Del(a); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string][]string{"app": {"1.1.0"}, "db": {"0.1.0"}}
+	if vs := versions(readIndex(t, st)); !reflect.DeepEqual(vs, expected) {
+		t.Errorf("expected versions %v after delete, got %v", expected, vs)
+	}
+	if exists, _, _ := st.Has(a); exists {
+		t.Error("deleted chart still exists")
+	}
+
+	// a lost index gets recreated by the next change
+	if err = st.DelObject(helm.IndexFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Put(writeChart(t, dir, "db", "0.2.0")); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string][]string{"app": {"1.1.0"}, "db": {"0.2.0", "0.1.0"}}
+	if vs := versions(readIndex(t, st)); !reflect.DeepEqual(vs, expected) {
+		t.Errorf("expected versions %v after losing the index, got %v", expected, vs)
+	}
+
+	// reindex drops entries of charts deleted behind its back
+	b, _ := store.MakeArtifact("db", "0.1.0")
+	if err = st.Del(b); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string][]string{"app": {"1.1.0"}, "db": {"0.2.0"}}
+	if vs := versions(readIndex(t, st)); !reflect.DeepEqual(vs, expected) {
+		t.Errorf("expected versions %v after reindex, got %v", expected, vs)
+	}
+	objects, err := st.ListObjects(helm.EntriesPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Errorf("expected 2 entry objects after reindex, got %v", objects)
+	}
+}
+
+func TestRepositoryMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-helm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	st := store.NewMemStore()
+	r := &helm.Repository{Store: st}
+	if _, err = r.Put(writeChart(t, dir, "app", "1.0.0")); err != nil {
+		t.Fatal(err)
+	}
+	// an index written by an older release has no entry objects
+	if err = st.DelObject(helm.EntriesPrefix + "app@1.0.0.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Put(writeChart(t, dir, "app", "2.0.0")); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{"app": {"2.0.0", "1.0.0"}}
+	if vs := versions(readIndex(t, st)); !reflect.DeepEqual(vs, expected) {
+		t.Errorf("expected versions %v, got %v", expected, vs)
+	}
+}
+
+func TestRepositoryConcurrentPut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-helm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	st := store.NewMemStore()
+	r := &helm.Repository{Store: st}
+	if _, err = r.Put(writeChart(t, dir, "base", "1.0.0")); err != nil {
+		t.Fatal(err)
+	}
+
+	var charts []string
+	for i := 0; i < 10; i++ {
+		charts = append(charts, writeChart(t, dir, fmt.Sprintf("app%d", i), "1.0.0"))
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(charts))
+	for _, c := range charts {
+		wg.Add(1)
+		go func(c string) {
+			defer wg.Done()
+			if _, err := r.Put(c); err != nil {
+				errs <- err
+			}
+		}(c)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	var names []string
+	for name := range readIndex(t, st).Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := []string{"app0", "app1", "app2", "app3", "app4", "app5", "app6", "app7", "app8", "app9", "base"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected charts %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,364 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package maven publishes artifacts using the layout of a Maven repository,
+// so Maven and Gradle are able to resolve them from a store.
+package maven
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/store"
+)
+
+const MetadataFile = "maven-metadata.xml"
+
+// Coordinates identify a version of an artifact in a Maven repository.
+type Coordinates struct {
+	GroupID    string
+	ArtifactID string
+	Version    string
+}
+
+// ParseCoordinates converts an artifact name of the form groupId:artifactId
+// and a version into coordinates.
+func ParseCoordinates(name, version string) (Coordinates, error) {
+	ga := strings.Split(name, ":")
+	if len(ga) != 2 || ga[0] == "" || ga[1] == "" {
+		return Coordinates{}, fmt.Errorf("invalid maven artifact name '%s', expected groupId:artifactId", name)
+	}
+	c := Coordinates{GroupID: ga[0], ArtifactID: ga[1], Version: version}
+	if err := c.Validate(); err != nil {
+		return Coordinates{}, err
+	}
+	return c, nil
+}
+
+// validSegment tells whether s may be used as a directory or part of a
+// filename of the repository layout.
+func validSegment(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, "/\\:")
+}
+
+// Validate checks that the coordinates map to the repository layout, i.e.
+// that the files of the version are kept directly below the directory of
+// the artifact.
+func (c Coordinates) Validate() error {
+	for _, g := range strings.Split(c.GroupID, ".") {
+		if !validSegment(g) {
+			return fmt.Errorf("invalid maven groupId '%s'", c.GroupID)
+		}
+	}
+	if !validSegment(c.ArtifactID) {
+		return fmt.Errorf("invalid maven artifactId '%s'", c.ArtifactID)
+	}
+	if !validSegment(c.Version) {
+		return fmt.Errorf("invalid maven version '%s'", c.Version)
+	}
+	if path.Dir(c.VersionDir()) != c.Dir() {
+		return fmt.Errorf("invalid maven coordinates %s:%s:%s", c.GroupID, c.ArtifactID, c.Version)
+	}
+	return nil
+}
+
+// Dir returns the directory of the artifact which holds maven-metadata.xml.
+func (c Coordinates) Dir() string {
+	return path.Join(strings.Replace(c.GroupID, ".", "/", -1), c.ArtifactID)
+}
+
+// VersionDir returns the directory which holds the files of the version.
+func (c Coordinates) VersionDir() string {
+	return path.Join(c.Dir(), c.Version)
+}
+
+// Filename returns the name of a file of the version.
+func (c Coordinates) Filename(classifier, extension string) string {
+	name := c.ArtifactID + "-" + c.Version
+	if classifier != "" {
+		name += "-" + classifier
+	}
+	return name + "." + extension
+}
+
+func (c Coordinates) IsSnapshot() bool {
+	return strings.HasSuffix(c.Version, "-SNAPSHOT")
+}
+
+// File is a file to be deployed for a version.
+type File struct {
+	Path       string
+	Classifier string
+	Extension  string
+}
+
+var doubleExts = []string{"tar.gz", "tar.bz2", "tar.xz"}
+
+// ClassifyFile derives classifier and extension from the name of a file.
+// Files named like <artifactId>-<version>-<classifier>.<ext> get this
+// classifier, all other files are the main artifact or its pom.
+func (c Coordinates) ClassifyFile(filename string) File {
+	base := filepath.Base(filename)
+	f := File{Path: filename, Extension: strings.TrimPrefix(path.Ext(base), ".")}
+	for _, ext := range doubleExts {
+		if strings.HasSuffix(base, "."+ext) {
+			f.Extension = ext
+		}
+	}
+	name := strings.TrimSuffix(base, "."+f.Extension)
+	prefix := c.ArtifactID + "-" + c.Version + "-"
+	if strings.HasPrefix(name, prefix) {
+		f.Classifier = strings.TrimPrefix(name, prefix)
+	}
+	return f
+}
+
+// checksumAlgos are the checksum files written next to every file.
+var checksumAlgos = []struct {
+	ext string
+	new func() hash.Hash
+}{
+	{"md5", md5.New},
+	{"sha1", sha1.New},
+	{"sha256", sha256.New},
+	{"sha512", sha512.New},
+}
+
+// Repository is a Maven repository within a store.
+type Repository struct {
+	Store store.ObjectStore
+}
+
+// put uploads a file together with its checksum files.
+func (r *Repository) put(key string, data io.Reader) error {
+	hashes := make([]hash.Hash, len(checksumAlgos))
+	writers := make([]io.Writer, len(checksumAlgos))
+	for i, algo := range checksumAlgos {
+		hashes[i] = algo.new()
+		writers[i] = hashes[i]
+	}
+	if err := r.Store.PutObject(key, io.TeeReader(data, io.MultiWriter(writers...))); err != nil {
+		return err
+	}
+	for i, algo := range checksumAlgos {
+		sum := hex.EncodeToString(hashes[i].Sum(nil))
+		if err := repo.WriteObject(r.Store, key+"."+algo.ext, []byte(sum)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) putFile(key, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("Error opening file: %v", err)
+	}
+	defer f.Close()
+	return r.put(key, f)
+}
+
+const pomTmpl = `<?xml version="1.0" encoding="UTF-8"?>
+<project xmlns="http://maven.apache.org/POM/4.0.0" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://maven.apache.org/POM/4.0.0 http://maven.apache.org/xsd/maven-4.0.0.xsd">
+  <modelVersion>4.0.0</modelVersion>
+  <groupId>%s</groupId>
+  <artifactId>%s</artifactId>
+  <version>%s</version>
+  <packaging>%s</packaging>
+</project>
+`
+
+func escapeXML(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// Deploy uploads the files of a version and adds the version to the
+// metadata. A minimal pom is created unless one of the files is a pom.
+// Released versions may not be overwritten, snapshots are stored without
+// timestamps and replaced on every deploy.
+func (r *Repository) Deploy(c Coordinates, files []File) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no files to deploy")
+	}
+	md, err := r.readMetadata(c)
+	if err != nil {
+		return err
+	}
+	if !c.IsSnapshot() && md.hasVersion(c.Version) {
+		return fmt.Errorf("version %s of %s:%s already exists", c.Version, c.GroupID, c.ArtifactID)
+	}
+
+	packaging := ""
+	hasPOM := false
+	targets := make(map[string]bool)
+	for _, f := range files {
+		target := c.Filename(f.Classifier, f.Extension)
+		if targets[target] {
+			return fmt.Errorf("more than one file would be deployed as %s", target)
+		}
+		targets[target] = true
+		if f.Classifier == "" && f.Extension == "pom" {
+			hasPOM = true
+		} else if f.Classifier == "" && packaging == "" {
+			packaging = f.Extension
+		}
+	}
+	for _, f := range files {
+		key := path.Join(c.VersionDir(), c.Filename(f.Classifier, f.Extension))
+		if err := r.putFile(key, f.Path); err != nil {
+			return err
+		}
+	}
+	if !hasPOM {
+		if packaging == "" {
+			packaging = "pom"
+		}
+		pom := fmt.Sprintf(pomTmpl, escapeXML(c.GroupID), escapeXML(c.ArtifactID), escapeXML(c.Version), escapeXML(packaging))
+		if err := r.put(path.Join(c.VersionDir(), c.Filename("", "pom")), strings.NewReader(pom)); err != nil {
+			return err
+		}
+	}
+
+	md.addVersion(c.Version)
+	return r.writeMetadata(c, md)
+}
+
+// Delete removes all files of a version and the version from the metadata.
+func (r *Repository) Delete(c Coordinates) error {
+	// a version directory outside of the artifact would delete other files
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	md, err := r.readMetadata(c)
+	if err != nil {
+		return err
+	}
+	objects, err := r.Store.ListObjects(c.VersionDir() + "/")
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 && !md.hasVersion(c.Version) {
+		return store.ErrArtifactNotFound
+	}
+	for _, o := range objects {
+		if err := r.Store.DelObject(o.Key); err != nil {
+			return err
+		}
+	}
+	md.removeVersion(c.Version)
+	return r.writeMetadata(c, md)
+}
+
+type metadata struct {
+	XMLName    xml.Name `xml:"metadata"`
+	GroupID    string   `xml:"groupId"`
+	ArtifactID string   `xml:"artifactId"`
+	Versioning struct {
+		Latest      string   `xml:"latest,omitempty"`
+		Release     string   `xml:"release,omitempty"`
+		Versions    []string `xml:"versions>version"`
+		LastUpdated string   `xml:"lastUpdated"`
+	} `xml:"versioning"`
+}
+
+func (md *metadata) hasVersion(version string) bool {
+	for _, v := range md.Versioning.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// addVersion appends a version, the last deployed version becomes latest
+// and, unless it is a snapshot, release.
+func (md *metadata) addVersion(version string) {
+	md.removeVersion(version)
+	md.Versioning.Versions = append(md.Versioning.Versions, version)
+	md.update()
+}
+
+func (md *metadata) removeVersion(version string) {
+	kept := []string{}
+	for _, v := range md.Versioning.Versions {
+		if v != version {
+			kept = append(kept, v)
+		}
+	}
+	md.Versioning.Versions = kept
+	md.update()
+}
+
+func (md *metadata) update() {
+	md.Versioning.Latest, md.Versioning.Release = "", ""
+	for _, v := range md.Versioning.Versions {
+		md.Versioning.Latest = v
+		if !strings.HasSuffix(v, "-SNAPSHOT") {
+			md.Versioning.Release = v
+		}
+	}
+	md.Versioning.LastUpdated = time.Now().UTC().Format("20060102150405")
+}
+
+func (r *Repository) readMetadata(c Coordinates) (*metadata, error) {
+	md := &metadata{GroupID: c.GroupID, ArtifactID: c.ArtifactID}
+	data, found, err := repo.ReadObject(r.Store, path.Join(c.Dir(), MetadataFile))
+	if err != nil || !found {
+		return md, err
+	}
+	if err := xml.Unmarshal(data, md); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", MetadataFile, err)
+	}
+	return md, nil
+}
+
+func (r *Repository) writeMetadata(c Coordinates, md *metadata) error {
+	key := path.Join(c.Dir(), MetadataFile)
+	if len(md.Versioning.Versions) == 0 {
+		if err := r.Store.DelObject(key); err != nil {
+			return err
+		}
+		for _, algo := range checksumAlgos {
+			if err := r.Store.DelObject(key + "." + algo.ext); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	data, err := xml.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	return r.put(key, bytes.NewReader(data))
+}
@@ -0,0 +1,237 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maven_test
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/repo/maven"
+	"github.com/mgit-at/arti/store"
+)
+
+func readObject(t *testing.T, s store.ObjectStore, key string) string {
+	data, found, err := repo.ReadObject(s, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatalf("object %s not found", key)
+	}
+	return string(data)
+}
+
+func hasObject(t *testing.T, s store.ObjectStore, key string) bool {
+	_, found, err := repo.ReadObject(s, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func coordinates(t *testing.T, name, version string) maven.Coordinates {
+	c, err := maven.ParseCoordinates(name, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestParseCoordinates(t *testing.T) {
+	c := coordinates(t, "com.example:lib", "1.0.0")
+	if c.Dir() != "com/example/lib" || c.VersionDir() != "com/example/lib/1.0.0" {
+		t.Errorf("unexpected directories %s and %s", c.Dir(), c.VersionDir())
+	}
+
+	invalid := []struct {
+		name, version string
+	}{
+		{"com.example", "1.0.0"},
+		{"a:b:c", "1.0.0"},
+		{":lib", "1.0.0"},
+		{"com.example:", "1.0.0"},
+		{"com..example:lib", "1.0.0"},
+		{".example:lib", "1.0.0"},
+		{"com.example.:lib", "1.0.0"},
+		{"com/example:lib", "1.0.0"},
+		{"com.example:..", "1.0.0"},
+		{"com.example:.", "1.0.0"},
+		{"com.example:lib/x", "1.0.0"},
+		{"com.example:lib", ""},
+		{"com.example:lib", "."},
+		{"com.example:lib", ".."},
+		{"com.example:lib", "1/2"},
+		{"com.example:lib", "../../other"},
+		{"com.example:lib", `1\2`},
+	}
+	for _, test := range invalid {
+		if c, err := maven.ParseCoordinates(test.name, test.version); err == nil {
+			t.Errorf("%s %s: expected an error, got %+v", test.name, test.version, c)
+		}
+	}
+}
+
+func TestDeploy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-maven-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := store.NewMemStore()
+	r := &maven.Repository{Store: s}
+
+	c := coordinates(t, "com.example:lib", "1.0.0")
+	files := []maven.File{
+		c.ClassifyFile(writeFile(t, dir, "lib.jar", "jar")),
+		c.ClassifyFile(writeFile(t, dir, "lib-1.0.0-sources.jar", "sources")),
+	}
+	if err = r.Deploy(c, files); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, content := range map[string]string{
+		"com/example/lib/1.0.0/lib-1.0.0.jar":         "jar",
+		"com/example/lib/1.0.0/lib-1.0.0-sources.jar": "sources",
+	} {
+		if got := readObject(t, s, key); got != content {
+			t.Errorf("%s: expected %q, got %q", key, content, got)
+		}
+		for ext, h := range map[string]hash.Hash{"md5": md5.New(), "sha1": sha1.New(), "sha256": sha256.New(), "sha512": sha512.New()} {
+			h.Write([]byte(content))
+			if sum := readObject(t, s, key+"."+ext); sum != hex.EncodeToString(h.Sum(nil)) {
+				t.Errorf("%s.%s: unexpected checksum %s", key, ext, sum)
+			}
+		}
+	}
+	pom := readObject(t, s, "com/example/lib/1.0.0/lib-1.0.0.pom")
+	for _, expected := range []string{"<groupId>com.example</groupId>", "<artifactId>lib</artifactId>", "<version>1.0.0</version>", "<packaging>jar</packaging>"} {
+		if !strings.Contains(pom, expected) {
+			t.Errorf("pom does not contain %s:\n%s", expected, pom)
+		}
+	}
+	if !hasObject(t, s, "com/example/lib/1.0.0/lib-1.0.0.pom.sha1") {
+		t.Error("pom has no checksum file")
+	}
+
+	if err = r.Deploy(c, files[:1]); err == nil {
+		t.Error("a released version has been deployed twice")
+	}
+
+	snapshot := coordinates(t, "com.example:lib", "1.1.0-SNAPSHOT")
+	for _, content := range []string{"first", "second"} {
+		if err = r.Deploy(snapshot, []maven.File{snapshot.ClassifyFile(writeFile(t, dir, "lib.jar", content))}); err != nil {
+			t.Fatal(err)
+		}
+		if got := readObject(t, s, "com/example/lib/1.1.0-SNAPSHOT/lib-1.1.0-SNAPSHOT.jar"); got != content {
+			t.Errorf("snapshot: expected %q, got %q", content, got)
+		}
+	}
+
+	md := readObject(t, s, "com/example/lib/"+maven.MetadataFile)
+	for _, expected := range []string{"<version>1.0.0</version>", "<version>1.1.0-SNAPSHOT</version>", "<latest>1.1.0-SNAPSHOT</latest>", "<release>1.0.0</release>"} {
+		if !strings.Contains(md, expected) {
+			t.Errorf("metadata does not contain %s:\n%s", expected, md)
+		}
+	}
+	if strings.Count(md, "<version>1.1.0-SNAPSHOT</version>") != 1 {
+		t.Errorf("snapshot has been added more than once:\n%s", md)
+	}
+	if !hasObject(t, s, "com/example/lib/"+maven.MetadataFile+".sha1") {
+		t.Error("metadata has no checksum file")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-maven-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := store.NewMemStore()
+	r := &maven.Repository{Store: s}
+
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		c := coordinates(t, "com.example:lib", version)
+		if err = r.Deploy(c, []maven.File{c.ClassifyFile(writeFile(t, dir, "lib.jar", version))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.PutObject("com/example/other.txt", strings.NewReader("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	// coordinates which do not stay below the artifact are refused
+	for _, version := range []string{".", "..", "1.0.0/.."} {
+		c := maven.Coordinates{GroupID: "com.example", ArtifactID: "lib", Version: version}
+		if err = r.Delete(c); err == nil {
+			t.Errorf("version %q: expected an error", version)
+		}
+	}
+	if err = r.Delete(maven.Coordinates{GroupID: "com", ArtifactID: "..", Version: "example"}); err == nil {
+		t.Error("artifactId ..: expected an error")
+	}
+	for _, key := range []string{"com/example/lib/1.0.0/lib-1.0.0.jar", "com/example/lib/1.1.0/lib-1.1.0.jar", "com/example/other.txt"} {
+		if !hasObject(t, s, key) {
+			t.Errorf("%s has been deleted", key)
+		}
+	}
+
+	if err = r.Delete(coordinates(t, "com.example:lib", "1.1.0")); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := s.ListObjects("com/example/lib/1.1.0/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("files of the version have not been deleted: %v", objects)
+	}
+	md := readObject(t, s, "com/example/lib/"+maven.MetadataFile)
+	if strings.Contains(md, "1.1.0") || !strings.Contains(md, "<latest>1.0.0</latest>") || !strings.Contains(md, "<release>1.0.0</release>") {
+		t.Errorf("unexpected metadata:\n%s", md)
+	}
+	if !hasObject(t, s, "com/example/lib/1.0.0/lib-1.0.0.jar") {
+		t.Error("other versions have been deleted")
+	}
+
+	if err = r.Delete(coordinates(t, "com.example:lib", "2.0.0")); err != store.ErrArtifactNotFound {
+		t.Errorf("missing version: expected %v, got %v", store.ErrArtifactNotFound, err)
+	}
+
+	if err = r.Delete(coordinates(t, "com.example:lib", "1.0.0")); err != nil {
+		t.Fatal(err)
+	}
+	if hasObject(t, s, "com/example/lib/"+maven.MetadataFile) {
+		t.Error("metadata without versions has not been deleted")
+	}
+}
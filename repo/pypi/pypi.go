@@ -0,0 +1,257 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pypi publishes Python distributions together with a PEP 503
+// simple index. Files are stored below packages/<project>/ next to an arti
+// checksum file, the index pages are static objects below simple/.
+package pypi
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/store"
+)
+
+var normalizeRE = regexp.MustCompile(`[-_.]+`)
+
+// Normalize returns the normalized project name as defined by PEP 503.
+func Normalize(name string) string {
+	return strings.ToLower(normalizeRE.ReplaceAllString(name, "-"))
+}
+
+var sdistExts = []string{".tar.gz", ".tar.bz2", ".tgz", ".zip"}
+
+// ParseFilename returns project name and version of a wheel or sdist.
+func ParseFilename(filename string) (name, version string, err error) {
+	base := path.Base(filename)
+	if strings.HasSuffix(base, ".whl") {
+		parts := strings.Split(strings.TrimSuffix(base, ".whl"), "-")
+		if len(parts) < 5 {
+			return "", "", fmt.Errorf("invalid wheel filename '%s'", base)
+		}
+		return parts[0], parts[1], nil
+	}
+	for _, ext := range sdistExts {
+		if !strings.HasSuffix(base, ext) {
+			continue
+		}
+		nv := strings.TrimSuffix(base, ext)
+		i := strings.LastIndex(nv, "-")
+		if i <= 0 || i == len(nv)-1 {
+			break
+		}
+		return nv[:i], nv[i+1:], nil
+	}
+	return "", "", fmt.Errorf("'%s' is neither a wheel nor a source distribution", base)
+}
+
+// Repository is a package index within a store.
+type Repository struct {
+	Store store.ObjectStore
+	// Force allows Add to replace files which are part of the index
+	// already. Installers cache files by name, so replacing them is
+	// usually a mistake.
+	Force bool
+}
+
+func packagePath(project, filename string) string {
+	return path.Join("packages", project, filename)
+}
+
+// exists reports whether the object key exists.
+func (r *Repository) exists(key string) (bool, error) {
+	obj, err := r.Store.GetObject(key)
+	if err == store.ErrObjectNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	obj.Close()
+	return true, nil
+}
+
+// Add uploads distribution files and updates the index pages. Unless Force
+// is set nothing gets uploaded if one of the files exists already.
+func (r *Repository) Add(files ...string) error {
+	keys := make(map[string]string)
+	for _, filename := range files {
+		name, _, err := ParseFilename(filename)
+		if err != nil {
+			return err
+		}
+		key := packagePath(Normalize(name), filepath.Base(filename))
+		if !r.Force {
+			if exists, err := r.exists(key); err != nil {
+				return err
+			} else if exists {
+				return fmt.Errorf("'%s' exists already", filepath.Base(filename))
+			}
+		}
+		keys[filename] = key
+	}
+
+	projects := make(map[string]bool)
+	for _, filename := range files {
+		key := keys[filename]
+		project := path.Base(path.Dir(key))
+		csum, err := store.CalcCSum(filename)
+		if err != nil {
+			return err
+		}
+		if err := repo.UploadFile(r.Store, key, filename); err != nil {
+			return err
+		}
+		if err := repo.WriteObject(r.Store, key+store.CSumExt, []byte(csum)); err != nil {
+			return err
+		}
+		projects[project] = true
+	}
+	for project := range projects {
+		if err := r.writeProject(project); err != nil {
+			return err
+		}
+	}
+	return r.writeRoot()
+}
+
+// Remove deletes all files of a project, or only those of version if not
+// empty, and returns their names.
+func (r *Repository) Remove(name, version string) ([]string, error) {
+	project := Normalize(name)
+	objects, err := r.Store.ListObjects(packagePath(project, "") + "/")
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, o := range objects {
+		filename := path.Base(o.Key)
+		if store.IsSidecar(filename) {
+			continue
+		}
+		if _, v, err := ParseFilename(filename); err != nil || (version != "" && v != version) {
+			continue
+		}
+		if err := r.Store.DelObject(o.Key); err != nil {
+			return removed, err
+		}
+		if err := r.Store.DelObject(o.Key + store.CSumExt); err != nil {
+			return removed, err
+		}
+		removed = append(removed, filename)
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	if err := r.writeProject(project); err != nil {
+		return removed, err
+	}
+	return removed, r.writeRoot()
+}
+
+const pageTmpl = `<!DOCTYPE html>
+<html>
+  <head>
+    <meta name="pypi:repository-version" content="1.0">
+    <title>{{.Title}}</title>
+  </head>
+  <body>
+    <h1>{{.Title}}</h1>
+{{- range .Links}}
+    <a href="{{.Href}}">{{.Name}}</a><br/>
+{{- end}}
+  </body>
+</html>
+`
+
+var page = template.Must(template.New("page").Parse(pageTmpl))
+
+type link struct {
+	Name string
+	Href string
+}
+
+type linksByName []link
+
+func (l linksByName) Len() int           { return len(l) }
+func (l linksByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+func (l linksByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+func (r *Repository) writePage(key, title string, links []link) error {
+	sort.Sort(linksByName(links))
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, struct {
+		Title string
+		Links []link
+	}{title, links}); err != nil {
+		return err
+	}
+	return repo.WriteObject(r.Store, key, buf.Bytes())
+}
+
+// writeProject recreates simple/<project>/index.html. The links carry the
+// sha256 of the files taken from their checksum files.
+func (r *Repository) writeProject(project string) error {
+	objects, err := r.Store.ListObjects(packagePath(project, "") + "/")
+	if err != nil {
+		return err
+	}
+	var links []link
+	for _, o := range objects {
+		filename := path.Base(o.Key)
+		if store.IsSidecar(filename) {
+			continue
+		}
+		href := "../../" + packagePath(project, filename)
+		csum, _, err := repo.ReadObject(r.Store, o.Key+store.CSumExt)
+		if err != nil {
+			return err
+		}
+		if algoHash := strings.SplitN(string(csum), store.CSumAlgoSeperator, 2); len(algoHash) == 2 {
+			href += "#" + algoHash[0] + "=" + strings.TrimSpace(algoHash[1])
+		}
+		links = append(links, link{Name: filename, Href: href})
+	}
+	key := path.Join("simple", project, "index.html")
+	if len(links) == 0 {
+		return r.Store.DelObject(key)
+	}
+	return r.writePage(key, "Links for "+project, links)
+}
+
+// writeRoot recreates simple/index.html listing all projects.
+func (r *Repository) writeRoot() error {
+	objects, err := r.Store.ListObjects("packages/")
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	var links []link
+	for _, o := range objects {
+		parts := strings.Split(o.Key, "/")
+		if len(parts) != 3 || seen[parts[1]] {
+			continue
+		}
+		seen[parts[1]] = true
+		links = append(links, link{Name: parts[1], Href: parts[1] + "/"})
+	}
+	return r.writePage("simple/index.html", "Simple index", links)
+}
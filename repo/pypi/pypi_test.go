@@ -0,0 +1,165 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pypi_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mgit-at/arti/repo/pypi"
+	"github.com/mgit-at/arti/store"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"mypkg":           "mypkg",
+		"My_Pkg":          "my-pkg",
+		"my.pkg":          "my-pkg",
+		"My--Pkg__.Tools": "my-pkg-tools",
+		"zope.interface":  "zope-interface",
+	}
+	for name, expected := range tests {
+		if n := pypi.Normalize(name); n != expected {
+			t.Errorf("Normalize(%q): expected %q, got %q", name, expected, n)
+		}
+	}
+}
+
+func TestParseFilename(t *testing.T) {
+	tests := []struct {
+		filename, name, version string
+	}{
+		{"mypkg-1.2.3-py3-none-any.whl", "mypkg", "1.2.3"},
+		{"dist/my_pkg-0.1.dev1-1-cp39-cp39-manylinux1_x86_64.whl", "my_pkg", "0.1.dev1"},
+		{"mypkg-1.2.3.tar.gz", "mypkg", "1.2.3"},
+		{"my-pkg-1.2.3.tar.bz2", "my-pkg", "1.2.3"},
+		{"my-pkg-2.0rc1.zip", "my-pkg", "2.0rc1"},
+		{"mypkg-1.0.tgz", "mypkg", "1.0"},
+	}
+	for _, test := range tests {
+		name, version, err := pypi.ParseFilename(test.filename)
+		if err != nil || name != test.name || version != test.version {
+			t.Errorf("ParseFilename(%q): expected %s %s, got %s %s (%v)", test.filename, test.name, test.version, name, version, err)
+		}
+	}
+
+	for _, invalid := range []string{"mypkg.whl", "mypkg-1.0.whl", "mypkg.tar.gz", "mypkg-.tar.gz", "-1.0.tar.gz", "mypkg-1.0.rpm"} {
+		if name, version, err := pypi.ParseFilename(invalid); err == nil {
+			t.Errorf("ParseFilename(%q): expected an error, got %s %s", invalid, name, version)
+		}
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func readObject(t *testing.T, st store.ObjectStore, key string) string {
+	r, err := st.GetObject(key)
+	if err != nil {
+		t.Fatalf("%s: %v", key, err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-pypi-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	st := store.NewMemStore()
+	r := &pypi.Repository{Store: st}
+	wheel := writeFile(t, dir, "My_Pkg-1.0-py3-none-any.whl", "wheel")
+	sdist := writeFile(t, dir, "My_Pkg-1.0.tar.gz", "sdist")
+	other := writeFile(t, dir, "other-2.0.zip", "other")
+	if err = r.Add(wheel, sdist, other); err != nil {
+		t.Fatal(err)
+	}
+
+	root := readObject(t, st, "simple/index.html")
+	for _, l := range []string{`<a href="my-pkg/">my-pkg</a>`, `<a href="other/">other</a>`} {
+		if !strings.Contains(root, l) {
+			t.Errorf("root page misses %s:\n%s", l, root)
+		}
+	}
+	csum, err := store.CalcCSum(wheel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := strings.SplitN(csum, store.CSumAlgoSeperator, 2)[1]
+	project := readObject(t, st, "simple/my-pkg/index.html")
+	for _, l := range []string{
+		`<title>Links for my-pkg</title>`,
+		`<a href="../../packages/my-pkg/My_Pkg-1.0-py3-none-any.whl#sha256=` + hash + `">My_Pkg-1.0-py3-none-any.whl</a>`,
+		`<a href="../../packages/my-pkg/My_Pkg-1.0.tar.gz#sha256=`,
+	} {
+		if !strings.Contains(project, l) {
+			t.Errorf("project page misses %s:\n%s", l, project)
+		}
+	}
+
+	// existing files are only replaced if forced
+	replaced := writeFile(t, dir, "other-2.0.zip", "replaced")
+	newer := writeFile(t, dir, "other-2.1.zip", "newer")
+	if err = r.Add(newer, replaced); err == nil || !strings.Contains(err.Error(), "exists already") {
+		t.Errorf("expected adding an existing file to fail, got %v", err)
+	}
+	if data := readObject(t, st, "packages/other/other-2.0.zip"); data != "other" {
+		t.Errorf("existing file has been replaced: %q", data)
+	}
+	if _, err = st.GetObject("packages/other/other-2.1.zip"); err != store.ErrObjectNotFound {
+		t.Errorf("files have been uploaded although one exists already: %v", err)
+	}
+	r.Force = true
+	if err = r.Add(newer, replaced); err != nil {
+		t.Fatal(err)
+	}
+	if data := readObject(t, st, "packages/other/other-2.0.zip"); data != "replaced" {
+		t.Errorf("file hasn't been replaced: %q", data)
+	}
+	csum, _ = store.CalcCSum(replaced)
+	if data := readObject(t, st, "packages/other/other-2.0.zip"+store.CSumExt); data != csum {
+		t.Errorf("checksum hasn't been replaced: %q", data)
+	}
+
+	removed, err := r.Remove("my.pkg", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"My_Pkg-1.0-py3-none-any.whl", "My_Pkg-1.0.tar.gz"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("expected %v to be removed, got %v", expected, removed)
+	}
+	if _, err = st.GetObject("simple/my-pkg/index.html"); err != store.ErrObjectNotFound {
+		t.Errorf("page of removed project still exists: %v", err)
+	}
+	if root = readObject(t, st, "simple/index.html"); strings.Contains(root, "my-pkg") {
+		t.Errorf("root page still lists removed project:\n%s", root)
+	}
+}
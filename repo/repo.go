@@ -0,0 +1,158 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package repo contains helpers shared by the package repository formats
+// which arti is able to maintain within a store.
+package repo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	_ "crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/mgit-at/arti/store"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// ObjectStore returns the object interface of a store or an error if the
+// store is not able to keep files outside of the artifact scheme. Objects
+// are kept below the cache and compression of a store as they are, stores
+// using encryption are refused as the objects would be stored unencrypted.
+func ObjectStore(s store.Store) (store.ObjectStore, error) {
+	if store.EncryptedBackend(s) != nil {
+		return nil, fmt.Errorf("package repositories are not supported for encrypted stores")
+	}
+	b := store.Backend(s)
+	os, ok := b.(store.ObjectStore)
+	if !ok {
+		return nil, fmt.Errorf("store type %T does not support package repositories", b)
+	}
+	return os, nil
+}
+
+// ReadObject returns the content of an object. found is false if the
+// object does not exist.
+func ReadObject(s store.ObjectStore, key string) (data []byte, found bool, err error) {
+	r, err := s.GetObject(key)
+	if err == store.ErrObjectNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer r.Close()
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return nil, false, fmt.Errorf("Error fetching '%s': %v", key, err)
+	}
+	return data, true, nil
+}
+
+// WriteObject stores data as object key.
+func WriteObject(s store.ObjectStore, key string, data []byte) error {
+	return s.PutObject(key, bytes.NewReader(data))
+}
+
+// UploadFile stores the content of a local file as object key.
+func UploadFile(s store.ObjectStore, key, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("Error opening file: %v", err)
+	}
+	defer f.Close()
+	return s.PutObject(key, f)
+}
+
+// Gzip returns data compressed using gzip.
+func Gzip(data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// Gunzip returns the decompressed content of gzip data.
+func Gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+var signConfig = &packet.Config{DefaultHash: crypto.SHA256}
+
+// Signer creates OpenPGP signatures of repository metadata.
+type Signer struct {
+	entity *openpgp.Entity
+}
+
+// LoadSigner reads the first secret key of an (armored) keyring file.
+// Encrypted keys are decrypted using passphrase.
+func LoadSigner(filename, passphrase string) (*Signer, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading signing key: %v", err)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		if keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("Error reading signing key: %v", err)
+		}
+	}
+	for _, e := range keyring {
+		if e.PrivateKey == nil {
+			continue
+		}
+		if e.PrivateKey.Encrypted {
+			if err := e.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return nil, fmt.Errorf("unable to decrypt signing key: %v", err)
+			}
+		}
+		return &Signer{entity: e}, nil
+	}
+	return nil, fmt.Errorf("'%s' contains no secret key", filename)
+}
+
+// ClearSign returns data wrapped into a cleartext signature.
+func (s *Signer) ClearSign(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, s.entity.PrivateKey, signConfig)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DetachSign returns an armored detached signature of data.
+func (s *Signer) DetachSign(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&buf, s.entity, bytes.NewReader(data), signConfig); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
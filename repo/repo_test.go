@@ -0,0 +1,77 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/store"
+)
+
+func TestObjectStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-repo-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := store.NewMemStore()
+	compressed, err := store.NewCompressedStore(backend, store.EncodingGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := store.NewCachedStore(compressed, &store.Cache{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []store.Store{backend, compressed, cached} {
+		objects, err := repo.ObjectStore(s)
+		if err != nil {
+			t.Fatalf("%T: %v", s, err)
+		}
+		if err = repo.WriteObject(objects, "dists/stable/Release", []byte("release")); err != nil {
+			t.Fatal(err)
+		}
+		// objects are kept as they are
+		data, found, err := repo.ReadObject(backend, "dists/stable/Release")
+		if err != nil || !found || string(data) != "release" {
+			t.Errorf("%T: unexpected object %q, %t, %v", s, data, found, err)
+		}
+		if err = objects.DelObject("dists/stable/Release"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := store.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := store.NewEncryptedStore(backend, store.EncryptionKeys{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err = store.NewCompressedStore(encrypted, store.EncodingGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []store.Store{encrypted, compressed} {
+		if _, err := repo.ObjectStore(s); err == nil {
+			t.Errorf("%T: objects of an encrypted store are not refused", s)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	leadSize = 96
+
+	typeInt16       = 3
+	typeInt32       = 4
+	typeInt64       = 5
+	typeString      = 6
+	typeBin         = 7
+	typeStringArray = 8
+	typeI18NString  = 9
+)
+
+var headerMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+
+type indexEntry struct {
+	Tag    int32
+	Type   int32
+	Offset int32
+	Count  int32
+}
+
+// header is a parsed rpm header structure.
+type header struct {
+	entries map[int32]indexEntry
+	data    []byte
+	size    int64
+}
+
+func readHeader(r io.Reader) (*header, error) {
+	var intro [16]byte
+	if _, err := io.ReadFull(r, intro[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(intro[0:4], headerMagic) {
+		return nil, errors.New("invalid header magic")
+	}
+	nindex := binary.BigEndian.Uint32(intro[8:12])
+	hsize := binary.BigEndian.Uint32(intro[12:16])
+	if nindex > 1<<16 || hsize > 1<<28 {
+		return nil, errors.New("header too large")
+	}
+
+	h := &header{
+		entries: make(map[int32]indexEntry, nindex),
+		data:    make([]byte, hsize),
+		size:    16 + 16*int64(nindex) + int64(hsize),
+	}
+	for i := uint32(0); i < nindex; i++ {
+		var e indexEntry
+		if err := binary.Read(r, binary.BigEndian, &e); err != nil {
+			return nil, err
+		}
+		if e.Offset < 0 || uint32(e.Offset) > hsize || e.Count < 0 {
+			return nil, fmt.Errorf("invalid header entry for tag %d", e.Tag)
+		}
+		h.entries[e.Tag] = e
+	}
+	if _, err := io.ReadFull(r, h.data); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *header) strings(tag int32) []string {
+	e, ok := h.entries[tag]
+	if !ok {
+		return nil
+	}
+	switch e.Type {
+	case typeString, typeStringArray, typeI18NString:
+	default:
+		return nil
+	}
+	count := int(e.Count)
+	if e.Type == typeString {
+		count = 1
+	}
+	data := h.data[e.Offset:]
+	// every string is terminated by a NUL byte, a larger count is bogus
+	if count > len(data) {
+		count = len(data)
+	}
+	values := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			break
+		}
+		values = append(values, string(data[:end]))
+		data = data[end+1:]
+	}
+	return values
+}
+
+func (h *header) string(tag int32) string {
+	if s := h.strings(tag); len(s) > 0 {
+		return s[0]
+	}
+	return ""
+}
+
+func (h *header) ints(tag int32) []int64 {
+	e, ok := h.entries[tag]
+	if !ok {
+		return nil
+	}
+	var size int
+	switch e.Type {
+	case typeInt16:
+		size = 2
+	case typeInt32:
+		size = 4
+	case typeInt64:
+		size = 8
+	default:
+		return nil
+	}
+	data := h.data[e.Offset:]
+	if len(data) < size*int(e.Count) {
+		return nil
+	}
+	values := make([]int64, e.Count)
+	for i := range values {
+		switch size {
+		case 2:
+			values[i] = int64(binary.BigEndian.Uint16(data[i*2:]))
+		case 4:
+			values[i] = int64(binary.BigEndian.Uint32(data[i*4:]))
+		case 8:
+			values[i] = int64(binary.BigEndian.Uint64(data[i*8:]))
+		}
+	}
+	return values
+}
+
+func (h *header) int(tag int32) (int64, bool) {
+	if v := h.ints(tag); len(v) > 0 {
+		return v[0], true
+	}
+	return 0, false
+}
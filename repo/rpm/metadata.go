@@ -0,0 +1,249 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpm
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	nsCommon    = "http://linux.duke.edu/metadata/common"
+	nsRPM       = "http://linux.duke.edu/metadata/rpm"
+	nsFilelists = "http://linux.duke.edu/metadata/filelists"
+	nsOther     = "http://linux.duke.edu/metadata/other"
+	nsRepo      = "http://linux.duke.edu/metadata/repo"
+)
+
+// The rpm namespace prefix is written literally since encoding/xml is not
+// able to use prefixes when marshalling.
+
+type xmlVersion struct {
+	Epoch string `xml:"epoch,attr"`
+	Ver   string `xml:"ver,attr"`
+	Rel   string `xml:"rel,attr"`
+}
+
+type xmlChecksum struct {
+	Type  string `xml:"type,attr"`
+	PkgID string `xml:"pkgid,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type xmlLocation struct {
+	Href string `xml:"href,attr"`
+}
+
+type xmlEntry struct {
+	Name  string `xml:"name,attr"`
+	Flags string `xml:"flags,attr,omitempty"`
+	Epoch string `xml:"epoch,attr,omitempty"`
+	Ver   string `xml:"ver,attr,omitempty"`
+	Rel   string `xml:"rel,attr,omitempty"`
+	Pre   string `xml:"pre,attr,omitempty"`
+}
+
+type xmlEntries struct {
+	Entries []xmlEntry `xml:"rpm:entry"`
+}
+
+type xmlFile struct {
+	Type string `xml:"type,attr,omitempty"`
+	Path string `xml:",chardata"`
+}
+
+type xmlPrimaryPackage struct {
+	XMLName     xml.Name    `xml:"package"`
+	Type        string      `xml:"type,attr"`
+	Name        string      `xml:"name"`
+	Arch        string      `xml:"arch"`
+	Version     xmlVersion  `xml:"version"`
+	Checksum    xmlChecksum `xml:"checksum"`
+	Summary     string      `xml:"summary"`
+	Description string      `xml:"description"`
+	Packager    string      `xml:"packager"`
+	URL         string      `xml:"url"`
+	Time        struct {
+		File  int64 `xml:"file,attr"`
+		Build int64 `xml:"build,attr"`
+	} `xml:"time"`
+	Size struct {
+		Package   int64 `xml:"package,attr"`
+		Installed int64 `xml:"installed,attr"`
+		Archive   int64 `xml:"archive,attr"`
+	} `xml:"size"`
+	Location xmlLocation `xml:"location"`
+	Format   struct {
+		License     string `xml:"rpm:license"`
+		Vendor      string `xml:"rpm:vendor"`
+		Group       string `xml:"rpm:group"`
+		BuildHost   string `xml:"rpm:buildhost"`
+		SourceRPM   string `xml:"rpm:sourcerpm"`
+		HeaderRange struct {
+			Start int64 `xml:"start,attr"`
+			End   int64 `xml:"end,attr"`
+		} `xml:"rpm:header-range"`
+		Provides  *xmlEntries `xml:"rpm:provides,omitempty"`
+		Requires  *xmlEntries `xml:"rpm:requires,omitempty"`
+		Conflicts *xmlEntries `xml:"rpm:conflicts,omitempty"`
+		Obsoletes *xmlEntries `xml:"rpm:obsoletes,omitempty"`
+		Files     []xmlFile   `xml:"file"`
+	} `xml:"format"`
+}
+
+type xmlFilelistsPackage struct {
+	XMLName xml.Name   `xml:"package"`
+	PkgID   string     `xml:"pkgid,attr"`
+	Name    string     `xml:"name,attr"`
+	Arch    string     `xml:"arch,attr"`
+	Version xmlVersion `xml:"version"`
+	Files   []xmlFile  `xml:"file"`
+}
+
+type xmlChangelog struct {
+	Author string `xml:"author,attr"`
+	Date   int64  `xml:"date,attr"`
+	Text   string `xml:",chardata"`
+}
+
+type xmlOtherPackage struct {
+	XMLName   xml.Name       `xml:"package"`
+	PkgID     string         `xml:"pkgid,attr"`
+	Name      string         `xml:"name,attr"`
+	Arch      string         `xml:"arch,attr"`
+	Version   xmlVersion     `xml:"version"`
+	Changelog []xmlChangelog `xml:"changelog"`
+}
+
+type xmlRepomdData struct {
+	Type         string      `xml:"type,attr"`
+	Checksum     xmlChecksum `xml:"checksum"`
+	OpenChecksum xmlChecksum `xml:"open-checksum"`
+	Location     xmlLocation `xml:"location"`
+	Timestamp    int64       `xml:"timestamp"`
+	Size         int64       `xml:"size"`
+	OpenSize     int64       `xml:"open-size"`
+}
+
+type xmlRepomd struct {
+	XMLName  xml.Name        `xml:"repomd"`
+	Xmlns    string          `xml:"xmlns,attr"`
+	XmlnsRPM string          `xml:"xmlns:rpm,attr"`
+	Revision string          `xml:"revision"`
+	Data     []xmlRepomdData `xml:"data"`
+}
+
+// rawPackage is a package entry of one of the metadata files. Entries of
+// existing packages are kept as they are.
+type rawPackage struct {
+	PkgID   string
+	Name    string
+	Arch    string
+	Version xmlVersion
+	Href    string
+	XML     []byte
+}
+
+// matchVersion compares the version of a package with a version given as
+// version, version-release or epoch:version-release.
+func (p *rawPackage) matchVersion(v string) bool {
+	epoch := p.Version.Epoch
+	if epoch == "" {
+		epoch = "0"
+	}
+	vr := p.Version.Ver + "-" + p.Version.Rel
+	return v == "" || v == p.Version.Ver || v == vr || v == epoch+":"+vr
+}
+
+// readPackages splits a metadata file into its package entries. The
+// identifying attributes are taken from the primary as well as from the
+// filelists and other format.
+func readPackages(data []byte) ([]*rawPackage, error) {
+	var packages []*rawPackage
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return packages, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+		var p struct {
+			PkgID    string      `xml:"pkgid,attr"`
+			Name     string      `xml:"name,attr"`
+			Arch     string      `xml:"arch,attr"`
+			PName    string      `xml:"name"`
+			PArch    string      `xml:"arch"`
+			Version  xmlVersion  `xml:"version"`
+			Checksum xmlChecksum `xml:"checksum"`
+			Location xmlLocation `xml:"location"`
+			Inner    []byte      `xml:",innerxml"`
+		}
+		if err := d.DecodeElement(&p, &start); err != nil {
+			return nil, err
+		}
+		rp := &rawPackage{
+			PkgID:   p.PkgID,
+			Name:    p.Name,
+			Arch:    p.Arch,
+			Version: p.Version,
+			Href:    p.Location.Href,
+		}
+		if rp.PkgID == "" {
+			rp.PkgID, rp.Name, rp.Arch = p.Checksum.Value, p.PName, p.PArch
+		}
+		var attrs bytes.Buffer
+		for _, a := range start.Attr {
+			fmt.Fprintf(&attrs, " %s=\"", a.Name.Local)
+			xml.EscapeText(&attrs, []byte(a.Value))
+			attrs.WriteString("\"")
+		}
+		rp.XML = []byte("<package" + attrs.String() + ">" + string(p.Inner) + "</package>")
+		packages = append(packages, rp)
+	}
+}
+
+func newRawPackage(pkgID, name, arch string, version xmlVersion, href string, v interface{}) (*rawPackage, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &rawPackage{PkgID: pkgID, Name: name, Arch: arch, Version: version, Href: href, XML: data}, nil
+}
+
+// writePackages creates a metadata file containing the given packages.
+func writePackages(root, xmlns string, packages []*rawPackage) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	fmt.Fprintf(&buf, "<%s xmlns=\"%s\"", root, xmlns)
+	if root == "metadata" {
+		fmt.Fprintf(&buf, " xmlns:rpm=\"%s\"", nsRPM)
+	}
+	fmt.Fprintf(&buf, " packages=\"%s\">\n", strconv.Itoa(len(packages)))
+	for _, p := range packages {
+		buf.Write(p.XML)
+		buf.WriteString("\n")
+	}
+	fmt.Fprintf(&buf, "</%s>\n", root)
+	return buf.Bytes()
+}
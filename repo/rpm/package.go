@@ -0,0 +1,331 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpm
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	tagName            = 1000
+	tagVersion         = 1001
+	tagRelease         = 1002
+	tagEpoch           = 1003
+	tagSummary         = 1004
+	tagDescription     = 1005
+	tagBuildTime       = 1006
+	tagBuildHost       = 1007
+	tagSize            = 1009
+	tagVendor          = 1011
+	tagLicense         = 1014
+	tagPackager        = 1015
+	tagGroup           = 1016
+	tagURL             = 1020
+	tagArch            = 1022
+	tagOldFilenames    = 1027
+	tagFileModes       = 1030
+	tagFileFlags       = 1037
+	tagSourceRPM       = 1044
+	tagArchiveSize     = 1046
+	tagProvideName     = 1047
+	tagRequireFlags    = 1048
+	tagRequireName     = 1049
+	tagRequireVersion  = 1050
+	tagConflictFlags   = 1053
+	tagConflictName    = 1054
+	tagConflictVersion = 1055
+	tagChangelogTime   = 1080
+	tagChangelogName   = 1081
+	tagChangelogText   = 1082
+	tagObsoleteName    = 1090
+	tagProvideFlags    = 1112
+	tagProvideVersion  = 1113
+	tagObsoleteFlags   = 1114
+	tagObsoleteVersion = 1115
+	tagDirIndexes      = 1116
+	tagBaseNames       = 1117
+	tagDirNames        = 1118
+	tagSourcePackage   = 1106
+	tagLongSize        = 5009
+
+	sigTagPayloadSize = 1007
+
+	senseLess    = 1 << 1
+	senseGreater = 1 << 2
+	senseEqual   = 1 << 3
+	sensePreReq  = 1 << 6
+	senseScripts = 1<<9 | 1<<10 | 1<<11 | 1<<12
+
+	fileGhost = 1 << 6
+	modeDir   = 0040000
+	modeType  = 0170000
+)
+
+// Package is an rpm file to be added to a repository.
+type Package struct {
+	Name    string
+	Epoch   string
+	Version string
+	Release string
+	Arch    string
+
+	filename    string
+	checksum    string
+	size        int64
+	mtime       int64
+	headerStart int64
+	headerEnd   int64
+	sig         *header
+	h           *header
+}
+
+// ReadPackage parses the header of an rpm file.
+func ReadPackage(filename string) (*Package, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening package: %v", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	r := bufio.NewReader(io.TeeReader(f, hash))
+	lead := make([]byte, leadSize)
+	if _, err := io.ReadFull(r, lead); err != nil || lead[0] != 0xed || lead[1] != 0xab || lead[2] != 0xee || lead[3] != 0xdb {
+		return nil, fmt.Errorf("%s: not an rpm package", filename)
+	}
+	sig, err := readHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid signature header: %v", filename, err)
+	}
+	pad := (8 - sig.size%8) % 8
+	if _, err := io.CopyN(ioutil.Discard, r, pad); err != nil {
+		return nil, fmt.Errorf("%s: invalid signature header: %v", filename, err)
+	}
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid header: %v", filename, err)
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return nil, fmt.Errorf("Error reading package: %v", err)
+	}
+
+	p := &Package{
+		Name:        h.string(tagName),
+		Version:     h.string(tagVersion),
+		Release:     h.string(tagRelease),
+		Arch:        h.string(tagArch),
+		Epoch:       "0",
+		filename:    path.Base(filename),
+		checksum:    hex.EncodeToString(hash.Sum(nil)),
+		size:        fi.Size(),
+		mtime:       fi.ModTime().Unix(),
+		headerStart: leadSize + sig.size + pad,
+		sig:         sig,
+		h:           h,
+	}
+	p.headerEnd = p.headerStart + h.size
+	if epoch, ok := h.int(tagEpoch); ok {
+		p.Epoch = strconv.FormatInt(epoch, 10)
+	}
+	if _, ok := h.entries[tagSourcePackage]; ok {
+		p.Arch = "src"
+	}
+	if p.Name == "" || p.Version == "" || p.Arch == "" {
+		return nil, fmt.Errorf("%s: header misses name, version or architecture", filename)
+	}
+	return p, nil
+}
+
+func (p *Package) version() xmlVersion {
+	return xmlVersion{Epoch: p.Epoch, Ver: p.Version, Rel: p.Release}
+}
+
+// files returns all files of the package including their type.
+func (p *Package) files() []xmlFile {
+	names := p.h.strings(tagOldFilenames)
+	if names == nil {
+		dirs := p.h.strings(tagDirNames)
+		indexes := p.h.ints(tagDirIndexes)
+		for i, base := range p.h.strings(tagBaseNames) {
+			if i < len(indexes) && int(indexes[i]) < len(dirs) {
+				names = append(names, dirs[indexes[i]]+base)
+			}
+		}
+	}
+	modes := p.h.ints(tagFileModes)
+	flags := p.h.ints(tagFileFlags)
+
+	files := make([]xmlFile, 0, len(names))
+	for i, name := range names {
+		f := xmlFile{Path: name}
+		if i < len(flags) && flags[i]&fileGhost != 0 {
+			f.Type = "ghost"
+		} else if i < len(modes) && modes[i]&modeType == modeDir {
+			f.Type = "dir"
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+func isPrimaryFile(name string) bool {
+	return strings.HasPrefix(name, "/etc/") || strings.Contains(name, "bin/") || name == "/usr/lib/sendmail"
+}
+
+func (p *Package) entries(nameTag, flagsTag, versionTag int32, requires bool) *xmlEntries {
+	names := p.h.strings(nameTag)
+	if len(names) == 0 {
+		return nil
+	}
+	flags := p.h.ints(flagsTag)
+	versions := p.h.strings(versionTag)
+
+	e := &xmlEntries{}
+	seen := make(map[xmlEntry]bool)
+	for i, name := range names {
+		if requires && strings.HasPrefix(name, "rpmlib(") {
+			continue
+		}
+		entry := xmlEntry{Name: name}
+		var f int64
+		if i < len(flags) {
+			f = flags[i]
+		}
+		switch f & (senseLess | senseGreater | senseEqual) {
+		case senseLess:
+			entry.Flags = "LT"
+		case senseGreater:
+			entry.Flags = "GT"
+		case senseEqual:
+			entry.Flags = "EQ"
+		case senseLess | senseEqual:
+			entry.Flags = "LE"
+		case senseGreater | senseEqual:
+			entry.Flags = "GE"
+		}
+		if i < len(versions) && versions[i] != "" {
+			entry.Epoch, entry.Ver, entry.Rel = splitEVR(versions[i])
+		}
+		if requires && f&(sensePreReq|senseScripts) != 0 {
+			entry.Pre = "1"
+		}
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		e.Entries = append(e.Entries, entry)
+	}
+	if len(e.Entries) == 0 {
+		return nil
+	}
+	return e
+}
+
+func splitEVR(evr string) (epoch, ver, rel string) {
+	epoch = "0"
+	if i := strings.Index(evr, ":"); i >= 0 {
+		epoch, evr = evr[:i], evr[i+1:]
+	}
+	ver = evr
+	if i := strings.LastIndex(evr, "-"); i >= 0 {
+		ver, rel = evr[:i], evr[i+1:]
+	}
+	return
+}
+
+func (p *Package) primary(href string) (*rawPackage, error) {
+	x := &xmlPrimaryPackage{
+		Type:        "rpm",
+		Name:        p.Name,
+		Arch:        p.Arch,
+		Version:     p.version(),
+		Checksum:    xmlChecksum{Type: "sha256", PkgID: "YES", Value: p.checksum},
+		Summary:     p.h.string(tagSummary),
+		Description: p.h.string(tagDescription),
+		Packager:    p.h.string(tagPackager),
+		URL:         p.h.string(tagURL),
+		Location:    xmlLocation{Href: href},
+	}
+	x.Time.File = p.mtime
+	x.Time.Build, _ = p.h.int(tagBuildTime)
+	x.Size.Package = p.size
+	if x.Size.Installed, _ = p.h.int(tagLongSize); x.Size.Installed == 0 {
+		x.Size.Installed, _ = p.h.int(tagSize)
+	}
+	if x.Size.Archive, _ = p.h.int(tagArchiveSize); x.Size.Archive == 0 {
+		x.Size.Archive, _ = p.sig.int(sigTagPayloadSize)
+	}
+
+	x.Format.License = p.h.string(tagLicense)
+	x.Format.Vendor = p.h.string(tagVendor)
+	x.Format.Group = p.h.string(tagGroup)
+	x.Format.BuildHost = p.h.string(tagBuildHost)
+	x.Format.SourceRPM = p.h.string(tagSourceRPM)
+	x.Format.HeaderRange.Start = p.headerStart
+	x.Format.HeaderRange.End = p.headerEnd
+	x.Format.Provides = p.entries(tagProvideName, tagProvideFlags, tagProvideVersion, false)
+	x.Format.Requires = p.entries(tagRequireName, tagRequireFlags, tagRequireVersion, true)
+	x.Format.Conflicts = p.entries(tagConflictName, tagConflictFlags, tagConflictVersion, false)
+	x.Format.Obsoletes = p.entries(tagObsoleteName, tagObsoleteFlags, tagObsoleteVersion, false)
+	for _, f := range p.files() {
+		if f.Type != "ghost" && isPrimaryFile(f.Path) {
+			x.Format.Files = append(x.Format.Files, f)
+		}
+	}
+	return newRawPackage(p.checksum, p.Name, p.Arch, p.version(), href, x)
+}
+
+func (p *Package) filelists() (*rawPackage, error) {
+	x := &xmlFilelistsPackage{
+		PkgID:   p.checksum,
+		Name:    p.Name,
+		Arch:    p.Arch,
+		Version: p.version(),
+		Files:   p.files(),
+	}
+	return newRawPackage(p.checksum, p.Name, p.Arch, p.version(), "", x)
+}
+
+func (p *Package) other() (*rawPackage, error) {
+	x := &xmlOtherPackage{
+		PkgID:   p.checksum,
+		Name:    p.Name,
+		Arch:    p.Arch,
+		Version: p.version(),
+	}
+	times := p.h.ints(tagChangelogTime)
+	names := p.h.strings(tagChangelogName)
+	texts := p.h.strings(tagChangelogText)
+	for i := range times {
+		if i >= len(names) || i >= len(texts) {
+			break
+		}
+		x.Changelog = append(x.Changelog, xmlChangelog{Author: names[i], Date: times[i], Text: texts[i]})
+	}
+	return newRawPackage(p.checksum, p.Name, p.Arch, p.version(), "", x)
+}
@@ -0,0 +1,313 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rpm maintains YUM/DNF repositories within a store.
+package rpm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/store"
+)
+
+// Repository is a directory of a store which is usable as baseurl.
+type Repository struct {
+	Store store.ObjectStore
+	// Path is the location of the repository within the bucket.
+	Path string
+
+	// Signer creates repodata/repomd.xml.asc if set.
+	Signer *repo.Signer
+
+	metadata map[string][]*rawPackage
+	extra    []xmlRepomdData
+	old      []string
+}
+
+type metadataFile struct {
+	name  string
+	root  string
+	xmlns string
+}
+
+var metadataFiles = []metadataFile{
+	{"primary", "metadata", nsCommon},
+	{"filelists", "filelists", nsFilelists},
+	{"other", "otherdata", nsOther},
+}
+
+func (r *Repository) key(elem ...string) string {
+	return path.Join(append([]string{r.Path}, elem...)...)
+}
+
+func (r *Repository) load() error {
+	r.metadata = make(map[string][]*rawPackage)
+	r.extra = nil
+	r.old = nil
+
+	data, found, err := repo.ReadObject(r.Store, r.key("repodata", "repomd.xml"))
+	if err != nil || !found {
+		return err
+	}
+	var repomd xmlRepomd
+	if err := xml.Unmarshal(data, &repomd); err != nil {
+		return fmt.Errorf("invalid repomd.xml: %v", err)
+	}
+	for _, d := range repomd.Data {
+		if !isMetadataFile(d.Type) {
+			// e.g. comps or updateinfo added by other tools
+			r.extra = append(r.extra, d)
+			continue
+		}
+		r.old = append(r.old, d.Location.Href)
+		if _, known := r.metadata[d.Type]; known {
+			continue
+		}
+		data, found, err := repo.ReadObject(r.Store, r.key(d.Location.Href))
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%s metadata '%s' is missing", d.Type, d.Location.Href)
+		}
+		if path.Ext(d.Location.Href) == ".gz" {
+			if data, err = repo.Gunzip(data); err != nil {
+				return fmt.Errorf("invalid %s metadata: %v", d.Type, err)
+			}
+		}
+		if r.metadata[d.Type], err = readPackages(data); err != nil {
+			return fmt.Errorf("invalid %s metadata: %v", d.Type, err)
+		}
+	}
+	return nil
+}
+
+func isMetadataFile(name string) bool {
+	for _, mf := range metadataFiles {
+		if mf.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Add uploads the given rpm files below Packages/ and adds them to the
+// metadata. Existing packages with the same name, version and architecture
+// are replaced.
+func (r *Repository) Add(files ...string) error {
+	if err := r.load(); err != nil {
+		return err
+	}
+
+	for _, filename := range files {
+		p, err := ReadPackage(filename)
+		if err != nil {
+			return err
+		}
+		href := path.Join("Packages", p.filename)
+		primary, err := p.primary(href)
+		if err != nil {
+			return err
+		}
+		filelists, err := p.filelists()
+		if err != nil {
+			return err
+		}
+		other, err := p.other()
+		if err != nil {
+			return err
+		}
+
+		if err := repo.UploadFile(r.Store, r.key(href), filename); err != nil {
+			return err
+		}
+		r.remove(func(rp *rawPackage) bool {
+			return rp.PkgID == primary.PkgID || (rp.Name == primary.Name && rp.Arch == primary.Arch && rp.Version == primary.Version)
+		})
+		r.metadata["primary"] = append(r.metadata["primary"], primary)
+		r.metadata["filelists"] = append(r.metadata["filelists"], filelists)
+		r.metadata["other"] = append(r.metadata["other"], other)
+	}
+	return r.publish()
+}
+
+// Remove deletes all packages matching name, and if not empty version and
+// arch, from the metadata. version may be given as version,
+// version-release or epoch:version-release. If purge is set, the package
+// files are deleted as well.
+func (r *Repository) Remove(name, version, arch string, purge bool) ([]string, error) {
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	removed := r.remove(func(rp *rawPackage) bool {
+		return rp.Name == name && rp.matchVersion(version) && (arch == "" || rp.Arch == arch)
+	})
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	if err := r.publish(); err != nil {
+		return nil, err
+	}
+
+	var hrefs []string
+	referenced := make(map[string]bool)
+	for _, rp := range r.metadata["primary"] {
+		referenced[rp.Href] = true
+	}
+	for _, rp := range removed {
+		hrefs = append(hrefs, rp.Href)
+		if !purge || referenced[rp.Href] {
+			continue
+		}
+		referenced[rp.Href] = true
+		if err := r.Store.DelObject(r.key(rp.Href)); err != nil {
+			return hrefs, err
+		}
+	}
+	return hrefs, nil
+}
+
+// remove deletes the packages for which match returns true from all
+// metadata files and returns their primary entries.
+func (r *Repository) remove(match func(*rawPackage) bool) []*rawPackage {
+	var removed []*rawPackage
+	ids := make(map[string]bool)
+	kept := []*rawPackage{}
+	for _, rp := range r.metadata["primary"] {
+		if match(rp) {
+			removed = append(removed, rp)
+			ids[rp.PkgID] = true
+		} else {
+			kept = append(kept, rp)
+		}
+	}
+	r.metadata["primary"] = kept
+
+	for _, mf := range metadataFiles[1:] {
+		kept := []*rawPackage{}
+		for _, rp := range r.metadata[mf.name] {
+			if !ids[rp.PkgID] {
+				kept = append(kept, rp)
+			}
+		}
+		r.metadata[mf.name] = kept
+	}
+	return removed
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// publish writes the metadata files and repomd.xml. Metadata files are
+// named after their checksum so clients never see a mix of old and new
+// files. The files of the previous repomd.xml are kept until the next
+// publish, since clients may have fetched it just before it was replaced,
+// older generations are deleted.
+func (r *Repository) publish() error {
+	now := time.Now().Unix()
+	repomd := xmlRepomd{
+		Xmlns:    nsRepo,
+		XmlnsRPM: nsRPM,
+		Revision: strconv.FormatInt(now, 10),
+	}
+	current := make(map[string]bool)
+	for _, mf := range metadataFiles {
+		data := writePackages(mf.root, mf.xmlns, r.metadata[mf.name])
+		gz := repo.Gzip(data)
+		checksum := sha256Hex(gz)
+		href := path.Join("repodata", checksum+"-"+mf.name+".xml.gz")
+		if err := repo.WriteObject(r.Store, r.key(href), gz); err != nil {
+			return err
+		}
+		current[href] = true
+		repomd.Data = append(repomd.Data, xmlRepomdData{
+			Type:         mf.name,
+			Checksum:     xmlChecksum{Type: "sha256", Value: checksum},
+			OpenChecksum: xmlChecksum{Type: "sha256", Value: sha256Hex(data)},
+			Location:     xmlLocation{Href: href},
+			Timestamp:    now,
+			Size:         int64(len(gz)),
+			OpenSize:     int64(len(data)),
+		})
+	}
+
+	repomd.Data = append(repomd.Data, r.extra...)
+	data, err := xml.MarshalIndent(repomd, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	if err := repo.WriteObject(r.Store, r.key("repodata", "repomd.xml"), data); err != nil {
+		return err
+	}
+	if r.Signer != nil {
+		signature, err := r.Signer.DetachSign(data)
+		if err != nil {
+			return fmt.Errorf("signing repomd.xml failed: %v", err)
+		}
+		if err := repo.WriteObject(r.Store, r.key("repodata", "repomd.xml.asc"), signature); err != nil {
+			return err
+		}
+	} else if err := r.Store.DelObject(r.key("repodata", "repomd.xml.asc")); err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool)
+	for _, href := range r.old {
+		keep[r.key(href)] = true
+	}
+	for href := range current {
+		keep[r.key(href)] = true
+	}
+	objects, err := r.Store.ListObjects(r.key("repodata") + "/")
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if keep[o.Key] || !isGeneratedMetadata(path.Base(o.Key)) {
+			continue
+		}
+		if err := r.Store.DelObject(o.Key); err != nil {
+			return err
+		}
+	}
+	r.old = nil
+	for href := range current {
+		r.old = append(r.old, href)
+	}
+	return nil
+}
+
+// isGeneratedMetadata returns true for the names publish uses for metadata
+// files, other files in repodata/ belong to other tools.
+func isGeneratedMetadata(name string) bool {
+	for _, mf := range metadataFiles {
+		if sum := strings.TrimSuffix(name, "-"+mf.name+".xml.gz"); sum != name {
+			_, err := hex.DecodeString(sum)
+			return err == nil && len(sum) == sha256.Size*2
+		}
+	}
+	return false
+}
@@ -0,0 +1,328 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpm_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/repo/rpm"
+	"github.com/mgit-at/arti/store"
+)
+
+type i18n string
+
+type tag struct {
+	tag   int32
+	value interface{}
+}
+
+// makeHeader encodes tags as rpm header structure.
+func makeHeader(tags ...tag) []byte {
+	var index, data bytes.Buffer
+	for _, tg := range tags {
+		var typ, count int32
+		align := 1
+		switch v := tg.value.(type) {
+		case string:
+			typ, count = 6, 1
+		case i18n:
+			typ, count = 9, 1
+		case []string:
+			typ, count = 8, int32(len(v))
+		case []uint16:
+			typ, count, align = 3, int32(len(v)), 2
+		case []int32:
+			typ, count, align = 4, int32(len(v)), 4
+		case []byte:
+			typ, count = 7, int32(len(v))
+		}
+		for data.Len()%align != 0 {
+			data.WriteByte(0)
+		}
+		binary.Write(&index, binary.BigEndian, []int32{tg.tag, typ, int32(data.Len()), count})
+		switch v := tg.value.(type) {
+		case string:
+			data.WriteString(v + "\x00")
+		case i18n:
+			data.WriteString(string(v) + "\x00")
+		case []string:
+			for _, s := range v {
+				data.WriteString(s + "\x00")
+			}
+		default:
+			binary.Write(&data, binary.BigEndian, v)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(&buf, binary.BigEndian, []int32{int32(len(tags)), int32(data.Len())})
+	buf.Write(index.Bytes())
+	buf.Write(data.Bytes())
+	return buf.Bytes()
+}
+
+// writeRPM creates an rpm file with a signature header containing the
+// payload size, the given header and a dummy payload.
+func writeRPM(t *testing.T, dir, name string, h []byte) string {
+	var buf bytes.Buffer
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	buf.Write(lead)
+	buf.Write(makeHeader(tag{1007, []int32{42}}, tag{1000, []int32{int32(len(h))}}, tag{1004, []byte{1, 2, 3}}))
+	for buf.Len()%8 != 0 {
+		buf.WriteByte(0)
+	}
+	buf.Write(h)
+	buf.WriteString("payload")
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func helloHeader(version, release string) []byte {
+	return makeHeader(
+		tag{1000, "hello"},
+		tag{1001, version},
+		tag{1002, release},
+		tag{1003, []int32{2}},
+		tag{1004, i18n("greeting program")},
+		tag{1005, i18n("Prints a <greeting> & exits.")},
+		tag{1006, []int32{1500000000}},
+		tag{1009, []int32{1234}},
+		tag{1014, "MIT"},
+		tag{1022, "x86_64"},
+		tag{1044, "hello-" + version + "-" + release + ".src.rpm"},
+		tag{1047, []string{"hello", "hello(x86-64)"}},
+		tag{1112, []int32{8, 8}},
+		tag{1113, []string{"2:" + version + "-" + release, "2:" + version + "-" + release}},
+		tag{1049, []string{"rpmlib(CompressedFileNames)", "libc.so.6()(64bit)", "bash", "bash"}},
+		tag{1048, []int32{16777226, 16384, 12 | 1<<6, 12 | 1<<6}},
+		tag{1050, []string{"3.0.4-1", "", "4.0", "4.0"}},
+		tag{1054, []string{"goodbye"}},
+		tag{1053, []int32{2}},
+		tag{1055, []string{"1:0.9"}},
+		tag{1116, []int32{0, 1, 2, 2}},
+		tag{1117, []string{"hello", "hello.conf", "cache", "hello.lock"}},
+		tag{1118, []string{"/usr/bin/", "/etc/", "/var/lib/hello/"}},
+		tag{1030, []uint16{0100755, 0100644, 040755, 0100644}},
+		tag{1037, []int32{0, 1, 0, 64}},
+		tag{1080, []int32{1500000000}},
+		tag{1081, []string{"Jane Doe <jane@example.com> - 1.0-1"}},
+		tag{1082, []string{"- initial package"}},
+	)
+}
+
+func TestReadPackage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-rpm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := rpm.ReadPackage(writeRPM(t, dir, "hello.rpm", helloHeader("1.0", "1.el9")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "hello" || p.Epoch != "2" || p.Version != "1.0" || p.Release != "1.el9" || p.Arch != "x86_64" {
+		t.Errorf("unexpected package %s %s:%s-%s %s", p.Name, p.Epoch, p.Version, p.Release, p.Arch)
+	}
+
+	src, err := rpm.ReadPackage(writeRPM(t, dir, "hello.src.rpm", makeHeader(
+		tag{1000, "hello"}, tag{1001, "1.0"}, tag{1002, "1"}, tag{1022, "x86_64"}, tag{1106, []int32{1}},
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Epoch != "0" || src.Arch != "src" {
+		t.Errorf("unexpected source package %s:%s-%s %s", src.Epoch, src.Version, src.Release, src.Arch)
+	}
+
+	if _, err := rpm.ReadPackage(writeRPM(t, dir, "noname.rpm", makeHeader(tag{1001, "1.0"}, tag{1022, "noarch"}))); err == nil {
+		t.Error("expected a package without name to be rejected")
+	}
+
+	// the count of strings is bounded by the data of the header
+	h := makeHeader(tag{1000, []string{"hello"}}, tag{1001, "1.0"}, tag{1002, "1"}, tag{1022, "noarch"})
+	binary.BigEndian.PutUint32(h[16+12:], 0x7fffffff)
+	if p, err := rpm.ReadPackage(writeRPM(t, dir, "count.rpm", h)); err != nil || p.Name != "hello" {
+		t.Errorf("bogus count: unexpected package %+v, %v", p, err)
+	}
+
+	data, _ := ioutil.ReadFile(writeRPM(t, dir, "valid.rpm", helloHeader("1.0", "1")))
+	corrupt := func(offset int, b ...byte) []byte {
+		c := append([]byte{}, data...)
+		copy(c[offset:], b)
+		return c
+	}
+	for name, invalid := range map[string][]byte{
+		"lead":      corrupt(0, 'n', 'o', 'n', 'e'),
+		"magic":     corrupt(96, 0, 0, 0, 0),
+		"too large": corrupt(96+8, 0, 1, 0, 1),
+		"offset":    corrupt(96+16+8, 0x7f, 0, 0, 0),
+		"truncated": data[:200],
+	} {
+		fn := filepath.Join(dir, "invalid.rpm")
+		if err := ioutil.WriteFile(fn, invalid, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rpm.ReadPackage(fn); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func readMetadata(t *testing.T, st store.ObjectStore) (map[string]string, []string) {
+	data, found, err := repo.ReadObject(st, "el9/repodata/repomd.xml")
+	if err != nil || !found {
+		t.Fatalf("repomd.xml: found %t, %v", found, err)
+	}
+	var repomd struct {
+		Data []struct {
+			Type     string `xml:"type,attr"`
+			Location struct {
+				Href string `xml:"href,attr"`
+			} `xml:"location"`
+		} `xml:"data"`
+	}
+	if err = xml.Unmarshal(data, &repomd); err != nil {
+		t.Fatal(err)
+	}
+	metadata := make(map[string]string)
+	var hrefs []string
+	for _, d := range repomd.Data {
+		hrefs = append(hrefs, d.Location.Href)
+		gz, found, err := repo.ReadObject(st, path.Join("el9", d.Location.Href))
+		if err != nil || !found {
+			t.Fatalf("%s: found %t, %v", d.Location.Href, found, err)
+		}
+		if data, err = repo.Gunzip(gz); err != nil {
+			t.Fatalf("%s: %v", d.Location.Href, err)
+		}
+		// without indentation for easier matching
+		var compact string
+		for _, line := range strings.Split(string(data), "\n") {
+			compact += strings.TrimSpace(line)
+		}
+		metadata[d.Type] = compact
+	}
+	sort.Strings(hrefs)
+	return metadata, hrefs
+}
+
+func repodata(t *testing.T, st store.ObjectStore) []string {
+	objects, err := st.ListObjects("el9/repodata/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, o := range objects {
+		if strings.HasSuffix(o.Key, ".xml.gz") {
+			keys = append(keys, strings.TrimPrefix(o.Key, "el9/"))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-rpm-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	st := store.NewMemStore()
+	r := &rpm.Repository{Store: st, Path: "el9"}
+	if err = r.Add(writeRPM(t, dir, "hello-1.0-1.el9.x86_64.rpm", helloHeader("1.0", "1.el9"))); err != nil {
+		t.Fatal(err)
+	}
+	metadata, first := readMetadata(t, st)
+	for typ, expected := range map[string][]string{
+		"primary": {
+			`<name>hello</name>`,
+			`<version epoch="2" ver="1.0" rel="1.el9"></version>`,
+			`<description>Prints a &lt;greeting&gt; &amp; exits.</description>`,
+			`<location href="Packages/hello-1.0-1.el9.x86_64.rpm"></location>`,
+			`<rpm:entry name="hello(x86-64)" flags="EQ" epoch="2" ver="1.0" rel="1.el9"></rpm:entry>`,
+			`<rpm:requires><rpm:entry name="libc.so.6()(64bit)"></rpm:entry><rpm:entry name="bash" flags="GE" epoch="0" ver="4.0" pre="1"></rpm:entry></rpm:requires>`,
+			`<rpm:conflicts><rpm:entry name="goodbye" flags="LT" epoch="1" ver="0.9"></rpm:entry></rpm:conflicts>`,
+			`<rpm:license>MIT</rpm:license>`,
+			`<file>/usr/bin/hello</file><file>/etc/hello.conf</file></format>`,
+		},
+		"filelists": {
+			`<file>/usr/bin/hello</file><file>/etc/hello.conf</file><file type="dir">/var/lib/hello/cache</file><file type="ghost">/var/lib/hello/hello.lock</file>`,
+		},
+		"other": {
+			`<changelog author="Jane Doe &lt;jane@example.com&gt; - 1.0-1" date="1500000000">- initial package</changelog>`,
+		},
+	} {
+		for _, e := range expected {
+			if !strings.Contains(metadata[typ], e) {
+				t.Errorf("%s metadata misses %s:\n%s", typ, e, metadata[typ])
+			}
+		}
+	}
+	if data, _, _ := repo.ReadObject(st, "el9/Packages/hello-1.0-1.el9.x86_64.rpm"); !bytes.HasSuffix(data, []byte("payload")) {
+		t.Error("package has not been uploaded")
+	}
+
+	// the metadata files referenced by the previous repomd.xml are kept
+	if err = r.Add(writeRPM(t, dir, "hello-1.1-1.el9.x86_64.rpm", helloHeader("1.1", "1.el9"))); err != nil {
+		t.Fatal(err)
+	}
+	metadata, second := readMetadata(t, st)
+	if !strings.Contains(metadata["primary"], `ver="1.0"`) || !strings.Contains(metadata["primary"], `ver="1.1"`) {
+		t.Errorf("primary metadata misses a version:\n%s", metadata["primary"])
+	}
+	expected := append(append([]string{}, first...), second...)
+	sort.Strings(expected)
+	if keys := repodata(t, st); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected metadata files %v, got %v", expected, keys)
+	}
+
+	removed, err := r.Remove("hello", "2:1.0-1.el9", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{"Packages/hello-1.0-1.el9.x86_64.rpm"}) {
+		t.Errorf("unexpected removed packages %v", removed)
+	}
+	metadata, third := readMetadata(t, st)
+	if strings.Contains(metadata["primary"], `ver="1.0"`) {
+		t.Errorf("primary metadata still contains removed version:\n%s", metadata["primary"])
+	}
+	expected = append(append([]string{}, second...), third...)
+	sort.Strings(expected)
+	if keys := repodata(t, st); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected metadata files %v, got %v", expected, keys)
+	}
+	if _, err = st.GetObject("el9/Packages/hello-1.0-1.el9.x86_64.rpm"); err != store.ErrObjectNotFound {
+		t.Errorf("removed package has not been purged: %v", err)
+	}
+}
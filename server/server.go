@@ -0,0 +1,652 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package server exposes stores via HTTP.
+//
+// Every store is mounted below /<store>/<bucket>/ and uses the same layout
+// as the store itself:
+//
+//	/<store>/<bucket>/                                   list of artifacts
+//	/<store>/<bucket>/<name>/                            versions of an artifact
+//	/<store>/<bucket>/<name>/<version>/                  file of a version
+//	/<store>/<bucket>/<name>/<version>/<file>            the file itself
+//	/<store>/<bucket>/<name>/<version>/<file>.checksum   its checksum
+//
+// Listings are rendered as HTML unless JSON is requested using the Accept
+// header or ?format=json. Other paths are served from the objects of the
+// store, e.g. the metadata of package repositories. Paths containing /@v/
+// or ending in /@latest are answered using the GOPROXY protocol.
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/mgit-at/arti/repo"
+	"github.com/mgit-at/arti/repo/goproxy"
+	"github.com/mgit-at/arti/store"
+)
+
+// Mount makes a store available below /<Name>/.
+type Mount struct {
+	Name  string
+	Store store.Store
+}
+
+// DefaultMaxUploadSize limits uploads if Options.MaxUploadSize is not set.
+const DefaultMaxUploadSize = 1 << 30
+
+type Options struct {
+	// Users maps the names of users which are allowed to upload artifacts
+	// to their passwords. Uploads are disabled if there are no users.
+	Users map[string]string
+	// MaxUploadSize is the size in bytes uploaded files may have at most.
+	MaxUploadSize int64
+}
+
+type Server struct {
+	mounts map[string]store.Store
+	names  []string
+	opts   Options
+}
+
+func New(mounts []Mount, opts Options) *Server {
+	s := &Server{mounts: make(map[string]store.Store), opts: opts}
+	for _, m := range mounts {
+		name := strings.Trim(m.Name, "/")
+		s.mounts[name] = m.Store
+		s.names = append(s.names, name)
+	}
+	sort.Strings(s.names)
+	return s
+}
+
+type nameEntry struct {
+	Name     string `json:"name"`
+	Versions int    `json:"versions"`
+	Size     int64  `json:"size"`
+}
+
+type nameEntries []nameEntry
+
+func (e nameEntries) Len() int           { return len(e) }
+func (e nameEntries) Less(i, j int) bool { return e[i].Name < e[j].Name }
+func (e nameEntries) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+type versionEntry struct {
+	Version  string `json:"version"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+type fileEntry struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// mount finds the store addressed by the request and returns the rest of
+// the path.
+func (s *Server) mount(p string) (name string, st store.Store, rest string, found bool) {
+	p = strings.TrimPrefix(p, "/")
+	for _, n := range s.names {
+		if p == n || strings.HasPrefix(p, n+"/") {
+			if len(n) > len(name) {
+				name, st, rest, found = n, s.mounts[n], strings.TrimPrefix(p, n), true
+			}
+		}
+	}
+	return
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		s.serveIndex(w, r)
+		return
+	}
+
+	name, st, rest, found := s.mount(r.URL.Path)
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	if rest == "" {
+		http.Redirect(w, r, "/"+name+"/", http.StatusMovedPermanently)
+		return
+	}
+	rest = strings.TrimPrefix(rest, "/")
+
+	if goproxy.IsRequest(rest) {
+		goproxy.Serve(w, r, st, rest)
+		return
+	}
+	if rest == "" || strings.HasSuffix(rest, "/") {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// static index pages, e.g. of a python package index, take
+		// precedence over the listing of artifacts
+		if rest != "" {
+			if obj, err := openObject(st, rest+"index.html"); err == nil {
+				defer obj.Close()
+				writeObject(w, r, "index.html", obj)
+				return
+			}
+		}
+		s.serveListing(w, r, st, strings.TrimSuffix(rest, "/"))
+		return
+	}
+
+	dir, file := path.Split(rest)
+	nv := strings.TrimSuffix(dir, "/")
+	n, v := path.Split(nv)
+	n = strings.TrimSuffix(n, "/")
+	a, err := store.MakeArtifact(n, v)
+	if n == "" || err != nil {
+		if r.Method == "GET" || r.Method == "HEAD" {
+			serveObject(w, r, st, rest)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		s.serveFile(w, r, st, a, file, rest)
+	case "PUT":
+		s.serveUpload(w, r, st, a, file)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func wantsJSON(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == "json"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func serveError(w http.ResponseWriter, err error) {
+	if err == store.ErrArtifactNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Println("error:", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		writeJSON(w, s.names)
+		return
+	}
+	rows := [][]string{}
+	for _, n := range s.names {
+		rows = append(rows, []string{n + "/"})
+	}
+	renderListing(w, "/", nil, rows)
+}
+
+// serveListing lists the artifacts of a store, the versions of an artifact
+// or the file of a version. The last element of p decides whether it is
+// the name of an artifact or a version.
+func (s *Server) serveListing(w http.ResponseWriter, r *http.Request, st store.Store, p string) {
+	if p == "" {
+		s.serveNames(w, r, st)
+		return
+	}
+	if n, v := path.Split(p); n != "" {
+		if a, err := store.MakeArtifact(strings.TrimSuffix(n, "/"), v); err == nil {
+			s.serveFiles(w, r, st, a)
+			return
+		}
+	}
+	s.serveVersions(w, r, st, p)
+}
+
+func (s *Server) serveNames(w http.ResponseWriter, r *http.Request, st store.Store) {
+	list, err := st.List("", nil)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	entries := []nameEntry{}
+	for name, versions := range list {
+		e := nameEntry{Name: name, Versions: len(versions)}
+		for _, v := range versions {
+			e.Size += v.Filesize
+		}
+		entries = append(entries, e)
+	}
+	sort.Sort(nameEntries(entries))
+
+	if wantsJSON(r) {
+		writeJSON(w, entries)
+		return
+	}
+	rows := [][]string{}
+	for _, e := range entries {
+		rows = append(rows, []string{e.Name + "/", fmt.Sprintf("%d", e.Versions), fmt.Sprintf("%d", e.Size)})
+	}
+	renderListing(w, r.URL.Path, []string{"name", "versions", "size"}, rows)
+}
+
+func (s *Server) serveVersions(w http.ResponseWriter, r *http.Request, st store.Store, name string) {
+	list, err := st.List(name, nil)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	versions, found := list[name]
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	sort.Sort(sort.Reverse(versions))
+
+	entries := []versionEntry{}
+	for _, v := range versions {
+		entries = append(entries, versionEntry{v.Version.String(), v.Filename, v.Filesize})
+	}
+	if wantsJSON(r) {
+		writeJSON(w, entries)
+		return
+	}
+	rows := [][]string{}
+	for _, e := range entries {
+		rows = append(rows, []string{e.Version + "/", e.Filename, fmt.Sprintf("%d", e.Size)})
+	}
+	renderListing(w, r.URL.Path, []string{"version", "filename", "size"}, rows)
+}
+
+func (s *Server) serveFiles(w http.ResponseWriter, r *http.Request, st store.Store, a store.Artifact) {
+	list, err := st.List(a.Name, semver.Range(func(v semver.Version) bool { return v.EQ(a.Version) }))
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	entries := []fileEntry{}
+	for _, v := range list[a.Name] {
+		e := fileEntry{Filename: v.Filename, Size: v.Filesize}
+		if rs, ok := st.(store.RawStore); ok {
+			if info, err := rs.Stat(a); err == nil {
+				e.Checksum = info.CSum
+			}
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if wantsJSON(r) {
+		writeJSON(w, entries)
+		return
+	}
+	rows := [][]string{}
+	for _, e := range entries {
+		rows = append(rows, []string{e.Filename, fmt.Sprintf("%d", e.Size), e.Checksum})
+		if e.Checksum != "" {
+			rows = append(rows, []string{e.Filename + store.CSumExt, fmt.Sprintf("%d", len(e.Checksum)), ""})
+		}
+	}
+	renderListing(w, r.URL.Path, []string{"filename", "size", "checksum"}, rows)
+}
+
+// setChecksumHeaders sets ETag and Digest (RFC 3230) from the checksum.
+func setChecksumHeaders(w http.ResponseWriter, csum string) {
+	if csum == "" {
+		return
+	}
+	w.Header().Set("ETag", `"`+csum+`"`)
+	algoHash := strings.SplitN(csum, store.CSumAlgoSeperator, 2)
+	if len(algoHash) == 2 && algoHash[0] == store.CSumAlgoSHA256 {
+		if h, err := hex.DecodeString(algoHash[1]); err == nil {
+			w.Header().Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(h))
+		}
+	}
+}
+
+// openObject returns a file outside of the artifact scheme, e.g. the
+// metadata of package repositories, if the store supports them.
+func openObject(st store.Store, key string) (io.ReadCloser, error) {
+	objects, err := repo.ObjectStore(st)
+	if err != nil {
+		return nil, store.ErrObjectNotFound
+	}
+	return objects.GetObject(key)
+}
+
+func serveObject(w http.ResponseWriter, r *http.Request, st store.Store, key string) {
+	obj, err := openObject(st, key)
+	if err == store.ErrObjectNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	defer obj.Close()
+	writeObject(w, r, key, obj)
+}
+
+func writeObject(w http.ResponseWriter, r *http.Request, key string, obj io.Reader) {
+	ct := mime.TypeByExtension(path.Ext(key))
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ct)
+	if r.Method == "HEAD" {
+		return
+	}
+	io.Copy(w, obj)
+}
+
+// servePlainObject serves key if it is no file of an artifact, files of
+// artifacts always come with a checksum file. Keys which are neither get a
+// 404, a directory of plain objects does not make up an artifact.
+func servePlainObject(w http.ResponseWriter, r *http.Request, objects store.ObjectStore, key string) bool {
+	csum := key + store.CSumExt
+	if store.IsSidecar(key) {
+		csum = key
+	}
+	if c, err := objects.GetObject(csum); err == nil {
+		c.Close()
+		return false
+	} else if err != store.ErrObjectNotFound {
+		serveError(w, err)
+		return true
+	}
+	obj, err := objects.GetObject(key)
+	if err == store.ErrObjectNotFound {
+		http.NotFound(w, r)
+		return true
+	} else if err != nil {
+		serveError(w, err)
+		return true
+	}
+	defer obj.Close()
+	writeObject(w, r, key, obj)
+	return true
+}
+
+// serveFile answers checksum, HEAD and conditional requests using the
+// metadata of the artifact. The file itself is streamed from stores which
+// are able to open it unless it has been compressed, otherwise it gets
+// downloaded and verified first.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, st store.Store, a store.Artifact, file, key string) {
+	// paths like those of a maven repository look like artifacts but are
+	// plain objects, a directory may hold several of them
+	if objects, err := repo.ObjectStore(st); err == nil && servePlainObject(w, r, objects, key) {
+		return
+	}
+
+	rs, isRaw := st.(store.RawStore)
+	if !isRaw {
+		if r.Method == "HEAD" && r.Header.Get("Range") == "" {
+			exists, filename, err := st.Has(a)
+			if err != nil {
+				serveError(w, err)
+			} else if !exists {
+				serveObject(w, r, st, key)
+			} else if filename != file {
+				http.NotFound(w, r)
+			}
+			return
+		}
+		s.serveFetched(w, r, st, a, file, key)
+		return
+	}
+
+	info, err := rs.Stat(a)
+	if err == store.ErrArtifactNotFound {
+		// paths like those of a maven repository look like
+		// artifacts but are plain objects
+		serveObject(w, r, st, key)
+		return
+	} else if err != nil {
+		serveError(w, err)
+		return
+	}
+	if file == info.Filename+store.CSumExt {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeContent(w, r, file, time.Time{}, strings.NewReader(info.CSum))
+		return
+	}
+	if file != info.Filename {
+		http.NotFound(w, r)
+		return
+	}
+
+	setChecksumHeaders(w, info.CSum)
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, `"`+info.CSum+`"`) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	opener, ok := st.(store.Opener)
+	if !ok || info.Encoding != "" {
+		if r.Method == "HEAD" && r.Header.Get("Range") == "" {
+			w.Header().Set("Accept-Ranges", "bytes")
+			return
+		}
+		s.serveFetched(w, r, st, a, file, key)
+		return
+	}
+	f, err := opener.Open(a, info.Filename)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	defer f.Close()
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, file, time.Time{}, rs)
+		return
+	}
+	writeObject(w, r, file, f)
+}
+
+// serveFetched downloads the artifact to a temporary directory and serves
+// it from there.
+func (s *Server) serveFetched(w http.ResponseWriter, r *http.Request, st store.Store, a store.Artifact, file, key string) {
+	tmp, err := ioutil.TempDir("", "arti-serve-")
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	defer os.RemoveAll(tmp)
+
+	target := filepath.Join(tmp, "file")
+	fetched, err := store.Fetch(st, a, target)
+	if err == store.ErrArtifactNotFound {
+		serveObject(w, r, st, key)
+		return
+	} else if err != nil {
+		serveError(w, err)
+		return
+	}
+	if fetched.Filename != file {
+		http.NotFound(w, r)
+		return
+	}
+	setChecksumHeaders(w, fetched.CSum)
+
+	f, err := os.Open(target)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	defer f.Close()
+	http.ServeContent(w, r, file, time.Time{}, f)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	expected, found := s.opts.Users[user]
+	if !found {
+		// compare anyway to not reveal which users exist
+		expected = password + "x"
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+}
+
+// serveUpload stores the request body as artifact. If the request has a
+// Digest header the body gets verified before storing it.
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, st store.Store, a store.Artifact, file string) {
+	if len(s.opts.Users) == 0 {
+		http.Error(w, "uploads are disabled", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="arti"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if file == "" || file == "." || file == ".." || file != filepath.Base(file) || store.IsSidecar(file) {
+		http.Error(w, "invalid filename", http.StatusBadRequest)
+		return
+	}
+	maxSize := s.opts.MaxUploadSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxUploadSize
+	}
+	if r.ContentLength > maxSize {
+		http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+
+	if exists, _, err := st.Has(a); err != nil {
+		serveError(w, err)
+		return
+	} else if exists {
+		http.Error(w, "artifact already exists", http.StatusConflict)
+		return
+	}
+
+	tmp, err := ioutil.TempDir("", "arti-serve-")
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	defer os.RemoveAll(tmp)
+
+	target := filepath.Join(tmp, file)
+	f, err := os.Create(target)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	n, err := io.Copy(f, r.Body)
+	f.Close()
+	if err != nil && n >= maxSize {
+		http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, "Error receiving file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if digest := r.Header.Get("Digest"); digest != "" {
+		if err = checkDigest(target, digest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err = st.Put(a, target); err != nil {
+		serveError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func checkDigest(filename, digest string) error {
+	for _, d := range strings.Split(digest, ",") {
+		algoValue := strings.SplitN(strings.TrimSpace(d), "=", 2)
+		if len(algoValue) != 2 || strings.ToUpper(algoValue[0]) != "SHA-256" {
+			continue
+		}
+		h, err := base64.StdEncoding.DecodeString(algoValue[1])
+		if err != nil {
+			return fmt.Errorf("invalid digest: %v", err)
+		}
+		csum := store.CSumAlgoSHA256 + store.CSumAlgoSeperator + hex.EncodeToString(h)
+		if valid, err := store.CheckCSum(filename, csum); err != nil {
+			return err
+		} else if !valid {
+			return fmt.Errorf("digest mismatch")
+		}
+	}
+	return nil
+}
+
+var listingTmpl = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>arti: {{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+<table>
+{{- if .Header}}
+<tr>{{range .Header}}<th align="left">{{.}}</th>{{end}}</tr>
+{{- end}}
+{{- if ne .Path "/"}}
+<tr><td><a href="../">../</a></td></tr>
+{{- end}}
+{{- range .Rows}}
+<tr>{{range $i, $c := .}}{{if eq $i 0}}<td><a href="{{$c}}">{{$c}}</a></td>{{else}}<td>{{$c}}</td>{{end}}{{end}}</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+func renderListing(w http.ResponseWriter, p string, header []string, rows [][]string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	listingTmpl.Execute(w, struct {
+		Path   string
+		Header []string
+		Rows   [][]string
+	}{p, header, rows})
+}
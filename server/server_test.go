@@ -0,0 +1,353 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server_test
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mgit-at/arti/repo/maven"
+	"github.com/mgit-at/arti/server"
+	"github.com/mgit-at/arti/store"
+)
+
+var content = []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+func checksum(data []byte) string {
+	h := sha256.Sum256(data)
+	return store.CSumAlgoSHA256 + store.CSumAlgoSeperator + hex.EncodeToString(h[:])
+}
+
+func digest(data []byte) string {
+	h := sha256.Sum256(data)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(h[:])
+}
+
+// newServer returns a server with an artifact app/1.0.0/app.tar stored in
+// st.
+func newServer(t *testing.T, st store.Store) *httptest.Server {
+	tmp, err := ioutil.TempDir("", "arti-server-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	filename := filepath.Join(tmp, "app.tar")
+	if err = ioutil.WriteFile(filename, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := store.MakeArtifact("app", "1.0.0")
+	if err = st.Put(a, filename); err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New([]server.Mount{{Name: "mem", Store: st}}, server.Options{Users: map[string]string{"deploy": "secret"}})
+	return httptest.NewServer(srv)
+}
+
+func request(t *testing.T, method, url string, header map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func testDownload(t *testing.T, st store.Store) {
+	srv := newServer(t, st)
+	defer srv.Close()
+	url := srv.URL + "/mem/app/1.0.0/app.tar"
+
+	resp, body := request(t, "GET", url, nil)
+	if resp.StatusCode != http.StatusOK || body != string(content) {
+		t.Fatalf("GET: unexpected response %d: %q", resp.StatusCode, body)
+	}
+	if etag := resp.Header.Get("ETag"); etag != `"`+checksum(content)+`"` {
+		t.Errorf("GET: unexpected ETag %q", etag)
+	}
+	if d := resp.Header.Get("Digest"); d != digest(content) {
+		t.Errorf("GET: unexpected Digest %q", d)
+	}
+
+	resp, body = request(t, "HEAD", url, nil)
+	if resp.StatusCode != http.StatusOK || body != "" {
+		t.Errorf("HEAD: unexpected response %d: %q", resp.StatusCode, body)
+	}
+	if etag := resp.Header.Get("ETag"); etag != `"`+checksum(content)+`"` {
+		t.Errorf("HEAD: unexpected ETag %q", etag)
+	}
+
+	resp, body = request(t, "GET", url, map[string]string{"Range": "bytes=2-5"})
+	if resp.StatusCode != http.StatusPartialContent || body != string(content[2:6]) {
+		t.Errorf("Range: unexpected response %d: %q", resp.StatusCode, body)
+	}
+
+	resp, body = request(t, "GET", url+store.CSumExt, nil)
+	if resp.StatusCode != http.StatusOK || body != checksum(content) {
+		t.Errorf("checksum: unexpected response %d: %q", resp.StatusCode, body)
+	}
+
+	resp, body = request(t, "GET", url, map[string]string{"If-None-Match": `"` + checksum(content) + `"`})
+	if resp.StatusCode != http.StatusNotModified || body != "" {
+		t.Errorf("If-None-Match: unexpected response %d: %q", resp.StatusCode, body)
+	}
+
+	for _, p := range []string{"/mem/app/1.0.0/other.tar", "/mem/app/2.0.0/app.tar", "/other/app/1.0.0/app.tar"} {
+		if resp, _ = request(t, "GET", srv.URL+p, nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, got %d", p, resp.StatusCode)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	testDownload(t, store.NewMemStore())
+}
+
+func TestDownloadCompressed(t *testing.T) {
+	for _, encoding := range []string{"gzip", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			st, err := store.NewCompressedStore(store.NewMemStore(), encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testDownload(t, st)
+		})
+	}
+}
+
+// failingStat is a store whose metadata is unavailable.
+type failingStat struct {
+	*store.FSStore
+}
+
+func (s failingStat) Stat(a store.Artifact) (store.RawInfo, error) {
+	return store.RawInfo{}, errors.New("metadata unavailable")
+}
+
+func TestDownloadObject(t *testing.T) {
+	st := store.NewMemStore()
+	srv := newServer(t, st)
+	defer srv.Close()
+
+	// paths of maven repositories look like artifacts
+	if err := st.PutObject("lib/1.0/lib-1.0.pom", strings.NewReader("<project/>")); err != nil {
+		t.Fatal(err)
+	}
+	resp, body := request(t, "GET", srv.URL+"/mem/lib/1.0/lib-1.0.pom", nil)
+	if resp.StatusCode != http.StatusOK || body != "<project/>" {
+		t.Errorf("unexpected response %d: %q", resp.StatusCode, body)
+	}
+
+	// objects are kept below the compression of a store
+	backend := store.NewMemStore()
+	compressed, err := store.NewCompressedStore(backend, store.EncodingGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = backend.PutObject("index.yaml", strings.NewReader("entries: {}")); err != nil {
+		t.Fatal(err)
+	}
+	csrv := httptest.NewServer(server.New([]server.Mount{{Name: "mem", Store: compressed}}, server.Options{}))
+	defer csrv.Close()
+	resp, body = request(t, "GET", csrv.URL+"/mem/index.yaml", nil)
+	if resp.StatusCode != http.StatusOK || body != "entries: {}" {
+		t.Errorf("unexpected response %d: %q", resp.StatusCode, body)
+	}
+
+	// objects are no fallback for failures of existing artifacts
+	failing := failingStat{store.NewMemStore()}
+	if err := failing.PutObject("app/1.0.0/app.tar", strings.NewReader("object")); err != nil {
+		t.Fatal(err)
+	}
+	if err := failing.PutObject("app/1.0.0/app.tar"+store.CSumExt, strings.NewReader(checksum([]byte("object")))); err != nil {
+		t.Fatal(err)
+	}
+	srv = httptest.NewServer(server.New([]server.Mount{{Name: "mem", Store: failing}}, server.Options{}))
+	defer srv.Close()
+	resp, body = request(t, "GET", srv.URL+"/mem/app/1.0.0/app.tar", nil)
+	if resp.StatusCode != http.StatusInternalServerError || !strings.Contains(body, "metadata unavailable") {
+		t.Errorf("unexpected response %d: %q", resp.StatusCode, body)
+	}
+}
+
+func TestDownloadMaven(t *testing.T) {
+	st := store.NewMemStore()
+	srv := newServer(t, st)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "arti-server-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jar := filepath.Join(dir, "lib-1.0.0.jar")
+	if err = ioutil.WriteFile(jar, []byte("jar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := maven.ParseCoordinates("com.example:lib", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &maven.Repository{Store: st}
+	if err = r.Deploy(c, []maven.File{c.ClassifyFile(jar)}); err != nil {
+		t.Fatal(err)
+	}
+
+	sha1sum := sha1.Sum([]byte("jar"))
+	for p, expected := range map[string]string{
+		"/mem/com/example/lib/1.0.0/lib-1.0.0.jar":      "jar",
+		"/mem/com/example/lib/1.0.0/lib-1.0.0.jar.sha1": hex.EncodeToString(sha1sum[:]),
+	} {
+		resp, body := request(t, "GET", srv.URL+p, nil)
+		if resp.StatusCode != http.StatusOK || body != expected {
+			t.Errorf("%s: unexpected response %d: %q", p, resp.StatusCode, body)
+		}
+	}
+	for _, p := range []string{"/mem/com/example/lib/1.0.0/lib-1.0.0.pom", "/mem/com/example/lib/maven-metadata.xml"} {
+		if resp, body := request(t, "GET", srv.URL+p, nil); resp.StatusCode != http.StatusOK || !strings.Contains(body, "<artifactId>lib</artifactId>") {
+			t.Errorf("%s: unexpected response %d: %q", p, resp.StatusCode, body)
+		}
+	}
+	if resp, _ := request(t, "GET", srv.URL+"/mem/com/example/lib/1.0.0/lib-1.0.0.war", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing file: unexpected status %d", resp.StatusCode)
+	}
+
+	// artifacts are still served with their checksums
+	resp, body := request(t, "GET", srv.URL+"/mem/app/1.0.0/app.tar", nil)
+	if resp.StatusCode != http.StatusOK || body != string(content) || resp.Header.Get("ETag") != `"`+checksum(content)+`"` {
+		t.Errorf("unexpected response %d: %q, ETag %s", resp.StatusCode, body, resp.Header.Get("ETag"))
+	}
+}
+
+func TestUpload(t *testing.T) {
+	srv := newServer(t, store.NewMemStore())
+	defer srv.Close()
+
+	upload := func(p, user, password, d string) int {
+		req, err := http.NewRequest("PUT", srv.URL+p, strings.NewReader(string(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		if d != "" {
+			req.Header.Set("Digest", d)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		path, user, password, digest string
+		status                       int
+	}{
+		{"/mem/app/2.0.0/app.tar", "", "", "", http.StatusUnauthorized},
+		{"/mem/app/2.0.0/app.tar", "deploy", "wrong", "", http.StatusUnauthorized},
+		{"/mem/app/2.0.0/app.tar", "nobody", "secret", "", http.StatusUnauthorized},
+		{"/mem/app/2.0.0/app.tar", "deploy", "secret", digest([]byte("other")), http.StatusBadRequest},
+		{"/mem/app/2.0.0/app.tar", "deploy", "secret", "SHA-256=invalid!", http.StatusBadRequest},
+		{"/mem/app/2.0.0/app.tar" + store.CSumExt, "deploy", "secret", "", http.StatusBadRequest},
+		{"/mem/app/2.0.0/app.tar", "deploy", "secret", digest(content), http.StatusCreated},
+		{"/mem/app/2.0.0/app.tar", "deploy", "secret", "", http.StatusConflict},
+		{"/mem/app/1.0.0/app.tar", "deploy", "secret", "", http.StatusConflict},
+		{"/mem/app/3.0.0/app.tar", "deploy", "secret", "", http.StatusCreated},
+		{"/mem/app/4.0.0/..", "deploy", "secret", "", http.StatusBadRequest},
+		{"/mem/app/4.0.0/.", "deploy", "secret", "", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if status := upload(test.path, test.user, test.password, test.digest); status != test.status {
+			t.Errorf("PUT %s as '%s' with digest '%s': expected status %d, got %d", test.path, test.user, test.digest, test.status, status)
+		}
+	}
+
+	resp, body := request(t, "GET", srv.URL+"/mem/app/2.0.0/app.tar", nil)
+	if resp.StatusCode != http.StatusOK || body != string(content) {
+		t.Errorf("unexpected response %d: %q", resp.StatusCode, body)
+	}
+}
+
+func TestUploadTooLarge(t *testing.T) {
+	srv := httptest.NewServer(server.New([]server.Mount{{Name: "mem", Store: store.NewMemStore()}}, server.Options{
+		Users:         map[string]string{"deploy": "secret"},
+		MaxUploadSize: int64(len(content)) - 1,
+	}))
+	defer srv.Close()
+
+	for _, chunked := range []bool{false, true} {
+		var body io.Reader = strings.NewReader(string(content))
+		if chunked {
+			// hides the length of the body
+			body = ioutil.NopCloser(body)
+		}
+		req, err := http.NewRequest("PUT", srv.URL+"/mem/app/1.0.0/app.tar", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("deploy", "secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("chunked %t: expected status 413, got %d", chunked, resp.StatusCode)
+		}
+	}
+}
+
+func TestUploadDisabled(t *testing.T) {
+	srv := httptest.NewServer(server.New([]server.Mount{{Name: "mem", Store: store.NewMemStore()}}, server.Options{}))
+	defer srv.Close()
+
+	req, err := http.NewRequest("PUT", srv.URL+"/mem/app/1.0.0/app.tar", strings.NewReader(string(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("deploy", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", resp.StatusCode)
+	}
+}
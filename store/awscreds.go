@@ -0,0 +1,412 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// awsRefreshWindow is how long before they expire temporary credentials
+	// are renewed, which allows requests already signed to complete.
+	awsRefreshWindow = 5 * time.Minute
+
+	awsDefaultSTSEndpoint      = "https://sts.amazonaws.com"
+	awsDefaultMetadataEndpoint = "http://169.254.169.254"
+	awsECSEndpoint             = "http://169.254.170.2"
+)
+
+// errNoAWSCredentials is returned by providers which are not configured.
+var errNoAWSCredentials = errors.New("no credentials")
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expiration is zero for credentials which don't expire.
+	Expiration time.Time
+}
+
+func (c awsCredentials) temporary() bool {
+	return c.SessionToken != "" || !c.Expiration.IsZero()
+}
+
+// awsProvider retrieves credentials from one source of the chain.
+type awsProvider struct {
+	name     string
+	retrieve func() (awsCredentials, error)
+}
+
+// awsCredentialChain looks up credentials like the AWS tools do: the
+// settings of the store, AWS_* environment variables, a web identity token,
+// a profile of ~/.aws/credentials, the ECS container endpoint and the EC2
+// instance metadata service. The first source which provides credentials
+// is used from then on. Temporary credentials are renewed before they
+// expire.
+type awsCredentialChain struct {
+	providers []awsProvider
+
+	mu       sync.Mutex
+	provider *awsProvider
+	creds    awsCredentials
+}
+
+// newAWSMetadataClient returns a client for the container and instance
+// metadata services, which are local and must not be asked via a proxy.
+func newAWSMetadataClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: &http.Transport{Proxy: nil}}
+}
+
+func newAWSCredentialChain(cfg *viper.Viper) *awsCredentialChain {
+	client := &http.Client{Timeout: 5 * time.Second}
+	metadata := newAWSMetadataClient(5 * time.Second)
+	return &awsCredentialChain{providers: []awsProvider{
+		{"config", func() (awsCredentials, error) {
+			return staticAWSCredentials(cfg.GetString("access-key-id"), cfg.GetString("secret-access-key"), cfg.GetString("session-token"))
+		}},
+		{"environment", func() (awsCredentials, error) {
+			id := firstEnv("AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY")
+			secret := firstEnv("AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY")
+			return staticAWSCredentials(id, secret, os.Getenv("AWS_SESSION_TOKEN"))
+		}},
+		{"web identity", func() (awsCredentials, error) {
+			return webIdentityAWSCredentials(client,
+				firstNonEmpty(cfg.GetString("web-identity-token-file"), os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")),
+				firstNonEmpty(cfg.GetString("role-arn"), os.Getenv("AWS_ROLE_ARN")),
+				firstNonEmpty(cfg.GetString("role-session-name"), os.Getenv("AWS_ROLE_SESSION_NAME"), "arti"),
+				firstNonEmpty(cfg.GetString("sts-endpoint"), os.Getenv("AWS_STS_ENDPOINT"), awsDefaultSTSEndpoint))
+		}},
+		{"shared credentials file", func() (awsCredentials, error) {
+			return profileAWSCredentials(
+				firstNonEmpty(cfg.GetString("credentials-file"), os.Getenv("AWS_SHARED_CREDENTIALS_FILE"), filepath.Join(os.Getenv("HOME"), ".aws", "credentials")),
+				firstNonEmpty(cfg.GetString("profile"), os.Getenv("AWS_PROFILE"), "default"))
+		}},
+		{"container endpoint", func() (awsCredentials, error) {
+			u := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+			if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+				u = awsECSEndpoint + rel
+			}
+			if u == "" {
+				return awsCredentials{}, errNoAWSCredentials
+			}
+			return containerAWSCredentials(metadata, u, os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"))
+		}},
+		{"instance metadata", func() (awsCredentials, error) {
+			if strings.ToLower(os.Getenv("AWS_EC2_METADATA_DISABLED")) == "true" {
+				return awsCredentials{}, errNoAWSCredentials
+			}
+			endpoint := firstNonEmpty(cfg.GetString("metadata-endpoint"), os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), awsDefaultMetadataEndpoint)
+			return instanceAWSCredentials(metadata, endpoint, cfg.IsSet("metadata-endpoint"))
+		}},
+	}}
+}
+
+// get returns valid credentials. Empty credentials are returned if no
+// source provides any, requests are sent anonymously in this case.
+func (c *awsCredentialChain) get() (awsCredentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.provider != nil {
+		if c.creds.Expiration.IsZero() || time.Now().Add(awsRefreshWindow).Before(c.creds.Expiration) {
+			return c.creds, nil
+		}
+		creds, err := c.provider.retrieve()
+		if err != nil {
+			return awsCredentials{}, fmt.Errorf("Error renewing credentials from %s: %v", c.provider.name, err)
+		}
+		c.creds = creds
+		return creds, nil
+	}
+
+	for i := range c.providers {
+		creds, err := c.providers[i].retrieve()
+		if err == errNoAWSCredentials {
+			continue
+		}
+		if err != nil {
+			return awsCredentials{}, fmt.Errorf("Error fetching credentials from %s: %v", c.providers[i].name, err)
+		}
+		c.provider, c.creds = &c.providers[i], creds
+		return creds, nil
+	}
+	c.provider = &awsProvider{"anonymous", func() (awsCredentials, error) { return awsCredentials{}, nil }}
+	return c.creds, nil
+}
+
+// source returns the name of the source the credentials come from.
+func (c *awsCredentialChain) source() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.provider == nil {
+		return ""
+	}
+	return c.provider.name
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func firstEnv(names ...string) string {
+	for _, n := range names {
+		if v := os.Getenv(n); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func staticAWSCredentials(id, secret, token string) (awsCredentials, error) {
+	if id == "" && secret == "" {
+		return awsCredentials{}, errNoAWSCredentials
+	}
+	if id == "" || secret == "" {
+		return awsCredentials{}, fmt.Errorf("access key id and secret access key must be set both")
+	}
+	return awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: token}, nil
+}
+
+// profileAWSCredentials reads a profile of a shared credentials file.
+func profileAWSCredentials(filename, profile string) (awsCredentials, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return awsCredentials{}, errNoAWSCredentials
+	}
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if section != profile || len(kv) != 2 {
+			continue
+		}
+		values[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return awsCredentials{}, err
+	}
+	if len(values) == 0 {
+		return awsCredentials{}, errNoAWSCredentials
+	}
+	return staticAWSCredentials(values["aws_access_key_id"], values["aws_secret_access_key"], values["aws_session_token"])
+}
+
+type stsCredentials struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+type stsResponse struct {
+	Credentials stsCredentials `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+type stsError struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// webIdentityAWSCredentials exchanges the token of tokenFile, e.g. issued
+// by Kubernetes, for temporary credentials of a role.
+func webIdentityAWSCredentials(client *http.Client, tokenFile, roleARN, sessionName, endpoint string) (awsCredentials, error) {
+	if tokenFile == "" || roleARN == "" {
+		return awsCredentials{}, errNoAWSCredentials
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	form := url.Values{}
+	form.Set("Action", "AssumeRoleWithWebIdentity")
+	form.Set("Version", "2011-06-15")
+	form.Set("RoleArn", roleARN)
+	form.Set("RoleSessionName", sessionName)
+	form.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+	resp, err := client.PostForm(endpoint, form)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := stsError{}
+		if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+			return awsCredentials{}, fmt.Errorf("%s: %s", e.Code, e.Message)
+		}
+		return awsCredentials{}, fmt.Errorf("%s", resp.Status)
+	}
+
+	r := stsResponse{}
+	if err := xml.Unmarshal(body, &r); err != nil {
+		return awsCredentials{}, fmt.Errorf("invalid response: %v", err)
+	}
+	c := r.Credentials
+	if c.AccessKeyID == "" {
+		return awsCredentials{}, fmt.Errorf("invalid response: no credentials")
+	}
+	return awsCredentials{c.AccessKeyID, c.SecretAccessKey, c.SessionToken, c.Expiration}, nil
+}
+
+type metadataCredentials struct {
+	Code            string
+	Message         string
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+func fetchMetadataCredentials(client *http.Client, req *http.Request) (awsCredentials, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("%s", resp.Status)
+	}
+	c := metadataCredentials{}
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return awsCredentials{}, fmt.Errorf("invalid response: %v", err)
+	}
+	if c.Code != "" && c.Code != "Success" {
+		return awsCredentials{}, fmt.Errorf("%s: %s", c.Code, c.Message)
+	}
+	if c.AccessKeyID == "" {
+		return awsCredentials{}, fmt.Errorf("invalid response: no credentials")
+	}
+	return awsCredentials{c.AccessKeyID, c.SecretAccessKey, c.Token, c.Expiration}, nil
+}
+
+// containerAWSCredentials fetches the credentials of an ECS task.
+func containerAWSCredentials(client *http.Client, u, authToken string) (awsCredentials, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if authToken != "" {
+		req.Header.Set("Authorization", authToken)
+	}
+	return fetchMetadataCredentials(client, req)
+}
+
+// instanceAWSCredentials fetches the credentials of the role of an EC2
+// instance. Unless the endpoint was configured explicitly, a metadata
+// service which is unreachable or doesn't know a role means we are not
+// running on EC2.
+func instanceAWSCredentials(client *http.Client, endpoint string, explicit bool) (awsCredentials, error) {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	notFound := func(err error) (awsCredentials, error) {
+		if !explicit {
+			return awsCredentials{}, errNoAWSCredentials
+		}
+		return awsCredentials{}, err
+	}
+
+	// use IMDSv2 if available
+	token := ""
+	req, err := http.NewRequest("PUT", endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	probe := client
+	if !explicit {
+		probe = newAWSMetadataClient(time.Second)
+	}
+	resp, err := probe.Do(req)
+	if err != nil {
+		return notFound(err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		token = strings.TrimSpace(string(data))
+	}
+	resp.Body.Close()
+
+	get := func(p string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", endpoint+"/latest/meta-data/iam/security-credentials/"+p, nil)
+		if err == nil && token != "" {
+			req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+		}
+		return req, err
+	}
+
+	req, err = get("")
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if resp, err = client.Do(req); err != nil {
+		return notFound(err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return notFound(err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return awsCredentials{}, errNoAWSCredentials // no role attached
+	}
+	if resp.StatusCode != http.StatusOK {
+		return notFound(fmt.Errorf("%s", resp.Status))
+	}
+	role := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+	if role == "" {
+		return awsCredentials{}, errNoAWSCredentials
+	}
+
+	if req, err = get(url.PathEscape(role)); err != nil {
+		return awsCredentials{}, err
+	}
+	return fetchMetadataCredentials(client, req)
+}
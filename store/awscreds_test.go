@@ -0,0 +1,336 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/s3test"
+	"github.com/spf13/viper"
+)
+
+var awsEnv = []string{
+	"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY", "AWS_SESSION_TOKEN",
+	"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME", "AWS_STS_ENDPOINT",
+	"AWS_SHARED_CREDENTIALS_FILE", "AWS_PROFILE",
+	"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_AUTHORIZATION_TOKEN",
+	"AWS_EC2_METADATA_SERVICE_ENDPOINT", "AWS_EC2_METADATA_DISABLED", "HOME",
+}
+
+// isolateAWS clears the AWS environment, so only the sources set up by a
+// test are used, and returns a function restoring it.
+func isolateAWS(t *testing.T) func() {
+	saved := make(map[string]string)
+	for _, name := range awsEnv {
+		if v, ok := os.LookupEnv(name); ok {
+			saved[name] = v
+		}
+		os.Unsetenv(name)
+	}
+	home, err := ioutil.TempDir("", "arti-aws-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("HOME", home)
+	os.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	return func() {
+		os.RemoveAll(home)
+		for _, name := range awsEnv {
+			os.Unsetenv(name)
+		}
+		for name, v := range saved {
+			os.Setenv(name, v)
+		}
+	}
+}
+
+// s3WithCredentials returns a S3 server accepting the given access key and
+// a configuration for it without credentials.
+func s3WithCredentials(t *testing.T, accessKeyID, sessionToken string) (*s3test.Server, *viper.Viper, func()) {
+	srv := s3test.New()
+	srv.MakeBucket("artifacts")
+	srv.AddCredentials(accessKeyID, sessionToken)
+	ts := httptest.NewServer(srv)
+
+	cfg := viper.New()
+	cfg.Set("endpoint", strings.TrimPrefix(ts.URL, "http://"))
+	cfg.Set("nossl", true)
+	return srv, cfg, ts.Close
+}
+
+func checkS3Access(t *testing.T, cfg *viper.Viper, source string) store.Store {
+	s, err := store.NewS3Store(cfg, "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.(store.Describer).Describe()["credentials"]; got != source {
+		t.Errorf("credentials taken from %q, want %q", got, source)
+	}
+	if _, err := s.List("", nil); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	return s
+}
+
+func TestAWSCredentialsEnvironment(t *testing.T) {
+	defer isolateAWS(t)()
+	_, cfg, done := s3WithCredentials(t, "env-key", "env-token")
+	defer done()
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	os.Setenv("AWS_SESSION_TOKEN", "env-token")
+	checkS3Access(t, cfg, "environment")
+
+	// the settings of the store take precedence
+	cfg.Set("access-key-id", "other-key")
+	cfg.Set("secret-access-key", "secret")
+	s, err := store.NewS3Store(cfg, "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.List("", nil); err == nil {
+		t.Fatal("List with wrong credentials succeeded")
+	}
+}
+
+func TestAWSCredentialsProfile(t *testing.T) {
+	defer isolateAWS(t)()
+	_, cfg, done := s3WithCredentials(t, "ci-key", "")
+	defer done()
+
+	fn := filepath.Join(os.Getenv("HOME"), "credentials")
+	data := `
+[default]
+aws_access_key_id = default-key
+aws_secret_access_key = secret
+
+# used by the CI
+[ci]
+aws_access_key_id = ci-key
+aws_secret_access_key = secret
+`
+	if err := ioutil.WriteFile(fn, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Set("credentials-file", fn)
+	cfg.Set("profile", "ci")
+	checkS3Access(t, cfg, "shared credentials file")
+}
+
+// credentialIssuer hands out new temporary credentials on every request and
+// registers them with the S3 server.
+type credentialIssuer struct {
+	mu     sync.Mutex
+	srv    *s3test.Server
+	issued int
+	valid  time.Duration
+}
+
+func (c *credentialIssuer) issue() (id, token string, expiration time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.issued++
+	id, token = fmt.Sprintf("key-%d", c.issued), fmt.Sprintf("token-%d", c.issued)
+	c.srv.AddCredentials(id, token)
+	return id, token, time.Now().Add(c.valid).UTC()
+}
+
+func (c *credentialIssuer) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.issued
+}
+
+func (c *credentialIssuer) writeJSON(w http.ResponseWriter) {
+	id, token, exp := c.issue()
+	json.NewEncoder(w).Encode(map[string]string{
+		"Code":            "Success",
+		"AccessKeyId":     id,
+		"SecretAccessKey": "secret",
+		"Token":           token,
+		"Expiration":      exp.Format(time.RFC3339),
+	})
+}
+
+func TestAWSCredentialsWebIdentity(t *testing.T) {
+	defer isolateAWS(t)()
+	srv, cfg, done := s3WithCredentials(t, "unused", "")
+	defer done()
+
+	issuer := &credentialIssuer{srv: srv, valid: time.Hour}
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("WebIdentityToken") != "jwt" ||
+			r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/arti" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<ErrorResponse><Error><Code>AccessDenied</Code><Message>denied</Message></Error></ErrorResponse>")
+			return
+		}
+		id, token, exp := issuer.issue()
+		fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+<AccessKeyId>%s</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>%s</SessionToken>
+<Expiration>%s</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`,
+			id, token, exp.Format(time.RFC3339))
+	}))
+	defer sts.Close()
+
+	fn := filepath.Join(os.Getenv("HOME"), "token")
+	if err := ioutil.WriteFile(fn, []byte("jwt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", fn)
+	os.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/arti")
+	cfg.Set("sts-endpoint", sts.URL)
+	checkS3Access(t, cfg, "web identity")
+
+	os.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/other")
+	if _, err := store.NewS3Store(cfg, "artifacts"); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("expected the STS error, got %v", err)
+	}
+}
+
+func TestAWSCredentialsContainer(t *testing.T) {
+	defer isolateAWS(t)()
+	srv, cfg, done := s3WithCredentials(t, "unused", "")
+	defer done()
+
+	issuer := &credentialIssuer{srv: srv, valid: time.Hour}
+	ecs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/credentials" || r.Header.Get("Authorization") != "secret-token" {
+			http.NotFound(w, r)
+			return
+		}
+		issuer.writeJSON(w)
+	}))
+	defer ecs.Close()
+
+	os.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", ecs.URL+"/v2/credentials")
+	os.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "secret-token")
+	checkS3Access(t, cfg, "container endpoint")
+}
+
+func TestAWSCredentialsInstanceMetadataRefresh(t *testing.T) {
+	defer isolateAWS(t)()
+	os.Unsetenv("AWS_EC2_METADATA_DISABLED")
+	srv, cfg, done := s3WithCredentials(t, "unused", "")
+	defer done()
+
+	// the credentials expire within the refresh window, so new ones are
+	// fetched for every request
+	issuer := &credentialIssuer{srv: srv, valid: time.Minute}
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+			fmt.Fprint(w, "imds-token")
+		case r.Header.Get("X-Aws-Ec2-Metadata-Token") != "imds-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, "arti-role")
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/arti-role":
+			issuer.writeJSON(w)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer imds.Close()
+	cfg.Set("metadata-endpoint", imds.URL)
+
+	s := checkS3Access(t, cfg, "instance metadata")
+	before := issuer.count()
+	a, _ := store.MakeArtifact("app", "1.0.0")
+	fn := filepath.Join(os.Getenv("HOME"), "app.tar.gz")
+	if err := ioutil.WriteFile(fn, []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(a, fn); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if issuer.count() <= before {
+		t.Error("credentials were not renewed")
+	}
+
+	pa, err := s.(store.Presigner).PresignGet(a, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(pa.URL, "X-Amz-Security-Token=token-") {
+		t.Errorf("pre-signed URL lacks the session token: %s", pa.URL)
+	}
+	resp, err := http.Get(pa.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("pre-signed URL returned %s", resp.Status)
+	}
+
+	cfg.Set("version", 2)
+	if _, err := store.NewS3Store(cfg, "artifacts"); err == nil {
+		t.Error("temporary credentials were accepted with signature version 2")
+	}
+}
+
+func TestAWSCredentialsAnonymous(t *testing.T) {
+	defer isolateAWS(t)()
+	srv := s3test.New()
+	srv.MakeBucket("artifacts")
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	cfg := viper.New()
+	cfg.Set("endpoint", strings.TrimPrefix(ts.URL, "http://"))
+	cfg.Set("nossl", true)
+	checkS3Access(t, cfg, "anonymous")
+}
+
+func TestAWSCredentialsNoInstanceMetadata(t *testing.T) {
+	defer isolateAWS(t)()
+	os.Unsetenv("AWS_EC2_METADATA_DISABLED")
+	srv := s3test.New()
+	srv.MakeBucket("artifacts")
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// e.g. a proxy answering in place of the metadata service
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+	os.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", bad.URL)
+
+	cfg := viper.New()
+	cfg.Set("endpoint", strings.TrimPrefix(ts.URL, "http://"))
+	cfg.Set("nossl", true)
+	checkS3Access(t, cfg, "anonymous")
+
+	// a configured endpoint has to work
+	cfg.Set("metadata-endpoint", bad.URL)
+	if _, err := store.NewS3Store(cfg, "artifacts"); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("broken metadata-endpoint: got %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/spf13/viper"
+)
+
+// Cache is a local directory holding downloaded files by their checksum.
+// Files are kept as <dir>/<algorithm>/<first two digits>/<hash>. The
+// modification time of a file is the last time it has been used, which is
+// used to evict the least recently used files once the cache grows beyond
+// MaxSize. The directory may be shared by several stores and processes.
+type Cache struct {
+	Dir     string
+	MaxSize int64
+}
+
+// CacheEntry describes a file of the cache.
+type CacheEntry struct {
+	CSum     string
+	Size     int64
+	LastUsed time.Time
+	Path     string
+}
+
+type cacheEntries []CacheEntry
+
+func (e cacheEntries) Len() int           { return len(e) }
+func (e cacheEntries) Less(i, j int) bool { return e[i].LastUsed.After(e[j].LastUsed) }
+func (e cacheEntries) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// DefaultCacheDir returns $XDG_CACHE_HOME/arti or ~/.cache/arti.
+func DefaultCacheDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "arti")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".cache", "arti")
+}
+
+// path returns the location of the file with the checksum csum.
+func (c *Cache) path(csum string) (string, error) {
+	algoHash := strings.SplitN(csum, CSumAlgoSeperator, 2)
+	if len(algoHash) != 2 || len(algoHash[1]) < 8 {
+		return "", fmt.Errorf("invalid checksum: %s", csum)
+	}
+	if _, found := CSumAlgos[algoHash[0]]; !found {
+		return "", fmt.Errorf("unkown checksum algorithm: %s", algoHash[0])
+	}
+	if _, err := hex.DecodeString(algoHash[1]); err != nil {
+		return "", fmt.Errorf("invalid checksum: %s", csum)
+	}
+	return filepath.Join(c.Dir, algoHash[0], algoHash[1][:2], algoHash[1]), nil
+}
+
+// Get copies the file with the checksum csum to target. It returns false if
+// there is no such file. Corrupted files are removed from the cache.
+func (c *Cache) Get(csum, target string) (bool, error) {
+	p, err := c.path(csum)
+	if err != nil {
+		return false, err
+	}
+	src, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	if err = copyToFile(target, src); err != nil {
+		return false, err
+	}
+	if valid, err := CheckCSum(target, csum); err != nil || !valid {
+		os.Remove(target)
+		os.Remove(p)
+		return false, err
+	}
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return true, nil
+}
+
+// Add copies filename into the cache and evicts old files if the cache has
+// grown too large.
+func (c *Cache) Add(csum, filename string) error {
+	p, err := c.path(csum)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	// copy to a temporary file first so that other processes never see a
+	// partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if c.MaxSize > 0 {
+		_, err = c.Evict(c.MaxSize)
+	}
+	return err
+}
+
+// Entries returns all files of the cache, the most recently used first.
+func (c *Cache) Entries() ([]CacheEntry, error) {
+	entries := []CacheEntry{}
+	err := filepath.Walk(c.Dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+		algo := filepath.Base(filepath.Dir(filepath.Dir(p)))
+		if _, found := CSumAlgos[algo]; !found {
+			return nil
+		}
+		entries = append(entries, CacheEntry{
+			CSum:     algo + CSumAlgoSeperator + fi.Name(),
+			Size:     fi.Size(),
+			LastUsed: fi.ModTime(),
+			Path:     p,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(cacheEntries(entries))
+	return entries, nil
+}
+
+// Evict removes the least recently used files until the cache is no larger
+// than maxSize and returns the removed files.
+func (c *Cache) Evict(maxSize int64) ([]CacheEntry, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, e := range entries {
+		size += e.Size
+	}
+	removed := []CacheEntry{}
+	for i := len(entries) - 1; i >= 0 && size > maxSize; i-- {
+		if err := os.Remove(entries[i].Path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		size -= entries[i].Size
+		removed = append(removed, entries[i])
+	}
+	return removed, nil
+}
+
+// Verify checks the checksums of all files and removes corrupted ones,
+// which are returned.
+func (c *Cache) Verify() ([]CacheEntry, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+	corrupted := []CacheEntry{}
+	for _, e := range entries {
+		if valid, err := CheckCSum(e.Path, e.CSum); err != nil {
+			return corrupted, err
+		} else if valid {
+			continue
+		}
+		if err := os.Remove(e.Path); err != nil {
+			return corrupted, err
+		}
+		corrupted = append(corrupted, e)
+	}
+	return corrupted, nil
+}
+
+func copyToFile(target string, src io.Reader) error {
+	dst, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(target)
+	}
+	return err
+}
+
+// CachedStore keeps downloaded artifacts in a Cache. The checksum of an
+// artifact is always fetched from the backend, so artifacts which have
+// been replaced are downloaded again.
+type CachedStore struct {
+	backend RawStore
+	cache   *Cache
+}
+
+func NewCachedStore(backend Store, cache *Cache) (*CachedStore, error) {
+	rs, ok := backend.(RawStore)
+	if !ok {
+		return nil, fmt.Errorf("store type %T does not support caching", backend)
+	}
+	return &CachedStore{backend: rs, cache: cache}, nil
+}
+
+func newCachedStoreFromConfig(cfg *viper.Viper, backend Store) (Store, error) {
+	if enabled, ok := cfg.Get("cache").(bool); ok && !enabled {
+		return backend, nil
+	}
+	cache := &Cache{Dir: cfg.GetString("cache.dir"), MaxSize: int64(cfg.GetSizeInBytes("cache.max-size"))}
+	if cache.Dir == "" {
+		cache.Dir = DefaultCacheDir()
+	}
+	return NewCachedStore(backend, cache)
+}
+
+func (s *CachedStore) List(name string, versions semver.Range) (ArtifactList, error) {
+	return s.backend.List(name, versions)
+}
+
+func (s *CachedStore) Has(artifact Artifact) (bool, string, error) {
+	return s.backend.Has(artifact)
+}
+
+func (s *CachedStore) Put(artifact Artifact, filename string) error {
+	return s.backend.Put(artifact, filename)
+}
+
+func (s *CachedStore) PutRaw(artifact Artifact, filename string, info RawInfo) error {
+	return s.backend.PutRaw(artifact, filename, info)
+}
+
+func (s *CachedStore) Get(artifact Artifact, filename string, keepCorrupted bool) error {
+	_, err := s.fetch(artifact, filename, keepCorrupted)
+	return err
+}
+
+// GetRaw bypasses the cache as the cache only holds decoded files.
+func (s *CachedStore) GetRaw(artifact Artifact, filename string) (RawInfo, error) {
+	return s.backend.GetRaw(artifact, filename)
+}
+
+// fetch copies the artifact from the cache if possible and downloads it
+// from the backend otherwise. Either way the file gets verified.
+func (s *CachedStore) fetch(artifact Artifact, filename string, keepCorrupted bool) (RawInfo, error) {
+	info, err := s.backend.Stat(artifact)
+	if err != nil {
+		return info, err
+	}
+	target := filename
+	if target == "" {
+		target = info.Filename
+	}
+
+	if hit, err := s.cache.Get(info.CSum, target); err != nil {
+		log.Printf("warning: unable to read from cache: %v", err)
+	} else if hit {
+		info.Encoding = ""
+		return info, nil
+	}
+
+	if info, err = getVerified(s.backend.GetRaw, artifact, filename, keepCorrupted); err != nil {
+		return info, err
+	}
+	if err = s.cache.Add(info.CSum, target); err != nil {
+		log.Printf("warning: unable to add '%s' to cache: %v", info.Filename, err)
+	}
+	return info, nil
+}
+
+func (s *CachedStore) Stat(artifact Artifact) (RawInfo, error) {
+	return s.backend.Stat(artifact)
+}
+
+func (s *CachedStore) Del(artifact Artifact) error {
+	return s.backend.Del(artifact)
+}
+
+func (s *CachedStore) Describe() map[string]string {
+	info := map[string]string{}
+	if d, ok := s.backend.(Describer); ok {
+		info = d.Describe()
+	}
+	info["cache"] = s.cache.Dir
+	if s.cache.MaxSize > 0 {
+		info["cache"] += fmt.Sprintf(" (max. %d Bytes)", s.cache.MaxSize)
+	}
+	return info
+}
@@ -0,0 +1,123 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/storetest"
+)
+
+func TestCachedStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "arti-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	storetest.Run(t, func(t *testing.T) store.Store {
+		dir, err := ioutil.TempDir(root, "cache-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := store.NewCachedStore(store.NewMemStore(), &store.Cache{Dir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestCachedStoreGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := store.NewMemStore()
+	cache := &store.Cache{Dir: filepath.Join(dir, "cache"), MaxSize: 10}
+	s, err := store.NewCachedStore(backend, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(version, content string) store.Artifact {
+		a, _ := store.MakeArtifact("app", version)
+		src := filepath.Join(dir, "app.tar.gz")
+		if err := ioutil.WriteFile(src, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(a, src); err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	get := func(a store.Artifact) string {
+		target := filepath.Join(dir, "download")
+		if err := s.Get(a, target, false); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		data, _ := ioutil.ReadFile(target)
+		return string(data)
+	}
+
+	a := put("1.0.0", "123456")
+	if got := get(a); got != "123456" {
+		t.Fatalf("Get = %q", got)
+	}
+	// the file in the backend gets replaced without updating the checksum,
+	// so a download from the backend would fail
+	if err := backend.PutObject("app/1.0.0/app.tar.gz", bytes.NewBufferString("corrupt")); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(a); got != "123456" {
+		t.Fatalf("Get from cache = %q", got)
+	}
+
+	// another file exceeds the size limit, so the first one gets evicted
+	b := put("1.1.0", "abcdef")
+	get(b)
+	entries, err := cache.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Size != 6 {
+		t.Fatalf("cache holds %+v", entries)
+	}
+	if err := s.Get(a, filepath.Join(dir, "download"), false); err == nil {
+		t.Fatal("Get of the evicted, corrupted artifact succeeded")
+	}
+
+	// corrupted cache files are detected
+	if err := ioutil.WriteFile(entries[0].Path, []byte("ABCDEF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	corrupted, err := cache.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(corrupted) != 1 {
+		t.Fatalf("Verify found %+v", corrupted)
+	}
+	if got := get(b); got != "abcdef" {
+		t.Fatalf("Get after Verify = %q", got)
+	}
+}
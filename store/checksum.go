@@ -76,7 +76,7 @@ type CSumResult struct {
 }
 
 func calcCSum(filename string) <-chan CSumResult {
-	c := make(chan CSumResult)
+	c := make(chan CSumResult, 1)
 
 	go func() {
 		res := CSumResult{"", nil}
@@ -96,7 +96,13 @@ func calcCSum(filename string) <-chan CSumResult {
 	return c
 }
 
-func checkCSum(filename, toCompare string) (bool, error) {
+// CalcCSum returns the checksum of a file as stored next to artifacts.
+func CalcCSum(filename string) (string, error) {
+	res := <-calcCSum(filename)
+	return res.hash, res.err
+}
+
+func CheckCSum(filename, toCompare string) (bool, error) {
 	tmp := strings.SplitN(toCompare, CSumAlgoSeperator, 2)
 	if len(tmp) != 2 {
 		return false, fmt.Errorf("invalid checksum file")
@@ -107,3 +113,17 @@ func checkCSum(filename, toCompare string) (bool, error) {
 	}
 	return algo.check(filename, tmp[1])
 }
+
+func verifyCSum(filename, toCompare string, keepCorrupted bool) error {
+	valid, err := CheckCSum(filename, toCompare)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		if !keepCorrupted {
+			os.Remove(filename)
+		}
+		return fmt.Errorf("hash-sum mismatch!")
+	}
+	return nil
+}
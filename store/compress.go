@@ -0,0 +1,215 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingExt = ".encoding"
+
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// IsSidecar reports whether the file belongs to another file of the same
+// artifact version rather than being the artifact itself.
+func IsSidecar(filename string) bool {
+	return strings.HasSuffix(filename, CSumExt) || strings.HasSuffix(filename, EncodingExt)
+}
+
+func checkEncoding(encoding string) error {
+	switch encoding {
+	case EncodingGzip, EncodingZstd:
+		return nil
+	}
+	return fmt.Errorf("unknown encoding '%s' (supported are %s and %s)", encoding, EncodingGzip, EncodingZstd)
+}
+
+func encode(dst io.Writer, src io.Reader, encoding string) error {
+	var w io.WriteCloser
+	switch encoding {
+	case EncodingGzip:
+		w = gzip.NewWriter(dst)
+	case EncodingZstd:
+		zw, err := zstd.NewWriter(dst)
+		if err != nil {
+			return err
+		}
+		w = zw
+	default:
+		return checkEncoding(encoding)
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func decode(dst io.Writer, src io.Reader, encoding string) error {
+	switch encoding {
+	case EncodingGzip:
+		r, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(dst, r)
+		return err
+	case EncodingZstd:
+		r, err := zstd.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(dst, r)
+		return err
+	}
+	return checkEncoding(encoding)
+}
+
+func encodeFile(dst, src, encoding string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Error opening file: %v", err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err = encode(out, in, encoding); err != nil {
+		out.Close()
+		return fmt.Errorf("Error compressing file: %v", err)
+	}
+	return out.Close()
+}
+
+// decodeFile replaces the content of filename by its decoded version.
+func decodeFile(filename, encoding string) error {
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	if err = decode(out, in, encoding); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return fmt.Errorf("Error decompressing file: %v", err)
+	}
+	if err = out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Rename(out.Name(), filename)
+}
+
+// CompressedStore wraps another store and compresses all files before they
+// are uploaded. The encoding is recorded next to the file, and Get of any
+// store decompresses such files. The checksum describes the original file.
+type CompressedStore struct {
+	backend  RawStore
+	encoding string
+}
+
+func NewCompressedStore(backend Store, encoding string) (*CompressedStore, error) {
+	rs, ok := backend.(RawStore)
+	if !ok {
+		return nil, fmt.Errorf("store type %T does not support compression", backend)
+	}
+	encoding = strings.ToLower(encoding)
+	if err := checkEncoding(encoding); err != nil {
+		return nil, err
+	}
+	return &CompressedStore{backend: rs, encoding: encoding}, nil
+}
+
+func (s *CompressedStore) List(name string, versions semver.Range) (ArtifactList, error) {
+	return s.backend.List(name, versions)
+}
+
+func (s *CompressedStore) Has(artifact Artifact) (bool, string, error) {
+	return s.backend.Has(artifact)
+}
+
+func (s *CompressedStore) Put(artifact Artifact, filename string) error {
+	csum, err := CalcCSum(filename)
+	if err != nil {
+		return fmt.Errorf("Error calculating checksum of '%s': %v", filepath.Base(filename), err)
+	}
+	return s.PutRaw(artifact, filename, RawInfo{CSum: csum})
+}
+
+// PutRaw compresses the file unless info already names an encoding.
+func (s *CompressedStore) PutRaw(artifact Artifact, filename string, info RawInfo) error {
+	if info.Encoding != "" {
+		return s.backend.PutRaw(artifact, filename, info)
+	}
+
+	tmp, err := ioutil.TempDir("", "arti-compress-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// the backend uses the basename of the file it gets
+	enc := filepath.Join(tmp, filepath.Base(filename))
+	if err = encodeFile(enc, filename, s.encoding); err != nil {
+		return err
+	}
+	info.Encoding = s.encoding
+	return s.backend.PutRaw(artifact, enc, info)
+}
+
+func (s *CompressedStore) Get(artifact Artifact, filename string, keepCorrupted bool) error {
+	_, err := getVerified(s.backend.GetRaw, artifact, filename, keepCorrupted)
+	return err
+}
+
+func (s *CompressedStore) GetRaw(artifact Artifact, filename string) (RawInfo, error) {
+	return s.backend.GetRaw(artifact, filename)
+}
+
+func (s *CompressedStore) Stat(artifact Artifact) (RawInfo, error) {
+	return s.backend.Stat(artifact)
+}
+
+func (s *CompressedStore) Del(artifact Artifact) error {
+	return s.backend.Del(artifact)
+}
+
+func (s *CompressedStore) Describe() map[string]string {
+	info := map[string]string{}
+	if d, ok := s.backend.(Describer); ok {
+		info = d.Describe()
+	}
+	info["compression"] = s.encoding
+	return info
+}
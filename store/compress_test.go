@@ -0,0 +1,149 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/storetest"
+)
+
+func TestCompressedStore(t *testing.T) {
+	for _, encoding := range []string{store.EncodingGzip, store.EncodingZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			storetest.Run(t, func(t *testing.T) store.Store {
+				s, err := store.NewCompressedStore(store.NewMemStore(), encoding)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			})
+		})
+	}
+}
+
+func readObjectString(t *testing.T, s store.ObjectStore, key string) string {
+	r, err := s.GetObject(key)
+	if err != nil {
+		t.Fatalf("%s: %v", key, err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCompressedStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-compress-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := []byte(strings.Repeat("uncompressed debug symbols\n", 1000))
+	filename := filepath.Join(dir, "app.debug")
+	if err = ioutil.WriteFile(filename, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	csum, err := store.CalcCSum(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoders := map[string]func([]byte) ([]byte, error){
+		store.EncodingGzip: func(data []byte) ([]byte, error) {
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.ReadAll(r)
+		},
+		store.EncodingZstd: func(data []byte) ([]byte, error) {
+			r, err := zstd.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return ioutil.ReadAll(r)
+		},
+	}
+	for encoding, decode := range decoders {
+		backend := store.NewMemStore()
+		s, err := store.NewCompressedStore(backend, strings.ToUpper(encoding))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, _ := store.MakeArtifact("app", "1.0.0")
+		if err = s.Put(a, filename); err != nil {
+			t.Fatal(err)
+		}
+
+		// the encoding is recorded next to the compressed file and the
+		// checksum describes the original one
+		if e := readObjectString(t, backend, "app/1.0.0/app.debug"+store.EncodingExt); strings.TrimSpace(e) != encoding {
+			t.Errorf("%s: unexpected encoding file %q", encoding, e)
+		}
+		if c := readObjectString(t, backend, "app/1.0.0/app.debug"+store.CSumExt); strings.TrimSpace(c) != csum {
+			t.Errorf("%s: expected checksum %s, got %s", encoding, csum, c)
+		}
+		compressed := readObjectString(t, backend, "app/1.0.0/app.debug")
+		if len(compressed) >= len(content) {
+			t.Errorf("%s: the file has not been compressed", encoding)
+		}
+		if data, err := decode([]byte(compressed)); err != nil || !bytes.Equal(data, content) {
+			t.Errorf("%s: the stored file doesn't decode to the original one: %v", encoding, err)
+		}
+		if info, err := s.Stat(a); err != nil || info.Encoding != encoding || info.CSum != csum {
+			t.Errorf("%s: unexpected info %+v, %v", encoding, info, err)
+		}
+
+		// both, the compressed store and its backend, decompress
+		for name, st := range map[string]store.Store{"compressed": s, "backend": backend} {
+			target := filepath.Join(dir, fmt.Sprintf("%s-%s", encoding, name))
+			if err = st.Get(a, target, false); err != nil {
+				t.Errorf("%s: Get from %s failed: %v", encoding, name, err)
+				continue
+			}
+			if data, _ := ioutil.ReadFile(target); !bytes.Equal(data, content) {
+				t.Errorf("%s: Get from %s returned different content", encoding, name)
+			}
+		}
+
+		// a corrupt compressed file is detected
+		if err = backend.PutObject("app/1.0.0/app.debug", strings.NewReader(compressed[:len(compressed)/2])); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Get(a, filepath.Join(dir, "corrupt"), false); err == nil {
+			t.Errorf("%s: expected Get of a truncated file to fail", encoding)
+		}
+		if _, err = os.Stat(filepath.Join(dir, "corrupt")); err == nil {
+			t.Errorf("%s: the truncated file has been kept", encoding)
+		}
+	}
+
+	if _, err = store.NewCompressedStore(store.NewMemStore(), "lzma"); err == nil {
+		t.Error("expected an error for an unknown encoding")
+	}
+}
@@ -0,0 +1,437 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go"
+)
+
+// CheckStatus is the outcome of a diagnostic check.
+type CheckStatus int
+
+const (
+	CheckPassed CheckStatus = iota
+	CheckWarning
+	CheckFailed
+	CheckSkipped
+)
+
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckPassed:
+		return "PASS"
+	case CheckWarning:
+		return "WARN"
+	case CheckFailed:
+		return "FAIL"
+	}
+	return "SKIP"
+}
+
+// CheckResult is the result of one check done by Diagnose.
+type CheckResult struct {
+	Name   string
+	Status CheckStatus
+	Detail string
+}
+
+const (
+	// maxClockSkew is the difference S3 tolerates for signature v4.
+	maxClockSkew   = 15 * time.Minute
+	warnClockSkew  = time.Minute
+	warnCertExpiry = 14 * 24 * time.Hour
+	dialTimeout    = 5 * time.Second
+
+	doctorPrefix = ".arti-doctor/"
+)
+
+// serverStore is implemented by stores talking to a server.
+type serverStore interface {
+	serverURL() *url.URL
+}
+
+// transportStore is implemented by stores with their own HTTP transport.
+type transportStore interface {
+	httpTransport() *http.Transport
+}
+
+// diagnoser is implemented by stores which do checks of their own.
+type diagnoser interface {
+	diagnose() []CheckResult
+}
+
+func (s *S3Store) serverURL() *url.URL {
+	scheme := "https"
+	if !s.useSSL {
+		scheme = "http"
+	}
+	return &url.URL{Scheme: scheme, Host: s.endpoint}
+}
+
+func (s *S3Store) httpTransport() *http.Transport {
+	return s.transport
+}
+
+func (s *OCIStore) serverURL() *url.URL {
+	u, _ := url.Parse(s.registry)
+	return u
+}
+
+func (s *HTTPStore) serverURL() *url.URL {
+	return s.base
+}
+
+func (s *SFTPStore) serverURL() *url.URL {
+	return &url.URL{Scheme: "ssh", Host: s.host}
+}
+
+// Diagnose checks whether the store works: the DNS, TLS and clock of the
+// server, the credentials, store specific settings and finally the
+// permissions to list, upload, download and delete using a scratch file.
+// Stores made of other stores are checked member by member.
+func Diagnose(s Store) []CheckResult {
+	d := &doctor{}
+	d.backend(s, "")
+	d.permissions(s)
+	return d.results
+}
+
+type doctor struct {
+	results []CheckResult
+}
+
+func (d *doctor) add(name string, status CheckStatus, format string, args ...interface{}) {
+	d.results = append(d.results, CheckResult{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (d *doctor) failed() bool {
+	for _, r := range d.results {
+		if r.Status == CheckFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// backend checks the stores s consists of.
+func (d *doctor) backend(s Store, prefix string) {
+	s = Backend(s)
+	if r, ok := s.(*ReplicatedStore); ok {
+		for _, m := range r.members {
+			d.backend(m.Store, prefix+m.Name+": ")
+		}
+		return
+	}
+
+	if e, ok := s.(serverStore); ok {
+		var t *http.Transport
+		if ts, ok := s.(transportStore); ok {
+			t = ts.httpTransport()
+		}
+		if u := e.serverURL(); u != nil && !d.network(u, t, prefix) {
+			return
+		}
+	}
+	if dg, ok := s.(diagnoser); ok {
+		for _, r := range dg.diagnose() {
+			r.Name = prefix + r.Name
+			d.results = append(d.results, r)
+		}
+	}
+}
+
+// network checks DNS, TCP, TLS and the clock of the server at u and
+// reports whether the server is reachable. t is the transport used by the
+// store, nil for the default one.
+func (d *doctor) network(u *url.URL, t *http.Transport, prefix string) bool {
+	if t == nil {
+		t = http.DefaultTransport.(*http.Transport)
+	}
+	base := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}
+
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = map[string]string{"https": "443", "http": "80", "ssh": "22"}[u.Scheme]
+	}
+	addr := net.JoinHostPort(host, port)
+
+	var proxy *url.URL
+	if (u.Scheme == "http" || u.Scheme == "https") && t.Proxy != nil {
+		proxy, _ = t.Proxy(&http.Request{URL: base})
+	}
+	if proxy != nil {
+		// the proxy resolves the name and connects to the server
+		d.add(prefix+"connect", CheckSkipped, "using proxy %s", proxy.Host)
+		d.clock(base, t, prefix)
+		return true
+	}
+
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		d.add(prefix+"dns", CheckFailed, "%v", err)
+		return false
+	}
+	d.add(prefix+"dns", CheckPassed, "%s resolves to %s", host, strings.Join(addrs, ", "))
+
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		d.add(prefix+"connect", CheckFailed, "%v", err)
+		return false
+	}
+	conn.Close()
+	d.add(prefix+"connect", CheckPassed, "connected to %s", addr)
+
+	if u.Scheme == "https" {
+		config := &tls.Config{}
+		if t.TLSClientConfig != nil {
+			config = t.TLSClientConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = host
+		}
+		tconn, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", addr, config)
+		if err != nil {
+			d.add(prefix+"tls", CheckFailed, "%v", err)
+			return false
+		}
+		cert := tconn.ConnectionState().PeerCertificates[0]
+		tconn.Close()
+		switch {
+		case config.InsecureSkipVerify:
+			d.add(prefix+"tls", CheckWarning, "the certificate of %s is not verified", cert.Subject.CommonName)
+		case cert.NotAfter.Sub(time.Now()) < warnCertExpiry:
+			d.add(prefix+"tls", CheckWarning, "certificate of %s expires on %s", cert.Subject.CommonName, cert.NotAfter.Format("2006-01-02"))
+		default:
+			d.add(prefix+"tls", CheckPassed, "certificate of %s is valid until %s", cert.Subject.CommonName, cert.NotAfter.Format("2006-01-02"))
+		}
+	}
+
+	if u.Scheme == "http" || u.Scheme == "https" {
+		d.clock(base, t, prefix)
+	}
+	return true
+}
+
+// clock compares the local time with the date of a response of the server.
+func (d *doctor) clock(u *url.URL, t *http.Transport, prefix string) {
+	client := &http.Client{Transport: t, Timeout: dialTimeout}
+	start := time.Now()
+	resp, err := client.Head(u.String())
+	if err != nil {
+		d.add(prefix+"clock", CheckFailed, "%v", err)
+		return
+	}
+	resp.Body.Close()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		d.add(prefix+"clock", CheckSkipped, "the server didn't send its time")
+		return
+	}
+
+	// the date of the server has a resolution of one second
+	skew := date.Sub(start.Add(time.Since(start) / 2)).Truncate(time.Second)
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs > maxClockSkew:
+		d.add(prefix+"clock", CheckFailed, "the clocks differ by %v, signed requests will be rejected", skew)
+	case abs > warnClockSkew:
+		d.add(prefix+"clock", CheckWarning, "the clocks differ by %v", skew)
+	default:
+		d.add(prefix+"clock", CheckPassed, "the clocks differ by less than %v", warnClockSkew)
+	}
+}
+
+func (s *S3Store) diagnose() []CheckResult {
+	d := &doctor{}
+
+	source := s.creds.source()
+	if source == "anonymous" {
+		d.add("credentials", CheckSkipped, "no credentials found, requests are sent anonymously")
+	} else if _, err := s.client.ListBuckets(); err == nil {
+		d.add("credentials", CheckPassed, "valid (from %s)", source)
+	} else {
+		switch minio.ToErrorResponse(err).Code {
+		case "AccessDenied":
+			d.add("credentials", CheckPassed, "valid (from %s), but listing buckets is not allowed", source)
+		case "RequestTimeTooSkewed":
+			d.add("credentials", CheckFailed, "the request was rejected because the clock is off")
+			return d.results
+		default:
+			d.add("credentials", CheckFailed, "%v (from %s)", err, source)
+			return d.results
+		}
+	}
+
+	exists, err := s.client.BucketExists(s.bucket)
+	switch {
+	case err != nil:
+		d.add("bucket", CheckFailed, "%v", err)
+		return d.results
+	case !exists && s.autoCreate:
+		// the permission checks create the bucket with the first upload
+		d.add("bucket", CheckWarning, "bucket %s does not exist, it is created by the first upload", s.bucket)
+		d.add("location", CheckSkipped, "the bucket does not exist yet")
+		return d.results
+	case !exists:
+		d.add("bucket", CheckFailed, "bucket %s does not exist and auto-create is disabled", s.bucket)
+		return d.results
+	}
+	d.add("bucket", CheckPassed, "bucket %s exists", s.bucket)
+
+	location, err := s.client.GetBucketLocation(s.bucket)
+	switch {
+	case err != nil:
+		d.add("location", CheckFailed, "%v", err)
+	case s.location != "" && location != s.location:
+		d.add("location", CheckFailed, "the bucket is located in %s but location is %s", location, s.location)
+	default:
+		d.add("location", CheckPassed, "the bucket is located in %s", location)
+	}
+	return d.results
+}
+
+// permissions uploads, lists, downloads and deletes a scratch file.
+func (d *doctor) permissions(s Store) {
+	checks := []string{"list", "put", "get", "delete"}
+	if d.failed() {
+		for _, c := range checks {
+			d.add(c, CheckSkipped, "skipped because of previous failures")
+		}
+		return
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	data := []byte("scratch file of arti doctor, may be deleted\n")
+
+	if obj, ok := s.(ObjectStore); ok {
+		d.objectPermissions(obj, doctorPrefix+hex.EncodeToString(id), data)
+		return
+	}
+	a, err := MakeArtifact("arti-doctor", "0.0.0-"+hex.EncodeToString(id))
+	if err != nil {
+		panic(err)
+	}
+	d.artifactPermissions(s, a, data)
+}
+
+func (d *doctor) objectPermissions(s ObjectStore, key string, data []byte) {
+	if _, err := s.ListObjects(doctorPrefix); err != nil {
+		d.add("list", CheckFailed, "%v", err)
+	} else {
+		d.add("list", CheckPassed, "listing files is allowed")
+	}
+
+	if err := s.PutObject(key, bytes.NewReader(data)); err != nil {
+		d.add("put", CheckFailed, "%v", err)
+		d.add("get", CheckSkipped, "nothing was uploaded")
+		d.add("delete", CheckSkipped, "nothing was uploaded")
+		return
+	}
+	d.add("put", CheckPassed, "uploaded %s", key)
+
+	if r, err := s.GetObject(key); err != nil {
+		d.add("get", CheckFailed, "%v", err)
+	} else {
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		switch {
+		case err != nil:
+			d.add("get", CheckFailed, "%v", err)
+		case !bytes.Equal(got, data):
+			d.add("get", CheckFailed, "the downloaded file differs from the uploaded one")
+		default:
+			d.add("get", CheckPassed, "downloaded %s", key)
+		}
+	}
+
+	if err := s.DelObject(key); err != nil {
+		d.add("delete", CheckFailed, "%v, please remove %s manually", err, key)
+	} else if r, err := s.GetObject(key); err != ErrObjectNotFound {
+		if err == nil {
+			r.Close()
+		}
+		d.add("delete", CheckFailed, "%s still exists after deleting it", key)
+	} else {
+		d.add("delete", CheckPassed, "deleted %s", key)
+	}
+}
+
+func (d *doctor) artifactPermissions(s Store, a Artifact, data []byte) {
+	if _, err := s.List(a.Name, nil); err != nil {
+		d.add("list", CheckFailed, "%v", err)
+	} else {
+		d.add("list", CheckPassed, "listing artifacts is allowed")
+	}
+
+	dir, err := ioutil.TempDir("", "arti-doctor-")
+	if err != nil {
+		d.add("put", CheckFailed, "%v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "scratch.txt")
+	if err := ioutil.WriteFile(fn, data, 0600); err != nil {
+		d.add("put", CheckFailed, "%v", err)
+		return
+	}
+
+	name := a.Name + " " + a.Version.String()
+	if err := s.Put(a, fn); err != nil {
+		if err == ErrNotImplemented {
+			d.add("put", CheckSkipped, "the store is read-only")
+		} else {
+			d.add("put", CheckFailed, "%v", err)
+		}
+		d.add("get", CheckSkipped, "nothing was uploaded")
+		d.add("delete", CheckSkipped, "nothing was uploaded")
+		return
+	}
+	d.add("put", CheckPassed, "uploaded %s", name)
+
+	target := filepath.Join(dir, "download.txt")
+	if err := s.Get(a, target, false); err != nil {
+		d.add("get", CheckFailed, "%v", err)
+	} else if got, err := ioutil.ReadFile(target); err != nil || !bytes.Equal(got, data) {
+		d.add("get", CheckFailed, "the downloaded file differs from the uploaded one")
+	} else {
+		d.add("get", CheckPassed, "downloaded %s", name)
+	}
+
+	if err := s.Del(a); err != nil {
+		d.add("delete", CheckFailed, "%v, please remove %s manually", err, name)
+	} else if exists, _, err := s.Has(a); err != nil || exists {
+		d.add("delete", CheckFailed, "%s still exists after deleting it", name)
+	} else {
+		d.add("delete", CheckPassed, "deleted %s", name)
+	}
+}
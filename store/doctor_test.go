@@ -0,0 +1,151 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/spf13/viper"
+)
+
+// checkDiagnosis runs Diagnose and compares the status of the checks with
+// want. Checks missing in want must pass.
+func checkDiagnosis(t *testing.T, s store.Store, want map[string]store.CheckStatus) {
+	seen := make(map[string]bool)
+	for _, r := range store.Diagnose(s) {
+		seen[r.Name] = true
+		status, ok := want[r.Name]
+		if !ok {
+			status = store.CheckPassed
+		}
+		if r.Status != status {
+			t.Errorf("%s: got %v (%s), want %v", r.Name, r.Status, r.Detail, status)
+		}
+	}
+	for name := range want {
+		if !seen[name] {
+			t.Errorf("%s: not checked", name)
+		}
+	}
+}
+
+func newDoctorS3Store(t *testing.T, cfg *viper.Viper, bucket string) store.Store {
+	cfg.Set("access-key-id", "doctor")
+	cfg.Set("secret-access-key", "secret")
+	s, err := store.NewS3Store(cfg, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDiagnoseS3(t *testing.T) {
+	defer isolateAWS(t)()
+	srv, cfg, done := s3WithCredentials(t, "doctor", "")
+	defer done()
+	srv.Location = "eu-central-1"
+	cfg.Set("location", "eu-central-1")
+
+	s := newDoctorS3Store(t, cfg, "artifacts")
+	checkDiagnosis(t, s, map[string]store.CheckStatus{
+		"dns": store.CheckPassed, "connect": store.CheckPassed, "clock": store.CheckPassed,
+		"credentials": store.CheckPassed, "bucket": store.CheckPassed, "location": store.CheckPassed,
+		"list": store.CheckPassed, "put": store.CheckPassed, "get": store.CheckPassed, "delete": store.CheckPassed,
+	})
+	if keys := srv.Keys("artifacts"); len(keys) != 0 {
+		t.Errorf("scratch files left behind: %v", keys)
+	}
+
+	cfg.Set("location", "us-east-1")
+	s = newDoctorS3Store(t, cfg, "artifacts")
+	checkDiagnosis(t, s, map[string]store.CheckStatus{
+		"location": store.CheckFailed,
+		"list":     store.CheckSkipped, "put": store.CheckSkipped, "get": store.CheckSkipped, "delete": store.CheckSkipped,
+	})
+}
+
+func TestDiagnoseS3Failures(t *testing.T) {
+	defer isolateAWS(t)()
+	srv, cfg, done := s3WithCredentials(t, "someone-else", "")
+	defer done()
+	skipped := map[string]store.CheckStatus{
+		"list": store.CheckSkipped, "put": store.CheckSkipped, "get": store.CheckSkipped, "delete": store.CheckSkipped,
+	}
+	withFailure := func(names ...string) map[string]store.CheckStatus {
+		want := make(map[string]store.CheckStatus)
+		for k, v := range skipped {
+			want[k] = v
+		}
+		for _, name := range names {
+			want[name] = store.CheckFailed
+		}
+		return want
+	}
+
+	s := newDoctorS3Store(t, cfg, "artifacts")
+	checkDiagnosis(t, s, withFailure("credentials"))
+
+	srv.AddCredentials("doctor", "")
+	cfg.Set("auto-create", false)
+	s = newDoctorS3Store(t, cfg, "missing")
+	checkDiagnosis(t, s, withFailure("bucket"))
+
+	cfg.Set("auto-create", true)
+	s = newDoctorS3Store(t, cfg, "missing")
+	checkDiagnosis(t, s, map[string]store.CheckStatus{
+		"bucket": store.CheckWarning, "location": store.CheckSkipped,
+		"list": store.CheckPassed, "put": store.CheckPassed, "get": store.CheckPassed, "delete": store.CheckPassed,
+	})
+	cfg.Set("auto-create", false)
+	s = newDoctorS3Store(t, cfg, "missing")
+	checkDiagnosis(t, s, map[string]store.CheckStatus{"bucket": store.CheckPassed})
+
+	srv.ClockSkew = time.Hour
+	s = newDoctorS3Store(t, cfg, "artifacts")
+	checkDiagnosis(t, s, withFailure("clock", "credentials"))
+}
+
+func TestDiagnoseMemStore(t *testing.T) {
+	checkDiagnosis(t, store.NewMemStore(), map[string]store.CheckStatus{
+		"list": store.CheckPassed, "put": store.CheckPassed, "get": store.CheckPassed, "delete": store.CheckPassed,
+	})
+}
+
+func TestDiagnoseTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	cfg := viper.New()
+	cfg.Set("url", ts.URL)
+	s, err := store.NewHTTPStore(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := store.Diagnose(s)
+	for _, r := range results {
+		if r.Name == "tls" {
+			if r.Status != store.CheckFailed || !strings.Contains(r.Detail, "certificate") {
+				t.Errorf("tls: got %v (%s), want a certificate failure", r.Status, r.Detail)
+			}
+			return
+		}
+	}
+	t.Errorf("tls not checked: %v", results)
+}
@@ -0,0 +1,657 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encrypted files start with a header followed by the plaintext split into
+// chunks of encChunkSize bytes, each sealed using AES-256-GCM. The nonce of
+// a chunk is its sequence number and a flag marking the last chunk which
+// makes truncation and reordering detectable. All chunks authenticate the
+// header as additional data.
+//
+//	magic(8) version(1) mode(1) salt(32)
+//	mode encKeySymmetric: key-id(8)
+//	mode encKeyX25519:    count(1) count * [ recipient-id(8) ephemeral(32) wrapped-key(48) ]
+const (
+	encMagic     = "arti-enc"
+	encVersion   = 1
+	encChunkSize = 64 * 1024
+	encKeySize   = 32
+	encIDSize    = 8
+	encSaltSize  = 32
+
+	encKeySymmetric = 1
+	encKeyX25519    = 2
+
+	encWrappedKeySize = encKeySize + 16
+	encStanzaSize     = encIDSize + encKeySize + encWrappedKeySize
+)
+
+var (
+	ErrNoDecryptionKey = errors.New("none of the configured keys can decrypt this file")
+)
+
+// EncryptionKeys holds the key material of an EncryptedStore. New files are
+// encrypted either using Key or for all Recipients. OldKeys and Identities
+// are only used to decrypt existing files.
+type EncryptionKeys struct {
+	Key        []byte
+	Recipients [][]byte
+	OldKeys    [][]byte
+	Identities [][]byte
+}
+
+// EncryptedStore wraps another store and encrypts all files before they
+// leave the machine. The checksum stored alongside the file describes the
+// plaintext so downloads are verified after decryption.
+type EncryptedStore struct {
+	backend RawStore
+	keys    EncryptionKeys
+}
+
+func NewEncryptedStore(backend Store, keys EncryptionKeys) (*EncryptedStore, error) {
+	rs, ok := backend.(RawStore)
+	if !ok {
+		return nil, fmt.Errorf("store type %T does not support encryption", backend)
+	}
+	if keys.Key == nil && len(keys.Recipients) == 0 {
+		return nil, fmt.Errorf("encryption needs either a key or at least one recipient")
+	}
+	if keys.Key != nil && len(keys.Recipients) > 0 {
+		return nil, fmt.Errorf("encryption key and recipients are mutually exclusive")
+	}
+	if len(keys.Recipients) > 255 {
+		return nil, fmt.Errorf("too many recipients")
+	}
+	for _, k := range append(append([][]byte{keys.Key}, keys.OldKeys...), append(keys.Recipients, keys.Identities...)...) {
+		if k != nil && len(k) != encKeySize {
+			return nil, fmt.Errorf("invalid key size %d, must be %d bytes", len(k), encKeySize)
+		}
+	}
+	return &EncryptedStore{backend: rs, keys: keys}, nil
+}
+
+func newEncryptedStoreFromConfig(cfg *viper.Viper, backend Store) (Store, error) {
+	keys := EncryptionKeys{}
+
+	key, err := readSecret(cfg, "encryption.key")
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		if keys.Key, err = DecodeKey(key); err != nil {
+			return nil, fmt.Errorf("invalid encryption key: %v", err)
+		}
+	}
+	for _, k := range cfg.GetStringSlice("encryption.old-keys") {
+		dk, err := DecodeKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid old encryption key: %v", err)
+		}
+		keys.OldKeys = append(keys.OldKeys, dk)
+	}
+	for _, fn := range cfg.GetStringSlice("encryption.old-key-files") {
+		dks, err := readKeyFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		keys.OldKeys = append(keys.OldKeys, dks...)
+	}
+	for _, r := range cfg.GetStringSlice("encryption.recipients") {
+		dr, err := DecodeKey(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient: %v", err)
+		}
+		keys.Recipients = append(keys.Recipients, dr)
+	}
+	if fn := cfg.GetString("encryption.identity-file"); fn != "" {
+		if keys.Identities, err = readKeyFile(fn); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewEncryptedStore(backend, keys)
+}
+
+// readKeyFile reads one key per line, ignoring empty lines and comments.
+func readKeyFile(filename string) (keys [][]byte, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading key file: %v", err)
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		k, err := DecodeKey(l)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in '%s': %v", filename, err)
+		}
+		keys = append(keys, k)
+	}
+	return
+}
+
+// DecodeKey parses a base64 encoded 256-bit key.
+func DecodeKey(s string) ([]byte, error) {
+	k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(k) != encKeySize {
+		return nil, fmt.Errorf("key must be %d bytes long", encKeySize)
+	}
+	return k, nil
+}
+
+func EncodeKey(k []byte) string {
+	return base64.StdEncoding.EncodeToString(k)
+}
+
+// GenerateKey returns a new random key for symmetric encryption.
+func GenerateKey() ([]byte, error) {
+	k := make([]byte, encKeySize)
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// GenerateIdentity returns a new X25519 private key and the matching
+// public key to be used as recipient.
+func GenerateIdentity() (identity, recipient []byte, err error) {
+	var priv, pub [encKeySize]byte
+	if _, err = io.ReadFull(rand.Reader, priv[:]); err != nil {
+		return
+	}
+	curve25519.ScalarBaseMult(&pub, &priv)
+	return priv[:], pub[:], nil
+}
+
+func encKeyID(key []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("arti-enc key id"))
+	h.Write(key)
+	return h.Sum(nil)[:encIDSize]
+}
+
+func encDeriveKey(secret, salt []byte, info string) ([]byte, error) {
+	k := make([]byte, encKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func encNewGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encX25519WrapKey(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	k, err := encDeriveKey(shared, append(append([]byte{}, ephemeral...), recipient...), "arti-enc x25519")
+	if err != nil {
+		return nil, err
+	}
+	return encNewGCM(k)
+}
+
+// newHeader returns the header of a new file as well as the key used for
+// its payload.
+func (s *EncryptedStore) newHeader() (hdr, fileKey []byte, err error) {
+	salt := make([]byte, encSaltSize)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return
+	}
+	buf := bytes.NewBufferString(encMagic)
+	buf.WriteByte(encVersion)
+
+	secret := s.keys.Key
+	if secret != nil {
+		buf.WriteByte(encKeySymmetric)
+		buf.Write(salt)
+		buf.Write(encKeyID(secret))
+	} else {
+		buf.WriteByte(encKeyX25519)
+		buf.Write(salt)
+		buf.WriteByte(byte(len(s.keys.Recipients)))
+		if secret, err = GenerateKey(); err != nil {
+			return
+		}
+		for _, r := range s.keys.Recipients {
+			var eph, ephPub, shared, rcpt [encKeySize]byte
+			if _, err = io.ReadFull(rand.Reader, eph[:]); err != nil {
+				return
+			}
+			copy(rcpt[:], r)
+			curve25519.ScalarBaseMult(&ephPub, &eph)
+			curve25519.ScalarMult(&shared, &eph, &rcpt)
+			var aead cipher.AEAD
+			if aead, err = encX25519WrapKey(shared[:], ephPub[:], r); err != nil {
+				return
+			}
+			buf.Write(encKeyID(r))
+			buf.Write(ephPub[:])
+			buf.Write(aead.Seal(nil, make([]byte, aead.NonceSize()), secret, nil))
+		}
+	}
+
+	if fileKey, err = encDeriveKey(secret, salt, "arti-enc file key"); err != nil {
+		return
+	}
+	return buf.Bytes(), fileKey, nil
+}
+
+type encHeader struct {
+	raw      []byte
+	mode     byte
+	salt     []byte
+	keyIDs   [][]byte
+	stanzas  [][]byte
+	isLatest bool
+}
+
+func readHeader(r io.Reader) (*encHeader, error) {
+	fixed := make([]byte, len(encMagic)+2+encSaltSize)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("file is not encrypted or truncated")
+	}
+	if string(fixed[:len(encMagic)]) != encMagic {
+		return nil, fmt.Errorf("file is not encrypted")
+	}
+	if fixed[len(encMagic)] != encVersion {
+		return nil, fmt.Errorf("unsupported encryption format version %d", fixed[len(encMagic)])
+	}
+	hdr := &encHeader{mode: fixed[len(encMagic)+1], salt: fixed[len(encMagic)+2:]}
+	buf := bytes.NewBuffer(fixed)
+
+	switch hdr.mode {
+	case encKeySymmetric:
+		id := make([]byte, encIDSize)
+		if _, err := io.ReadFull(r, id); err != nil {
+			return nil, fmt.Errorf("truncated encryption header")
+		}
+		buf.Write(id)
+		hdr.keyIDs = append(hdr.keyIDs, id)
+	case encKeyX25519:
+		cnt := make([]byte, 1)
+		if _, err := io.ReadFull(r, cnt); err != nil {
+			return nil, fmt.Errorf("truncated encryption header")
+		}
+		buf.Write(cnt)
+		for i := 0; i < int(cnt[0]); i++ {
+			stanza := make([]byte, encStanzaSize)
+			if _, err := io.ReadFull(r, stanza); err != nil {
+				return nil, fmt.Errorf("truncated encryption header")
+			}
+			buf.Write(stanza)
+			hdr.keyIDs = append(hdr.keyIDs, stanza[:encIDSize])
+			hdr.stanzas = append(hdr.stanzas, stanza)
+		}
+	default:
+		return nil, fmt.Errorf("unknown encryption mode %d", hdr.mode)
+	}
+	hdr.raw = buf.Bytes()
+	return hdr, nil
+}
+
+// fileKey recovers the payload key of a file using any of the known keys.
+func (s *EncryptedStore) fileKey(hdr *encHeader) ([]byte, error) {
+	switch hdr.mode {
+	case encKeySymmetric:
+		for _, k := range append([][]byte{s.keys.Key}, s.keys.OldKeys...) {
+			if k != nil && bytes.Equal(encKeyID(k), hdr.keyIDs[0]) {
+				return encDeriveKey(k, hdr.salt, "arti-enc file key")
+			}
+		}
+	case encKeyX25519:
+		for _, id := range s.keys.Identities {
+			var priv, pub, eph, shared [encKeySize]byte
+			copy(priv[:], id)
+			curve25519.ScalarBaseMult(&pub, &priv)
+			rid := encKeyID(pub[:])
+			for _, stanza := range hdr.stanzas {
+				if !bytes.Equal(stanza[:encIDSize], rid) {
+					continue
+				}
+				copy(eph[:], stanza[encIDSize:encIDSize+encKeySize])
+				curve25519.ScalarMult(&shared, &priv, &eph)
+				aead, err := encX25519WrapKey(shared[:], eph[:], pub[:])
+				if err != nil {
+					return nil, err
+				}
+				secret, err := aead.Open(nil, make([]byte, aead.NonceSize()), stanza[encIDSize+encKeySize:], nil)
+				if err != nil {
+					return nil, fmt.Errorf("unable to unwrap file key: %v", err)
+				}
+				return encDeriveKey(secret, hdr.salt, "arti-enc file key")
+			}
+		}
+	}
+	return nil, ErrNoDecryptionKey
+}
+
+// isCurrent reports whether a file with this header would be encrypted the
+// same way using the current configuration.
+func (s *EncryptedStore) isCurrent(hdr *encHeader) bool {
+	if s.keys.Key != nil {
+		return hdr.mode == encKeySymmetric && bytes.Equal(hdr.keyIDs[0], encKeyID(s.keys.Key))
+	}
+	if hdr.mode != encKeyX25519 || len(hdr.keyIDs) != len(s.keys.Recipients) {
+		return false
+	}
+	for _, r := range s.keys.Recipients {
+		found := false
+		for _, id := range hdr.keyIDs {
+			if bytes.Equal(id, encKeyID(r)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func encNonce(nonce []byte, counter uint64, last bool) {
+	for i := range nonce {
+		nonce[i] = 0
+	}
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+}
+
+func (s *EncryptedStore) encrypt(dst io.Writer, src io.Reader) error {
+	hdr, key, err := s.newHeader()
+	if err != nil {
+		return err
+	}
+	aead, err := encNewGCM(key)
+	if err != nil {
+		return err
+	}
+	if _, err = dst.Write(hdr); err != nil {
+		return err
+	}
+	ad := sha256.Sum256(hdr)
+
+	nonce := make([]byte, aead.NonceSize())
+	in := bufio.NewReaderSize(src, encChunkSize)
+	buf := make([]byte, encChunkSize)
+	out := make([]byte, 0, encChunkSize+aead.Overhead())
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(in, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := n < encChunkSize
+		if !last {
+			if _, err := in.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+		encNonce(nonce, counter, last)
+		if _, err = dst.Write(aead.Seal(out[:0], nonce, buf[:n], ad[:])); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+func (s *EncryptedStore) decrypt(dst io.Writer, src io.Reader) error {
+	hdr, err := readHeader(src)
+	if err != nil {
+		return err
+	}
+	key, err := s.fileKey(hdr)
+	if err != nil {
+		return err
+	}
+	aead, err := encNewGCM(key)
+	if err != nil {
+		return err
+	}
+	ad := sha256.Sum256(hdr.raw)
+
+	nonce := make([]byte, aead.NonceSize())
+	in := bufio.NewReaderSize(src, encChunkSize+aead.Overhead())
+	buf := make([]byte, encChunkSize+aead.Overhead())
+	out := make([]byte, 0, encChunkSize)
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(in, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := n < len(buf)
+		if !last {
+			if _, err := in.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+		encNonce(nonce, counter, last)
+		plain, err := aead.Open(out[:0], nonce, buf[:n], ad[:])
+		if err != nil {
+			return fmt.Errorf("decryption failed, file is corrupted or truncated")
+		}
+		if _, err = dst.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+func encryptFile(s *EncryptedStore, dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("Error opening file: %v", err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err = s.encrypt(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("Error encrypting file: %v", err)
+	}
+	return out.Close()
+}
+
+func decryptFile(s *EncryptedStore, dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err = s.decrypt(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+func (s *EncryptedStore) List(name string, versions semver.Range) (ArtifactList, error) {
+	return s.backend.List(name, versions)
+}
+
+func (s *EncryptedStore) Has(artifact Artifact) (bool, string, error) {
+	return s.backend.Has(artifact)
+}
+
+func (s *EncryptedStore) Put(artifact Artifact, filename string) error {
+	csum, err := CalcCSum(filename)
+	if err != nil {
+		return fmt.Errorf("Error calculating checksum of '%s': %v", filepath.Base(filename), err)
+	}
+	return s.PutRaw(artifact, filename, RawInfo{CSum: csum})
+}
+
+func (s *EncryptedStore) PutRaw(artifact Artifact, filename string, info RawInfo) error {
+	tmp, err := ioutil.TempDir("", "arti-enc-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// the backend uses the basename of the file it gets
+	enc := filepath.Join(tmp, filepath.Base(filename))
+	if err = encryptFile(s, enc, filename); err != nil {
+		return err
+	}
+	return s.backend.PutRaw(artifact, enc, info)
+}
+
+func (s *EncryptedStore) Get(artifact Artifact, filename string, keepCorrupted bool) error {
+	_, err := getVerified(s.GetRaw, artifact, filename, keepCorrupted)
+	return err
+}
+
+func (s *EncryptedStore) GetRaw(artifact Artifact, filename string) (info RawInfo, err error) {
+	tmp, err := ioutil.TempDir("", "arti-enc-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmp)
+
+	enc := filepath.Join(tmp, "encrypted")
+	if info, err = s.backend.GetRaw(artifact, enc); err != nil {
+		return
+	}
+	target := filename
+	if target == "" {
+		target = info.Filename
+	}
+	err = decryptFile(s, target, enc)
+	return
+}
+
+func (s *EncryptedStore) Stat(artifact Artifact) (RawInfo, error) {
+	return s.backend.Stat(artifact)
+}
+
+func (s *EncryptedStore) Del(artifact Artifact) error {
+	return s.backend.Del(artifact)
+}
+
+// Reencrypt decrypts the artifact and stores it again using the current
+// key or recipients. Files which are already encrypted this way are left
+// alone. If uploading the re-encrypted file fails after the old one has
+// been deleted the plaintext is kept and its location is returned with
+// the error.
+func (s *EncryptedStore) Reencrypt(artifact Artifact) (changed bool, err error) {
+	tmp, err := ioutil.TempDir("", "arti-enc-")
+	if err != nil {
+		return
+	}
+
+	enc := filepath.Join(tmp, "encrypted")
+	info, err := s.backend.GetRaw(artifact, enc)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return
+	}
+
+	f, err := os.Open(enc)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return
+	}
+	hdr, err := readHeader(f)
+	f.Close()
+	if err != nil {
+		os.RemoveAll(tmp)
+		return
+	}
+	if s.isCurrent(hdr) {
+		os.RemoveAll(tmp)
+		return
+	}
+
+	plain := filepath.Join(tmp, info.Filename)
+	if err = decryptFile(s, plain, enc); err != nil {
+		os.RemoveAll(tmp)
+		return
+	}
+	if info.Encoding == "" {
+		if err = verifyCSum(plain, info.CSum, false); err != nil {
+			os.RemoveAll(tmp)
+			return
+		}
+	}
+
+	if err = s.backend.Del(artifact); err != nil {
+		os.RemoveAll(tmp)
+		return
+	}
+	if err = s.PutRaw(artifact, plain, info); err != nil {
+		err = fmt.Errorf("%v (the decrypted file has been kept at '%s')", err, plain)
+		return
+	}
+	os.RemoveAll(tmp)
+	return true, nil
+}
+
+func (s *EncryptedStore) Describe() map[string]string {
+	info := map[string]string{}
+	if d, ok := s.backend.(Describer); ok {
+		info = d.Describe()
+	}
+	if s.keys.Key != nil {
+		info["client-side-encryption"] = "AES-256-GCM (key-id: " + hex.EncodeToString(encKeyID(s.keys.Key)) + ")"
+	} else {
+		info["client-side-encryption"] = fmt.Sprintf("AES-256-GCM (%d X25519 recipients)", len(s.keys.Recipients))
+	}
+	return info
+}
@@ -0,0 +1,368 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/storetest"
+	"github.com/spf13/viper"
+)
+
+const (
+	// these mirror the file format of the encrypted store
+	encChunkSize       = 64 * 1024
+	encSealedChunkSize = encChunkSize + 16
+	encSymmetricHeader = 8 + 1 + 1 + 32 + 8
+)
+
+func newKey(t *testing.T) []byte {
+	k, err := store.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func newIdentity(t *testing.T) (identity, recipient []byte) {
+	identity, recipient, err := store.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity, recipient
+}
+
+func newEncryptedStore(t *testing.T, backend store.Store, keys store.EncryptionKeys) *store.EncryptedStore {
+	s, err := store.NewEncryptedStore(backend, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// encTestFile writes a file of size bytes to dir.
+func encTestFile(t *testing.T, dir string, size int) (string, []byte) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	fn := filepath.Join(dir, "app.tar.gz")
+	if err := ioutil.WriteFile(fn, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fn, data
+}
+
+func encTempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "arti-enc-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func checkGet(t *testing.T, s store.Store, a store.Artifact, want []byte) {
+	dir, done := encTempDir(t)
+	defer done()
+	target := filepath.Join(dir, "download")
+	if err := s.Get(a, target, false); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	got, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return newEncryptedStore(t, store.NewMemStore(), store.EncryptionKeys{Key: newKey(t)})
+	})
+}
+
+func TestEncryptedStoreSizes(t *testing.T) {
+	dir, done := encTempDir(t)
+	defer done()
+
+	identity, recipient := newIdentity(t)
+	for _, keys := range []store.EncryptionKeys{{Key: newKey(t)}, {Recipients: [][]byte{recipient}, Identities: [][]byte{identity}}} {
+		backend := store.NewMemStore()
+		s := newEncryptedStore(t, backend, keys)
+		for i, size := range []int{0, 1, encChunkSize - 1, encChunkSize, encChunkSize + 1, 3 * encChunkSize} {
+			a, _ := store.MakeArtifact("app", fmt.Sprintf("1.0.%d", i))
+			fn, data := encTestFile(t, dir, size)
+			if err := s.Put(a, fn); err != nil {
+				t.Fatalf("%d bytes: Put failed: %v", size, err)
+			}
+			checkGet(t, s, a, data)
+
+			// the backend must not see the plaintext
+			enc := filepath.Join(dir, "encrypted")
+			if _, err := backend.GetRaw(a, enc); err != nil {
+				t.Fatal(err)
+			}
+			stored, _ := ioutil.ReadFile(enc)
+			if size > 16 && bytes.Contains(stored, data) {
+				t.Errorf("%d bytes: the backend holds the plaintext", size)
+			}
+			// a file of n full chunks has no empty chunk at the end
+			chunks := (size + encChunkSize - 1) / encChunkSize
+			if chunks == 0 {
+				chunks = 1
+			}
+			if keys.Key != nil && len(stored) != encSymmetricHeader+size+16*chunks {
+				t.Errorf("%d bytes: encrypted file has %d bytes", size, len(stored))
+			}
+		}
+	}
+}
+
+// tamper replaces the encrypted file of the artifact in backend by the
+// result of fn.
+func tamper(t *testing.T, backend *store.FSStore, a store.Artifact, fn func([]byte) []byte) {
+	dir, done := encTempDir(t)
+	defer done()
+	enc := filepath.Join(dir, "app.tar.gz")
+	info, err := backend.GetRaw(a, enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(enc, fn(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Del(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.PutRaw(a, enc, info); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptedStoreTampering(t *testing.T) {
+	dir, done := encTempDir(t)
+	defer done()
+	fn, _ := encTestFile(t, dir, 3*encChunkSize+100)
+	a, _ := store.MakeArtifact("app", "1.0.0")
+
+	chunk := func(data []byte, i int) []byte {
+		start := encSymmetricHeader + i*encSealedChunkSize
+		end := start + encSealedChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		return append([]byte{}, data[start:end]...)
+	}
+	tests := []struct {
+		name string
+		fn   func([]byte) []byte
+	}{
+		{"last chunk removed", func(data []byte) []byte {
+			return data[:encSymmetricHeader+3*encSealedChunkSize]
+		}},
+		{"truncated within a chunk", func(data []byte) []byte {
+			return data[:len(data)-10]
+		}},
+		{"only the header", func(data []byte) []byte {
+			return data[:encSymmetricHeader]
+		}},
+		{"chunks reordered", func(data []byte) []byte {
+			out := append([]byte{}, data[:encSymmetricHeader]...)
+			for _, i := range []int{1, 0, 2, 3} {
+				out = append(out, chunk(data, i)...)
+			}
+			return out
+		}},
+		{"chunk duplicated", func(data []byte) []byte {
+			out := append([]byte{}, data[:encSymmetricHeader]...)
+			for _, i := range []int{0, 0, 2, 3} {
+				out = append(out, chunk(data, i)...)
+			}
+			return out
+		}},
+		{"header modified", func(data []byte) []byte {
+			data[20] ^= 1
+			return data
+		}},
+		{"ciphertext modified", func(data []byte) []byte {
+			data[len(data)/2] ^= 1
+			return data
+		}},
+	}
+	for _, test := range tests {
+		backend := store.NewMemStore()
+		s := newEncryptedStore(t, backend, store.EncryptionKeys{Key: newKey(t)})
+		if err := s.Put(a, fn); err != nil {
+			t.Fatal(err)
+		}
+		tamper(t, backend, a, test.fn)
+		if err := s.Get(a, filepath.Join(dir, "download"), false); err == nil {
+			t.Errorf("%s: Get succeeded", test.name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "download")); err == nil {
+			t.Errorf("%s: the corrupted file was kept", test.name)
+			os.Remove(filepath.Join(dir, "download"))
+		}
+	}
+}
+
+func TestEncryptedStoreKeys(t *testing.T) {
+	dir, done := encTempDir(t)
+	defer done()
+	fn, data := encTestFile(t, dir, 1000)
+	a, _ := store.MakeArtifact("app", "1.0.0")
+
+	// symmetric keys
+	backend := store.NewMemStore()
+	key := newKey(t)
+	if err := newEncryptedStore(t, backend, store.EncryptionKeys{Key: key}).Put(a, fn); err != nil {
+		t.Fatal(err)
+	}
+	err := newEncryptedStore(t, backend, store.EncryptionKeys{Key: newKey(t)}).Get(a, filepath.Join(dir, "download"), false)
+	if err != store.ErrNoDecryptionKey {
+		t.Errorf("wrong key: got %v", err)
+	}
+	checkGet(t, newEncryptedStore(t, backend, store.EncryptionKeys{Key: newKey(t), OldKeys: [][]byte{key}}), a, data)
+
+	// X25519 recipients
+	backend = store.NewMemStore()
+	id1, r1 := newIdentity(t)
+	id2, r2 := newIdentity(t)
+	id3, r3 := newIdentity(t)
+	if err := newEncryptedStore(t, backend, store.EncryptionKeys{Recipients: [][]byte{r1, r2}}).Put(a, fn); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range [][]byte{id1, id2} {
+		checkGet(t, newEncryptedStore(t, backend, store.EncryptionKeys{Recipients: [][]byte{r3}, Identities: [][]byte{id}}), a, data)
+	}
+	for _, keys := range []store.EncryptionKeys{
+		{Recipients: [][]byte{r1}},
+		{Recipients: [][]byte{r1}, Identities: [][]byte{id3}},
+		{Key: key},
+	} {
+		if err := newEncryptedStore(t, backend, keys).Get(a, filepath.Join(dir, "download"), false); err != store.ErrNoDecryptionKey {
+			t.Errorf("no matching identity: got %v", err)
+		}
+	}
+
+	for _, keys := range []store.EncryptionKeys{
+		{},
+		{Key: key, Recipients: [][]byte{r1}},
+		{Key: key[:16]},
+		{Recipients: [][]byte{r1}, Identities: [][]byte{id1[:31]}},
+	} {
+		if _, err := store.NewEncryptedStore(store.NewMemStore(), keys); err == nil {
+			t.Errorf("invalid keys %v accepted", keys)
+		}
+	}
+	if _, err := store.DecodeKey(store.EncodeKey(key[:16])); err == nil {
+		t.Error("short key decoded")
+	}
+	if got, err := store.DecodeKey(store.EncodeKey(key)); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("DecodeKey(EncodeKey(key)) = %x, %v", got, err)
+	}
+}
+
+func TestEncryptedStoreReencrypt(t *testing.T) {
+	dir, done := encTempDir(t)
+	defer done()
+	fn, data := encTestFile(t, dir, encChunkSize+10)
+	a, _ := store.MakeArtifact("app", "1.0.0")
+
+	backend := store.NewMemStore()
+	oldKey, newKey := newKey(t), newKey(t)
+	if err := newEncryptedStore(t, backend, store.EncryptionKeys{Key: oldKey}).Put(a, fn); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newEncryptedStore(t, backend, store.EncryptionKeys{Key: newKey, OldKeys: [][]byte{oldKey}})
+	if changed, err := s.Reencrypt(a); err != nil || !changed {
+		t.Fatalf("Reencrypt = %v, %v", changed, err)
+	}
+	if changed, err := s.Reencrypt(a); err != nil || changed {
+		t.Fatalf("second Reencrypt = %v, %v", changed, err)
+	}
+	checkGet(t, newEncryptedStore(t, backend, store.EncryptionKeys{Key: newKey}), a, data)
+	if err := newEncryptedStore(t, backend, store.EncryptionKeys{Key: oldKey}).Get(a, filepath.Join(dir, "download"), false); err != store.ErrNoDecryptionKey {
+		t.Errorf("old key still works: %v", err)
+	}
+
+	// rotating from a key to recipients
+	id, r := newIdentity(t)
+	s = newEncryptedStore(t, backend, store.EncryptionKeys{Recipients: [][]byte{r}, OldKeys: [][]byte{newKey}})
+	if changed, err := s.Reencrypt(a); err != nil || !changed {
+		t.Fatalf("Reencrypt to recipients = %v, %v", changed, err)
+	}
+	checkGet(t, newEncryptedStore(t, backend, store.EncryptionKeys{Recipients: [][]byte{r}, Identities: [][]byte{id}}), a, data)
+
+	// without the old key nothing is changed
+	s = newEncryptedStore(t, backend, store.EncryptionKeys{Key: oldKey})
+	if _, err := s.Reencrypt(a); err != store.ErrNoDecryptionKey {
+		t.Errorf("Reencrypt without the old key: got %v", err)
+	}
+	checkGet(t, newEncryptedStore(t, backend, store.EncryptionKeys{Recipients: [][]byte{r}, Identities: [][]byte{id}}), a, data)
+}
+
+func TestEncryptedStoreReencryptWrapped(t *testing.T) {
+	dir, done := encTempDir(t)
+	defer done()
+	fn, data := encTestFile(t, dir, encChunkSize+10)
+	a, _ := store.MakeArtifact("app", "1.0.0")
+	oldKey, newKey := newKey(t), newKey(t)
+
+	open := func(cache string, encryption map[string]interface{}) store.Store {
+		cfg := viper.New()
+		cfg.Set("type", "fs")
+		cfg.Set("root", filepath.Join(dir, "store"))
+		cfg.Set("encryption", encryption)
+		cfg.Set("compression", store.EncodingGzip)
+		cfg.Set("cache", map[string]interface{}{"dir": filepath.Join(dir, cache)})
+		s, err := store.NewStore(cfg, "bucket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	if err := open("cache", map[string]interface{}{"key": store.EncodeKey(oldKey)}).Put(a, fn); err != nil {
+		t.Fatal(err)
+	}
+
+	s := open("cache", map[string]interface{}{"key": store.EncodeKey(newKey), "old-keys": []string{store.EncodeKey(oldKey)}})
+	enc := store.EncryptedBackend(s)
+	if enc == nil {
+		t.Fatal("the encrypted store has not been found below the cache and compression")
+	}
+	if changed, err := enc.Reencrypt(a); err != nil || !changed {
+		t.Fatalf("Reencrypt = %v, %v", changed, err)
+	}
+	// a new cache makes sure the file is decrypted using the new key
+	checkGet(t, open("new-cache", map[string]interface{}{"key": store.EncodeKey(newKey)}), a, data)
+
+	if store.EncryptedBackend(store.NewMemStore()) != nil {
+		t.Error("found an encrypted store below a plain one")
+	}
+}
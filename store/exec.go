@@ -0,0 +1,333 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/blang/semver"
+	"github.com/spf13/viper"
+)
+
+// ExecStore talks to an external program implementing a store. This way
+// backends may be added without changing arti.
+//
+// The program is started once and reads requests from stdin, one JSON
+// object per line, and answers each of them with one line on stdout:
+//
+//	{"id": 1, "method": "has", "params": {"name": "app", "version": "1.0.0"}}
+//	{"id": 1, "result": {"exists": true, "filename": "app.tar.gz"}}
+//
+// Failed requests are answered with an error instead of a result. Its code
+// is "not_found" if the artifact does not exist, "exists" if it does exist
+// already and "not_implemented" for unsupported methods:
+//
+//	{"id": 2, "error": {"code": "not_found", "message": "no such artifact"}}
+//
+// The methods are:
+//
+//	init    {path, config}                       -> {}
+//	list    {name}                               -> [{name, version, filename, size}]
+//	has     {name, version}                      -> {exists, filename}
+//	put     {name, version, file, checksum, encoding} -> {}
+//	get     {name, version, file}                -> {filename, checksum, encoding}
+//	stat    {name, version}                      -> {filename, checksum, encoding}
+//	delete  {name, version}                      -> {}
+//	describe {}                                  -> {key: value, ...}
+//
+// init is sent first and hands over the bucket and the configuration of the
+// store without the settings of encryption, compression and cache. describe is optional and reports details shown by arti info.
+// file is the path of a local file: put reads the artifact from it,
+// get writes the artifact to it. Checksums are calculated and verified by
+// arti, the program only needs to store them. Messages on stderr are passed
+// through. The program should exit once stdin is closed.
+type ExecStore struct {
+	command []string
+	path    string
+	config  map[string]interface{}
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	lastID int
+}
+
+type execRequest struct {
+	ID     int         `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+type execError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type execResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *execError      `json:"error"`
+}
+
+// ExecArtifact identifies an artifact in requests of the exec protocol.
+type ExecArtifact struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// ExecFile holds the parameters of put and get requests and the results of
+// get and stat requests.
+type ExecFile struct {
+	ExecArtifact
+	File     string `json:"file,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// ExecListEntry is an element of the result of list requests.
+type ExecListEntry struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// ExecHasResult is the result of has requests.
+type ExecHasResult struct {
+	Exists   bool   `json:"exists"`
+	Filename string `json:"filename"`
+}
+
+// ExecInit holds the parameters of init requests.
+type ExecInit struct {
+	Path   string                 `json:"path"`
+	Config map[string]interface{} `json:"config"`
+}
+
+func NewExecStore(cfg *viper.Viper, path string) (Store, error) {
+	s := &ExecStore{path: path, config: cfg.AllSettings()}
+	// the keys of encryption in particular are none of the plugin's business
+	for _, k := range wrapperKeys {
+		delete(s.config, k)
+	}
+
+	command := cfg.GetString("command")
+	if command == "" {
+		return nil, fmt.Errorf("no command specified")
+	}
+	s.command = append([]string{command}, cfg.GetStringSlice("args")...)
+	return Store(s), nil
+}
+
+func (s *ExecStore) Describe() map[string]string {
+	info := map[string]string{
+		"type":    "exec",
+		"command": s.command[0],
+	}
+	if d, err := s.describe(); err == nil {
+		for k, v := range d {
+			info[k] = v
+		}
+	}
+	return info
+}
+
+func (s *ExecStore) describe() (info map[string]string, err error) {
+	err = s.call("describe", struct{}{}, &info)
+	return
+}
+
+// start runs the program and sends the init request.
+func (s *ExecStore) start() error {
+	cmd := exec.Command(s.command[0], s.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("Error starting '%s': %v", s.command[0], err)
+	}
+	s.cmd, s.stdin, s.stdout = cmd, stdin, bufio.NewReader(stdout)
+
+	if err = s.roundTrip("init", ExecInit{Path: s.path, Config: s.config}, nil); err != nil {
+		s.stop()
+		return fmt.Errorf("Error initializing '%s': %v", s.command[0], err)
+	}
+	return nil
+}
+
+func (s *ExecStore) stop() {
+	s.stdin.Close()
+	s.cmd.Wait()
+	s.cmd = nil
+}
+
+func (s *ExecStore) call(method string, params, result interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmd == nil {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+	return s.roundTrip(method, params, result)
+}
+
+func (s *ExecStore) roundTrip(method string, params, result interface{}) error {
+	s.lastID++
+	req, err := json.Marshal(execRequest{ID: s.lastID, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	if _, err = s.stdin.Write(append(req, '\n')); err != nil {
+		s.stop()
+		return fmt.Errorf("Error sending request to '%s': %v", s.command[0], err)
+	}
+
+	line, err := s.stdout.ReadBytes('\n')
+	if err != nil {
+		s.stop()
+		return fmt.Errorf("Error reading response of '%s': %v", s.command[0], err)
+	}
+	var resp execResponse
+	if err = json.Unmarshal(line, &resp); err != nil {
+		s.stop()
+		return fmt.Errorf("invalid response of '%s': %v", s.command[0], err)
+	}
+	if resp.ID != s.lastID {
+		s.stop()
+		return fmt.Errorf("invalid response of '%s': unexpected id %d", s.command[0], resp.ID)
+	}
+	if resp.Error != nil {
+		switch resp.Error.Code {
+		case "not_found":
+			return ErrArtifactNotFound
+		case "not_implemented":
+			return ErrNotImplemented
+		case "exists":
+			return fmt.Errorf("artifact already exists")
+		}
+		return fmt.Errorf("%s: %s", s.command[0], resp.Error.Message)
+	}
+	if result != nil && len(resp.Result) > 0 {
+		if err = json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("invalid response of '%s': %v", s.command[0], err)
+		}
+	}
+	return nil
+}
+
+func execArtifact(artifact Artifact) ExecArtifact {
+	return ExecArtifact{Name: artifact.Name, Version: artifact.Version.String()}
+}
+
+func (s *ExecStore) List(name string, versions semver.Range) (ArtifactList, error) {
+	var entries []ExecListEntry
+	if err := s.call("list", struct {
+		Name string `json:"name"`
+	}{name}, &entries); err != nil {
+		return nil, err
+	}
+
+	list := make(ArtifactList)
+	for _, e := range entries {
+		if a, err := MakeArtifactVersion(e.Version, e.Filename, e.Size); err == nil {
+			if versions == nil || versions(a.Version) {
+				list[e.Name] = append(list[e.Name], a)
+			}
+		}
+	}
+	return list, nil
+}
+
+func (s *ExecStore) Has(artifact Artifact) (bool, string, error) {
+	var res ExecHasResult
+	err := s.call("has", execArtifact(artifact), &res)
+	return res.Exists, res.Filename, err
+}
+
+func (s *ExecStore) Put(artifact Artifact, filename string) error {
+	csum, err := CalcCSum(filename)
+	if err != nil {
+		return fmt.Errorf("Error calculating checksum of '%s': %v", filepath.Base(filename), err)
+	}
+	return s.PutRaw(artifact, filename, RawInfo{CSum: csum})
+}
+
+func (s *ExecStore) PutRaw(artifact Artifact, filename string, info RawInfo) error {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	return s.call("put", ExecFile{
+		ExecArtifact: execArtifact(artifact),
+		File:         abs,
+		Filename:     filepath.Base(filename),
+		Checksum:     info.CSum,
+		Encoding:     info.Encoding,
+	}, nil)
+}
+
+func (s *ExecStore) Get(artifact Artifact, filename string, keepCorrupted bool) error {
+	_, err := getVerified(s.GetRaw, artifact, filename, keepCorrupted)
+	return err
+}
+
+func (s *ExecStore) GetRaw(artifact Artifact, filename string) (RawInfo, error) {
+	info, err := s.Stat(artifact)
+	if err != nil {
+		return info, err
+	}
+	target := filename
+	if target == "" {
+		target = info.Filename
+	}
+	if target, err = filepath.Abs(target); err != nil {
+		return info, err
+	}
+
+	var res ExecFile
+	if err = s.call("get", ExecFile{ExecArtifact: execArtifact(artifact), File: target}, &res); err != nil {
+		return info, err
+	}
+	return RawInfo{Filename: res.Filename, CSum: res.Checksum, Encoding: res.Encoding}, nil
+}
+
+func (s *ExecStore) Stat(artifact Artifact) (RawInfo, error) {
+	var res ExecFile
+	if err := s.call("stat", execArtifact(artifact), &res); err != nil {
+		return RawInfo{}, err
+	}
+	return RawInfo{Filename: res.Filename, CSum: res.Checksum, Encoding: res.Encoding}, nil
+}
+
+func (s *ExecStore) Del(artifact Artifact) error {
+	return s.call("delete", execArtifact(artifact), nil)
+}
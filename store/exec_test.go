@@ -0,0 +1,114 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/storetest"
+	"github.com/spf13/viper"
+)
+
+// TestMain lets the test binary act as plugin for TestExecStore.
+func TestMain(m *testing.M) {
+	if os.Getenv("ARTI_TEST_EXEC_PLUGIN") != "" {
+		err := store.ServeExec(func(init store.ExecInit) (store.Store, error) {
+			for _, k := range []string{"encryption", "compression", "cache"} {
+				if _, found := init.Config[k]; found {
+					return nil, fmt.Errorf("init got the %s settings", k)
+				}
+			}
+			return store.NewMemStore(), nil
+		}, os.Stdin, os.Stdout)
+		if err != nil {
+			os.Stderr.WriteString(err.Error() + "\n")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestExecStore(t *testing.T) {
+	os.Setenv("ARTI_TEST_EXEC_PLUGIN", "1")
+	defer os.Unsetenv("ARTI_TEST_EXEC_PLUGIN")
+
+	storetest.Run(t, func(t *testing.T) store.Store {
+		cfg := viper.New()
+		cfg.Set("type", "exec")
+		cfg.Set("command", os.Args[0])
+		s, err := store.NewStore(cfg, "bucket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// TestExecStoreWrappers checks that the settings of the wrappers are not
+// sent to the plugin.
+func TestExecStoreWrappers(t *testing.T) {
+	os.Setenv("ARTI_TEST_EXEC_PLUGIN", "1")
+	defer os.Unsetenv("ARTI_TEST_EXEC_PLUGIN")
+	cache, err := ioutil.TempDir("", "arti-exec-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cache)
+	key, err := store.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storetest.Run(t, func(t *testing.T) store.Store {
+		cfg := viper.New()
+		cfg.Set("type", "exec")
+		cfg.Set("command", os.Args[0])
+		cfg.Set("compression", "gzip")
+		cfg.Set("encryption", map[string]interface{}{"key": store.EncodeKey(key)})
+		cfg.Set("cache", map[string]interface{}{"dir": cache})
+		s, err := store.NewStore(cfg, "bucket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestRegister(t *testing.T) {
+	store.Register("Test", func(cfg *viper.Viper, path string) (store.Store, error) {
+		return store.NewMemStore(), nil
+	})
+	cfg := viper.New()
+	cfg.Set("type", "test")
+	s, err := store.NewStore(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*store.FSStore); !ok {
+		t.Fatalf("NewStore returned %T", s)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a type twice didn't panic")
+		}
+	}()
+	store.Register("s3", nil)
+}
@@ -0,0 +1,149 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ServeExec answers requests of the exec protocol (see ExecStore) read from
+// r by writing to w, using the store returned by open. It returns once r is
+// closed. This makes writing plugins in Go simple:
+//
+//	func main() {
+//		err := store.ServeExec(openMyStore, os.Stdin, os.Stdout)
+//		...
+//	}
+func ServeExec(open func(init ExecInit) (Store, error), r io.Reader, w io.Writer) error {
+	var s Store
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	enc := json.NewEncoder(w)
+	for scanner.Scan() {
+		var req struct {
+			ID     int             `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return fmt.Errorf("invalid request: %v", err)
+		}
+
+		var result interface{}
+		var err error
+		if req.Method == "init" {
+			var init ExecInit
+			if err = json.Unmarshal(req.Params, &init); err == nil {
+				s, err = open(init)
+			}
+			result = struct{}{}
+		} else if s == nil {
+			err = fmt.Errorf("not initialized")
+		} else {
+			result, err = serveExecRequest(s, req.Method, req.Params)
+		}
+
+		resp := map[string]interface{}{"id": req.ID}
+		if err != nil {
+			resp["error"] = execErrorOf(err)
+		} else {
+			resp["result"] = result
+		}
+		if err = enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func execErrorOf(err error) execError {
+	switch {
+	case err == ErrArtifactNotFound:
+		return execError{Code: "not_found", Message: err.Error()}
+	case err == ErrNotImplemented:
+		return execError{Code: "not_implemented", Message: err.Error()}
+	case strings.Contains(err.Error(), "already exists"):
+		return execError{Code: "exists", Message: err.Error()}
+	}
+	return execError{Code: "error", Message: err.Error()}
+}
+
+func serveExecRequest(s Store, method string, params json.RawMessage) (interface{}, error) {
+	var p ExecFile
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, fmt.Errorf("invalid parameters: %v", err)
+	}
+	var a Artifact
+	if method != "list" && method != "describe" {
+		var err error
+		if a, err = MakeArtifact(p.Name, p.Version); err != nil {
+			return nil, err
+		}
+	}
+
+	switch method {
+	case "describe":
+		if d, ok := s.(Describer); ok {
+			return d.Describe(), nil
+		}
+		return map[string]string{}, nil
+	case "list":
+		list, err := s.List(p.Name, nil)
+		if err != nil {
+			return nil, err
+		}
+		entries := []ExecListEntry{}
+		for n, vs := range list {
+			for _, v := range vs {
+				entries = append(entries, ExecListEntry{Name: n, Version: v.Version.String(), Filename: v.Filename, Size: v.Filesize})
+			}
+		}
+		return entries, nil
+	case "has":
+		exists, filename, err := s.Has(a)
+		return ExecHasResult{Exists: exists, Filename: filename}, err
+	case "put":
+		rs, ok := s.(RawStore)
+		if !ok {
+			return nil, ErrNotImplemented
+		}
+		if filepath.Base(p.File) != p.Filename {
+			return nil, fmt.Errorf("the filename must be the name of the file")
+		}
+		return struct{}{}, rs.PutRaw(a, p.File, RawInfo{CSum: p.Checksum, Encoding: p.Encoding})
+	case "get":
+		rs, ok := s.(RawStore)
+		if !ok {
+			return nil, ErrNotImplemented
+		}
+		info, err := rs.GetRaw(a, p.File)
+		return ExecFile{Filename: info.Filename, Checksum: info.CSum, Encoding: info.Encoding}, err
+	case "stat":
+		rs, ok := s.(RawStore)
+		if !ok {
+			return nil, ErrNotImplemented
+		}
+		info, err := rs.Stat(a)
+		return ExecFile{Filename: info.Filename, Checksum: info.CSum, Encoding: info.Encoding}, err
+	case "delete":
+		return struct{}{}, s.Del(a)
+	}
+	return nil, ErrNotImplemented
+}
@@ -0,0 +1,41 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// NewS3Transport exposes the transport of S3 stores to the tests. It signs
+// requests again using the given credentials and adds the server-side
+// encryption headers of s unless s is nil.
+func NewS3Transport(base http.RoundTripper, s *S3Store, accessKeyID, secretAccessKey string) http.RoundTripper {
+	t := &s3Transport{
+		base: base,
+		credentials: func() (awsCredentials, error) {
+			return awsCredentials{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey}, nil
+		},
+	}
+	if s != nil {
+		t.bucket, t.headers = s.bucket, s.sseHeaders
+	}
+	return t
+}
+
+// PresignV4 exposes presignV4 to the tests.
+func PresignV4(method string, u *url.URL, accessKeyID, secretAccessKey string) (*url.URL, error) {
+	return presignV4(method, u, awsCredentials{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey})
+}
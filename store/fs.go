@@ -0,0 +1,377 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+// FSStore keeps artifacts in a filesystem using the same layout as the S3
+// store. Together with afero.NewMemMapFs it is useful to test tools built
+// on this package without a real storage backend.
+type FSStore struct {
+	fs   afero.Fs
+	root string
+	kind string
+}
+
+// NewFSStore returns a store which keeps the artifacts below root.
+func NewFSStore(fs afero.Fs, root string) *FSStore {
+	return &FSStore{fs: fs, root: filepath.Clean(root), kind: "fs"}
+}
+
+func newFSStoreFromConfig(cfg *viper.Viper, path string) (Store, error) {
+	root := cfg.GetString("root")
+	if root == "" {
+		return nil, fmt.Errorf("no root directory specified")
+	}
+	return NewFSStore(afero.NewOsFs(), filepath.Join(root, filepath.FromSlash(path))), nil
+}
+
+// NewMemStore returns a store which keeps the artifacts in memory.
+func NewMemStore() *FSStore {
+	s := NewFSStore(afero.NewMemMapFs(), string(filepath.Separator))
+	s.kind = "memory"
+	return s
+}
+
+func (s *FSStore) Describe() map[string]string {
+	info := map[string]string{"type": s.kind}
+	if s.kind != "memory" {
+		info["root"] = s.root
+	}
+	return info
+}
+
+// filename converts a slash separated key to a path of the filesystem.
+func (s *FSStore) filename(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+// key converts a path of the filesystem to a slash separated key.
+func (s *FSStore) key(filename string) (string, error) {
+	rel, err := filepath.Rel(s.root, filename)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// walk calls fn for every file below the directory p.
+func (s *FSStore) walk(p string, fn func(key string, fi os.FileInfo) error) error {
+	err := afero.Walk(s.fs, s.filename(p), func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		key, err := s.key(filename)
+		if err != nil {
+			return err
+		}
+		return fn(key, fi)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *FSStore) List(name string, versions semver.Range) (list ArtifactList, err error) {
+	list = make(ArtifactList)
+
+	err = s.walk(name, func(key string, fi os.FileInfo) error {
+		if IsSidecar(key) {
+			return nil
+		}
+		nv, f := path.Split(key)
+		n, v := path.Split(strings.TrimSuffix(nv, "/"))
+		n = strings.TrimSuffix(n, "/")
+		if a, err := MakeArtifactVersion(v, f, fi.Size()); err == nil && n != "" {
+			if versions == nil || versions(a.Version) {
+				list[n] = append(list[n], a)
+			}
+		}
+		// ignoring files outside of scheme
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("Error while listing files: %v", err)
+	}
+	return
+}
+
+func (s *FSStore) Has(artifact Artifact) (exists bool, filename string, err error) {
+	filename, _, exists, err = s.find(artifact)
+	return
+}
+
+// find looks up the file of an artifact and the sidecar files present.
+func (s *FSStore) find(artifact Artifact) (filename string, sidecars map[string]bool, exists bool, err error) {
+	sidecars = make(map[string]bool)
+	entries, err := afero.ReadDir(s.fs, s.filename(path.Join(artifact.Name, artifact.Version.String())))
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	} else if err != nil {
+		err = fmt.Errorf("Error while listing files: %v", err)
+		return
+	}
+	for _, fi := range entries {
+		f := fi.Name()
+		if fi.IsDir() {
+			continue
+		}
+		if IsSidecar(f) {
+			sidecars[path.Ext(f)] = true
+		} else {
+			if filename != "" {
+				err = fmt.Errorf("found more then one file")
+				return
+			}
+			filename = f
+		}
+	}
+	if filename != "" || sidecars[CSumExt] {
+		exists = true
+	}
+	return
+}
+
+func (s *FSStore) Put(artifact Artifact, filename string) error {
+	csum, err := CalcCSum(filename)
+	if err != nil {
+		return fmt.Errorf("Error calculating checksum of '%s': %v", filepath.Base(filename), err)
+	}
+	return s.PutRaw(artifact, filename, RawInfo{CSum: csum})
+}
+
+func (s *FSStore) PutRaw(artifact Artifact, filename string, info RawInfo) error {
+	if exists, _, err := s.Has(artifact); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("artifact already exists")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("Error opening file: %v", err)
+	}
+	defer f.Close()
+
+	basename := filepath.Base(filename)
+	p := path.Join(artifact.Name, artifact.Version.String(), basename)
+	if err = s.PutObject(p, f); err != nil {
+		return err
+	}
+	if err = s.PutObject(p+CSumExt, strings.NewReader(info.CSum)); err != nil {
+		return fmt.Errorf("Error uploading hash: %v", err)
+	}
+	if info.Encoding != "" {
+		if err = s.PutObject(p+EncodingExt, strings.NewReader(info.Encoding)); err != nil {
+			return fmt.Errorf("Error uploading encoding: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *FSStore) Get(artifact Artifact, filename string, keepCorrupted bool) error {
+	_, err := getVerified(s.GetRaw, artifact, filename, keepCorrupted)
+	return err
+}
+
+func (s *FSStore) GetRaw(artifact Artifact, filename string) (info RawInfo, err error) {
+	if info, err = s.Stat(artifact); err != nil {
+		return
+	}
+
+	target := filename
+	if target == "" {
+		target = info.Filename
+	}
+	src, err := s.fs.Open(s.filename(path.Join(artifact.Name, artifact.Version.String(), info.Filename)))
+	if err != nil {
+		err = fmt.Errorf("Error fetching file '%s': %v", info.Filename, err)
+		return
+	}
+	defer src.Close()
+
+	dst, err := os.Create(target)
+	if err != nil {
+		err = fmt.Errorf("Error fetching file '%s': %v", info.Filename, err)
+		return
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(target)
+		err = fmt.Errorf("Error fetching file '%s': %v", info.Filename, err)
+	}
+	return
+}
+
+func (s *FSStore) Open(artifact Artifact, filename string) (io.ReadCloser, error) {
+	f, err := s.fs.Open(s.filename(path.Join(artifact.Name, artifact.Version.String(), filename)))
+	if os.IsNotExist(err) {
+		return nil, ErrArtifactNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Error fetching file '%s': %v", filename, err)
+	}
+	return f, nil
+}
+
+func (s *FSStore) Stat(artifact Artifact) (info RawInfo, err error) {
+	var exists bool
+	var sidecars map[string]bool
+	if info.Filename, sidecars, exists, err = s.find(artifact); err != nil {
+		return
+	}
+	if !exists {
+		err = ErrArtifactNotFound
+		return
+	}
+
+	p := s.filename(path.Join(artifact.Name, artifact.Version.String(), info.Filename))
+	var data []byte
+	if data, err = afero.ReadFile(s.fs, p+CSumExt); err != nil {
+		err = fmt.Errorf("Error while fetching hash: %v", err)
+		return
+	}
+	info.CSum = string(data)
+	if sidecars[EncodingExt] {
+		if data, err = afero.ReadFile(s.fs, p+EncodingExt); err != nil {
+			err = fmt.Errorf("Error while fetching encoding: %v", err)
+			return
+		}
+		info.Encoding = string(data)
+	}
+	return
+}
+
+func (s *FSStore) Del(artifact Artifact) error {
+	p := s.filename(path.Join(artifact.Name, artifact.Version.String()))
+	if err := s.removeAll(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error deleting '%s': %v", artifact.Version, err)
+	}
+	s.removeEmptyParents(p)
+	return nil
+}
+
+// removeAll is like RemoveAll but removes one file after another as the
+// RemoveAll of afero.MemMapFs removes everything sharing the prefix, e.g.
+// 1.0.10 along with 1.0.1.
+func (s *FSStore) removeAll(p string) error {
+	fi, err := s.fs.Stat(p)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		entries, err := afero.ReadDir(s.fs, p)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err = s.removeAll(filepath.Join(p, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return s.fs.Remove(p)
+}
+
+// removeEmptyParents removes the directories containing filename as long
+// as they are empty, like an object store wouldn't keep them either.
+func (s *FSStore) removeEmptyParents(filename string) {
+	for dir := filepath.Dir(filename); dir != s.root && strings.HasPrefix(dir, s.root); dir = filepath.Dir(dir) {
+		if empty, err := afero.IsEmpty(s.fs, dir); err != nil || !empty {
+			return
+		}
+		if s.fs.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+func (s *FSStore) PutObject(key string, r io.Reader) error {
+	p := s.filename(key)
+	if err := s.fs.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("Error uploading '%s': %v", key, err)
+	}
+	if err := afero.WriteReader(s.fs, p, r); err != nil {
+		return fmt.Errorf("Error uploading '%s': %v", key, err)
+	}
+	return nil
+}
+
+func (s *FSStore) GetObject(key string) (io.ReadCloser, error) {
+	f, err := s.fs.Open(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Error fetching '%s': %v", key, err)
+	}
+	if fi, err := f.Stat(); err != nil || fi.IsDir() {
+		f.Close()
+		return nil, ErrObjectNotFound
+	}
+	return f, nil
+}
+
+func (s *FSStore) DelObject(key string) error {
+	p := s.filename(key)
+	if err := s.fs.Remove(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error deleting '%s': %v", key, err)
+	}
+	s.removeEmptyParents(p)
+	return nil
+}
+
+type objectInfos []ObjectInfo
+
+func (o objectInfos) Len() int           { return len(o) }
+func (o objectInfos) Less(i, j int) bool { return o[i].Key < o[j].Key }
+func (o objectInfos) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+
+func (s *FSStore) ListObjects(prefix string) (objects []ObjectInfo, err error) {
+	// walk the deepest directory which contains all keys with the prefix
+	dir := prefix
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+	}
+	err = s.walk(dir, func(key string, fi os.FileInfo) error {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: fi.Size(), LastModified: fi.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error while listing objects: %v", err)
+	}
+	sort.Sort(objectInfos(objects))
+	return
+}
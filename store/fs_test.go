@@ -0,0 +1,47 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/storetest"
+	"github.com/spf13/afero"
+)
+
+func TestMemStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewMemStore()
+	})
+}
+
+func TestFSStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "arti-fs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	storetest.Run(t, func(t *testing.T) store.Store {
+		dir, err := ioutil.TempDir(root, "store-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store.NewFSStore(afero.NewOsFs(), dir)
+	})
+}
@@ -0,0 +1,439 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/spf13/viper"
+)
+
+var (
+	errHTTPNotFound = fmt.Errorf("not found")
+	hrefRe          = regexp.MustCompile(`(?i)<a\s[^>]*href\s*=\s*["']([^"']+)["']`)
+)
+
+// HTTPStore reads artifacts from a web server which serves them using the
+// usual <name>/<version>/<file> layout, e.g. the release downloads of a
+// vendor or another arti server. Artifacts are found using an index file
+// if one is configured or by parsing directory listings otherwise. The
+// store is read-only.
+type HTTPStore struct {
+	base     *url.URL
+	index    string
+	username string
+	password string
+	client   *http.Client
+}
+
+func NewHTTPStore(cfg *viper.Viper, p string) (Store, error) {
+	s := &HTTPStore{client: &http.Client{}}
+
+	base := cfg.GetString("url")
+	if base == "" {
+		return nil, fmt.Errorf("no url specified")
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid url: unsupported scheme '%s'", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	if p = strings.Trim(p, "/"); p != "" {
+		u.Path += p + "/"
+	}
+	s.base = u
+
+	s.index = cfg.GetString("index")
+	s.username = cfg.GetString("username")
+	if s.password, err = readSecret(cfg, "password"); err != nil {
+		return nil, err
+	}
+	return Store(s), nil
+}
+
+func (s *HTTPStore) Describe() map[string]string {
+	info := map[string]string{
+		"type": "http",
+		"url":  MaskPassword(s.base.String()),
+	}
+	if s.index != "" {
+		info["index"] = s.index
+	} else {
+		info["index"] = "none (directory listings)"
+	}
+	return info
+}
+
+func (s *HTTPStore) url(p string) string {
+	u := *s.base
+	u.Path += strings.TrimPrefix(p, "/")
+	return u.String()
+}
+
+func (s *HTTPStore) request(method, p string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(p), nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		resp.Body.Close()
+		return nil, errHTTPNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return resp, nil
+}
+
+func (s *HTTPStore) getString(p string) (string, error) {
+	resp, err := s.request("GET", p)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var value bytes.Buffer
+	if _, err = io.Copy(&value, io.LimitReader(resp.Body, 64<<10)); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(value.String()), nil
+}
+
+// size returns the length of a file using a HEAD request. It is 0 if the
+// server doesn't tell.
+func (s *HTTPStore) size(p string) (int64, error) {
+	resp, err := s.request("HEAD", p)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.ContentLength < 0 {
+		return 0, nil
+	}
+	return resp.ContentLength, nil
+}
+
+type httpFile struct {
+	path string
+	size int64
+}
+
+// readIndex parses the index file. Every line holds the path of a file
+// relative to the store and optionally its size, separated by whitespace.
+// Empty lines and lines starting with '#' are ignored.
+func (s *HTTPStore) readIndex() (files []httpFile, err error) {
+	resp, err := s.request("GET", s.index)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching index: %v", err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		f := httpFile{path: strings.TrimPrefix(fields[0], "/"), size: -1}
+		if len(fields) > 1 {
+			if f.size, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid size in index: %s", line)
+			}
+		}
+		files = append(files, f)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error fetching index: %v", err)
+	}
+	return files, nil
+}
+
+// readDir returns the entries of the directory listing at p which lie
+// below it. Names of directories end with a slash. Entries usually are
+// direct children but listings like those of arti may skip levels.
+func (s *HTTPStore) readDir(p string) (entries []string, err error) {
+	resp, err := s.request("GET", p)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := url.Parse(s.url(p))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, m := range hrefRe.FindAllSubmatch(data, -1) {
+		ref, err := url.Parse(html.UnescapeString(string(m[1])))
+		if err != nil || ref.RawQuery != "" {
+			// e.g. the sort links of apache
+			continue
+		}
+		u := dir.ResolveReference(ref)
+		if u.Scheme != dir.Scheme || u.Host != dir.Host || !strings.HasPrefix(u.Path, dir.Path) {
+			continue
+		}
+		name := strings.TrimPrefix(u.Path, dir.Path)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		entries = append(entries, name)
+	}
+	return entries, nil
+}
+
+// walk collects all files below the directory p. seen holds the paths
+// already visited.
+func (s *HTTPStore) walk(p string, seen map[string]bool, files []httpFile) ([]httpFile, error) {
+	entries, err := s.readDir(p)
+	if err == errHTTPNotFound {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("Error while listing '%s': %v", s.url(p), err)
+	}
+	for _, e := range entries {
+		if seen[p+e] {
+			continue
+		}
+		seen[p+e] = true
+		if strings.HasSuffix(e, "/") {
+			sub, err := s.walk(p+e, seen, files)
+			if err == errHTTPNotFound {
+				// broken link
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			files = sub
+		} else {
+			files = append(files, httpFile{path: p + e, size: -1})
+		}
+	}
+	return files, nil
+}
+
+func (s *HTTPStore) List(name string, versions semver.Range) (list ArtifactList, err error) {
+	list = make(ArtifactList)
+
+	p := name
+	if p != "" {
+		p += "/"
+	}
+	var files []httpFile
+	if s.index != "" {
+		files, err = s.readIndex()
+	} else {
+		files, err = s.walk(p, make(map[string]bool), nil)
+	}
+	if err != nil {
+		if err == errHTTPNotFound {
+			err = nil
+		}
+		return
+	}
+
+	for _, f := range files {
+		if !strings.HasPrefix(f.path, p) || IsSidecar(f.path) {
+			continue
+		}
+		nv, fn := path.Split(f.path)
+		n, v := path.Split(strings.TrimSuffix(nv, "/"))
+		n = strings.TrimSuffix(n, "/")
+		a, err := MakeArtifactVersion(v, fn, f.size)
+		if n == "" || err != nil {
+			// ignoring files outside of scheme
+			continue
+		}
+		if versions != nil && !versions(a.Version) {
+			continue
+		}
+		if a.Filesize < 0 {
+			if a.Filesize, err = s.size(f.path); err != nil {
+				return nil, fmt.Errorf("Error while fetching size of '%s': %v", f.path, err)
+			}
+		}
+		list[n] = append(list[n], a)
+	}
+	return
+}
+
+// find looks up the file of an artifact and the sidecar files present.
+func (s *HTTPStore) find(artifact Artifact) (filename string, sidecars map[string]bool, err error) {
+	sidecars = make(map[string]bool)
+	p := path.Join(artifact.Name, artifact.Version.String()) + "/"
+
+	var names []string
+	if s.index != "" {
+		files, err := s.readIndex()
+		if err != nil {
+			return "", nil, err
+		}
+		for _, f := range files {
+			if dir, fn := path.Split(f.path); dir == p {
+				names = append(names, fn)
+			}
+		}
+	} else {
+		entries, err := s.readDir(p)
+		if err == errHTTPNotFound {
+			return "", sidecars, nil
+		} else if err != nil {
+			return "", nil, fmt.Errorf("Error while listing '%s': %v", s.url(p), err)
+		}
+		for _, e := range entries {
+			if !strings.Contains(e, "/") {
+				names = append(names, e)
+			}
+		}
+	}
+
+	for _, n := range names {
+		if IsSidecar(n) {
+			sidecars[path.Ext(n)] = true
+			continue
+		}
+		if filename != "" {
+			return "", nil, fmt.Errorf("found more then one file")
+		}
+		filename = n
+	}
+	return filename, sidecars, nil
+}
+
+func (s *HTTPStore) Has(artifact Artifact) (bool, string, error) {
+	filename, _, err := s.find(artifact)
+	if err != nil || filename == "" {
+		return false, "", err
+	}
+	p := path.Join(artifact.Name, artifact.Version.String(), filename)
+	if _, err = s.size(p); err == errHTTPNotFound {
+		return false, "", nil
+	} else if err != nil {
+		return false, "", fmt.Errorf("Error while checking '%s': %v", filename, err)
+	}
+	return true, filename, nil
+}
+
+func (s *HTTPStore) Put(artifact Artifact, filename string) error {
+	return ErrNotImplemented
+}
+
+func (s *HTTPStore) PutRaw(artifact Artifact, filename string, info RawInfo) error {
+	return ErrNotImplemented
+}
+
+func (s *HTTPStore) Get(artifact Artifact, filename string, keepCorrupted bool) error {
+	_, err := getVerified(s.GetRaw, artifact, filename, keepCorrupted)
+	return err
+}
+
+func (s *HTTPStore) GetRaw(artifact Artifact, filename string) (info RawInfo, err error) {
+	if info, err = s.Stat(artifact); err != nil {
+		return
+	}
+
+	target := filename
+	if target == "" {
+		target = info.Filename
+	}
+	p := path.Join(artifact.Name, artifact.Version.String(), info.Filename)
+	resp, err := s.request("GET", p)
+	if err != nil {
+		err = fmt.Errorf("Error fetching file '%s': %v", info.Filename, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	f, err := os.Create(target)
+	if err != nil {
+		err = fmt.Errorf("Error fetching file '%s': %v", info.Filename, err)
+		return
+	}
+	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(target)
+		err = fmt.Errorf("Error fetching file '%s': %v", info.Filename, err)
+		return
+	}
+	return
+}
+
+func (s *HTTPStore) Stat(artifact Artifact) (info RawInfo, err error) {
+	var sidecars map[string]bool
+	if info.Filename, sidecars, err = s.find(artifact); err != nil {
+		return
+	}
+	if info.Filename == "" {
+		err = ErrArtifactNotFound
+		return
+	}
+
+	p := path.Join(artifact.Name, artifact.Version.String(), info.Filename)
+	if info.CSum, err = s.getString(p + CSumExt); err == errHTTPNotFound {
+		err = fmt.Errorf("Error while fetching hash: '%s' has no checksum file", info.Filename)
+		return
+	} else if err != nil {
+		err = fmt.Errorf("Error while fetching hash: %v", err)
+		return
+	}
+	// a directory listing which doesn't show the checksum file might hide
+	// the encoding as well, otherwise it is only fetched if listed
+	if sidecars[EncodingExt] || (s.index == "" && !sidecars[CSumExt]) {
+		if info.Encoding, err = s.getString(p + EncodingExt); err == errHTTPNotFound {
+			info.Encoding, err = "", nil
+		} else if err != nil {
+			err = fmt.Errorf("Error while fetching encoding: %v", err)
+			return
+		}
+	}
+	return
+}
+
+func (s *HTTPStore) Del(artifact Artifact) error {
+	return ErrNotImplemented
+}
@@ -0,0 +1,216 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/storetest"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+// httpFixture is an HTTP store reading the files of an FSStore which are
+// served by a web server. Changes, including those of the objects storetest
+// uses to tamper with checksums, are made to the FSStore directly.
+type httpFixture struct {
+	*store.HTTPStore
+	fs    *store.FSStore
+	index string
+}
+
+func newHTTPFixture(t *testing.T, fs afero.Fs, url, dir, index string) *httpFixture {
+	cfg := viper.New()
+	cfg.Set("url", url)
+	cfg.Set("index", index)
+	s, err := store.NewHTTPStore(cfg, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &httpFixture{HTTPStore: s.(*store.HTTPStore), fs: store.NewFSStore(fs, "/"+dir), index: index}
+	if err = f.updated(nil); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// updated rewrites the index after a change.
+func (s *httpFixture) updated(err error) error {
+	if err != nil || s.index == "" {
+		return err
+	}
+	objects, err := s.fs.ListObjects("")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# generated by the test")
+	for i, o := range objects {
+		if o.Key == s.index {
+			continue
+		}
+		// the size is optional
+		if i%2 == 0 {
+			fmt.Fprintf(&buf, "/%s %d\n", o.Key, o.Size)
+		} else {
+			fmt.Fprintf(&buf, "%s\n", o.Key)
+		}
+	}
+	return s.fs.PutObject(s.index, &buf)
+}
+
+func (s *httpFixture) Put(a store.Artifact, filename string) error {
+	return s.updated(s.fs.Put(a, filename))
+}
+
+func (s *httpFixture) PutRaw(a store.Artifact, filename string, info store.RawInfo) error {
+	return s.updated(s.fs.PutRaw(a, filename, info))
+}
+
+func (s *httpFixture) Del(a store.Artifact) error {
+	return s.updated(s.fs.Del(a))
+}
+
+func (s *httpFixture) ListObjects(prefix string) ([]store.ObjectInfo, error) {
+	return s.fs.ListObjects(prefix)
+}
+
+func (s *httpFixture) GetObject(key string) (io.ReadCloser, error) {
+	return s.fs.GetObject(key)
+}
+
+func (s *httpFixture) PutObject(key string, r io.Reader) error {
+	return s.updated(s.fs.PutObject(key, r))
+}
+
+func (s *httpFixture) DelObject(key string) error {
+	return s.updated(s.fs.DelObject(key))
+}
+
+func TestHTTPStore(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	ts := httptest.NewServer(http.FileServer(afero.NewHttpFs(fs).Dir("/")))
+	defer ts.Close()
+
+	for _, index := range []string{"", "index.txt"} {
+		name := "Listing"
+		if index != "" {
+			name = "Index"
+		}
+		t.Run(name, func(t *testing.T) {
+			n := 0
+			storetest.Run(t, func(t *testing.T) store.Store {
+				n++
+				return newHTTPFixture(t, fs, ts.URL, fmt.Sprintf("%s-%d", name, n), index)
+			})
+		})
+	}
+}
+
+func TestHTTPStoreEncoding(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := http.FileServer(afero.NewHttpFs(fs).Dir("/"))
+	var mu sync.Mutex
+	encodingRequests := 0
+	hideSidecars := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if strings.HasSuffix(r.URL.Path, store.EncodingExt) {
+			encodingRequests++
+		}
+		hide := hideSidecars
+		mu.Unlock()
+		if !hide || !strings.HasSuffix(r.URL.Path, "/") {
+			files.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		files.ServeHTTP(rec, r)
+		for _, line := range strings.SplitAfter(rec.Body.String(), "\n") {
+			if !strings.Contains(line, store.CSumExt) && !strings.Contains(line, store.EncodingExt) {
+				io.WriteString(w, line)
+			}
+		}
+	}))
+	defer ts.Close()
+	requests := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		n := encodingRequests
+		encodingRequests = 0
+		return n
+	}
+
+	dir, err := ioutil.TempDir("", "arti-http-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "app.tar")
+	if err = ioutil.WriteFile(filename, []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, index := range []string{"", "index.txt"} {
+		s := newHTTPFixture(t, fs, ts.URL, "encoding"+index, index)
+		plain, _ := store.MakeArtifact("app", "1.0.0")
+		gzipped, _ := store.MakeArtifact("app", "1.1.0")
+		if err = s.Put(plain, filename); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.PutRaw(gzipped, filename, store.RawInfo{CSum: "sha256:0123", Encoding: store.EncodingGzip}); err != nil {
+			t.Fatal(err)
+		}
+
+		if info, err := s.Stat(plain); err != nil || info.Encoding != "" {
+			t.Errorf("index %q: unexpected info %+v, %v", index, info, err)
+		}
+		if n := requests(); n != 0 {
+			t.Errorf("index %q: the encoding of an artifact without one has been fetched %d times", index, n)
+		}
+		if info, err := s.Stat(gzipped); err != nil || info.Encoding != store.EncodingGzip {
+			t.Errorf("index %q: unexpected info %+v, %v", index, info, err)
+		}
+		if n := requests(); n != 1 {
+			t.Errorf("index %q: the encoding has been fetched %d times", index, n)
+		}
+	}
+
+	// listings without sidecar files don't tell whether there is an encoding
+	mu.Lock()
+	hideSidecars = true
+	mu.Unlock()
+	s := newHTTPFixture(t, fs, ts.URL, "encoding", "")
+	for version, encoding := range map[string]string{"1.0.0": "", "1.1.0": store.EncodingGzip} {
+		a, _ := store.MakeArtifact("app", version)
+		if info, err := s.Stat(a); err != nil || info.Encoding != encoding {
+			t.Errorf("%s: unexpected info %+v, %v", version, info, err)
+		}
+		if n := requests(); n != 1 {
+			t.Errorf("%s: the encoding has been fetched %d times", version, n)
+		}
+	}
+}
@@ -17,14 +17,22 @@ package store
 import (
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/blang/semver"
 	"github.com/spf13/viper"
 )
 
 var (
-	ErrNotImplemented = errors.New("not implemented")
+	ErrNotImplemented   = errors.New("not implemented")
+	ErrArtifactNotFound = errors.New("artifact not found")
+	ErrObjectNotFound   = errors.New("object not found")
 )
 
 type Artifact struct {
@@ -82,13 +90,361 @@ type Store interface {
 	Del(artifact Artifact) error
 }
 
+// RawInfo describes a file as it is kept by a store. Encoding is empty
+// unless the content has been compressed before storing it.
+type RawInfo struct {
+	Filename string
+	CSum     string
+	Encoding string
+}
+
+// RawStore is implemented by stores which let the caller take care of the
+// checksum. This is used by wrappers which transform files before handing
+// them to another store but want the checksum to describe the original.
+type RawStore interface {
+	Store
+	// PutRaw uploads the file and stores the checksum and encoding of
+	// info alongside. info.Filename is ignored.
+	PutRaw(artifact Artifact, filename string, info RawInfo) error
+	// GetRaw downloads the file without decoding or verifying it and
+	// returns the stored checksum and encoding.
+	GetRaw(artifact Artifact, filename string) (RawInfo, error)
+	// Stat returns the same information as GetRaw without downloading
+	// the file.
+	Stat(artifact Artifact) (RawInfo, error)
+}
+
+// Opener is implemented by stores which are able to read the file of an
+// artifact without downloading it first.
+type Opener interface {
+	// Open returns the file named filename, as reported by Stat, without
+	// decoding or verifying it. The reader implements io.Seeker if the
+	// store is able to read parts of the file.
+	Open(artifact Artifact, filename string) (io.ReadCloser, error)
+}
+
+// Describer is implemented by stores which are able to report details of
+// their configuration.
+type Describer interface {
+	Describe() map[string]string
+}
+
+// ObjectInfo describes a single file of an ObjectStore.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ObjectStore is implemented by stores which are able to keep files outside
+// of the artifact scheme, e.g. the metadata of package repositories. Keys
+// are slash separated paths relative to the bucket.
+type ObjectStore interface {
+	PutObject(key string, r io.Reader) error
+	// GetObject returns ErrObjectNotFound if there is no such object.
+	GetObject(key string) (io.ReadCloser, error)
+	// DelObject does not fail if there is no such object.
+	DelObject(key string) error
+	ListObjects(prefix string) ([]ObjectInfo, error)
+}
+
+// PresignedArtifact holds time-limited URLs to access an artifact without
+// credentials. Encoding is set if the file has been compressed.
+type PresignedArtifact struct {
+	Filename    string
+	URL         string
+	ChecksumURL string
+	Encoding    string
+}
+
+// Presigner is implemented by stores which are able to hand out
+// time-limited URLs for downloading or uploading artifacts.
+type Presigner interface {
+	PresignGet(artifact Artifact, expires time.Duration) (PresignedArtifact, error)
+	PresignPut(artifact Artifact, filename string, expires time.Duration) (PresignedArtifact, error)
+}
+
+// BucketInfo describes a bucket returned by ListBuckets.
+type BucketInfo struct {
+	Name    string
+	Created time.Time
+}
+
+// BucketManager is implemented by stores whose bucket may be managed
+// explicitly rather than being created by the first upload.
+type BucketManager interface {
+	// CreateBucket fails if the bucket exists already.
+	CreateBucket() error
+	// DeleteBucket fails if the bucket isn't empty unless force is set.
+	DeleteBucket(force bool) error
+	BucketInfo() (map[string]string, error)
+	// SetBucketPolicy sets one of the policies private, public-read,
+	// public-write or public-read-write.
+	SetBucketPolicy(policy string) error
+	SetBucketVersioning(enabled bool) error
+}
+
+// Backend returns the store wrapped by the cache, compression and
+// encryption of s.
+func Backend(s Store) Store {
+	for {
+		switch t := s.(type) {
+		case *CachedStore:
+			s = t.backend
+		case *CompressedStore:
+			s = t.backend
+		case *EncryptedStore:
+			s = t.backend
+		default:
+			return s
+		}
+	}
+}
+
+// EncryptedBackend returns the encrypted store below the cache and
+// compression of s or nil if s isn't encrypted.
+func EncryptedBackend(s Store) *EncryptedStore {
+	for {
+		switch t := s.(type) {
+		case *CachedStore:
+			s = t.backend
+		case *CompressedStore:
+			s = t.backend
+		case *EncryptedStore:
+			return t
+		default:
+			return nil
+		}
+	}
+}
+
+// Factory creates a store from its configuration. path is the bucket part
+// of "<store>/<bucket>".
+type Factory func(cfg *viper.Viper, path string) (Store, error)
+
+var (
+	factoriesMu sync.Mutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a store type available to NewStore and Open. Type names
+// are case insensitive. Register panics if the type is registered twice.
+func Register(typ string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	typ = strings.ToLower(typ)
+	if factory == nil {
+		panic("store: Register factory is nil")
+	}
+	if _, dup := factories[typ]; dup || typ == "replicated" {
+		panic("store: Register called twice for type " + typ)
+	}
+	factories[typ] = factory
+}
+
+// Types returns the names of all registered store types.
+func Types() []string {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	types := []string{"replicated"}
+	for t := range factories {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func init() {
+	Register("s3", NewS3Store)
+	Register("oci", NewOCIStore)
+	Register("http", NewHTTPStore)
+	Register("exec", NewExecStore)
+	Register("fs", newFSStoreFromConfig)
+	Register("sftp", NewSFTPStore)
+}
+
+// maxNesting limits how deep stores made of other stores may be nested.
+const maxNesting = 8
+
+// Open initializes the store named by "<name>/<bucket>" using the
+// configuration of stores, which holds all stores by name. Settings may be
+// overridden by ARTI_STORES__<NAME>__<KEY> environment variables. Stores
+// which consist of other stores, like replicated stores, must be opened
+// this way. Instead of a name a store URL like s3://host/bucket or
+// file:///srv/artifacts may be used, stores may be nil in this case.
+//
+// Settings may reference secrets using "env:<variable>", "file:<path>" or
+// "exec:<command>", which are resolved when the store is opened. The
+// credential-helper setting names a program which is asked for the user
+// and password like the credential helpers of docker.
+func Open(stores *viper.Viper, nameAndPath string) (Store, error) {
+	return open(stores, nameAndPath, 0)
+}
+
+func open(stores *viper.Viper, nameAndPath string, depth int) (Store, error) {
+	if depth > maxNesting {
+		return nil, fmt.Errorf("stores are nested too deep")
+	}
+	cfg, bucket, err := storeConfig(stores, nameAndPath)
+	if err != nil {
+		return nil, err
+	}
+	return newStore(cfg, bucket, stores, depth)
+}
+
+// storeConfig returns the settings and the bucket of nameAndPath with all
+// secrets resolved.
+func storeConfig(stores *viper.Viper, nameAndPath string) (*viper.Viper, string, error) {
+	var cfg *viper.Viper
+	var name, bucket string
+	if IsURL(nameAndPath) {
+		var err error
+		if cfg, bucket, err = configFromURL(stores, nameAndPath); err != nil {
+			return nil, "", err
+		}
+		name = nameAndPath
+	} else {
+		if stores == nil {
+			return nil, "", fmt.Errorf("no stores specified")
+		}
+		np := strings.SplitN(nameAndPath, "/", 2)
+		if len(np) < 2 {
+			np = append(np, "")
+		}
+		name, bucket = np[0], np[1]
+		if cfg = Config(stores, name); cfg == nil {
+			return nil, "", fmt.Errorf("no such store: %s", name)
+		}
+	}
+
+	// secrets are only looked up for stores actually used. Settings of
+	// URLs are taken literally, a URL must not be able to run commands.
+	if !IsURL(nameAndPath) {
+		if err := resolveSecrets(cfg); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := applyCredentialHelper(cfg, name); err != nil {
+		return nil, "", err
+	}
+	return cfg, bucket, nil
+}
+
+// Config returns the settings of the store name including the overrides
+// of the environment, or nil if there is no such store.
+func Config(stores *viper.Viper, name string) *viper.Viper {
+	cfg := stores.Sub(name)
+	if cfg == nil {
+		return nil
+	}
+	cfg.SetEnvPrefix("arti_stores__" + name + "_")
+	cfg.SetEnvKeyReplacer(strings.NewReplacer(".", "__", "-", "_"))
+	cfg.AutomaticEnv()
+	return cfg
+}
+
+// wrapperKeys are the settings of the wrappers newStore puts around stores
+// of any type, they don't belong to the store itself.
+var wrapperKeys = []string{"encryption", "compression", "cache"}
+
 func NewStore(cfg *viper.Viper, path string) (store Store, err error) {
+	return newStore(cfg, path, nil, 0)
+}
+
+func newStore(cfg *viper.Viper, path string, stores *viper.Viper, depth int) (store Store, err error) {
 	t := cfg.GetString("type")
-	switch strings.ToLower(t) {
-	case "s3":
-		store, err = NewS3Store(cfg, path)
-	default:
-		err = fmt.Errorf("unknown store type: %s", t)
+	if strings.ToLower(t) == "replicated" {
+		if stores == nil {
+			return nil, fmt.Errorf("replicated stores need to be opened using Open")
+		}
+		store, err = newReplicatedStoreFromConfig(cfg, path, func(member string) (Store, error) {
+			return open(stores, member, depth+1)
+		})
+	} else {
+		factoriesMu.Lock()
+		factory, found := factories[strings.ToLower(t)]
+		factoriesMu.Unlock()
+		if !found {
+			return nil, fmt.Errorf("unknown store type: %s", t)
+		}
+		store, err = factory(cfg, path)
+	}
+	if err != nil {
+		return
+	}
+	if cfg.Get("encryption") != nil {
+		if store, err = newEncryptedStoreFromConfig(cfg, store); err != nil {
+			return
+		}
+	}
+	if c := cfg.GetString("compression"); c != "" {
+		if store, err = NewCompressedStore(store, c); err != nil {
+			return
+		}
+	}
+	if cfg.Get("cache") != nil {
+		store, err = newCachedStoreFromConfig(cfg, store)
 	}
 	return
 }
+
+// Fetch downloads an artifact like Get does and returns its checksum if the
+// store supports this.
+func Fetch(s Store, artifact Artifact, filename string) (RawInfo, error) {
+	if rs, ok := s.(RawStore); ok {
+		return getVerified(rs.GetRaw, artifact, filename, false)
+	}
+	info := RawInfo{}
+	exists, f, err := s.Has(artifact)
+	if err != nil {
+		return info, err
+	}
+	if !exists {
+		return info, ErrArtifactNotFound
+	}
+	info.Filename = f
+	return info, s.Get(artifact, filename, false)
+}
+
+// getVerified downloads a file using getRaw, decodes it if necessary and
+// verifies its checksum.
+func getVerified(getRaw func(Artifact, string) (RawInfo, error), artifact Artifact, filename string, keepCorrupted bool) (RawInfo, error) {
+	info, err := getRaw(artifact, filename)
+	if err != nil {
+		return info, err
+	}
+	target := filename
+	if target == "" {
+		target = info.Filename
+	}
+	if info.Encoding != "" {
+		if err = decodeFile(target, info.Encoding); err != nil {
+			if !keepCorrupted {
+				os.Remove(target)
+			}
+			return info, err
+		}
+		info.Encoding = ""
+	}
+	return info, verifyCSum(target, info.CSum, keepCorrupted)
+}
+
+// readSecret returns the value of key, <key>-env or <key>-file in that order.
+func readSecret(cfg *viper.Viper, key string) (string, error) {
+	if v := cfg.GetString(key); v != "" {
+		return v, nil
+	}
+	if env := cfg.GetString(key + "-env"); env != "" {
+		return os.Getenv(env), nil
+	}
+	if fn := cfg.GetString(key + "-file"); fn != "" {
+		data, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return "", fmt.Errorf("Error reading %s: %v", key, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,373 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/spf13/viper"
+)
+
+const (
+	ociManifestType = "application/vnd.oci.image.manifest.v1+json"
+	ociArtifactType = "application/vnd.mgit-at.arti.artifact.v1"
+	ociEmptyType    = "application/vnd.oci.empty.v1+json"
+	ociLayerType    = "application/octet-stream"
+
+	ociAnnotationTitle    = "org.opencontainers.image.title"
+	ociAnnotationVersion  = "org.opencontainers.image.version"
+	ociAnnotationChecksum = "at.mgit.arti.checksum"
+	ociAnnotationEncoding = "at.mgit.arti.encoding"
+)
+
+// ociEmpty is the empty JSON object used as config of artifact manifests.
+var ociEmpty = []byte("{}")
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Data        []byte            `json:"data,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// OCIStore keeps every artifact version as artifact manifest in an OCI
+// registry. The repository is <path>/<name>, the tag is the version and the
+// only layer is the file.
+type OCIStore struct {
+	registry string
+	prefix   string
+	client   *ociClient
+}
+
+// ociRepositoryRe matches valid repository names of the distribution
+// specification.
+var ociRepositoryRe = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+
+func NewOCIStore(cfg *viper.Viper, p string) (Store, error) {
+	// registries only accept lower case repository names
+	s := &OCIStore{prefix: strings.ToLower(strings.Trim(p, "/"))}
+	if s.prefix != "" && !ociRepositoryRe.MatchString(s.prefix) {
+		return nil, fmt.Errorf("invalid repository name '%s'", s.prefix)
+	}
+
+	s.registry = cfg.GetString("registry")
+	if s.registry == "" {
+		return nil, fmt.Errorf("no registry specified")
+	}
+	if !strings.Contains(s.registry, "://") {
+		s.registry = "https://" + s.registry
+	}
+	u, err := url.Parse(s.registry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry URL: %v", err)
+	}
+
+	s.client = &ociClient{
+		base:     u,
+		username: cfg.GetString("username"),
+		client:   &http.Client{},
+	}
+	if s.client.password, err = readSecret(cfg, "password"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *OCIStore) Describe() map[string]string {
+	return map[string]string{
+		"type":     "oci",
+		"registry": s.registry,
+		"prefix":   s.prefix,
+	}
+}
+
+func (s *OCIStore) repository(name string) string {
+	return path.Join(s.prefix, strings.ToLower(name))
+}
+
+// ociTag converts a version to a tag, which may not contain '+'.
+func ociTag(v semver.Version) string {
+	return strings.Replace(v.String(), "+", "_", -1)
+}
+
+func ociVersion(tag string) (semver.Version, error) {
+	return semver.ParseTolerant(strings.Replace(tag, "_", "+", -1))
+}
+
+func (s *OCIStore) manifest(artifact Artifact) (m ociManifest, digest string, err error) {
+	data, digest, err := s.client.getManifest(s.repository(artifact.Name), ociTag(artifact.Version), ociManifestType)
+	if err == errOCINotFound {
+		return m, "", ErrArtifactNotFound
+	}
+	if err != nil {
+		return m, "", err
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return m, "", fmt.Errorf("invalid manifest: %v", err)
+	}
+	if m.ArtifactType != ociArtifactType || len(m.Layers) != 1 {
+		return m, "", fmt.Errorf("%s:%s is no arti artifact", s.repository(artifact.Name), ociTag(artifact.Version))
+	}
+	// the title becomes the name of downloaded files, it must not point
+	// outside of the target directory
+	if f := m.info().Filename; f == "." || f == ".." || f == string(filepath.Separator) {
+		return m, "", fmt.Errorf("%s:%s has an invalid title", s.repository(artifact.Name), ociTag(artifact.Version))
+	}
+	if digest == "" {
+		sum := sha256.Sum256(data)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return m, digest, nil
+}
+
+func (m ociManifest) info() RawInfo {
+	layer := m.Layers[0]
+	info := RawInfo{
+		Filename: filepath.Base(layer.Annotations[ociAnnotationTitle]),
+		CSum:     m.Annotations[ociAnnotationChecksum],
+		Encoding: m.Annotations[ociAnnotationEncoding],
+	}
+	if info.CSum == "" {
+		// the digest of the layer is a checksum in the format of arti
+		info.CSum = layer.Digest
+	}
+	return info
+}
+
+func (s *OCIStore) List(name string, versions semver.Range) (list ArtifactList, err error) {
+	list = make(ArtifactList)
+
+	var names []string
+	if name != "" {
+		names = []string{name}
+	} else {
+		repos, err := s.client.list("/v2/_catalog", "repositories")
+		if err != nil {
+			return nil, fmt.Errorf("Error while listing repositories: %v", err)
+		}
+		for _, r := range repos {
+			if s.prefix == "" {
+				names = append(names, r)
+			} else if strings.HasPrefix(r, s.prefix+"/") {
+				names = append(names, strings.TrimPrefix(r, s.prefix+"/"))
+			}
+		}
+	}
+
+	for _, n := range names {
+		tags, err := s.client.list("/v2/"+s.repository(n)+"/tags/list", "tags")
+		if err == errOCINotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error while listing tags: %v", err)
+		}
+		for _, tag := range tags {
+			v, err := ociVersion(tag)
+			if err != nil || (versions != nil && !versions(v)) {
+				continue
+			}
+			m, _, err := s.manifest(Artifact{Name: n, Version: v})
+			if err != nil {
+				// ignoring images which are no artifacts
+				continue
+			}
+			list[n] = append(list[n], ArtifactVersion{Version: v, Filename: m.info().Filename, Filesize: m.Layers[0].Size})
+		}
+	}
+	return list, nil
+}
+
+func (s *OCIStore) Has(artifact Artifact) (bool, string, error) {
+	m, _, err := s.manifest(artifact)
+	if err == ErrArtifactNotFound {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return true, m.info().Filename, nil
+}
+
+func (s *OCIStore) Put(artifact Artifact, filename string) error {
+	csum, err := CalcCSum(filename)
+	if err != nil {
+		return err
+	}
+	return s.PutRaw(artifact, filename, RawInfo{CSum: csum})
+}
+
+func (s *OCIStore) PutRaw(artifact Artifact, filename string, info RawInfo) error {
+	if exists, _, err := s.Has(artifact); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("artifact already exists")
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("Error opening file: %v", err)
+	}
+	digest, err := calcSHA256(filename)
+	if err != nil {
+		return err
+	}
+	digest = CSumAlgoSHA256 + CSumAlgoSeperator + digest
+
+	repository := s.repository(artifact.Name)
+	if !ociRepositoryRe.MatchString(repository) {
+		return fmt.Errorf("invalid repository name '%s'", repository)
+	}
+	basename := filepath.Base(filename)
+	err = s.client.upload(repository, digest, fi.Size(), func() (io.ReadCloser, error) {
+		return os.Open(filename)
+	})
+	if err != nil {
+		return fmt.Errorf("Error uploading file '%s': %v", basename, err)
+	}
+	emptySum := sha256.Sum256(ociEmpty)
+	config := ociDescriptor{MediaType: ociEmptyType, Digest: "sha256:" + hex.EncodeToString(emptySum[:]), Size: int64(len(ociEmpty)), Data: ociEmpty}
+	err = s.client.upload(repository, config.Digest, config.Size, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(ociEmpty)), nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error uploading config: %v", err)
+	}
+
+	m := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestType,
+		ArtifactType:  ociArtifactType,
+		Config:        config,
+		Layers: []ociDescriptor{{
+			MediaType:   ociLayerType,
+			Digest:      digest,
+			Size:        fi.Size(),
+			Annotations: map[string]string{ociAnnotationTitle: basename},
+		}},
+		// the version keeps manifests of identical files distinct, so that
+		// deleting one by digest doesn't remove the other tags too
+		Annotations: map[string]string{
+			ociAnnotationVersion:  artifact.Version.String(),
+			ociAnnotationChecksum: info.CSum,
+		},
+	}
+	if info.Encoding != "" {
+		m.Annotations[ociAnnotationEncoding] = info.Encoding
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err = s.client.putManifest(repository, ociTag(artifact.Version), ociManifestType, data); err != nil {
+		return fmt.Errorf("Error uploading manifest: %v", err)
+	}
+
+	log.Printf("successfully uploaded %d Bytes to '%s:%s'", fi.Size(), repository, ociTag(artifact.Version))
+	log.Printf("%s", info.CSum)
+	return nil
+}
+
+func (s *OCIStore) Get(artifact Artifact, filename string, keepCorrupted bool) error {
+	_, err := getVerified(s.GetRaw, artifact, filename, keepCorrupted)
+	return err
+}
+
+func (s *OCIStore) GetRaw(artifact Artifact, filename string) (RawInfo, error) {
+	m, _, err := s.manifest(artifact)
+	if err != nil {
+		return RawInfo{}, err
+	}
+	info := m.info()
+	if filename == "" {
+		filename = info.Filename
+	}
+
+	layer := m.Layers[0]
+	resp, err := s.client.request("GET", "/v2/"+s.repository(artifact.Name)+"/blobs/"+layer.Digest, nil, nil, http.StatusOK)
+	if err != nil {
+		return info, fmt.Errorf("Error downloading file: %v", err)
+	}
+	defer resp.Body.Close()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return info, fmt.Errorf("Error creating file: %v", err)
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && "sha256:"+hex.EncodeToString(h.Sum(nil)) != layer.Digest {
+		err = fmt.Errorf("digest mismatch of downloaded layer")
+	}
+	if err != nil {
+		os.Remove(filename)
+		return info, fmt.Errorf("Error downloading file: %v", err)
+	}
+	return info, nil
+}
+
+func (s *OCIStore) Stat(artifact Artifact) (RawInfo, error) {
+	m, _, err := s.manifest(artifact)
+	if err != nil {
+		return RawInfo{}, err
+	}
+	return m.info(), nil
+}
+
+// Del deletes the manifest, the registry removes unreferenced blobs during
+// garbage collection.
+func (s *OCIStore) Del(artifact Artifact) error {
+	_, digest, err := s.manifest(artifact)
+	if err == ErrArtifactNotFound {
+		// like the other stores deleting a missing artifact succeeds
+		return nil
+	} else if err != nil {
+		return err
+	}
+	resp, err := s.client.request("DELETE", "/v2/"+s.repository(artifact.Name)+"/manifests/"+digest, nil, nil, http.StatusAccepted, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("Error deleting manifest: %v", err)
+	}
+	resp.Body.Close()
+	return nil
+}
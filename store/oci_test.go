@@ -0,0 +1,208 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/ocitest"
+	"github.com/mgit-at/arti/store/storetest"
+	"github.com/spf13/viper"
+)
+
+func newOCIStore(t *testing.T, url, prefix, username, password string) store.Store {
+	cfg := viper.New()
+	cfg.Set("registry", url)
+	cfg.Set("username", username)
+	cfg.Set("password", password)
+	s, err := store.NewOCIStore(cfg, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestOCIStore(t *testing.T) {
+	reg := ocitest.New()
+	reg.Username, reg.Password = "user", "pass"
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	n := 0
+	storetest.Run(t, func(t *testing.T) store.Store {
+		n++
+		return newOCIStore(t, srv.URL, fmt.Sprintf("arti-%d", n), "user", "pass")
+	})
+}
+
+func TestOCIRepositoryNames(t *testing.T) {
+	srv := httptest.NewServer(ocitest.New())
+	defer srv.Close()
+
+	s := newOCIStore(t, srv.URL, "/Team/Arti/", "", "")
+	if prefix := s.(store.Describer).Describe()["prefix"]; prefix != "team/arti" {
+		t.Errorf("expected prefix team/arti, got %s", prefix)
+	}
+	dir, err := ioutil.TempDir("", "arti-oci-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "app.tar.gz")
+	if err = ioutil.WriteFile(filename, []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := store.MakeArtifact("app", "1.0.0")
+	if err = s.Put(a, filename); err != nil {
+		t.Fatal(err)
+	}
+	upper, _ := store.MakeArtifact("App", "1.0.0")
+	if exists, filename, err := s.Has(upper); err != nil || !exists || filename != "app.tar.gz" {
+		t.Errorf("artifact names are not lower cased: %t %s %v", exists, filename, err)
+	}
+
+	invalid, _ := store.MakeArtifact("my app", "1.0.0")
+	if err = s.Put(invalid, filename); err == nil || !strings.Contains(err.Error(), "invalid repository name") {
+		t.Errorf("expected an invalid repository name, got %v", err)
+	}
+
+	for _, prefix := range []string{"team//arti", "-arti", "arti_", "team/.arti", "arti@example"} {
+		cfg := viper.New()
+		cfg.Set("registry", srv.URL)
+		if _, err := store.NewOCIStore(cfg, prefix); err == nil {
+			t.Errorf("%s: expected an error", prefix)
+		}
+	}
+}
+
+// setOCITitle replaces the title of the layer of a manifest in the registry.
+func setOCITitle(t *testing.T, url, title string) {
+	const mediaType = "application/vnd.oci.image.manifest.v1+json"
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", mediaType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var m map[string]interface{}
+	if err = json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	layer := m["layers"].([]interface{})[0].(map[string]interface{})
+	layer["annotations"].(map[string]interface{})["org.opencontainers.image.title"] = title
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest("PUT", url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mediaType)
+	put, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put.Body.Close()
+	if put.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status %d replacing the manifest", put.StatusCode)
+	}
+}
+
+func TestOCIStoreTitle(t *testing.T) {
+	srv := httptest.NewServer(ocitest.New())
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "arti-oci-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "app.tar.gz")
+	if err = ioutil.WriteFile(filename, []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := newOCIStore(t, srv.URL, "arti", "", "")
+	a, _ := store.MakeArtifact("app", "1.0.0")
+	if err = s.Put(a, filename); err != nil {
+		t.Fatal(err)
+	}
+	rs := s.(store.RawStore)
+	target := filepath.Join(dir, "target")
+	if err = os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(target); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	manifest := srv.URL + "/v2/arti/app/manifests/1.0.0"
+	setOCITitle(t, manifest, "../escaped.tar.gz")
+	info, err := rs.GetRaw(a, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Filename != "escaped.tar.gz" {
+		t.Errorf("expected the base name of the title, got %s", info.Filename)
+	}
+	if _, err = os.Stat(filepath.Join(target, "escaped.tar.gz")); err != nil {
+		t.Errorf("file has not been downloaded to the working directory: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "escaped.tar.gz")); !os.IsNotExist(err) {
+		t.Errorf("file has been downloaded outside of the working directory: %v", err)
+	}
+
+	for _, title := range []string{"", ".", "..", "app/..", "/"} {
+		setOCITitle(t, manifest, title)
+		if _, err = rs.GetRaw(a, ""); err == nil || !strings.Contains(err.Error(), "invalid title") {
+			t.Errorf("title %q: expected an invalid title, got %v", title, err)
+		}
+		if _, _, err = s.Has(a); err == nil {
+			t.Errorf("title %q: expected an error", title)
+		}
+	}
+}
+
+func TestOCIStoreUnauthorized(t *testing.T) {
+	reg := ocitest.New()
+	reg.Username, reg.Password = "user", "pass"
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	s := newOCIStore(t, srv.URL, "arti", "", "")
+	a, _ := store.MakeArtifact("app", "1.0.0")
+	if _, _, err := s.Has(a); err == nil {
+		t.Fatal("expected an error without credentials")
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var errOCINotFound = errors.New("not found")
+
+// ociClient speaks the HTTP API of an OCI distribution registry.
+type ociClient struct {
+	base     *url.URL
+	username string
+	password string
+	client   *http.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+type ociError struct {
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func ociResponseError(resp *http.Response) error {
+	var e ociError
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &e) == nil && len(e.Errors) > 0 {
+		return fmt.Errorf("registry error: %s: %s", e.Errors[0].Code, e.Errors[0].Message)
+	}
+	return fmt.Errorf("registry error: %s", resp.Status)
+}
+
+func (c *ociClient) url(p string, query url.Values) string {
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (c *ociClient) authorize(req *http.Request) {
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// do sends the request created by newReq. If the registry asks for a bearer
+// token, a token is fetched and the request is sent again.
+func (c *ociClient) do(newReq func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		c.authorize(req)
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+			return nil, fmt.Errorf("registry error: %s", resp.Status)
+		}
+		if err := c.fetchToken(challenge[len("bearer "):]); err != nil {
+			return nil, err
+		}
+	}
+}
+
+var challengeParamRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// fetchToken requests a token using the parameters of a bearer challenge.
+func (c *ociClient) fetchToken(params string) error {
+	p := make(map[string]string)
+	for _, m := range challengeParamRe.FindAllStringSubmatch(params, -1) {
+		p[m[1]] = m[2]
+	}
+	if p["realm"] == "" {
+		return errors.New("registry requested token authentication without realm")
+	}
+	u, err := url.Parse(p["realm"])
+	if err != nil {
+		return fmt.Errorf("invalid token realm: %v", err)
+	}
+	q := u.Query()
+	for _, k := range []string{"service", "scope"} {
+		if p[k] != "" {
+			q.Set(k, p[k])
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error fetching registry token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Error fetching registry token: %s", resp.Status)
+	}
+	var t struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return fmt.Errorf("Error fetching registry token: %v", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token = t.Token; c.token == "" {
+		c.token = t.AccessToken
+	}
+	return nil
+}
+
+// request sends a request without body and checks the status code. A 404
+// response returns errOCINotFound.
+func (c *ociClient) request(method, p string, query url.Values, header http.Header, expected ...int) (*http.Response, error) {
+	resp, err := c.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(method, c.url(p, query), nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checkOCIResponse(resp, expected...)
+}
+
+func checkOCIResponse(resp *http.Response, expected ...int) (*http.Response, error) {
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errOCINotFound
+	}
+	return nil, ociResponseError(resp)
+}
+
+// upload pushes a blob unless the registry already has it.
+func (c *ociClient) upload(repository, digest string, size int64, open func() (io.ReadCloser, error)) error {
+	resp, err := c.request("HEAD", "/v2/"+repository+"/blobs/"+digest, nil, nil, http.StatusOK)
+	if err == nil {
+		resp.Body.Close()
+		return nil
+	}
+	if err != errOCINotFound {
+		return err
+	}
+
+	resp, err = c.request("POST", "/v2/"+repository+"/blobs/uploads/", nil, nil, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	loc, err := resp.Location()
+	if err != nil {
+		return fmt.Errorf("registry returned no upload location: %v", err)
+	}
+	q := loc.Query()
+	q.Set("digest", digest)
+	loc.RawQuery = q.Encode()
+
+	resp, err = c.do(func() (*http.Request, error) {
+		body, err := open()
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest("PUT", loc.String(), body)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	if resp, err = checkOCIResponse(resp, http.StatusCreated); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *ociClient) putManifest(repository, reference, mediaType string, data []byte) error {
+	resp, err := c.do(func() (*http.Request, error) {
+		req, err := http.NewRequest("PUT", c.url("/v2/"+repository+"/manifests/"+reference, nil), bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", mediaType)
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	if resp, err = checkOCIResponse(resp, http.StatusCreated); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// getManifest returns the manifest and its digest.
+func (c *ociClient) getManifest(repository, reference, mediaType string) ([]byte, string, error) {
+	resp, err := c.request("GET", "/v2/"+repository+"/manifests/"+reference, nil, http.Header{"Accept": {mediaType}}, http.StatusOK)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+var linkNextRe = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// list fetches a paginated list like tags or the catalog.
+func (c *ociClient) list(p, field string) ([]string, error) {
+	var all []string
+	next := c.url(p, nil)
+	for next != "" {
+		u := next
+		resp, err := c.do(func() (*http.Request, error) {
+			return http.NewRequest("GET", u, nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = checkOCIResponse(resp, http.StatusOK); err != nil {
+			return nil, err
+		}
+		var page map[string]json.RawMessage
+		var values []string
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err == nil && page[field] != nil {
+			err = json.Unmarshal(page[field], &values)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid registry response: %v", err)
+		}
+		all = append(all, values...)
+
+		next = ""
+		if m := linkNextRe.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			ref, err := url.Parse(m[1])
+			if err != nil {
+				return nil, err
+			}
+			next = resp.Request.URL.ResolveReference(ref).String()
+		}
+	}
+	return all, nil
+}
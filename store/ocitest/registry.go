@@ -0,0 +1,262 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ocitest provides an in-process stand-in for an OCI distribution
+// registry (like registry:2) to test the oci store against.
+package ocitest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry keeps blobs and manifests in memory. It implements the parts of
+// the distribution API used by arti: blob uploads, manifests, tag listing
+// and the catalog.
+type Registry struct {
+	// Username and Password enable basic authentication if set.
+	Username string
+	Password string
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	uploads   map[string][]byte
+	manifests map[string]map[string]manifest
+}
+
+type manifest struct {
+	mediaType string
+	data      []byte
+}
+
+func New() *Registry {
+	return &Registry{
+		blobs:     make(map[string][]byte),
+		uploads:   make(map[string][]byte),
+		manifests: make(map[string]map[string]manifest),
+	}
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"errors":[{"code":%q,"message":%q}]}`, code, message)
+}
+
+var nameRe = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if reg.Username != "" {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != reg.Username || p != reg.Password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ocitest"`)
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+			return
+		}
+	}
+
+	p := r.URL.Path
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	switch {
+	case p == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case p == "/v2/_catalog":
+		reg.catalog(w)
+	case strings.HasSuffix(p, "/tags/list"):
+		reg.tags(w, strings.TrimSuffix(strings.TrimPrefix(p, "/v2/"), "/tags/list"))
+	case strings.Contains(p, "/blobs/uploads/"):
+		i := strings.Index(p, "/blobs/uploads/")
+		name := strings.TrimPrefix(p[:i], "/v2/")
+		if !nameRe.MatchString(name) {
+			writeError(w, http.StatusBadRequest, "NAME_INVALID", "invalid repository name")
+			return
+		}
+		reg.upload(w, r, name, p[i+len("/blobs/uploads/"):])
+	case strings.Contains(p, "/blobs/"):
+		reg.blob(w, r, p[strings.Index(p, "/blobs/")+len("/blobs/"):])
+	case strings.Contains(p, "/manifests/"):
+		i := strings.Index(p, "/manifests/")
+		name := strings.TrimPrefix(p[:i], "/v2/")
+		if !nameRe.MatchString(name) {
+			writeError(w, http.StatusBadRequest, "NAME_INVALID", "invalid repository name")
+			return
+		}
+		reg.manifest(w, r, name, p[i+len("/manifests/"):])
+	default:
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "not found")
+	}
+}
+
+func (reg *Registry) catalog(w http.ResponseWriter) {
+	repos := []string{}
+	for name := range reg.manifests {
+		repos = append(repos, name)
+	}
+	sort.Strings(repos)
+	json.NewEncoder(w).Encode(map[string][]string{"repositories": repos})
+}
+
+func (reg *Registry) tags(w http.ResponseWriter, name string) {
+	refs, ok := reg.manifests[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository not known")
+		return
+	}
+	tags := []string{}
+	for ref := range refs {
+		if !strings.HasPrefix(ref, "sha256:") {
+			tags = append(tags, ref)
+		}
+	}
+	sort.Strings(tags)
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "tags": tags})
+}
+
+func (reg *Registry) upload(w http.ResponseWriter, r *http.Request, name, id string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		buf := make([]byte, 16)
+		rand.Read(buf)
+		id = hex.EncodeToString(buf)
+		reg.uploads[id] = body
+		w.Header().Set("Location", "/v2/"+name+"/blobs/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case "PATCH", "PUT":
+		data, ok := reg.uploads[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "upload not known")
+			return
+		}
+		data = append(data, body...)
+		if r.Method == "PATCH" {
+			reg.uploads[id] = data
+			w.Header().Set("Location", r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		delete(reg.uploads, id)
+		if d := r.URL.Query().Get("digest"); d != digest(data) {
+			writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "digest mismatch")
+			return
+		}
+		reg.blobs[digest(data)] = data
+		w.Header().Set("Docker-Content-Digest", digest(data))
+		w.WriteHeader(http.StatusCreated)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "method not allowed")
+	}
+}
+
+func (reg *Registry) blob(w http.ResponseWriter, r *http.Request, d string) {
+	data, ok := reg.blobs[d]
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob not known")
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+		w.Header().Set("Docker-Content-Digest", d)
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "method not allowed")
+	}
+}
+
+func (reg *Registry) manifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	refs := reg.manifests[name]
+	switch r.Method {
+	case "PUT":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		var m struct {
+			Config struct{ Digest string }   `json:"config"`
+			Layers []struct{ Digest string } `json:"layers"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		for _, d := range append(m.Layers, m.Config) {
+			if _, ok := reg.blobs[d.Digest]; !ok {
+				writeError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", d.Digest)
+				return
+			}
+		}
+		if refs == nil {
+			refs = make(map[string]manifest)
+			reg.manifests[name] = refs
+		}
+		mf := manifest{mediaType: r.Header.Get("Content-Type"), data: data}
+		refs[ref] = mf
+		refs[digest(data)] = mf
+		w.Header().Set("Docker-Content-Digest", digest(data))
+		w.WriteHeader(http.StatusCreated)
+	case "GET", "HEAD":
+		mf, ok := refs[ref]
+		if !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest not known")
+			return
+		}
+		w.Header().Set("Content-Type", mf.mediaType)
+		w.Header().Set("Docker-Content-Digest", digest(mf.data))
+		if r.Method == "GET" {
+			w.Write(mf.data)
+		}
+	case "DELETE":
+		if !strings.HasPrefix(ref, "sha256:") {
+			writeError(w, http.StatusBadRequest, "UNSUPPORTED", "manifests must be deleted by digest")
+			return
+		}
+		if _, ok := refs[ref]; !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest not known")
+			return
+		}
+		for k, mf := range refs {
+			if digest(mf.data) == ref {
+				delete(refs, k)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "method not allowed")
+	}
+}
@@ -0,0 +1,454 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/spf13/viper"
+)
+
+// ReplicaMember is a store which is part of a ReplicatedStore.
+type ReplicaMember struct {
+	Name  string
+	Store Store
+}
+
+// ReplicatedStore keeps every artifact in a number of member stores. Uploads
+// go to all members and succeed if at least quorum members hold a verified
+// copy afterwards. Downloads are served by the first member which is able
+// to deliver a valid copy.
+type ReplicatedStore struct {
+	members []ReplicaMember
+	quorum  int
+}
+
+// NewReplicatedStore returns a store replicating to members. If quorum is 0
+// all members need to succeed.
+func NewReplicatedStore(members []ReplicaMember, quorum int) (*ReplicatedStore, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("no members specified")
+	}
+	if quorum == 0 {
+		quorum = len(members)
+	}
+	if quorum < 0 || quorum > len(members) {
+		return nil, fmt.Errorf("invalid quorum %d for %d members", quorum, len(members))
+	}
+	return &ReplicatedStore{members: members, quorum: quorum}, nil
+}
+
+// newReplicatedStoreFromConfig opens the members using open. Members
+// without a bucket use the bucket of the replicated store.
+func newReplicatedStoreFromConfig(cfg *viper.Viper, path string, open func(string) (Store, error)) (Store, error) {
+	var members []ReplicaMember
+	for _, m := range cfg.GetStringSlice("members") {
+		if !strings.Contains(m, "/") && path != "" {
+			m += "/" + path
+		}
+		s, err := open(m)
+		if err != nil {
+			return nil, fmt.Errorf("member %s: %v", m, err)
+		}
+		members = append(members, ReplicaMember{Name: m, Store: s})
+	}
+	return NewReplicatedStore(members, cfg.GetInt("quorum"))
+}
+
+func (s *ReplicatedStore) Describe() map[string]string {
+	names := []string{}
+	for _, m := range s.members {
+		names = append(names, m.Name)
+	}
+	return map[string]string{
+		"type":    "replicated",
+		"members": strings.Join(names, ", "),
+		"quorum":  fmt.Sprintf("%d of %d", s.quorum, len(s.members)),
+	}
+}
+
+// memberErrors collects the errors of the members.
+type memberErrors []string
+
+func (e *memberErrors) add(m ReplicaMember, err error) {
+	*e = append(*e, fmt.Sprintf("%s: %v", m.Name, err))
+}
+
+func (e memberErrors) String() string {
+	return strings.Join(e, "; ")
+}
+
+// List merges the lists of all members. Versions which are missing on some
+// members or differ between them are reported as warnings.
+func (s *ReplicatedStore) List(name string, versions semver.Range) (ArtifactList, error) {
+	type entry struct {
+		version ArtifactVersion
+		members []string
+	}
+	merged := make(map[string]map[string]*entry)
+	var errs memberErrors
+	listed := 0
+	for _, m := range s.members {
+		list, err := m.Store.List(name, versions)
+		if err != nil {
+			errs.add(m, err)
+			continue
+		}
+		listed++
+		for n, vs := range list {
+			if merged[n] == nil {
+				merged[n] = make(map[string]*entry)
+			}
+			for _, v := range vs {
+				e, found := merged[n][v.Version.String()]
+				if !found {
+					e = &entry{version: v}
+					merged[n][v.Version.String()] = e
+				} else if e.version.Filename != v.Filename || e.version.Filesize != v.Filesize {
+					log.Printf("warning: '%s %s' differs between %s (%s, %d Bytes) and %s (%s, %d Bytes)",
+						n, v.Version, e.members[0], e.version.Filename, e.version.Filesize,
+						m.Name, v.Filename, v.Filesize)
+				}
+				e.members = append(e.members, m.Name)
+			}
+		}
+	}
+	if listed == 0 {
+		return nil, fmt.Errorf("Error while listing artifacts: %s", errs)
+	}
+	for _, e := range errs {
+		log.Printf("warning: unable to list %s", e)
+	}
+
+	result := make(ArtifactList)
+	for n, vs := range merged {
+		for _, e := range vs {
+			if len(e.members) < listed {
+				log.Printf("warning: '%s %s' is only present on %s", n, e.version.Version, strings.Join(e.members, ", "))
+			}
+			result[n] = append(result[n], e.version)
+		}
+		sort.Sort(result[n])
+	}
+	return result, nil
+}
+
+// Has reports whether any member holds the artifact.
+func (s *ReplicatedStore) Has(artifact Artifact) (bool, string, error) {
+	var errs memberErrors
+	for _, m := range s.members {
+		exists, filename, err := m.Store.Has(artifact)
+		if err != nil {
+			errs.add(m, err)
+			continue
+		}
+		if exists {
+			return true, filename, nil
+		}
+	}
+	if len(errs) == len(s.members) {
+		return false, "", fmt.Errorf("Error while looking up artifact: %s", errs)
+	}
+	return false, "", nil
+}
+
+func (s *ReplicatedStore) Put(artifact Artifact, filename string) error {
+	csum, err := CalcCSum(filename)
+	if err != nil {
+		return fmt.Errorf("Error calculating checksum of '%s': %v", filepath.Base(filename), err)
+	}
+	return s.put(artifact, filename, RawInfo{CSum: csum}, false)
+}
+
+func (s *ReplicatedStore) PutRaw(artifact Artifact, filename string, info RawInfo) error {
+	return s.put(artifact, filename, info, true)
+}
+
+// put uploads the file to all members which don't have the artifact yet and
+// verifies the checksum on every member. This way running put again after
+// a partial failure completes the replication.
+func (s *ReplicatedStore) put(artifact Artifact, filename string, info RawInfo, raw bool) error {
+	missing := []ReplicaMember{}
+	present := []ReplicaMember{}
+	var errs memberErrors
+	for _, m := range s.members {
+		exists, _, err := m.Store.Has(artifact)
+		switch {
+		case err != nil:
+			errs.add(m, err)
+		case exists:
+			present = append(present, m)
+		default:
+			missing = append(missing, m)
+		}
+	}
+	if len(missing) == 0 && len(errs) == 0 {
+		return fmt.Errorf("artifact already exists")
+	}
+
+	ok := 0
+	for _, m := range present {
+		if err := s.verify(m, artifact, info, raw); err != nil {
+			errs.add(m, fmt.Errorf("existing copy is invalid: %v", err))
+			continue
+		}
+		ok++
+	}
+	for _, m := range missing {
+		var err error
+		if raw {
+			rs, isRaw := m.Store.(RawStore)
+			if !isRaw {
+				errs.add(m, fmt.Errorf("store does not support raw uploads"))
+				continue
+			}
+			err = rs.PutRaw(artifact, filename, info)
+		} else {
+			err = m.Store.Put(artifact, filename)
+		}
+		if err == nil {
+			err = s.verify(m, artifact, info, raw)
+		}
+		if err != nil {
+			errs.add(m, err)
+			continue
+		}
+		ok++
+	}
+
+	if ok < s.quorum {
+		return fmt.Errorf("artifact stored on %d of %d members, %d needed: %s", ok, len(s.members), s.quorum, errs)
+	}
+	for _, e := range errs {
+		log.Printf("warning: replication failed: %s", e)
+	}
+	return nil
+}
+
+// verify checks that the member holds a copy with the checksum of info.
+// The encoding is only compared for raw uploads, as members may compress
+// files themselves. Members which don't report checksums are checked by
+// downloading the file.
+func (s *ReplicatedStore) verify(m ReplicaMember, artifact Artifact, info RawInfo, raw bool) error {
+	if rs, ok := m.Store.(RawStore); ok {
+		stored, err := rs.Stat(artifact)
+		if err != nil {
+			return err
+		}
+		if stored.CSum != info.CSum {
+			return fmt.Errorf("checksum mismatch: %s, expected %s", stored.CSum, info.CSum)
+		}
+		if raw && stored.Encoding != info.Encoding {
+			return fmt.Errorf("encoding mismatch: '%s', expected '%s'", stored.Encoding, info.Encoding)
+		}
+		return nil
+	}
+
+	tmp, err := ioutil.TempDir("", "arti-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	target := filepath.Join(tmp, "file")
+	if err = m.Store.Get(artifact, target, false); err != nil {
+		return err
+	}
+	if valid, err := CheckCSum(target, info.CSum); err != nil {
+		return err
+	} else if !valid {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
+
+// Get downloads the artifact from the first member which delivers a valid
+// copy.
+func (s *ReplicatedStore) Get(artifact Artifact, filename string, keepCorrupted bool) error {
+	var errs memberErrors
+	for _, m := range s.members {
+		err := m.Store.Get(artifact, filename, keepCorrupted)
+		if err == nil {
+			return nil
+		}
+		if err != ErrArtifactNotFound {
+			errs.add(m, err)
+		}
+	}
+	if len(errs) == 0 {
+		return ErrArtifactNotFound
+	}
+	return fmt.Errorf("Error fetching artifact: %s", errs)
+}
+
+// raw runs fn for the first member able to answer it.
+func (s *ReplicatedStore) raw(fn func(rs RawStore) (RawInfo, error)) (RawInfo, error) {
+	var errs memberErrors
+	for _, m := range s.members {
+		rs, ok := m.Store.(RawStore)
+		if !ok {
+			continue
+		}
+		info, err := fn(rs)
+		if err == nil {
+			return info, nil
+		}
+		if err != ErrArtifactNotFound {
+			errs.add(m, err)
+		}
+	}
+	if len(errs) == 0 {
+		return RawInfo{}, ErrArtifactNotFound
+	}
+	return RawInfo{}, fmt.Errorf("Error fetching artifact: %s", errs)
+}
+
+func (s *ReplicatedStore) GetRaw(artifact Artifact, filename string) (RawInfo, error) {
+	return s.raw(func(rs RawStore) (RawInfo, error) { return rs.GetRaw(artifact, filename) })
+}
+
+func (s *ReplicatedStore) Stat(artifact Artifact) (RawInfo, error) {
+	return s.raw(func(rs RawStore) (RawInfo, error) { return rs.Stat(artifact) })
+}
+
+// Del removes the artifact from all members.
+func (s *ReplicatedStore) Del(artifact Artifact) error {
+	var errs memberErrors
+	for _, m := range s.members {
+		if err := m.Store.Del(artifact); err != nil {
+			errs.add(m, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Error deleting artifact: %s", errs)
+	}
+	return nil
+}
+
+// objectStores returns the members which are able to keep objects.
+func (s *ReplicatedStore) objectStores() ([]ReplicaMember, error) {
+	members := []ReplicaMember{}
+	for _, m := range s.members {
+		if _, ok := m.Store.(ObjectStore); ok {
+			members = append(members, m)
+		}
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("no member supports objects")
+	}
+	return members, nil
+}
+
+func (s *ReplicatedStore) PutObject(key string, r io.Reader) error {
+	members, err := s.objectStores()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("Error uploading '%s': %v", key, err)
+	}
+	var errs memberErrors
+	for _, m := range members {
+		if err := m.Store.(ObjectStore).PutObject(key, bytes.NewReader(data)); err != nil {
+			errs.add(m, err)
+		}
+	}
+	if len(members)-len(errs) < s.quorum {
+		return fmt.Errorf("Error uploading '%s': %s", key, errs)
+	}
+	for _, e := range errs {
+		log.Printf("warning: replication failed: %s", e)
+	}
+	return nil
+}
+
+func (s *ReplicatedStore) GetObject(key string) (io.ReadCloser, error) {
+	members, err := s.objectStores()
+	if err != nil {
+		return nil, err
+	}
+	var errs memberErrors
+	for _, m := range members {
+		r, err := m.Store.(ObjectStore).GetObject(key)
+		if err == nil {
+			return r, nil
+		}
+		if err != ErrObjectNotFound {
+			errs.add(m, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil, ErrObjectNotFound
+	}
+	return nil, fmt.Errorf("Error fetching '%s': %s", key, errs)
+}
+
+func (s *ReplicatedStore) DelObject(key string) error {
+	members, err := s.objectStores()
+	if err != nil {
+		return err
+	}
+	var errs memberErrors
+	for _, m := range members {
+		if err := m.Store.(ObjectStore).DelObject(key); err != nil {
+			errs.add(m, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Error deleting '%s': %s", key, errs)
+	}
+	return nil
+}
+
+// ListObjects merges the objects of all members. The newest version of an
+// object wins.
+func (s *ReplicatedStore) ListObjects(prefix string) ([]ObjectInfo, error) {
+	members, err := s.objectStores()
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]ObjectInfo)
+	var errs memberErrors
+	for _, m := range members {
+		objects, err := m.Store.(ObjectStore).ListObjects(prefix)
+		if err != nil {
+			errs.add(m, err)
+			continue
+		}
+		for _, o := range objects {
+			if prev, found := merged[o.Key]; !found || o.LastModified.After(prev.LastModified) {
+				merged[o.Key] = o
+			}
+		}
+	}
+	if len(errs) == len(members) {
+		return nil, fmt.Errorf("Error while listing objects: %s", errs)
+	}
+	objects := []ObjectInfo{}
+	for _, o := range merged {
+		objects = append(objects, o)
+	}
+	sort.Sort(objectInfos(objects))
+	return objects, nil
+}
@@ -0,0 +1,161 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/storetest"
+	"github.com/spf13/viper"
+)
+
+func newReplicatedStore(t *testing.T, quorum int, members ...store.Store) *store.ReplicatedStore {
+	var rm []store.ReplicaMember
+	for i, m := range members {
+		rm = append(rm, store.ReplicaMember{Name: fmt.Sprintf("member%d", i), Store: m})
+	}
+	s, err := store.NewReplicatedStore(rm, quorum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestReplicatedStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return newReplicatedStore(t, 0, store.NewMemStore(), store.NewMemStore())
+	})
+}
+
+// brokenStore fails every operation.
+type brokenStore struct{ store.Store }
+
+func (brokenStore) Has(store.Artifact) (bool, string, error) {
+	return false, "", os.ErrPermission
+}
+
+func (brokenStore) Put(store.Artifact, string) error {
+	return os.ErrPermission
+}
+
+func TestReplicatedStoreQuorum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arti-replicated-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "app.tar.gz")
+	if err := ioutil.WriteFile(src, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := store.MakeArtifact("app", "1.0.0")
+
+	good := store.NewMemStore()
+	broken := brokenStore{store.NewMemStore()}
+	if err := newReplicatedStore(t, 0, good, broken).Put(a, src); err == nil {
+		t.Fatal("Put without quorum succeeded")
+	}
+	if err := newReplicatedStore(t, 1, good, broken).Put(a, src); err != nil {
+		t.Fatalf("Put with quorum failed: %v", err)
+	}
+
+	// a member which lost the artifact gets it back by putting it again
+	other := store.NewMemStore()
+	s := newReplicatedStore(t, 0, other, good)
+	target := filepath.Join(dir, "download")
+	if err := s.Get(a, target, false); err != nil {
+		t.Fatalf("Get with fallback failed: %v", err)
+	}
+	if err := s.Put(a, src); err != nil {
+		t.Fatalf("Put completing the replication failed: %v", err)
+	}
+	if exists, _, _ := other.Has(a); !exists {
+		t.Fatal("artifact was not replicated")
+	}
+	if err := s.Put(a, src); err == nil {
+		t.Fatal("Put of an existing artifact succeeded")
+	}
+}
+
+func TestReplicatedStoreCompressedMember(t *testing.T) {
+	compressed, err := store.NewCompressedStore(store.NewMemStore(), "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newReplicatedStore(t, 0, store.NewMemStore(), compressed)
+
+	dir, err := ioutil.TempDir("", "arti-replicated-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "app.tar.gz")
+	if err := ioutil.WriteFile(src, []byte(strings.Repeat("content", 100)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := store.MakeArtifact("app", "1.0.0")
+	if err := s.Put(a, src); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if info, err := compressed.Stat(a); err != nil || info.Encoding != "gzip" {
+		t.Fatalf("compressed member: got %+v, %v", info, err)
+	}
+
+	// raw uploads need the encoding to match
+	raw := newReplicatedStore(t, 0, store.NewMemStore(), brokenEncodingStore{store.NewMemStore()})
+	if err := raw.PutRaw(a, src, store.RawInfo{CSum: "sha256:" + strings.Repeat("0", 64)}); err == nil || !strings.Contains(err.Error(), "encoding mismatch") {
+		t.Fatalf("PutRaw with a different encoding: got %v", err)
+	}
+}
+
+// brokenEncodingStore reports a different encoding than the one stored.
+type brokenEncodingStore struct{ *store.FSStore }
+
+func (s brokenEncodingStore) Stat(a store.Artifact) (store.RawInfo, error) {
+	info, err := s.FSStore.Stat(a)
+	info.Encoding = "zstd"
+	return info, err
+}
+
+func TestOpenReplicatedStore(t *testing.T) {
+	stores := viper.New()
+	stores.Set("both", map[string]interface{}{
+		"type":    "replicated",
+		"members": []string{"first", "second/other"},
+		"quorum":  1,
+	})
+	stores.Set("first", map[string]interface{}{"type": "http", "url": "http://first.example.com/"})
+	stores.Set("second", map[string]interface{}{"type": "http", "url": "http://second.example.com/"})
+
+	s, err := store.Open(stores, "both/bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := s.(store.Describer).Describe()
+	if info["members"] != "first/bucket, second/other" || info["quorum"] != "1 of 2" {
+		t.Fatalf("Describe = %v", info)
+	}
+
+	stores.Set("loop", map[string]interface{}{"type": "replicated", "members": []string{"loop"}})
+	if _, err := store.Open(stores, "loop/bucket"); err == nil || !strings.Contains(err.Error(), "nested") {
+		t.Fatalf("Open of a loop returned %v", err)
+	}
+}
@@ -19,7 +19,7 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
+	"net/http"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -35,41 +35,67 @@ var (
 )
 
 type S3Store struct {
-	endpoint        string
-	accessKeyID     string
-	secretAccessKey string
-	useSSL          bool
-	version         int
-	location        string
-	bucket          string
+	endpoint string
+	useSSL   bool
+	version  int
+	location string
+	bucket   string
+
+	creds     *awsCredentialChain
+	temporary bool
+
+	sse         string
+	sseKMSKeyID string
+	sseCKey     []byte
+
+	autoCreate   bool
+	bucketPolicy string
+	versioning   string
+
+	transport *http.Transport
 
 	client *minio.Client
 }
 
 func NewS3Store(cfg *viper.Viper, path string) (Store, error) {
-	s := &S3Store{}
-
 	if !bucketNameRe.MatchString(path) {
 		return nil, fmt.Errorf("'%s' is not a valid bucket name", path)
 	}
-	s.bucket = path
+	s, err := newS3Store(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	return Store(s), nil
+}
+
+// newS3Store creates the store without checking the bucket, which is
+// empty for requests not concerning a bucket.
+func newS3Store(cfg *viper.Viper, bucket string) (*S3Store, error) {
+	s := &S3Store{bucket: bucket}
 
 	s.endpoint = cfg.GetString("endpoint")
-	s.accessKeyID = cfg.GetString("access-key-id")
-	s.secretAccessKey = cfg.GetString("secret-access-key")
 	s.useSSL = !cfg.GetBool("nossl")
 	s.version = cfg.GetInt("version")
 	s.location = cfg.GetString("location")
 
-	var err error
+	s.creds = newAWSCredentialChain(cfg)
+	creds, err := s.creds.get()
+	if err != nil {
+		return nil, err
+	}
+	s.temporary = creds.temporary()
+
 	switch s.version {
 	case 2:
-		s.client, err = minio.NewV2(s.endpoint, s.accessKeyID, s.secretAccessKey, s.useSSL)
+		if s.temporary {
+			return nil, fmt.Errorf("temporary credentials need S3 protocol version 4")
+		}
+		s.client, err = minio.NewV2(s.endpoint, creds.AccessKeyID, creds.SecretAccessKey, s.useSSL)
 	case 0:
 		s.version = 4
 		fallthrough
 	case 4:
-		s.client, err = minio.NewV4(s.endpoint, s.accessKeyID, s.secretAccessKey, s.useSSL)
+		s.client, err = minio.NewV4(s.endpoint, creds.AccessKeyID, creds.SecretAccessKey, s.useSSL)
 	default:
 		err = fmt.Errorf("invalid S3 protocol version %d (only 2 and 4 are supported)", s.version)
 	}
@@ -77,7 +103,66 @@ func NewS3Store(cfg *viper.Viper, path string) (Store, error) {
 		return nil, err
 	}
 
-	return Store(s), nil
+	if err = s.configureSSE(cfg); err != nil {
+		return nil, err
+	}
+	if err = s.configureBucket(cfg); err != nil {
+		return nil, err
+	}
+	if s.transport, err = newS3HTTPTransport(cfg); err != nil {
+		return nil, err
+	}
+	var base http.RoundTripper = http.DefaultTransport
+	if s.transport != nil {
+		base = s.transport
+	}
+	if s.sse != "" || s.temporary {
+		t := &s3Transport{
+			base:        base,
+			bucket:      s.bucket,
+			credentials: s.creds.get,
+		}
+		if s.sse != "" {
+			t.headers = s.sseHeaders
+		}
+		s.client.SetCustomTransport(t)
+	} else if s.transport != nil {
+		s.client.SetCustomTransport(s.transport)
+	}
+
+	return s, nil
+}
+
+func (s *S3Store) Describe() map[string]string {
+	info := map[string]string{
+		"type":              "S3",
+		"endpoint":          s.endpoint,
+		"bucket":            s.bucket,
+		"ssl":               fmt.Sprintf("%t", s.useSSL),
+		"signature-version": fmt.Sprintf("%d", s.version),
+		"location":          s.location,
+		"credentials":       s.creds.source(),
+		"auto-create":       fmt.Sprintf("%t", s.autoCreate),
+	}
+	switch s.sse {
+	case "":
+		info["server-side-encryption"] = "none"
+	case sseS3:
+		info["server-side-encryption"] = "SSE-S3"
+	case sseKMS:
+		info["server-side-encryption"] = "SSE-KMS"
+		if s.sseKMSKeyID != "" {
+			info["server-side-encryption"] += " (key-id: " + s.sseKMSKeyID + ")"
+		}
+	case sseC:
+		info["server-side-encryption"] = "SSE-C"
+	}
+	if s.transport != nil {
+		tc := s.transport.TLSClientConfig
+		info["verify-certificate"] = fmt.Sprintf("%t", !tc.InsecureSkipVerify)
+		info["client-certificate"] = fmt.Sprintf("%t", len(tc.Certificates) > 0)
+	}
+	return info
 }
 
 func (s *S3Store) List(name string, versions semver.Range) (list ArtifactList, err error) {
@@ -96,7 +181,7 @@ func (s *S3Store) List(name string, versions semver.Range) (list ArtifactList, e
 			err = fmt.Errorf("Error while listing objects: %v", obj.Err)
 			return
 		}
-		if strings.HasSuffix(obj.Key, CSumExt) {
+		if IsSidecar(obj.Key) {
 			continue
 		}
 		nv, f := path.Split(obj.Key)
@@ -118,11 +203,18 @@ func (s *S3Store) List(name string, versions semver.Range) (list ArtifactList, e
 }
 
 func (s *S3Store) Has(artifact Artifact) (exists bool, filename string, err error) {
+	filename, _, exists, err = s.find(artifact)
+	return
+}
+
+// find looks up the file of an artifact and the sidecar files present.
+func (s *S3Store) find(artifact Artifact) (filename string, sidecars map[string]bool, exists bool, err error) {
 	doneCh := make(chan struct{})
 	defer close(doneCh)
 
-	hasHash := false
-	p := path.Join(artifact.Name, artifact.Version.String())
+	sidecars = make(map[string]bool)
+	// the trailing slash keeps 1.0.1 from matching 1.0.10
+	p := path.Join(artifact.Name, artifact.Version.String()) + "/"
 	objCh := s.client.ListObjectsV2(s.bucket, p, true, doneCh)
 	for obj := range objCh {
 		if obj.Err != nil {
@@ -130,8 +222,8 @@ func (s *S3Store) Has(artifact Artifact) (exists bool, filename string, err erro
 			return
 		}
 		f := path.Base(obj.Key)
-		if strings.HasSuffix(f, CSumExt) {
-			hasHash = true
+		if IsSidecar(f) {
+			sidecars[path.Ext(f)] = true
 		} else {
 			if filename != "" {
 				err = fmt.Errorf("found more then one file")
@@ -140,13 +232,22 @@ func (s *S3Store) Has(artifact Artifact) (exists bool, filename string, err erro
 			filename = f
 		}
 	}
-	if filename != "" || hasHash {
+	if filename != "" || sidecars[CSumExt] {
 		exists = true
 	}
 	return
 }
 
+// MakeBucket creates the bucket unless it exists already. If auto-create
+// is disabled it only checks whether the bucket exists.
 func (s *S3Store) MakeBucket() (err error) {
+	if !s.autoCreate {
+		exists, err := s.client.BucketExists(s.bucket)
+		if err == nil && !exists {
+			err = fmt.Errorf("bucket %s does not exist and auto-create is disabled", s.bucket)
+		}
+		return err
+	}
 	err = s.client.MakeBucket(s.bucket, s.location)
 	if err != nil {
 		// Check to see if we already own this bucket (which happens if you run this twice)
@@ -155,10 +256,20 @@ func (s *S3Store) MakeBucket() (err error) {
 		}
 		return err
 	}
-	return
+	return s.setupBucket()
 }
 
 func (s *S3Store) Put(artifact Artifact, filename string) error {
+	return s.put(artifact, filename, calcCSum(filename), "")
+}
+
+func (s *S3Store) PutRaw(artifact Artifact, filename string, info RawInfo) error {
+	c := make(chan CSumResult, 1)
+	c <- CSumResult{info.CSum, nil}
+	return s.put(artifact, filename, c, info.Encoding)
+}
+
+func (s *S3Store) put(artifact Artifact, filename string, csumCH <-chan CSumResult, encoding string) error {
 	if err := s.MakeBucket(); err != nil {
 		return err
 	}
@@ -169,8 +280,6 @@ func (s *S3Store) Put(artifact Artifact, filename string) error {
 		return fmt.Errorf("artifact already exists")
 	}
 
-	csumCH := calcCSum(filename)
-
 	basename := filepath.Base(filename)
 	p := path.Join(artifact.Name, artifact.Version.String(), basename)
 	n, err := s.client.FPutObject(s.bucket, p, filename, "application/octet-stream")
@@ -186,6 +295,12 @@ func (s *S3Store) Put(artifact Artifact, filename string) error {
 	if err != nil {
 		return fmt.Errorf("Error uploading hash: %v", err)
 	}
+	if encoding != "" {
+		_, err = s.client.PutObject(s.bucket, p+EncodingExt, strings.NewReader(encoding), "application/octet-stream")
+		if err != nil {
+			return fmt.Errorf("Error uploading encoding: %v", err)
+		}
+	}
 
 	log.Printf("successfully uploaded %d Bytes to '%s:/%s'", n, s.bucket, p)
 	log.Printf("%s", csum.hash)
@@ -193,50 +308,76 @@ func (s *S3Store) Put(artifact Artifact, filename string) error {
 	return nil
 }
 
-func (s *S3Store) Get(artifact Artifact, filename string, keepCorrupted bool) (err error) {
-	var exists bool
-	var f string
-	if exists, f, err = s.Has(artifact); err != nil {
+func (s *S3Store) Get(artifact Artifact, filename string, keepCorrupted bool) error {
+	_, err := getVerified(s.GetRaw, artifact, filename, keepCorrupted)
+	return err
+}
+
+func (s *S3Store) GetRaw(artifact Artifact, filename string) (info RawInfo, err error) {
+	if info, err = s.Stat(artifact); err != nil {
 		return
 	}
-	if !exists {
-		err = fmt.Errorf("artifact not found")
+
+	target := filename
+	if target == "" {
+		target = info.Filename
+	}
+	p := path.Join(artifact.Name, artifact.Version.String(), info.Filename)
+	if err = s.client.FGetObject(s.bucket, p, target); err != nil {
+		err = fmt.Errorf("Error fetching file '%s': %v", info.Filename, err)
 		return
 	}
+	return
+}
 
-	p := path.Join(artifact.Name, artifact.Version.String(), f)
-	var hashObj *minio.Object
-	if hashObj, err = s.client.GetObject(s.bucket, p+CSumExt); err != nil {
-		return fmt.Errorf("Error while fetching hash: %v", err)
+func (s *S3Store) Open(artifact Artifact, filename string) (io.ReadCloser, error) {
+	p := path.Join(artifact.Name, artifact.Version.String(), filename)
+	obj, err := s.client.GetObject(s.bucket, p)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching file '%s': %v", filename, err)
 	}
+	return obj, nil
+}
 
-	var hashValue bytes.Buffer
-	if _, err = io.Copy(&hashValue, hashObj); err != nil {
-		err = fmt.Errorf("Error while fetching hash: %v", err)
+func (s *S3Store) Stat(artifact Artifact) (info RawInfo, err error) {
+	var exists bool
+	var sidecars map[string]bool
+	if info.Filename, sidecars, exists, err = s.find(artifact); err != nil {
 		return
 	}
-
-	target := filename
-	if target == "" {
-		target = f
-	}
-	if err = s.client.FGetObject(s.bucket, p, target); err != nil {
-		err = fmt.Errorf("Error fetching file '%s': %v", f, err)
+	if !exists {
+		err = ErrArtifactNotFound
 		return
 	}
-	var valid bool
-	if valid, err = checkCSum(f, hashValue.String()); err != nil {
+
+	p := path.Join(artifact.Name, artifact.Version.String(), info.Filename)
+	if info.CSum, err = s.getString(p + CSumExt); err != nil {
+		err = fmt.Errorf("Error while fetching hash: %v", err)
 		return
 	}
-	if !valid {
-		if !keepCorrupted {
-			os.Remove(f)
+	if sidecars[EncodingExt] {
+		if info.Encoding, err = s.getString(p + EncodingExt); err != nil {
+			err = fmt.Errorf("Error while fetching encoding: %v", err)
+			return
 		}
-		return fmt.Errorf("hash-sum mismatch!")
 	}
 	return
 }
 
+func (s *S3Store) getString(object string) (string, error) {
+	obj, err := s.client.GetObject(s.bucket, object)
+	if err != nil {
+		return "", err
+	}
+	defer obj.Close()
+
+	var value bytes.Buffer
+	if _, err = io.Copy(&value, obj); err != nil {
+		return "", err
+	}
+	return value.String(), nil
+}
+
 func (s *S3Store) Del(artifact Artifact) (err error) {
 	objectsCh := make(chan string, 10)
 	errorCh := s.client.RemoveObjects(s.bucket, objectsCh)
@@ -244,7 +385,7 @@ func (s *S3Store) Del(artifact Artifact) (err error) {
 	doneCh := make(chan struct{})
 	defer close(doneCh)
 
-	p := path.Join(artifact.Name, artifact.Version.String())
+	p := path.Join(artifact.Name, artifact.Version.String()) + "/"
 	objCh := s.client.ListObjectsV2(s.bucket, p, true, doneCh)
 	for obj := range objCh {
 		if obj.Err != nil {
@@ -0,0 +1,51 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/s3test"
+	"github.com/mgit-at/arti/store/storetest"
+	"github.com/spf13/viper"
+)
+
+func TestS3Store(t *testing.T) {
+	srv := s3test.New()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	n := 0
+	storetest.Run(t, func(t *testing.T) store.Store {
+		n++
+		bucket := fmt.Sprintf("bucket-%d", n)
+		srv.MakeBucket(bucket)
+
+		cfg := viper.New()
+		cfg.Set("endpoint", strings.TrimPrefix(ts.URL, "http://"))
+		cfg.Set("access-key-id", "arti")
+		cfg.Set("secret-access-key", "secret")
+		cfg.Set("nossl", true)
+		s, err := store.NewS3Store(cfg, bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
@@ -0,0 +1,393 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/policy"
+	"github.com/spf13/viper"
+)
+
+// bucketPolicies are the canned policies which may be set for a bucket.
+var bucketPolicies = map[string]policy.BucketPolicy{
+	"private":           policy.BucketPolicyNone,
+	"public-read":       policy.BucketPolicyReadOnly,
+	"public-write":      policy.BucketPolicyWriteOnly,
+	"public-read-write": policy.BucketPolicyReadWrite,
+}
+
+// BucketPolicies returns the names of the supported bucket policies.
+func BucketPolicies() []string {
+	names := []string{}
+	for name := range bucketPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkBucketPolicy(name string) error {
+	if _, ok := bucketPolicies[name]; !ok {
+		return fmt.Errorf("unknown bucket policy '%s' (supported are %s)", name, strings.Join(BucketPolicies(), ", "))
+	}
+	return nil
+}
+
+// configureBucket reads the settings applied when the bucket is created.
+func (s *S3Store) configureBucket(cfg *viper.Viper) error {
+	s.autoCreate = !cfg.IsSet("auto-create") || cfg.GetBool("auto-create")
+	s.bucketPolicy = strings.ToLower(cfg.GetString("bucket-policy"))
+	if s.bucketPolicy != "" {
+		if err := checkBucketPolicy(s.bucketPolicy); err != nil {
+			return err
+		}
+	}
+	if cfg.IsSet("versioning") {
+		s.versioning = "Suspended"
+		if cfg.GetBool("versioning") {
+			s.versioning = "Enabled"
+		}
+		if s.version != 4 {
+			return fmt.Errorf("versioning needs S3 protocol version 4")
+		}
+	}
+	return nil
+}
+
+// setupBucket applies bucket-policy and versioning to a new bucket.
+func (s *S3Store) setupBucket() error {
+	if s.bucketPolicy != "" {
+		if err := s.SetBucketPolicy(s.bucketPolicy); err != nil {
+			return err
+		}
+	}
+	if s.versioning != "" {
+		if err := s.SetBucketVersioning(s.versioning == "Enabled"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListBuckets lists the buckets of the S3 store nameAndPath, which may be
+// a URL. The bucket part is ignored.
+func ListBuckets(stores *viper.Viper, nameAndPath string) ([]BucketInfo, error) {
+	cfg, _, err := storeConfig(stores, nameAndPath)
+	if err != nil {
+		return nil, err
+	}
+	if t := strings.ToLower(cfg.GetString("type")); t != "s3" {
+		return nil, fmt.Errorf("listing buckets is not supported by stores of type %s", t)
+	}
+	s, err := newS3Store(cfg, "")
+	if err != nil {
+		return nil, err
+	}
+	buckets, err := s.client.ListBuckets()
+	if err != nil {
+		return nil, fmt.Errorf("Error listing buckets: %v", err)
+	}
+	list := make([]BucketInfo, 0, len(buckets))
+	for _, b := range buckets {
+		list = append(list, BucketInfo{Name: b.Name, Created: b.CreationDate})
+	}
+	return list, nil
+}
+
+func (s *S3Store) CreateBucket() error {
+	if err := s.client.MakeBucket(s.bucket, s.location); err != nil {
+		if code := minio.ToErrorResponse(err).Code; code == "BucketAlreadyOwnedByYou" || code == "BucketAlreadyExists" {
+			return fmt.Errorf("bucket %s exists already", s.bucket)
+		}
+		return fmt.Errorf("Error creating bucket: %v", err)
+	}
+	return s.setupBucket()
+}
+
+// DeleteBucket removes all objects first if force is set. Removing objects
+// of versioned buckets only adds delete markers, so all versions and delete
+// markers are removed instead. Finding out about versioning needs S3
+// protocol version 4, with version 2 only the current objects are removed.
+func (s *S3Store) DeleteBucket(force bool) error {
+	if force {
+		versioned := false
+		if s.version == 4 {
+			status, err := s.bucketVersioning()
+			if err != nil {
+				return fmt.Errorf("Error fetching versioning: %v", err)
+			}
+			versioned = status != ""
+		}
+		if versioned {
+			if err := s.removeVersions(); err != nil {
+				return err
+			}
+		} else if err := s.removeObjects(); err != nil {
+			return err
+		}
+	}
+	if err := s.client.RemoveBucket(s.bucket); err != nil {
+		if minio.ToErrorResponse(err).Code == "BucketNotEmpty" {
+			if force && s.version != 4 {
+				return fmt.Errorf("bucket %s is not empty, removing versions of objects needs S3 protocol version 4", s.bucket)
+			}
+			return fmt.Errorf("bucket %s is not empty", s.bucket)
+		}
+		return fmt.Errorf("Error deleting bucket: %v", err)
+	}
+	return nil
+}
+
+func (s *S3Store) removeObjects() error {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for obj := range s.client.ListObjectsV2(s.bucket, "", true, doneCh) {
+		if obj.Err != nil {
+			return fmt.Errorf("Error while listing objects: %v", obj.Err)
+		}
+		if err := s.client.RemoveObject(s.bucket, obj.Key); err != nil {
+			return fmt.Errorf("Error deleting %s: %v", obj.Key, err)
+		}
+	}
+	return nil
+}
+
+type objectVersion struct {
+	Key       string
+	VersionId string
+}
+
+type listVersionsResult struct {
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIdMarker string
+	Versions            []objectVersion `xml:"Version"`
+	DeleteMarkers       []objectVersion `xml:"DeleteMarker"`
+}
+
+type deleteObjects struct {
+	XMLName xml.Name `xml:"Delete"`
+	Quiet   bool
+	Objects []objectVersion `xml:"Object"`
+}
+
+type deleteResult struct {
+	Errors []struct {
+		Key       string
+		VersionId string
+		Message   string
+	} `xml:"Error"`
+}
+
+// removeVersions deletes all versions and delete markers of a versioned
+// bucket, a page of the listing at a time.
+func (s *S3Store) removeVersions() error {
+	q := url.Values{"versions": {""}}
+	for {
+		data, err := s.bucketRequest("GET", q.Encode(), nil)
+		if err != nil {
+			return fmt.Errorf("Error while listing object versions: %v", err)
+		}
+		var list listVersionsResult
+		if err = xml.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("Error while listing object versions: %v", err)
+		}
+
+		del := deleteObjects{Quiet: true, Objects: append(list.Versions, list.DeleteMarkers...)}
+		if len(del.Objects) > 0 {
+			body, err := xml.Marshal(del)
+			if err != nil {
+				return err
+			}
+			if data, err = s.bucketRequest("POST", "delete", body); err != nil {
+				return fmt.Errorf("Error deleting object versions: %v", err)
+			}
+			var result deleteResult
+			if err = xml.Unmarshal(data, &result); err != nil {
+				return fmt.Errorf("Error deleting object versions: %v", err)
+			}
+			if len(result.Errors) > 0 {
+				e := result.Errors[0]
+				return fmt.Errorf("Error deleting %s (version %s): %s", e.Key, e.VersionId, e.Message)
+			}
+		}
+
+		if !list.IsTruncated {
+			return nil
+		}
+		q.Set("key-marker", list.NextKeyMarker)
+		q.Set("version-id-marker", list.NextVersionIdMarker)
+	}
+}
+
+func (s *S3Store) BucketInfo() (map[string]string, error) {
+	exists, err := s.client.BucketExists(s.bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s does not exist", s.bucket)
+	}
+
+	info := map[string]string{
+		"bucket":      s.bucket,
+		"auto-create": fmt.Sprintf("%t", s.autoCreate),
+	}
+	if info["location"], err = s.client.GetBucketLocation(s.bucket); err != nil {
+		return nil, fmt.Errorf("Error fetching location: %v", err)
+	}
+
+	p, err := s.client.GetBucketPolicy(s.bucket, "")
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching policy: %v", err)
+	}
+	info["policy"] = string(p)
+	for name, bp := range bucketPolicies {
+		if bp == p {
+			info["policy"] = name
+		}
+	}
+
+	if s.version == 4 {
+		status, err := s.bucketVersioning()
+		if err != nil {
+			return nil, fmt.Errorf("Error fetching versioning: %v", err)
+		}
+		info["versioning"] = strings.ToLower(status)
+		if status == "" {
+			info["versioning"] = "disabled"
+		}
+	}
+	return info, nil
+}
+
+func (s *S3Store) SetBucketPolicy(name string) error {
+	if err := checkBucketPolicy(name); err != nil {
+		return err
+	}
+	if err := s.client.SetBucketPolicy(s.bucket, "", bucketPolicies[name]); err != nil {
+		return fmt.Errorf("Error setting policy: %v", err)
+	}
+	return nil
+}
+
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Status  string   `xml:",omitempty"`
+}
+
+func (s *S3Store) SetBucketVersioning(enabled bool) error {
+	cfg := versioningConfiguration{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/", Status: "Suspended"}
+	if enabled {
+		cfg.Status = "Enabled"
+	}
+	body, err := xml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := s.bucketRequest("PUT", "versioning", body); err != nil {
+		return fmt.Errorf("Error setting versioning: %v", err)
+	}
+	return nil
+}
+
+// bucketVersioning returns Enabled, Suspended or an empty string if
+// versioning has never been enabled.
+func (s *S3Store) bucketVersioning() (string, error) {
+	data, err := s.bucketRequest("GET", "versioning", nil)
+	if err != nil {
+		return "", err
+	}
+	var cfg versioningConfiguration
+	if err := xml.Unmarshal(data, &cfg); err != nil {
+		return "", err
+	}
+	return cfg.Status, nil
+}
+
+// bucketRequest sends a signature v4 request for a subresource of the
+// bucket which minio-go has no API for and returns the response body.
+func (s *S3Store) bucketRequest(method, subresource string, body []byte) ([]byte, error) {
+	if s.version != 4 {
+		return nil, fmt.Errorf("%s needs S3 protocol version 4", subresource)
+	}
+	region, err := s.client.GetBucketLocation(s.bucket)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := s.creds.get()
+	if err != nil {
+		return nil, err
+	}
+
+	u := s.serverURL()
+	u.Path = "/" + s.bucket
+	u.RawQuery = subresource
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", now.Format(sigV4DateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	if len(body) > 0 {
+		md5sum := md5.Sum(body)
+		req.Header.Set("Content-Md5", base64.StdEncoding.EncodeToString(md5sum[:]))
+	}
+	if creds.AccessKeyID != "" {
+		if creds.SessionToken != "" {
+			req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+		}
+		req.Header.Set("Authorization", signV4(req, creds.AccessKeyID, creds.SecretAccessKey, region, now))
+	}
+
+	client := &http.Client{}
+	if s.transport != nil {
+		client.Transport = s.transport
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		errResp := minio.ErrorResponse{}
+		if xml.Unmarshal(data, &errResp) != nil || errResp.Code == "" {
+			return nil, fmt.Errorf("%s", resp.Status)
+		}
+		return nil, errResp
+	}
+	return data, nil
+}
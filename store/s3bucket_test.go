@@ -0,0 +1,217 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/s3test"
+	"github.com/spf13/viper"
+)
+
+func bucketStores(t *testing.T, endpoint string) *viper.Viper {
+	return readStores(t, fmt.Sprintf(`
+manual:
+  type: s3
+  endpoint: %[1]s
+  nossl: true
+  access-key-id: arti
+  secret-access-key: secret
+  auto-create: false
+  bucket-policy: public-read
+  versioning: true
+  encryption:
+    key: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+auto:
+  type: s3
+  endpoint: %[1]s
+  nossl: true
+  access-key-id: arti
+  secret-access-key: secret
+  bucket-policy: public-read
+`, endpoint))
+}
+
+func bucketNames(t *testing.T, stores *viper.Viper, name string) []string {
+	buckets, err := store.ListBuckets(stores, name)
+	if err != nil {
+		t.Fatalf("ListBuckets failed: %v", err)
+	}
+	names := []string{}
+	for _, b := range buckets {
+		names = append(names, b.Name)
+	}
+	return names
+}
+
+func TestS3Buckets(t *testing.T) {
+	defer isolateAWS(t)()
+	srv := s3test.New()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	stores := bucketStores(t, strings.TrimPrefix(ts.URL, "http://"))
+
+	s, err := store.Open(stores, "manual/releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm, ok := store.Backend(s).(store.BucketManager)
+	if !ok {
+		t.Fatalf("%T is no BucketManager", store.Backend(s))
+	}
+
+	if err := putTestArtifact(t, s, "1.0.0"); err == nil || !strings.Contains(err.Error(), "auto-create is disabled") {
+		t.Fatalf("upload without bucket: got %v", err)
+	}
+	if names := bucketNames(t, stores, "manual"); len(names) != 0 {
+		t.Fatalf("buckets created by upload: %v", names)
+	}
+
+	if err := bm.CreateBucket(); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if err := bm.CreateBucket(); err == nil || !strings.Contains(err.Error(), "exists already") {
+		t.Errorf("creating the bucket twice: got %v", err)
+	}
+	if !strings.Contains(srv.Policy("releases"), "s3:GetObject") {
+		t.Errorf("policy not applied: %s", srv.Policy("releases"))
+	}
+	if got := srv.Versioning("releases"); got != "Enabled" {
+		t.Errorf("versioning is %q, want Enabled", got)
+	}
+	info, err := bm.BucketInfo()
+	if err != nil {
+		t.Fatalf("BucketInfo failed: %v", err)
+	}
+	if info["policy"] != "public-read" || info["versioning"] != "enabled" {
+		t.Errorf("got info %v", info)
+	}
+
+	if err := putTestArtifact(t, s, "1.0.0"); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if names := bucketNames(t, stores, "manual"); len(names) != 1 || names[0] != "releases" {
+		t.Errorf("got buckets %v", names)
+	}
+
+	if err := bm.SetBucketPolicy("private"); err != nil {
+		t.Errorf("SetBucketPolicy failed: %v", err)
+	}
+	if err := bm.SetBucketPolicy("public"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+	if err := bm.SetBucketVersioning(false); err != nil {
+		t.Errorf("SetBucketVersioning failed: %v", err)
+	}
+	if info, err = bm.BucketInfo(); err != nil || info["policy"] != "private" || info["versioning"] != "suspended" {
+		t.Errorf("got info %v, %v", info, err)
+	}
+
+	if err := bm.DeleteBucket(false); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Errorf("deleting a bucket which isn't empty: got %v", err)
+	}
+	if err := bm.DeleteBucket(true); err != nil {
+		t.Fatalf("DeleteBucket failed: %v", err)
+	}
+	if names := bucketNames(t, stores, "manual"); len(names) != 0 {
+		t.Errorf("got buckets %v after deleting", names)
+	}
+	if _, err := bm.BucketInfo(); err == nil {
+		t.Error("BucketInfo of a deleted bucket succeeded")
+	}
+}
+
+// TestS3DeleteVersionedBucket checks that force removes all versions and
+// delete markers, more than fit into a single page of the listing.
+func TestS3DeleteVersionedBucket(t *testing.T) {
+	defer isolateAWS(t)()
+	srv := s3test.New()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	stores := bucketStores(t, strings.TrimPrefix(ts.URL, "http://"))
+
+	s, err := store.Open(stores, "manual/releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := store.Backend(s).(store.BucketManager)
+	if err := bm.CreateBucket(); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if got := srv.Versioning("releases"); got != "Enabled" {
+		t.Fatalf("versioning is %q, want Enabled", got)
+	}
+
+	objects := store.Backend(s).(store.ObjectStore)
+	for i := 0; i < 600; i++ {
+		key := fmt.Sprintf("files/%03d", i)
+		for _, data := range []string{"old", "new"} {
+			if err := objects.PutObject(key, strings.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i%2 == 0 {
+			if err := objects.DelObject(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := putTestArtifact(t, s, "1.0.0"); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if n := srv.Versions("releases"); n != 1200 {
+		t.Fatalf("expected 1200 noncurrent versions and delete markers, got %d", n)
+	}
+
+	if err := bm.DeleteBucket(true); err != nil {
+		t.Fatalf("DeleteBucket failed: %v", err)
+	}
+	if names := bucketNames(t, stores, "manual"); len(names) != 0 {
+		t.Errorf("got buckets %v after deleting", names)
+	}
+	if n := srv.Versions("releases"); n != 0 {
+		t.Errorf("%d versions left after deleting", n)
+	}
+}
+
+func TestS3AutoCreate(t *testing.T) {
+	defer isolateAWS(t)()
+	srv := s3test.New()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	stores := bucketStores(t, strings.TrimPrefix(ts.URL, "http://"))
+
+	s, err := store.Open(stores, "auto/releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := putTestArtifact(t, s, "1.0.0"); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if !strings.Contains(srv.Policy("releases"), "s3:GetObject") {
+		t.Errorf("policy not applied: %s", srv.Policy("releases"))
+	}
+	if got := srv.Versioning("releases"); got != "" {
+		t.Errorf("versioning is %q, want it unchanged", got)
+	}
+
+	if _, err := store.ListBuckets(stores, "missing"); err == nil {
+		t.Error("ListBuckets of a missing store succeeded")
+	}
+}
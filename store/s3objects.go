@@ -0,0 +1,71 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/minio/minio-go"
+)
+
+func (s *S3Store) PutObject(key string, r io.Reader) error {
+	if err := s.MakeBucket(); err != nil {
+		return err
+	}
+	if _, err := s.client.PutObject(s.bucket, key, r, "application/octet-stream"); err != nil {
+		return fmt.Errorf("Error uploading '%s': %v", key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) GetObject(key string) (io.ReadCloser, error) {
+	if _, err := s.client.StatObject(s.bucket, key); err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case "NoSuchKey", "NoSuchBucket":
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("Error fetching '%s': %v", key, err)
+	}
+	obj, err := s.client.GetObject(s.bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching '%s': %v", key, err)
+	}
+	return obj, nil
+}
+
+func (s *S3Store) DelObject(key string) error {
+	if err := s.client.RemoveObject(s.bucket, key); err != nil {
+		return fmt.Errorf("Error deleting '%s': %v", key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) ListObjects(prefix string) (objects []ObjectInfo, err error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	objCh := s.client.ListObjectsV2(s.bucket, prefix, true, doneCh)
+	for obj := range objCh {
+		if obj.Err != nil {
+			if minio.ToErrorResponse(obj.Err).Code == "NoSuchBucket" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("Error while listing objects: %v", obj.Err)
+		}
+		objects = append(objects, ObjectInfo{Key: obj.Key, Size: obj.Size, LastModified: obj.LastModified})
+	}
+	return
+}
@@ -0,0 +1,99 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"time"
+)
+
+func (s *S3Store) PresignGet(artifact Artifact, expires time.Duration) (pa PresignedArtifact, err error) {
+	if s.sse == sseC {
+		err = fmt.Errorf("pre-signed URLs are not supported with SSE-C")
+		return
+	}
+
+	info, err := s.Stat(artifact)
+	if err != nil {
+		return
+	}
+	pa.Filename = info.Filename
+	pa.Encoding = info.Encoding
+
+	p := path.Join(artifact.Name, artifact.Version.String(), pa.Filename)
+	params := url.Values{}
+	params.Set("response-content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", pa.Filename))
+	u, err := s.client.PresignedGetObject(s.bucket, p, expires, params)
+	if u, err = s.resign("GET", u, err); err != nil {
+		return
+	}
+	pa.URL = u.String()
+	u, err = s.client.PresignedGetObject(s.bucket, p+CSumExt, expires, nil)
+	if u, err = s.resign("GET", u, err); err != nil {
+		return
+	}
+	pa.ChecksumURL = u.String()
+	return
+}
+
+// PresignPut returns URLs to upload the file and its checksum. The uploader
+// is responsible for uploading both, the checksum file must contain
+// "sha256:" followed by the hex encoded hash of the file.
+func (s *S3Store) PresignPut(artifact Artifact, filename string, expires time.Duration) (pa PresignedArtifact, err error) {
+	if s.sse != "" {
+		err = fmt.Errorf("pre-signed uploads are not supported with server-side encryption")
+		return
+	}
+	if err = s.MakeBucket(); err != nil {
+		return
+	}
+
+	var exists bool
+	if exists, _, err = s.Has(artifact); err != nil {
+		return
+	} else if exists {
+		err = fmt.Errorf("artifact already exists")
+		return
+	}
+
+	pa.Filename = path.Base(filename)
+	p := path.Join(artifact.Name, artifact.Version.String(), pa.Filename)
+	u, err := s.client.PresignedPutObject(s.bucket, p, expires)
+	if u, err = s.resign("PUT", u, err); err != nil {
+		return
+	}
+	pa.URL = u.String()
+	u, err = s.client.PresignedPutObject(s.bucket, p+CSumExt, expires)
+	if u, err = s.resign("PUT", u, err); err != nil {
+		return
+	}
+	pa.ChecksumURL = u.String()
+	return
+}
+
+// resign signs URLs pre-signed by minio-go again if temporary credentials
+// are used, as they need to include the current session token.
+func (s *S3Store) resign(method string, u *url.URL, err error) (*url.URL, error) {
+	if err != nil || !s.temporary {
+		return u, err
+	}
+	creds, err := s.creds.get()
+	if err != nil {
+		return nil, err
+	}
+	return presignV4(method, u, creds)
+}
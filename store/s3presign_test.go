@@ -0,0 +1,179 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgit-at/arti/store"
+)
+
+func httpDo(t *testing.T, method, u, body string) (int, string) {
+	req, err := http.NewRequest(method, u, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func putTestArtifact(t *testing.T, s store.Store, version string) error {
+	dir, err := ioutil.TempDir("", "arti-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "app.tar.gz")
+	if err := ioutil.WriteFile(fn, []byte("app"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := store.MakeArtifact("app", version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Put(a, fn)
+}
+
+func TestS3Presign(t *testing.T) {
+	defer isolateAWS(t)()
+	srv, cfg, done := s3WithCredentials(t, "arti", "")
+	defer done()
+	cfg.Set("access-key-id", "arti")
+	cfg.Set("secret-access-key", "secret")
+	s, err := store.NewS3Store(cfg, "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = putTestArtifact(t, s, "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	ps := s.(store.Presigner)
+
+	a, _ := store.MakeArtifact("app", "1.0.0")
+	pa, err := ps.PresignGet(a, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pa.Filename != "app.tar.gz" || pa.Encoding != "" {
+		t.Errorf("unexpected pre-signed artifact %+v", pa)
+	}
+	u, err := url.Parse(pa.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("X-Amz-Expires") != "86400" || q.Get("X-Amz-Signature") == "" || !strings.Contains(q.Get("response-content-disposition"), `filename="app.tar.gz"`) {
+		t.Errorf("unexpected URL %s", pa.URL)
+	}
+	var csum string
+	if status, body := httpDo(t, "GET", pa.URL, ""); status != http.StatusOK || body != "app" {
+		t.Errorf("GET: unexpected response %d: %q", status, body)
+	}
+	if status, body := httpDo(t, "GET", pa.ChecksumURL, ""); status != http.StatusOK || !strings.HasPrefix(body, "sha256:") {
+		t.Errorf("GET checksum: unexpected response %d: %q", status, body)
+	} else {
+		csum = body
+	}
+	u.RawQuery = ""
+	if status, _ := httpDo(t, "GET", u.String(), ""); status != http.StatusForbidden {
+		t.Errorf("GET without signature: expected status 403, got %d", status)
+	}
+	missing, _ := store.MakeArtifact("app", "9.0.0")
+	if _, err = ps.PresignGet(missing, time.Hour); err != store.ErrArtifactNotFound {
+		t.Errorf("expected %v for a missing artifact, got %v", store.ErrArtifactNotFound, err)
+	}
+
+	// uploads
+	if _, err = ps.PresignPut(a, "app.tar.gz", time.Hour); err == nil {
+		t.Error("expected an error for an existing artifact")
+	}
+	b, _ := store.MakeArtifact("app", "1.1.0")
+	pa, err = ps.PresignPut(b, "dist/app.tar.gz", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pa.Filename != "app.tar.gz" {
+		t.Errorf("unexpected filename %s", pa.Filename)
+	}
+	if status, body := httpDo(t, "PUT", pa.URL, "app"); status != http.StatusOK {
+		t.Errorf("PUT: unexpected response %d: %q", status, body)
+	}
+	if status, body := httpDo(t, "PUT", pa.ChecksumURL, csum); status != http.StatusOK {
+		t.Errorf("PUT checksum: unexpected response %d: %q", status, body)
+	}
+	dir, err := ioutil.TempDir("", "arti-presign-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = s.Get(b, filepath.Join(dir, "app.tar.gz"), false); err != nil {
+		t.Errorf("Get of the uploaded artifact failed: %v", err)
+	}
+
+	// the encoding of compressed files is reported
+	compressed, err := store.NewCompressedStore(s, store.EncodingGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = putTestArtifact(t, compressed, "2.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := store.MakeArtifact("app", "2.0.0")
+	if pa, err = ps.PresignGet(c, time.Hour); err != nil || pa.Encoding != store.EncodingGzip {
+		t.Errorf("unexpected pre-signed artifact %+v, %v", pa, err)
+	}
+
+	// temporary credentials need the session token in the URL
+	srv.AddCredentials("temporary", "token")
+	cfg.Set("access-key-id", "temporary")
+	cfg.Set("session-token", "token")
+	s, err = store.NewS3Store(cfg, "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pa, err = s.(store.Presigner).PresignGet(a, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ = url.Parse(pa.URL); u.Query().Get("X-Amz-Security-Token") != "token" {
+		t.Errorf("URL misses the session token: %s", pa.URL)
+	}
+	if status, body := httpDo(t, "GET", pa.URL, ""); status != http.StatusOK || body != "app" {
+		t.Errorf("GET with session token: unexpected response %d: %q", status, body)
+	}
+
+	cfg.Set("sse", "S3")
+	s, err = store.NewS3Store(cfg, "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.(store.Presigner).PresignPut(b, "app.tar.gz", time.Hour); err == nil {
+		t.Error("expected pre-signed uploads to fail with server-side encryption")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	sseS3  = "s3"
+	sseKMS = "kms"
+	sseC   = "c"
+)
+
+func (s *S3Store) configureSSE(cfg *viper.Viper) error {
+	s.sse = strings.TrimPrefix(strings.ToLower(cfg.GetString("sse")), "sse-")
+	switch s.sse {
+	case "":
+		return nil
+	case sseS3:
+	case sseKMS:
+		s.sseKMSKeyID = cfg.GetString("sse-kms-key-id")
+	case sseC:
+		if !s.useSSL {
+			return fmt.Errorf("SSE-C needs SSL to be enabled")
+		}
+		key, err := readSecret(cfg, "sse-c-key")
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			return fmt.Errorf("SSE-C needs a customer key (sse-c-key, sse-c-key-env or sse-c-key-file)")
+		}
+		if s.sseCKey, err = DecodeKey(key); err != nil {
+			return fmt.Errorf("invalid SSE-C key: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown server-side encryption mode '%s' (supported are S3, KMS and C)", cfg.GetString("sse"))
+	}
+	if s.version != 4 {
+		return fmt.Errorf("server-side encryption needs S3 protocol version 4")
+	}
+	return nil
+}
+
+func (s *S3Store) setSSECHeaders(h http.Header, prefix string) {
+	md5sum := md5.Sum(s.sseCKey)
+	h.Set(prefix+"-Algorithm", "AES256")
+	h.Set(prefix+"-Key", base64.StdEncoding.EncodeToString(s.sseCKey))
+	h.Set(prefix+"-Key-Md5", base64.StdEncoding.EncodeToString(md5sum[:]))
+}
+
+// sseHeaders adds the server-side encryption headers to all requests which
+// create or read an object. S3 only accepts the encryption mode when an
+// object gets created while the customer key of SSE-C must be supplied for
+// every access to the object or its parts.
+func (s *S3Store) sseHeaders(req *http.Request, object bool) {
+	if !object {
+		return
+	}
+	q := req.URL.Query()
+	_, isInit := q["uploads"]
+	_, isPart := q["partNumber"]
+	_, hasUploadID := q["uploadId"]
+
+	create := false
+	switch req.Method {
+	case "PUT":
+		create = !isPart
+	case "POST":
+		if !isInit {
+			return
+		}
+		create = true
+	case "GET", "HEAD":
+		if hasUploadID {
+			return
+		}
+	default:
+		return
+	}
+
+	if s.sse == sseC {
+		s.setSSECHeaders(req.Header, "X-Amz-Server-Side-Encryption-Customer")
+		if req.Header.Get("X-Amz-Copy-Source") != "" {
+			s.setSSECHeaders(req.Header, "X-Amz-Copy-Source-Server-Side-Encryption-Customer")
+		}
+		return
+	}
+	if !create {
+		return
+	}
+	switch s.sse {
+	case sseS3:
+		req.Header.Set("X-Amz-Server-Side-Encryption", "AES256")
+	case sseKMS:
+		req.Header.Set("X-Amz-Server-Side-Encryption", "aws:kms")
+		if s.sseKMSKeyID != "" {
+			req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.sseKMSKeyID)
+		}
+	}
+}
@@ -0,0 +1,668 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package s3test provides an in-process stand-in for an S3 server to test
+// the s3 store against. It implements the parts of the API used by arti
+// and ignores request signatures, only the access key and session token
+// may be checked.
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type object struct {
+	data         []byte
+	etag         string
+	contentType  string
+	lastModified time.Time
+	versionID    string
+}
+
+// version is a noncurrent version or a delete marker of a versioned bucket.
+type version struct {
+	object
+	key          string
+	deleteMarker bool
+}
+
+// Server keeps buckets and objects in memory.
+type Server struct {
+	// Location is reported as the location of all buckets.
+	Location string
+	// ClockSkew shifts the clock of the server. Like S3 the server rejects
+	// requests signed more than 15 minutes off.
+	ClockSkew time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]map[string]object
+	// bucket -> policy document
+	policies map[string][]byte
+	// bucket -> versioning status
+	versioning map[string]string
+	// bucket -> noncurrent versions and delete markers
+	versions    map[string][]version
+	lastVersion int
+	// access key id -> session token
+	credentials map[string]string
+}
+
+func New() *Server {
+	return &Server{
+		buckets:    make(map[string]map[string]object),
+		policies:   make(map[string][]byte),
+		versioning: make(map[string]string),
+		versions:   make(map[string][]version),
+	}
+}
+
+// MakeBucket creates a bucket unless it exists already.
+func (s *Server) MakeBucket(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[name] == nil {
+		s.buckets[name] = make(map[string]object)
+	}
+}
+
+// AddCredentials makes the server reject requests which don't use one of
+// the added access keys together with its session token.
+func (s *Server) AddCredentials(accessKeyID, sessionToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.credentials == nil {
+		s.credentials = make(map[string]string)
+	}
+	s.credentials[accessKeyID] = sessionToken
+}
+
+var credentialRe = regexp.MustCompile(`Credential=([^/]*)/`)
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.credentials == nil {
+		return true
+	}
+	id := strings.SplitN(r.URL.Query().Get("X-Amz-Credential"), "/", 2)[0]
+	token := r.URL.Query().Get("X-Amz-Security-Token")
+	if m := credentialRe.FindStringSubmatch(r.Header.Get("Authorization")); m != nil {
+		id, token = m[1], r.Header.Get("X-Amz-Security-Token")
+	}
+	expected, ok := s.credentials[id]
+	return ok && token == expected
+}
+
+// Keys returns the sorted keys of all objects in a bucket.
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for k := range s.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Versions returns the number of noncurrent versions and delete markers
+// kept in a bucket.
+func (s *Server) Versions(bucket string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.versions[bucket])
+}
+
+// newVersionID returns the version id of a new object, which is "null"
+// unless versioning is enabled for the bucket.
+func (s *Server) newVersionID(bucket string) string {
+	if s.versioning[bucket] != "Enabled" {
+		return "null"
+	}
+	s.lastVersion++
+	return strconv.Itoa(s.lastVersion)
+}
+
+// remove deletes an object. Versioned buckets keep the object as
+// noncurrent version and get a delete marker instead.
+func (s *Server) remove(bucket, key string) {
+	objects := s.buckets[bucket]
+	if s.versioning[bucket] != "" {
+		if obj, ok := objects[key]; ok {
+			s.versions[bucket] = append(s.versions[bucket], version{object: obj, key: key})
+		}
+		marker := object{lastModified: time.Now().UTC().Truncate(time.Second), versionID: s.newVersionID(bucket)}
+		s.versions[bucket] = append(s.versions[bucket], version{object: marker, key: key, deleteMarker: true})
+	}
+	delete(objects, key)
+}
+
+// removeVersion deletes a single version of an object.
+func (s *Server) removeVersion(bucket, key, id string) {
+	if obj, ok := s.buckets[bucket][key]; ok && obj.versionID == id {
+		delete(s.buckets[bucket], key)
+		return
+	}
+	kept := []version{}
+	for _, v := range s.versions[bucket] {
+		if v.key != key || v.versionID != id {
+			kept = append(kept, v)
+		}
+	}
+	s.versions[bucket] = kept
+}
+
+type errorResponse struct {
+	XMLName    xml.Name `xml:"Error"`
+	Code       string
+	Message    string
+	BucketName string `xml:",omitempty"`
+	Key        string `xml:",omitempty"`
+	RequestID  string `xml:"RequestId"`
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, bucket, key string) {
+	if r.Method == "HEAD" {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, errorResponse{Code: code, Message: code, BucketName: bucket, Key: key, RequestID: "s3test"})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	bk := strings.SplitN(p, "/", 2)
+	bucket, key := bk[0], ""
+	if len(bk) == 2 {
+		key = bk[1]
+	}
+
+	now := time.Now().Add(s.ClockSkew)
+	w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+	date := r.Header.Get("X-Amz-Date")
+	if date == "" {
+		date = r.URL.Query().Get("X-Amz-Date")
+	}
+	if t, err := time.Parse("20060102T150405Z", date); err == nil && (now.Sub(t) > 15*time.Minute || t.Sub(now) > 15*time.Minute) {
+		writeError(w, r, http.StatusForbidden, "RequestTimeTooSkewed", bucket, key)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.authorized(r) {
+		writeError(w, r, http.StatusForbidden, "InvalidAccessKeyId", bucket, key)
+		return
+	}
+	if bucket == "" {
+		if r.Method != "GET" {
+			writeError(w, r, http.StatusNotImplemented, "NotImplemented", "", "")
+			return
+		}
+		s.listBuckets(w)
+		return
+	}
+	if key == "" {
+		s.serveBucket(w, r, bucket)
+		return
+	}
+	objects, found := s.buckets[bucket]
+	if !found {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", bucket, key)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		if _, ok := r.URL.Query()["uploadId"]; ok {
+			writeError(w, r, http.StatusNotImplemented, "NotImplemented", bucket, key)
+			return
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "IncompleteBody", bucket, key)
+			return
+		}
+		copySource := r.Header.Get("X-Amz-Copy-Source")
+		if copySource != "" {
+			src, found := s.copySource(copySource)
+			if !found {
+				writeError(w, r, http.StatusNotFound, "NoSuchKey", bucket, key)
+				return
+			}
+			data = src.data
+		}
+		sum := md5.Sum(data)
+		obj := object{
+			data:         data,
+			etag:         hex.EncodeToString(sum[:]),
+			contentType:  r.Header.Get("Content-Type"),
+			lastModified: time.Now().UTC().Truncate(time.Second),
+			versionID:    s.newVersionID(bucket),
+		}
+		if old, ok := objects[key]; ok && s.versioning[bucket] != "" {
+			s.versions[bucket] = append(s.versions[bucket], version{object: old, key: key})
+		}
+		objects[key] = obj
+		w.Header().Set("ETag", `"`+obj.etag+`"`)
+		if copySource != "" {
+			writeXML(w, http.StatusOK, copyObjectResult{ETag: `"` + obj.etag + `"`, LastModified: obj.lastModified.Format("2006-01-02T15:04:05.000Z")})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case "GET", "HEAD":
+		obj, ok := objects[key]
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "NoSuchKey", bucket, key)
+			return
+		}
+		w.Header().Set("ETag", `"`+obj.etag+`"`)
+		if obj.contentType != "" {
+			w.Header().Set("Content-Type", obj.contentType)
+		}
+		http.ServeContent(w, r, key, obj.lastModified, bytes.NewReader(obj.data))
+	case "DELETE":
+		if id := r.URL.Query().Get("versionId"); id != "" {
+			s.removeVersion(bucket, key, id)
+		} else {
+			s.remove(bucket, key)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "POST":
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", bucket, key)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", bucket, key)
+	}
+}
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	ETag         string
+	LastModified string
+}
+
+// copySource returns the object named by the X-Amz-Copy-Source header.
+func (s *Server) copySource(source string) (object, bool) {
+	if p, err := url.PathUnescape(source); err == nil {
+		source = p
+	}
+	bk := strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)
+	if len(bk) != 2 {
+		return object{}, false
+	}
+	obj, found := s.buckets[bk[0]][bk[1]]
+	return obj, found
+}
+
+type locationResponse struct {
+	XMLName  xml.Name `xml:"LocationConstraint"`
+	Location string   `xml:",chardata"`
+}
+
+type bucketsResponse struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Owner   struct {
+		ID          string
+		DisplayName string
+	}
+	Buckets []struct {
+		Name         string
+		CreationDate string
+	} `xml:"Buckets>Bucket"`
+}
+
+func (s *Server) listBuckets(w http.ResponseWriter) {
+	resp := bucketsResponse{}
+	resp.Owner.ID, resp.Owner.DisplayName = "s3test", "s3test"
+	names := []string{}
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		resp.Buckets = append(resp.Buckets, struct {
+			Name         string
+			CreationDate string
+		}{name, time.Now().UTC().Format(time.RFC3339)})
+	}
+	writeXML(w, http.StatusOK, resp)
+}
+
+type listEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type listResponse struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	KeyCount              int
+	MaxKeys               int
+	IsTruncated           bool
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	Contents              []listEntry
+}
+
+type deleteRequest struct {
+	Objects []struct {
+		Key       string
+		VersionId string
+	} `xml:"Object"`
+}
+
+type deleteResponse struct {
+	XMLName xml.Name `xml:"DeleteResult"`
+	Deleted []struct {
+		Key string
+	} `xml:"Deleted"`
+}
+
+// Policy returns the policy document of a bucket.
+func (s *Server) Policy(bucket string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return string(s.policies[bucket])
+}
+
+// Versioning returns the versioning status of a bucket.
+func (s *Server) Versioning(bucket string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.versioning[bucket]
+}
+
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Status  string   `xml:",omitempty"`
+}
+
+func (s *Server) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+	objects, found := s.buckets[bucket]
+
+	switch {
+	case r.Method == "PUT" && len(q) == 0:
+		if found {
+			writeError(w, r, http.StatusConflict, "BucketAlreadyOwnedByYou", bucket, "")
+			return
+		}
+		s.buckets[bucket] = make(map[string]object)
+		w.WriteHeader(http.StatusOK)
+		return
+	case r.Method == "HEAD":
+		if !found {
+			writeError(w, r, http.StatusNotFound, "NoSuchBucket", bucket, "")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if !found {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", bucket, "")
+		return
+	}
+	if _, ok := q["policy"]; ok {
+		s.servePolicy(w, r, bucket)
+		return
+	}
+	if _, ok := q["versioning"]; ok {
+		s.serveVersioning(w, r, bucket)
+		return
+	}
+	if _, ok := q["versions"]; ok && r.Method == "GET" {
+		s.listVersions(w, r, bucket)
+		return
+	}
+	switch {
+	case r.Method == "GET" && q.Get("list-type") == "2":
+		s.list(w, r, bucket, objects)
+	case r.Method == "GET":
+		if _, ok := q["location"]; ok {
+			writeXML(w, http.StatusOK, locationResponse{Location: s.Location})
+			return
+		}
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", bucket, "")
+	case r.Method == "POST":
+		if _, ok := q["delete"]; !ok {
+			writeError(w, r, http.StatusNotImplemented, "NotImplemented", bucket, "")
+			return
+		}
+		var req deleteRequest
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, "MalformedXML", bucket, "")
+			return
+		}
+		var resp deleteResponse
+		for _, o := range req.Objects {
+			if o.VersionId != "" {
+				s.removeVersion(bucket, o.Key, o.VersionId)
+			} else {
+				s.remove(bucket, o.Key)
+			}
+			resp.Deleted = append(resp.Deleted, struct{ Key string }{o.Key})
+		}
+		writeXML(w, http.StatusOK, resp)
+	case r.Method == "DELETE":
+		if len(objects) > 0 || len(s.versions[bucket]) > 0 {
+			writeError(w, r, http.StatusConflict, "BucketNotEmpty", bucket, "")
+			return
+		}
+		delete(s.buckets, bucket)
+		delete(s.policies, bucket)
+		delete(s.versioning, bucket)
+		delete(s.versions, bucket)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", bucket, "")
+	}
+}
+
+func (s *Server) servePolicy(w http.ResponseWriter, r *http.Request, bucket string) {
+	switch r.Method {
+	case "GET":
+		policy, ok := s.policies[bucket]
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "NoSuchBucketPolicy", bucket, "")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(policy)
+	case "PUT":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil || !json.Valid(data) {
+			writeError(w, r, http.StatusBadRequest, "MalformedPolicy", bucket, "")
+			return
+		}
+		s.policies[bucket] = data
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(s.policies, bucket)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", bucket, "")
+	}
+}
+
+func (s *Server) serveVersioning(w http.ResponseWriter, r *http.Request, bucket string) {
+	switch r.Method {
+	case "GET":
+		writeXML(w, http.StatusOK, versioningConfiguration{Status: s.versioning[bucket]})
+	case "PUT":
+		var cfg versioningConfiguration
+		if err := xml.NewDecoder(r.Body).Decode(&cfg); err != nil || (cfg.Status != "Enabled" && cfg.Status != "Suspended") {
+			writeError(w, r, http.StatusBadRequest, "MalformedXML", bucket, "")
+			return
+		}
+		s.versioning[bucket] = cfg.Status
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", bucket, "")
+	}
+}
+
+// list answers ListObjectsV2 requests. The continuation token is the last
+// key of the previous page.
+func (s *Server) list(w http.ResponseWriter, r *http.Request, bucket string, objects map[string]object) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	token := q.Get("continuation-token")
+	maxKeys := 1000
+	if mk, err := strconv.Atoi(q.Get("max-keys")); err == nil && mk > 0 && mk < maxKeys {
+		maxKeys = mk
+	}
+
+	keys := []string{}
+	for k := range objects {
+		if strings.HasPrefix(k, prefix) && k > token {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	resp := listResponse{Name: bucket, Prefix: prefix, MaxKeys: maxKeys, ContinuationToken: token}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		resp.IsTruncated = true
+		resp.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, k := range keys {
+		obj := objects[k]
+		resp.Contents = append(resp.Contents, listEntry{
+			Key:          k,
+			LastModified: obj.lastModified.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         fmt.Sprintf("%q", obj.etag),
+			Size:         int64(len(obj.data)),
+			StorageClass: "STANDARD",
+		})
+	}
+	resp.KeyCount = len(resp.Contents)
+	writeXML(w, http.StatusOK, resp)
+}
+
+type versionEntry struct {
+	Key          string
+	VersionId    string
+	IsLatest     bool
+	LastModified string
+	ETag         string `xml:",omitempty"`
+	Size         int64
+}
+
+type listVersionsResponse struct {
+	XMLName             xml.Name `xml:"ListVersionsResult"`
+	Name                string
+	KeyMarker           string
+	VersionIdMarker     string
+	MaxKeys             int
+	IsTruncated         bool
+	NextKeyMarker       string         `xml:",omitempty"`
+	NextVersionIdMarker string         `xml:",omitempty"`
+	Versions            []versionEntry `xml:"Version"`
+	DeleteMarkers       []versionEntry `xml:"DeleteMarker"`
+}
+
+// versionNumber orders the version ids handed out by newVersionID.
+func versionNumber(id string) int {
+	n, _ := strconv.Atoi(id)
+	return n
+}
+
+// byKeyAndAge sorts versions by key and from newest to oldest.
+type byKeyAndAge []version
+
+func (v byKeyAndAge) Len() int      { return len(v) }
+func (v byKeyAndAge) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v byKeyAndAge) Less(i, j int) bool {
+	if v[i].key != v[j].key {
+		return v[i].key < v[j].key
+	}
+	return versionNumber(v[i].versionID) > versionNumber(v[j].versionID)
+}
+
+// listVersions answers ListObjectVersions requests. Versions are sorted by
+// key and from newest to oldest, pages continue after the markers.
+func (s *Server) listVersions(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+	maxKeys := 1000
+	if mk, err := strconv.Atoi(q.Get("max-keys")); err == nil && mk > 0 && mk < maxKeys {
+		maxKeys = mk
+	}
+	keyMarker, idMarker := q.Get("key-marker"), q.Get("version-id-marker")
+
+	all := []version{}
+	for k, obj := range s.buckets[bucket] {
+		all = append(all, version{object: obj, key: k})
+	}
+	all = append(all, s.versions[bucket]...)
+	sort.Sort(byKeyAndAge(all))
+
+	resp := listVersionsResponse{Name: bucket, KeyMarker: keyMarker, VersionIdMarker: idMarker, MaxKeys: maxKeys}
+	for _, v := range all {
+		if keyMarker != "" && (v.key < keyMarker || v.key == keyMarker && versionNumber(v.versionID) >= versionNumber(idMarker)) {
+			continue
+		}
+		if len(resp.Versions)+len(resp.DeleteMarkers) == maxKeys {
+			resp.IsTruncated = true
+			break
+		}
+		resp.NextKeyMarker, resp.NextVersionIdMarker = v.key, v.versionID
+		e := versionEntry{
+			Key:          v.key,
+			VersionId:    v.versionID,
+			IsLatest:     s.buckets[bucket][v.key].versionID == v.versionID && !v.deleteMarker,
+			LastModified: v.lastModified.Format("2006-01-02T15:04:05.000Z"),
+		}
+		if v.deleteMarker {
+			resp.DeleteMarkers = append(resp.DeleteMarkers, e)
+		} else {
+			e.ETag, e.Size = fmt.Sprintf("%q", v.etag), int64(len(v.data))
+			resp.Versions = append(resp.Versions, e)
+		}
+	}
+	if !resp.IsTruncated {
+		resp.NextKeyMarker, resp.NextVersionIdMarker = "", ""
+	}
+	writeXML(w, http.StatusOK, resp)
+}
@@ -0,0 +1,100 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// s3TLSKeys are the settings handled by newS3HTTPTransport.
+var s3TLSKeys = []string{"ca-file", "client-cert", "client-key", "insecure-skip-verify", "server-name", "proxy"}
+
+// newS3HTTPTransport returns the transport for the TLS and proxy settings
+// of a S3 store, or nil if none are set. ca-file adds certificates to the
+// system pool, client-cert/client-key enable mutual TLS and proxy
+// overrides HTTPS_PROXY and friends.
+func newS3HTTPTransport(cfg *viper.Viper) (*http.Transport, error) {
+	custom := false
+	for _, key := range s3TLSKeys {
+		custom = custom || cfg.IsSet(key)
+	}
+	if !custom {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.GetString("server-name"),
+		InsecureSkipVerify: cfg.GetBool("insecure-skip-verify"),
+	}
+
+	if fn := cfg.GetString("ca-file"); fn != "" {
+		pem, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading ca-file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca-file %s", fn)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	cert, key := cfg.GetString("client-cert"), cfg.GetString("client-key")
+	switch {
+	case cert != "" && key != "":
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	case cert != "" || key != "":
+		return nil, fmt.Errorf("client-cert and client-key must be set together")
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if p := cfg.GetString("proxy"); p != "" {
+		u, err := url.Parse(p)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy: %s", p)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	// the same as http.DefaultTransport apart from TLS and proxy
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}, nil
+}
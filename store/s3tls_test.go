@@ -0,0 +1,226 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/s3test"
+	"github.com/spf13/viper"
+)
+
+// testCA issues certificates for TestS3TLS.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	ca := &testCA{dir: dir}
+	ca.cert, ca.key = ca.issue(t, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "arti test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	ca.pool = x509.NewCertPool()
+	ca.pool.AddCert(ca.cert)
+	return ca
+}
+
+// issue signs tmpl with the CA, self-signed if there is no CA yet, and
+// writes the certificate and key to <name>.crt and <name>.key.
+func (ca *testCA) issue(t *testing.T, name string, tmpl *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := tmpl, key
+	if ca.cert != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(ext, typ string, b []byte) {
+		if err := ioutil.WriteFile(filepath.Join(ca.dir, name+ext), pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".crt", "CERTIFICATE", der)
+	write(".key", "EC PRIVATE KEY", keyDER)
+	return cert, key
+}
+
+func (ca *testCA) path(name string) string {
+	return filepath.Join(ca.dir, name)
+}
+
+func TestS3TLS(t *testing.T) {
+	defer isolateAWS(t)()
+	dir, err := ioutil.TempDir("", "arti-tls-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, dir)
+	ca.issue(t, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "s3.internal"},
+		DNSNames:    []string{"s3.internal"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	ca.issue(t, "client", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "arti"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	serverCert, err := tls.LoadX509KeyPair(ca.path("server.crt"), ca.path("server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := s3test.New()
+	srv.MakeBucket("artifacts")
+	ts := httptest.NewUnstartedServer(srv)
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		problem  string
+	}{
+		{"unknown CA", map[string]interface{}{"server-name": "s3.internal"}, "unknown authority"},
+		{"no client certificate", map[string]interface{}{"ca-file": ca.path("ca.crt"), "server-name": "s3.internal"}, "certificate"},
+		{"wrong server name", map[string]interface{}{
+			"ca-file": ca.path("ca.crt"), "client-cert": ca.path("client.crt"), "client-key": ca.path("client.key"),
+		}, "127.0.0.1"},
+		{"mutual TLS", map[string]interface{}{
+			"ca-file": ca.path("ca.crt"), "client-cert": ca.path("client.crt"), "client-key": ca.path("client.key"),
+			"server-name": "s3.internal",
+		}, ""},
+		{"insecure", map[string]interface{}{
+			"insecure-skip-verify": true, "client-cert": ca.path("client.crt"), "client-key": ca.path("client.key"),
+		}, ""},
+	}
+	for _, test := range tests {
+		cfg := viper.New()
+		cfg.Set("endpoint", strings.TrimPrefix(ts.URL, "https://"))
+		for k, v := range test.settings {
+			cfg.Set(k, v)
+		}
+		s, err := store.NewS3Store(cfg, "artifacts")
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		_, err = s.List("", nil)
+		switch {
+		case test.problem == "" && err != nil:
+			t.Errorf("%s: List failed: %v", test.name, err)
+		case test.problem != "" && err == nil:
+			t.Errorf("%s: List succeeded", test.name)
+		case test.problem != "" && !strings.Contains(err.Error(), test.problem):
+			t.Errorf("%s: got %v, want %q", test.name, err, test.problem)
+		}
+	}
+
+	cfg := viper.New()
+	cfg.Set("endpoint", strings.TrimPrefix(ts.URL, "https://"))
+	cfg.Set("client-cert", ca.path("client.crt"))
+	if _, err := store.NewS3Store(cfg, "artifacts"); err == nil || !strings.Contains(err.Error(), "client-key") {
+		t.Errorf("client-cert without client-key: got %v", err)
+	}
+	cfg.Set("client-key", ca.path("server.key"))
+	if _, err := store.NewS3Store(cfg, "artifacts"); err == nil {
+		t.Error("mismatched client-cert and client-key accepted")
+	}
+	cfg.Set("ca-file", ca.path("ca.key"))
+	if _, err := store.NewS3Store(cfg, "artifacts"); err == nil || !strings.Contains(err.Error(), "no certificates") {
+		t.Errorf("ca-file without certificates: got %v", err)
+	}
+}
+
+func TestS3Proxy(t *testing.T) {
+	defer isolateAWS(t)()
+	srv := s3test.New()
+	srv.MakeBucket("artifacts")
+
+	var proxied int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&proxied, 1)
+		srv.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	// nothing listens on the endpoint, all requests must go to the proxy
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := l.Addr().String()
+	l.Close()
+
+	cfg := viper.New()
+	cfg.Set("endpoint", endpoint)
+	cfg.Set("nossl", true)
+	cfg.Set("proxy", proxy.URL)
+	s, err := store.NewS3Store(cfg, "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.List("", nil); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if atomic.LoadInt32(&proxied) == 0 {
+		t.Error("no requests were sent to the proxy")
+	}
+}
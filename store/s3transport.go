@@ -0,0 +1,225 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4DateFormat = "20060102T150405Z"
+)
+
+var (
+	sigV4CredentialRe = regexp.MustCompile(`Credential=[^/]*/[0-9]{8}/([^/]*)/s3/aws4_request`)
+)
+
+// s3Transport adds headers to the requests of a S3Store for which minio-go
+// has no API and signs the requests again afterwards. As minio-go only knows
+// static credentials the session token of temporary credentials is added
+// here as well.
+type s3Transport struct {
+	base   http.RoundTripper
+	bucket string
+
+	credentials func() (awsCredentials, error)
+
+	headers func(req *http.Request, object bool)
+}
+
+func (t *s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.headers == nil && t.credentials == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	r := *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string{}, v...)
+	}
+	u := *req.URL
+	r.URL = &u
+
+	if t.headers != nil {
+		t.headers(&r, t.isObjectRequest(&r))
+	}
+	if err := t.sign(&r); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(&r)
+}
+
+// isObjectRequest checks whether the request addresses an object of our
+// bucket, rather than the bucket itself, for path and virtual host style
+// requests.
+func (t *s3Transport) isObjectRequest(req *http.Request) bool {
+	p := strings.TrimPrefix(req.URL.Path, "/")
+	if !strings.HasPrefix(req.URL.Host, t.bucket+".") {
+		p = strings.TrimPrefix(strings.TrimPrefix(p, t.bucket), "/")
+	}
+	return p != ""
+}
+
+// sign replaces the signature v4 of the request, reusing the date and
+// region of the original one.
+func (t *s3Transport) sign(req *http.Request) error {
+	auth := req.Header.Get("Authorization")
+	if auth == "" {
+		return nil // anonymous request
+	}
+	m := sigV4CredentialRe.FindStringSubmatch(auth)
+	if m == nil {
+		return fmt.Errorf("unable to sign request: not a signature v4 request")
+	}
+	date, err := time.Parse(sigV4DateFormat, req.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return fmt.Errorf("unable to sign request: %v", err)
+	}
+	creds, err := t.credentials()
+	if err != nil {
+		return err
+	}
+	req.Header.Del("Authorization")
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	req.Header.Set("Authorization", signV4(req, creds.AccessKeyID, creds.SecretAccessKey, m[1], date))
+	return nil
+}
+
+var sigV4IgnoredHeaders = map[string]bool{
+	"Authorization":  true,
+	"Content-Type":   true,
+	"Content-Length": true,
+	"User-Agent":     true,
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// signV4 returns the authorization header of a request in accordance with
+// http://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html.
+// This matches the canonicalization done by minio-go.
+func signV4(req *http.Request, accessKeyID, secretAccessKey, region string, t time.Time) string {
+	var headers []string
+	vals := make(map[string][]string)
+	for k, vv := range req.Header {
+		if sigV4IgnoredHeaders[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		headers = append(headers, strings.ToLower(k))
+		vals[strings.ToLower(k)] = vv
+	}
+	headers = append(headers, "host")
+	vals["host"] = []string{req.URL.Host}
+	sort.Strings(headers)
+
+	var canonicalHeaders bytes.Buffer
+	for _, k := range headers {
+		canonicalHeaders.WriteString(k + ":" + strings.Join(vals[k], ",") + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	payload := req.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		payload = "UNSIGNED-PAYLOAD"
+	}
+	req.URL.RawQuery = strings.Replace(req.URL.Query().Encode(), "+", "%20", -1)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4EncodePath(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+
+	scope, signature := sigV4Sign(canonicalRequest, secretAccessKey, region, t)
+	return sigV4Algorithm + " Credential=" + accessKeyID + "/" + scope + ", SignedHeaders=" + signedHeaders + ", Signature=" + signature
+}
+
+// presignV4 signs a pre-signed URL of minio-go again using creds, reusing
+// the date, region and expiry of the original one.
+func presignV4(method string, u *url.URL, creds awsCredentials) (*url.URL, error) {
+	q := u.Query()
+	scope := strings.Split(q.Get("X-Amz-Credential"), "/")
+	if len(scope) != 5 {
+		return nil, fmt.Errorf("unable to sign URL: not a signature v4 URL")
+	}
+	date, err := time.Parse(sigV4DateFormat, q.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign URL: %v", err)
+	}
+
+	q.Set("X-Amz-Credential", creds.AccessKeyID+"/"+strings.Join(scope[1:], "/"))
+	if creds.SessionToken != "" {
+		q.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	q.Del("X-Amz-Signature")
+	query := strings.Replace(q.Encode(), "+", "%20", -1)
+	canonicalRequest := strings.Join([]string{
+		method,
+		sigV4EncodePath(u.Path),
+		query,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	_, signature := sigV4Sign(canonicalRequest, creds.SecretAccessKey, scope[2], date)
+	signed := *u
+	signed.RawQuery = query + "&X-Amz-Signature=" + signature
+	return &signed, nil
+}
+
+// sigV4Sign returns the credential scope and the signature of a canonical
+// request.
+func sigV4Sign(canonicalRequest, secretAccessKey, region string, t time.Time) (string, string) {
+	scope := strings.Join([]string{t.Format("20060102"), region, "s3", "aws4_request"}, "/")
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := sigV4Algorithm + "\n" + t.Format(sigV4DateFormat) + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), []byte(t.Format("20060102")))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte("s3"))
+	key = hmacSHA256(key, []byte("aws4_request"))
+	return scope, hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+}
+
+func sigV4EncodePath(p string) string {
+	var buf bytes.Buffer
+	for _, b := range []byte(p) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-_.~/", b) >= 0 {
+			buf.WriteByte(b)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,318 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/s3test"
+	"github.com/minio/minio-go"
+	"github.com/spf13/viper"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// signatureKeys are object keys which need escaping in URLs and canonical
+// requests.
+var signatureKeys = []string{
+	"plain/file.tar.gz",
+	"dir/file with spaces.txt",
+	"a+b=c&d.txt",
+	"tilde~file",
+	"paren(1)[2]!*'.txt",
+	"semi;colon,comma:@$.txt",
+	"percent%41/hash#frag?query",
+	"ümlaut/日本語/ファイル.txt",
+}
+
+// TestS3Signature compares the signatures of requests made by minio-go with
+// those calculated again by the transport of S3 stores.
+func TestS3Signature(t *testing.T) {
+	srv := s3test.New()
+	srv.MakeBucket("bucket")
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	const accessKeyID, secretAccessKey = "arti", "secret/key+with=special"
+	// requests are passed through one at a time to know the original
+	// signature of the request being signed again
+	var mu sync.Mutex
+	var original string
+	compared := 0
+	resign := store.NewS3Transport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		compared++
+		if auth := req.Header.Get("Authorization"); auth != original {
+			t.Errorf("%s %s: signatures differ\nminio-go: %s\n    arti: %s", req.Method, req.URL, original, auth)
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	}), nil, accessKeyID, secretAccessKey)
+
+	client, err := minio.NewV4(strings.TrimPrefix(ts.URL, "http://"), accessKeyID, secretAccessKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetCustomTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		original = req.Header.Get("Authorization")
+		return resign.RoundTrip(req)
+	}))
+
+	for _, key := range signatureKeys {
+		if _, err := client.PutObject("bucket", key, strings.NewReader("data of "+key), "text/plain"); err != nil {
+			t.Errorf("PutObject %s: %v", key, err)
+			continue
+		}
+		if _, err := client.StatObject("bucket", key); err != nil {
+			t.Errorf("StatObject %s: %v", key, err)
+		}
+		obj, err := client.GetObject("bucket", key)
+		if err != nil {
+			t.Errorf("GetObject %s: %v", key, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(obj)
+		obj.Close()
+		if err != nil || string(data) != "data of "+key {
+			t.Errorf("GetObject %s: got %q, %v", key, data, err)
+		}
+		if err := client.CopyObject("bucket", key+".copy", "bucket/"+key, minio.NewCopyConditions()); err != nil {
+			t.Errorf("CopyObject %s: %v", key, err)
+		}
+
+		doneCh := make(chan struct{})
+		found := false
+		for o := range client.ListObjectsV2("bucket", key, false, doneCh) {
+			if o.Err != nil {
+				t.Errorf("ListObjectsV2 %s: %v", key, o.Err)
+			}
+			found = found || o.Key == key
+		}
+		close(doneCh)
+		if !found {
+			t.Errorf("ListObjectsV2 %s: object not found", key)
+		}
+		if err := client.RemoveObject("bucket", key+".copy"); err != nil {
+			t.Errorf("RemoveObject %s: %v", key, err)
+		}
+	}
+	if compared < 6*len(signatureKeys) {
+		t.Errorf("only %d requests have been compared", compared)
+	}
+
+	for _, key := range signatureKeys {
+		for _, method := range []string{"GET", "PUT"} {
+			var u *url.URL
+			if method == "GET" {
+				u, err = client.PresignedGetObject("bucket", key, time.Hour, url.Values{"response-content-type": {"text/plain; charset=utf-8"}})
+			} else {
+				u, err = client.PresignedPutObject("bucket", key, time.Hour)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			signed, err := store.PresignV4(method, u, accessKeyID, secretAccessKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if orig, sig := u.Query().Get("X-Amz-Signature"), signed.Query().Get("X-Amz-Signature"); orig != sig {
+				t.Errorf("presigned %s %s: signatures differ, minio-go: %s, arti: %s", method, key, orig, sig)
+			}
+		}
+	}
+}
+
+type recordedRequest struct {
+	method string
+	path   string
+	query  string
+	header http.Header
+}
+
+// recordRequests returns a handler which records all requests before
+// passing them to h.
+func recordRequests(h http.Handler, requests *[]recordedRequest, mu *sync.Mutex) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*requests = append(*requests, recordedRequest{r.Method, r.URL.Path, r.URL.RawQuery, r.Header})
+		mu.Unlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// TestS3SSEHeaders checks that the headers of server-side encryption are
+// sent with the requests creating, reading and copying objects only.
+func TestS3SSEHeaders(t *testing.T) {
+	defer isolateAWS(t)()
+	key, err := store.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyMD5 := md5.Sum(key)
+	customer := map[string]string{
+		"X-Amz-Server-Side-Encryption-Customer-Algorithm": "AES256",
+		"X-Amz-Server-Side-Encryption-Customer-Key":       base64.StdEncoding.EncodeToString(key),
+		"X-Amz-Server-Side-Encryption-Customer-Key-Md5":   base64.StdEncoding.EncodeToString(keyMD5[:]),
+	}
+	copySource := map[string]string{
+		"X-Amz-Copy-Source-Server-Side-Encryption-Customer-Algorithm": "AES256",
+		"X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key":       base64.StdEncoding.EncodeToString(key),
+		"X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key-Md5":   base64.StdEncoding.EncodeToString(keyMD5[:]),
+	}
+	none := map[string]string{
+		"X-Amz-Server-Side-Encryption":                    "",
+		"X-Amz-Server-Side-Encryption-Customer-Algorithm": "",
+	}
+	merge := func(maps ...map[string]string) map[string]string {
+		m := make(map[string]string)
+		for _, mm := range maps {
+			for k, v := range mm {
+				m[k] = v
+			}
+		}
+		return m
+	}
+
+	tests := []struct {
+		sse                      string
+		create, read, copy, part map[string]string
+	}{
+		{
+			sse:    "SSE-S3",
+			create: map[string]string{"X-Amz-Server-Side-Encryption": "AES256"},
+			read:   none,
+			copy:   map[string]string{"X-Amz-Server-Side-Encryption": "AES256", "X-Amz-Copy-Source-Server-Side-Encryption-Customer-Algorithm": ""},
+			part:   none,
+		},
+		{
+			sse:    "SSE-KMS",
+			create: map[string]string{"X-Amz-Server-Side-Encryption": "aws:kms", "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "arti-key"},
+			read:   none,
+			copy:   map[string]string{"X-Amz-Server-Side-Encryption": "aws:kms", "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "arti-key"},
+			part:   none,
+		},
+		{
+			sse:    "SSE-C",
+			create: merge(customer, map[string]string{"X-Amz-Server-Side-Encryption": ""}),
+			read:   customer,
+			copy:   merge(customer, copySource),
+			part:   customer,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sse, func(t *testing.T) {
+			srv := s3test.New()
+			srv.MakeBucket("bucket")
+			var mu sync.Mutex
+			var requests []recordedRequest
+			ts := httptest.NewTLSServer(recordRequests(srv, &requests, &mu))
+			defer ts.Close()
+
+			cfg := viper.New()
+			cfg.Set("endpoint", strings.TrimPrefix(ts.URL, "https://"))
+			cfg.Set("access-key-id", "arti")
+			cfg.Set("secret-access-key", "secret")
+			cfg.Set("insecure-skip-verify", true)
+			cfg.Set("sse", test.sse)
+			cfg.Set("sse-kms-key-id", "arti-key")
+			cfg.Set("sse-c-key", store.EncodeKey(key))
+			s, err := store.NewS3Store(cfg, "bucket")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = putTestArtifact(t, s, "1.0.0"); err != nil {
+				t.Fatal(err)
+			}
+			dir, err := ioutil.TempDir("", "arti-sse-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			a, _ := store.MakeArtifact("app", "1.0.0")
+			if err = s.Get(a, filepath.Join(dir, "app.tar.gz"), false); err != nil {
+				t.Fatal(err)
+			}
+
+			base := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+			client, err := minio.NewV4(strings.TrimPrefix(ts.URL, "https://"), "arti", "secret", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := store.NewS3Transport(base, s.(*store.S3Store), "arti", "secret")
+			client.SetCustomTransport(tr)
+			if err = client.CopyObject("bucket", "copy", "bucket/app/1.0.0/app.tar.gz", minio.NewCopyConditions()); err != nil {
+				t.Fatal(err)
+			}
+			// the parts of multipart uploads need the key of SSE-C only
+			req, err := http.NewRequest("PUT", ts.URL+"/bucket/part?partNumber=1&uploadId=1", strings.NewReader("part"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp, err := tr.RoundTrip(req); err == nil {
+				resp.Body.Close()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			checked := make(map[string]bool)
+			for _, r := range requests {
+				var expected map[string]string
+				switch {
+				case r.method == "PUT" && r.path == "/bucket/app/1.0.0/app.tar.gz":
+					expected = test.create
+				case r.method == "GET" && r.path == "/bucket/app/1.0.0/app.tar.gz":
+					expected = test.read
+				case r.method == "PUT" && r.path == "/bucket/copy":
+					expected = test.copy
+				case r.method == "PUT" && r.path == "/bucket/part":
+					expected = test.part
+				case r.path == "/bucket/" || r.path == "/bucket":
+					expected = none
+				default:
+					continue
+				}
+				checked[r.method+" "+r.path] = true
+				for k, v := range expected {
+					if got := r.header.Get(k); got != v {
+						t.Errorf("%s %s?%s: expected %s: %q, got %q", r.method, r.path, r.query, k, v, got)
+					}
+				}
+			}
+			for _, r := range []string{"PUT /bucket/app/1.0.0/app.tar.gz", "GET /bucket/app/1.0.0/app.tar.gz", "PUT /bucket/copy", "PUT /bucket/part"} {
+				if !checked[r] {
+					t.Errorf("no request %s has been made", r)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// resolveSecret returns the value referenced by "env:<variable>",
+// "file:<path>" or "exec:<command> [<args>...]". Other values are returned
+// unchanged.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		return os.Getenv(value[4:]), nil
+	case strings.HasPrefix(value, "file:") && !strings.HasPrefix(value, "file://"):
+		data, err := ioutil.ReadFile(value[5:])
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	case strings.HasPrefix(value, "exec:"):
+		args := strings.Fields(value[5:])
+		if len(args) == 0 {
+			return "", fmt.Errorf("no command specified")
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		// allow tools like pass to ask for a passphrase
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", args[0], err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	return value, nil
+}
+
+// resolveSecrets replaces all settings of a store which reference a secret
+// by its value.
+func resolveSecrets(cfg *viper.Viper) error {
+	for _, key := range cfg.AllKeys() {
+		value, ok := cfg.Get(key).(string)
+		if !ok {
+			continue
+		}
+		secret, err := resolveSecret(value)
+		if err != nil {
+			return fmt.Errorf("Error reading secret %s: %v", key, err)
+		}
+		if secret != value {
+			cfg.Set(key, secret)
+		}
+	}
+	return nil
+}
+
+// credentialHelperResponse is the answer of a credential helper, which uses
+// the same protocol as the credential helpers of docker.
+type credentialHelperResponse struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// credentialHelperProgram returns the program implementing helper. Names
+// without a slash refer to arti-credential-<name> in the PATH.
+func credentialHelperProgram(helper string) string {
+	if strings.Contains(helper, "/") {
+		return helper
+	}
+	return "arti-credential-" + helper
+}
+
+// runCredentialHelper asks helper for the credentials of serverURL. Empty
+// credentials are returned if the helper doesn't know any.
+func runCredentialHelper(helper, serverURL string) (credentialHelperResponse, error) {
+	resp := credentialHelperResponse{}
+	cmd := exec.Command(credentialHelperProgram(helper), "get")
+	cmd.Stdin = strings.NewReader(serverURL + "\n")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if bytes.Contains(out, []byte("credentials not found")) {
+			return resp, nil
+		}
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return resp, fmt.Errorf("credential helper %s: %s", helper, msg)
+		}
+		return resp, fmt.Errorf("credential helper %s: %v", helper, err)
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return resp, fmt.Errorf("credential helper %s: invalid response: %v", helper, err)
+	}
+	return resp, nil
+}
+
+// applyCredentialHelper fills in the user and password settings of a store
+// using its credential-helper unless they are set already. The helper is
+// asked for the credentials of the host of the store or of its name if the
+// store type has no host.
+func applyCredentialHelper(cfg *viper.Viper, name string) error {
+	helper := cfg.GetString("credential-helper")
+	if helper == "" {
+		return nil
+	}
+	typ := strings.ToLower(cfg.GetString("type"))
+	var scheme *urlScheme
+	for _, s := range urlSchemes {
+		if s.typ == typ && s.passwordKey != "" {
+			s := s
+			scheme = &s
+			break
+		}
+	}
+	if scheme == nil {
+		return fmt.Errorf("credential helpers are not supported by stores of type %s", typ)
+	}
+	if cfg.GetString(scheme.userKey) != "" && cfg.GetString(scheme.passwordKey) != "" {
+		return nil
+	}
+
+	serverURL := name
+	if scheme.host != nil {
+		if host := scheme.host(cfg); host != "" {
+			serverURL = host
+		}
+	}
+	resp, err := runCredentialHelper(helper, serverURL)
+	if err != nil {
+		return err
+	}
+	if resp.Username != "" && cfg.GetString(scheme.userKey) == "" {
+		cfg.Set(scheme.userKey, resp.Username)
+	}
+	if resp.Secret != "" && cfg.GetString(scheme.passwordKey) == "" {
+		cfg.Set(scheme.passwordKey, resp.Secret)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/ocitest"
+	"github.com/spf13/viper"
+)
+
+func readStores(t *testing.T, config string) *viper.Viper {
+	stores := viper.New()
+	stores.SetConfigType("yaml")
+	if err := stores.ReadConfig(bytes.NewBufferString(config)); err != nil {
+		t.Fatal(err)
+	}
+	return stores
+}
+
+func TestSecretReferences(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "arti-secrets-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := ioutil.WriteFile(filepath.Join(tmp, "root"), []byte(tmp+"/from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("ARTI_TEST_ROOT", tmp+"/from-env")
+	defer os.Unsetenv("ARTI_TEST_ROOT")
+
+	stores := readStores(t, fmt.Sprintf(`
+env:
+  type: fs
+  root: "env:ARTI_TEST_ROOT"
+file:
+  type: fs
+  root: "file:%s/root"
+exec:
+  type: fs
+  root: "exec:echo %s/from-exec"
+missing:
+  type: fs
+  root: "file:%s/missing"
+broken:
+  type: fs
+  root: "exec:/nonexistent/command"
+`, tmp, tmp, tmp))
+
+	for _, name := range []string{"env", "file", "exec"} {
+		s, err := store.Open(stores, name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if root := s.(store.Describer).Describe()["root"]; root != tmp+"/from-"+name {
+			t.Errorf("%s: root is %s", name, root)
+		}
+	}
+	for _, name := range []string{"missing", "broken"} {
+		if _, err := store.Open(stores, name); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCredentialHelper(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "arti-helper-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	reg := ocitest.New()
+	reg.Username, reg.Password = "user", "pass"
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	helper := fmt.Sprintf(`#!/bin/sh
+test "$1" = get || exit 2
+read server
+if [ "$server" != "%s" ]; then
+  echo "credentials not found in native keychain"
+  exit 1
+fi
+echo '{"ServerURL":"'$server'","Username":"user","Secret":"pass"}'
+`, host)
+	if err := ioutil.WriteFile(filepath.Join(tmp, "arti-credential-test"), []byte(helper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", tmp+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	stores := readStores(t, fmt.Sprintf(`
+registry:
+  type: oci
+  registry: %s
+  credential-helper: test
+unknown:
+  type: oci
+  registry: http://unknown.example.com
+  credential-helper: test
+fs:
+  type: fs
+  root: /tmp
+  credential-helper: test
+`, srv.URL))
+
+	a, _ := store.MakeArtifact("app", "1.0.0")
+	s, err := store.Open(stores, "registry/arti")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Has(a); err != nil {
+		t.Errorf("credentials of the helper were not used: %v", err)
+	}
+	if _, err := store.Open(stores, "unknown/arti"); err != nil {
+		t.Errorf("unknown credentials should be ignored: %v", err)
+	}
+	if _, err := store.Open(stores, "fs"); err == nil {
+		t.Error("expected an error for a store type without credentials")
+	}
+}
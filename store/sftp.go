@@ -0,0 +1,369 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SFTPStore keeps artifacts on a SSH server using the same layout as the
+// filesystem store.
+type SFTPStore struct {
+	*FSStore
+	host string
+	user string
+}
+
+func NewSFTPStore(cfg *viper.Viper, p string) (Store, error) {
+	host := cfg.GetString("host")
+	if host == "" {
+		return nil, fmt.Errorf("no host specified")
+	}
+	port := cfg.GetString("port")
+	if port == "" {
+		port = "22"
+	}
+	addr := net.JoinHostPort(host, port)
+
+	user := cfg.GetString("user")
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	auth, err := sshAuthMethods(cfg)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ClientConfig{User: user, Auth: auth}
+	if cfg.GetBool("insecure-ignore-host-key") {
+		config.HostKeyCallback = func(string, net.Addr, ssh.PublicKey) error { return nil }
+	} else {
+		knownHosts := cfg.GetString("known-hosts")
+		if knownHosts == "" {
+			knownHosts = filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+		}
+		if config.HostKeyCallback, err = knownHostsCallback(knownHosts); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to %s: %v", addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Error starting sftp session on %s: %v", addr, err)
+	}
+
+	root := path.Join("/", cfg.GetString("root"), p)
+	s := &SFTPStore{FSStore: NewFSStore(&sftpFs{client}, root), host: addr, user: user}
+	s.kind = "sftp"
+	return s, nil
+}
+
+func (s *SFTPStore) Describe() map[string]string {
+	info := s.FSStore.Describe()
+	info["host"] = s.host
+	info["user"] = s.user
+	return info
+}
+
+// sshAuthMethods returns the ssh-agent, the identity file and the password
+// as authentication methods, whichever are available.
+func sshAuthMethods(cfg *viper.Viper) ([]ssh.AuthMethod, error) {
+	methods := []ssh.AuthMethod{}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	identity := cfg.GetString("identity-file")
+	if identity == "" {
+		if fn := filepath.Join(os.Getenv("HOME"), ".ssh", "id_rsa"); fileExists(fn) {
+			identity = fn
+		}
+	}
+	if identity != "" {
+		data, err := ioutil.ReadFile(identity)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading identity file: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity file %s: %v", identity, err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	password, err := readSecret(cfg, "password")
+	if err != nil {
+		return nil, err
+	}
+	if password != "" {
+		methods = append(methods, ssh.Password(password))
+	}
+	return methods, nil
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// knownHostsCallback verifies host keys using an OpenSSH known_hosts file.
+// Wildcards and certificate authorities are not supported.
+func knownHostsCallback(filename string) (func(string, net.Addr, ssh.PublicKey) error, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading known hosts: %v", err)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		host, port, err := net.SplitHostPort(hostname)
+		if err != nil {
+			host, port = hostname, "22"
+		}
+		if port != "22" {
+			host = "[" + host + "]:" + port
+		}
+
+		known := false
+		for rest := data; len(rest) > 0; {
+			var marker string
+			var hosts []string
+			var pubKey ssh.PublicKey
+			marker, hosts, pubKey, _, rest, err = ssh.ParseKnownHosts(rest)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("invalid known hosts file %s: %v", filename, err)
+			}
+			if marker == "@cert-authority" || !knownHostMatches(hosts, host) {
+				continue
+			}
+			sameKey := bytes.Equal(pubKey.Marshal(), key.Marshal())
+			if marker == "@revoked" && sameKey {
+				return fmt.Errorf("host key of %s has been revoked", hostname)
+			}
+			known = known || sameKey
+		}
+		if !known {
+			return fmt.Errorf("host key of %s not found in %s", hostname, filename)
+		}
+		return nil
+	}, nil
+}
+
+// knownHostMatches checks whether host is one of the plain or hashed
+// entries of hosts.
+func knownHostMatches(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if h == host {
+			return true
+		}
+		if !strings.HasPrefix(h, "|1|") {
+			continue
+		}
+		parts := strings.Split(h[3:], "|")
+		if len(parts) != 2 {
+			continue
+		}
+		salt, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			continue
+		}
+		hash, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			continue
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(host))
+		if hmac.Equal(mac.Sum(nil), hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// sftpFs implements afero.Fs using a sftp connection. The sftp filesystem of
+// afero lacks support for reading directories.
+type sftpFs struct {
+	client *sftp.Client
+}
+
+func (fs *sftpFs) Name() string { return "sftp" }
+
+func (fs *sftpFs) Create(name string) (afero.File, error) {
+	f, err := fs.client.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{File: f, client: fs.client}, nil
+}
+
+func (fs *sftpFs) Mkdir(name string, perm os.FileMode) error {
+	return fs.client.Mkdir(name)
+}
+
+func (fs *sftpFs) MkdirAll(p string, perm os.FileMode) error {
+	if fi, err := fs.client.Stat(p); err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: p, Err: fmt.Errorf("not a directory")}
+		}
+		return nil
+	}
+	if parent := path.Dir(p); parent != p {
+		if err := fs.MkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	if err := fs.client.Mkdir(p); err != nil {
+		// somebody else might have created it in the meantime
+		if fi, serr := fs.client.Stat(p); serr != nil || !fi.IsDir() {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *sftpFs) Open(name string) (afero.File, error) {
+	f, err := fs.client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{File: f, client: fs.client}, nil
+}
+
+func (fs *sftpFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.client.OpenFile(name, flag)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{File: f, client: fs.client}, nil
+}
+
+func (fs *sftpFs) Remove(name string) error {
+	return fs.client.Remove(name)
+}
+
+func (fs *sftpFs) RemoveAll(p string) error {
+	fi, err := fs.client.Stat(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		entries, err := fs.client.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := fs.RemoveAll(path.Join(p, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return fs.client.Remove(p)
+}
+
+func (fs *sftpFs) Rename(oldname, newname string) error {
+	return fs.client.Rename(oldname, newname)
+}
+
+func (fs *sftpFs) Stat(name string) (os.FileInfo, error) {
+	return fs.client.Stat(name)
+}
+
+func (fs *sftpFs) Chmod(name string, mode os.FileMode) error {
+	return fs.client.Chmod(name, mode)
+}
+
+func (fs *sftpFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return fs.client.Chtimes(name, atime, mtime)
+}
+
+type sftpFile struct {
+	*sftp.File
+	client *sftp.Client
+}
+
+func (f *sftpFile) ReadAt(b []byte, off int64) (int, error) {
+	if _, err := f.Seek(off, os.SEEK_SET); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *sftpFile) WriteAt(b []byte, off int64) (int, error) {
+	if _, err := f.Seek(off, os.SEEK_SET); err != nil {
+		return 0, err
+	}
+	return f.Write(b)
+}
+
+func (f *sftpFile) Readdir(count int) ([]os.FileInfo, error) {
+	entries, err := f.client.ReadDir(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 && len(entries) > count {
+		entries = entries[:count]
+	}
+	return entries, nil
+}
+
+func (f *sftpFile) Readdirnames(n int) ([]string, error) {
+	entries, err := f.Readdir(n)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+	return names, nil
+}
+
+func (f *sftpFile) Sync() error { return nil }
+
+func (f *sftpFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
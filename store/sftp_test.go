@@ -0,0 +1,149 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mgit-at/arti/store"
+	"github.com/mgit-at/arti/store/storetest"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSFTPServer starts a SSH server which accepts user/pass and serves
+// the local filesystem using sftp. It returns the address and the host key.
+func startSFTPServer(t *testing.T) (string, ssh.PublicKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() != "user" || string(pass) != "pass" {
+				return nil, fmt.Errorf("access denied")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	return l.Addr().String(), signer.PublicKey()
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range reqs {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					if srv, err := sftp.NewServer(ch); err == nil {
+						srv.Serve()
+					}
+					ch.Close()
+				}
+			}
+		}()
+	}
+}
+
+func TestSFTPStore(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "arti-sftp-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// don't use the keys of the user running the tests
+	os.Setenv("HOME", tmp)
+	os.Unsetenv("SSH_AUTH_SOCK")
+
+	addr, hostKey := startSFTPServer(t)
+	_, port, _ := net.SplitHostPort(addr)
+	knownHosts := filepath.Join(tmp, "known_hosts")
+	line := fmt.Sprintf("[127.0.0.1]:%s %s", port, ssh.MarshalAuthorizedKey(hostKey))
+	if err := ioutil.WriteFile(knownHosts, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// the port doesn't match
+	otherHosts := filepath.Join(tmp, "other_hosts")
+	line = fmt.Sprintf("127.0.0.1 %s", ssh.MarshalAuthorizedKey(hostKey))
+	if err := ioutil.WriteFile(otherHosts, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	storetest.Run(t, func(t *testing.T) store.Store {
+		dir, err := ioutil.TempDir(tmp, "store-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := store.Open(nil, "sftp://user:pass@"+addr+dir+"?known-hosts="+knownHosts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+
+	for _, u := range []string{
+		"sftp://user:wrong@" + addr + tmp + "?known-hosts=" + knownHosts,
+		"sftp://user:pass@" + addr + tmp + "?known-hosts=" + otherHosts,
+	} {
+		if _, err := store.Open(nil, u); err == nil {
+			t.Errorf("%s: expected an error", u)
+		}
+	}
+	if _, err := store.Open(nil, "sftp://user:pass@"+addr+tmp+"?insecure-ignore-host-key=true"); err != nil {
+		t.Error(err)
+	}
+}
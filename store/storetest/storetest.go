@@ -0,0 +1,508 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storetest provides a conformance test suite for implementations
+// of store.Store.
+package storetest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/mgit-at/arti/store"
+)
+
+// Factory returns a new, empty store. It is called once for every test.
+type Factory func(t *testing.T) store.Store
+
+// Run tests the behaviour every store must show. Stores which implement
+// store.RawStore or store.ObjectStore get tested for these as well. If a
+// store.ObjectStore keeps checksums in <name>/<version>/<file>.checksum,
+// like the S3 store does, these get tampered with to test that Get detects
+// missing or corrupt checksums.
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s store.Store)
+	}{
+		{"Empty", testEmpty},
+		{"PutGet", testPutGet},
+		{"List", testList},
+		{"ListRange", testListRange},
+		{"DuplicatePut", testDuplicatePut},
+		{"VersionPrefix", testVersionPrefix},
+		{"GetMissing", testGetMissing},
+		{"MissingChecksum", testMissingChecksum},
+		{"CorruptChecksum", testCorruptChecksum},
+		{"UnknownAlgorithm", testUnknownAlgorithm},
+		{"Del", testDel},
+		{"DelMissing", testDelMissing},
+		{"Raw", testRaw},
+		{"Objects", testObjects},
+	}
+	for _, test := range tests {
+		fn := test.fn
+		t.Run(test.name, func(t *testing.T) {
+			fn(t, newStore(t))
+		})
+	}
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "arti-storetest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func artifact(t *testing.T, name, version string) store.Artifact {
+	a, err := store.MakeArtifact(name, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func put(t *testing.T, s store.Store, dir, name, version, filename, content string) store.Artifact {
+	a := artifact(t, name, version)
+	if err := s.Put(a, writeFile(t, dir, filename, content)); err != nil {
+		t.Fatalf("Put(%s %s) failed: %v", name, version, err)
+	}
+	return a
+}
+
+func checkHas(t *testing.T, s store.Store, a store.Artifact, wantExists bool, wantFilename string) {
+	exists, filename, err := s.Has(a)
+	if err != nil {
+		t.Fatalf("Has(%s %s) failed: %v", a.Name, a.Version, err)
+	}
+	if exists != wantExists || (wantExists && filename != wantFilename) {
+		t.Fatalf("Has(%s %s) = %t, %q, want %t, %q", a.Name, a.Version, exists, filename, wantExists, wantFilename)
+	}
+}
+
+func checkGet(t *testing.T, s store.Store, a store.Artifact, dir, want string) {
+	target := filepath.Join(dir, "download")
+	defer os.Remove(target)
+	if err := s.Get(a, target, false); err != nil {
+		t.Fatalf("Get(%s %s) failed: %v", a.Name, a.Version, err)
+	}
+	data, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Fatalf("Get(%s %s) = %q, want %q", a.Name, a.Version, data, want)
+	}
+}
+
+func testEmpty(t *testing.T, s store.Store) {
+	list, err := s.List("", nil)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("List of an empty store = %v", list)
+	}
+	checkHas(t, s, artifact(t, "app", "1.0.0"), false, "")
+}
+
+func testPutGet(t *testing.T, s store.Store) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	a := put(t, s, dir, "app", "1.0.0", "app-1.0.0.tar.gz", "hello arti")
+	checkHas(t, s, a, true, "app-1.0.0.tar.gz")
+	checkGet(t, s, a, dir, "hello arti")
+
+	b := put(t, s, dir, "group/tool", "2.1.0+build.7", "tool", "#!/bin/sh\n")
+	checkHas(t, s, b, true, "tool")
+	checkGet(t, s, b, dir, "#!/bin/sh\n")
+}
+
+func testList(t *testing.T, s store.Store) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	put(t, s, dir, "app", "1.0.0", "app.tar.gz", "one")
+	put(t, s, dir, "app", "1.1.0", "app.tar.gz", "one.one")
+	put(t, s, dir, "lib", "0.1.0", "lib.zip", "lib")
+
+	list, err := s.List("", nil)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 2 || len(list["app"]) != 2 || len(list["lib"]) != 1 {
+		t.Fatalf("List = %v", list)
+	}
+	for _, v := range list["app"] {
+		if v.Filename != "app.tar.gz" {
+			t.Fatalf("List returned filename %q", v.Filename)
+		}
+		// wrappers report the size of the compressed or encrypted file
+		if want := int64(len("one")); v.Version.String() == "1.0.0" && v.Filesize != want && store.Backend(s) == s {
+			t.Fatalf("List returned size %d, want %d", v.Filesize, want)
+		}
+	}
+
+	list, err = s.List("lib", nil)
+	if err != nil {
+		t.Fatalf("List(lib) failed: %v", err)
+	}
+	if len(list) != 1 || len(list["lib"]) != 1 || list["lib"][0].Version.String() != "0.1.0" {
+		t.Fatalf("List(lib) = %v", list)
+	}
+
+	list, err = s.List("missing", nil)
+	if err != nil {
+		t.Fatalf("List(missing) failed: %v", err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("List(missing) = %v", list)
+	}
+}
+
+func versionStrings(vs store.ArtifactVersions) string {
+	list := []string{}
+	for _, v := range vs {
+		list = append(list, v.Version.String())
+	}
+	return strings.Join(list, " ")
+}
+
+func testListRange(t *testing.T, s store.Store) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	for _, v := range []string{"0.9.0", "1.0.0", "1.2.3", "1.10.0", "2.0.0-rc.1", "2.0.0"} {
+		put(t, s, dir, "app", v, "app.tar.gz", v)
+	}
+	put(t, s, dir, "other", "1.5.0", "other.tar.gz", "other")
+
+	tests := []struct {
+		r    string
+		want string
+	}{
+		// pre-releases of 2.0.0 are lower than 2.0.0
+		{">=1.0.0 <2.0.0", "1.0.0 1.2.3 1.10.0 2.0.0-rc.1"},
+		{">=1.0.0 <1.10.0", "1.0.0 1.2.3"},
+		{"<1.0.0 || >=2.0.0", "0.9.0 2.0.0"},
+		{"1.2.3", "1.2.3"},
+		{">3.0.0", ""},
+	}
+	for _, test := range tests {
+		list, err := s.List("app", semver.MustParseRange(test.r))
+		if err != nil {
+			t.Fatalf("List(app, %s) failed: %v", test.r, err)
+		}
+		if _, found := list["other"]; found {
+			t.Fatalf("List(app, %s) returned other artifacts: %v", test.r, list)
+		}
+		versions := list["app"]
+		sort.Sort(versions)
+		if got := versionStrings(versions); got != test.want {
+			t.Fatalf("List(app, %s) = %q, want %q", test.r, got, test.want)
+		}
+	}
+
+	list, err := s.List("", semver.MustParseRange(">=1.5.0 <2.0.0"))
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	sort.Sort(list["app"])
+	if versionStrings(list["app"]) != "1.10.0 2.0.0-rc.1" || versionStrings(list["other"]) != "1.5.0" {
+		t.Fatalf("List(>=1.5.0 <2.0.0) = %v", list)
+	}
+}
+
+func testDuplicatePut(t *testing.T, s store.Store) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	a := put(t, s, dir, "app", "1.0.0", "app.tar.gz", "original")
+	if err := s.Put(a, writeFile(t, dir, "other.tar.gz", "replacement")); err == nil {
+		t.Fatal("Put of an existing artifact succeeded")
+	}
+	checkHas(t, s, a, true, "app.tar.gz")
+	checkGet(t, s, a, dir, "original")
+}
+
+func testVersionPrefix(t *testing.T, s store.Store) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	a := put(t, s, dir, "app", "1.0.1", "app-1.0.1.tar.gz", "one")
+	b := put(t, s, dir, "app", "1.0.10", "app-1.0.10.tar.gz", "ten")
+	checkHas(t, s, a, true, "app-1.0.1.tar.gz")
+	checkHas(t, s, b, true, "app-1.0.10.tar.gz")
+	checkGet(t, s, a, dir, "one")
+	checkGet(t, s, b, dir, "ten")
+
+	if err := s.Del(a); err != nil {
+		t.Fatalf("Del failed: %v", err)
+	}
+	checkHas(t, s, a, false, "")
+	checkHas(t, s, b, true, "app-1.0.10.tar.gz")
+	checkGet(t, s, b, dir, "ten")
+
+	// artifact names may be prefixes of each other as well
+	c := put(t, s, dir, "app2", "1.0.10", "app2.tar.gz", "app2")
+	if err := s.Del(b); err != nil {
+		t.Fatalf("Del failed: %v", err)
+	}
+	checkHas(t, s, c, true, "app2.tar.gz")
+	list, err := s.List("app", nil)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list["app"]) != 0 || len(list["app2"]) != 0 {
+		t.Fatalf("List(app) = %v", list)
+	}
+}
+
+// checksumStore returns the store as ObjectStore together with the key of
+// the checksum of a. The test is skipped if the store doesn't keep
+// checksums this way.
+func checksumStore(t *testing.T, s store.Store, a store.Artifact, filename string) (store.ObjectStore, string) {
+	objects, ok := s.(store.ObjectStore)
+	if !ok {
+		t.Skip("store does not implement ObjectStore")
+	}
+	key := path.Join(a.Name, a.Version.String(), filename) + store.CSumExt
+	r, err := objects.GetObject(key)
+	if err == store.ErrObjectNotFound {
+		t.Skip("store does not keep checksums in " + key)
+	} else if err != nil {
+		t.Fatalf("GetObject(%s) failed: %v", key, err)
+	}
+	r.Close()
+	return objects, key
+}
+
+// checkGetFails makes sure Get fails and, unless keepCorrupted is set,
+// leaves no file behind.
+func checkGetFails(t *testing.T, s store.Store, a store.Artifact, dir string, keepCorrupted bool) error {
+	target := filepath.Join(dir, "download")
+	defer os.Remove(target)
+	err := s.Get(a, target, keepCorrupted)
+	if err == nil {
+		t.Fatalf("Get(%s %s) succeeded", a.Name, a.Version)
+	}
+	if err == store.ErrArtifactNotFound {
+		t.Fatalf("Get(%s %s) returned %v", a.Name, a.Version, err)
+	}
+	if _, serr := os.Stat(target); !keepCorrupted && serr == nil {
+		t.Fatalf("Get(%s %s) kept the file after failing with: %v", a.Name, a.Version, err)
+	}
+	return err
+}
+
+func testMissingChecksum(t *testing.T, s store.Store) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	a := put(t, s, dir, "app", "1.0.0", "app.tar.gz", "content")
+	objects, key := checksumStore(t, s, a, "app.tar.gz")
+	if err := objects.DelObject(key); err != nil {
+		t.Fatalf("DelObject failed: %v", err)
+	}
+	checkGetFails(t, s, a, dir, false)
+}
+
+func testCorruptChecksum(t *testing.T, s store.Store) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	a := put(t, s, dir, "app", "1.0.0", "app.tar.gz", "content")
+	objects, key := checksumStore(t, s, a, "app.tar.gz")
+	corrupt := store.CSumAlgoSHA256 + store.CSumAlgoSeperator + strings.Repeat("0", 64)
+	if err := objects.PutObject(key, bytes.NewBufferString(corrupt)); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	checkGetFails(t, s, a, dir, false)
+
+	target := filepath.Join(dir, "download")
+	if err := s.Get(a, target, true); err == nil {
+		t.Fatal("Get with keepCorrupted succeeded")
+	}
+	if data, err := ioutil.ReadFile(target); err != nil || string(data) != "content" {
+		t.Fatalf("Get with keepCorrupted left %q, %v", data, err)
+	}
+}
+
+func testUnknownAlgorithm(t *testing.T, s store.Store) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	a := put(t, s, dir, "app", "1.0.0", "app.tar.gz", "content")
+	objects, key := checksumStore(t, s, a, "app.tar.gz")
+	if err := objects.PutObject(key, bytes.NewBufferString("md5:9a0364b9e99bb480dd25e1f0284c8555")); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if err := checkGetFails(t, s, a, dir, true); !strings.Contains(err.Error(), "md5") {
+		t.Fatalf("Get returned %q which doesn't name the algorithm", err)
+	}
+}
+
+func testGetMissing(t *testing.T, s store.Store) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	if err := s.Get(artifact(t, "app", "1.0.0"), filepath.Join(dir, "download"), false); err != store.ErrArtifactNotFound {
+		t.Fatalf("Get of a missing artifact returned %v, want %v", err, store.ErrArtifactNotFound)
+	}
+}
+
+func testDel(t *testing.T, s store.Store) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	a := put(t, s, dir, "app", "1.0.0", "app.tar.gz", "one")
+	b := put(t, s, dir, "app", "1.1.0", "app.tar.gz", "two")
+	if err := s.Del(a); err != nil {
+		t.Fatalf("Del failed: %v", err)
+	}
+	checkHas(t, s, a, false, "")
+	checkHas(t, s, b, true, "app.tar.gz")
+
+	list, err := s.List("app", nil)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list["app"]) != 1 || list["app"][0].Version.String() != "1.1.0" {
+		t.Fatalf("List after Del = %v", list)
+	}
+
+	// the version may be uploaded again
+	put(t, s, dir, "app", "1.0.0", "app.tar.gz", "three")
+	checkGet(t, s, a, dir, "three")
+}
+
+func testDelMissing(t *testing.T, s store.Store) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	a := put(t, s, dir, "app", "1.0.0", "app.tar.gz", "content")
+	if err := s.Del(artifact(t, "app", "1.0.1")); err != nil {
+		t.Fatalf("Del of a missing version failed: %v", err)
+	}
+	if err := s.Del(artifact(t, "missing", "1.0.0")); err != nil {
+		t.Fatalf("Del of a missing artifact failed: %v", err)
+	}
+	checkHas(t, s, a, true, "app.tar.gz")
+}
+
+func testRaw(t *testing.T, s store.Store) {
+	rs, ok := s.(store.RawStore)
+	if !ok {
+		t.Skip("store does not implement RawStore")
+	}
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	a := artifact(t, "app", "1.0.0")
+	info := store.RawInfo{CSum: "sha256:0123456789abcdef", Encoding: store.EncodingGzip}
+	if err := rs.PutRaw(a, writeFile(t, dir, "app.tar", "raw content"), info); err != nil {
+		t.Fatalf("PutRaw failed: %v", err)
+	}
+
+	stat, err := rs.Stat(a)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	info.Filename = "app.tar"
+	if stat != info {
+		t.Fatalf("Stat = %+v, want %+v", stat, info)
+	}
+
+	target := filepath.Join(dir, "download")
+	got, err := rs.GetRaw(a, target)
+	if err != nil {
+		t.Fatalf("GetRaw failed: %v", err)
+	}
+	if got != info {
+		t.Fatalf("GetRaw = %+v, want %+v", got, info)
+	}
+	if data, _ := ioutil.ReadFile(target); string(data) != "raw content" {
+		t.Fatalf("GetRaw wrote %q", data)
+	}
+
+	if _, err := rs.Stat(artifact(t, "app", "2.0.0")); err != store.ErrArtifactNotFound {
+		t.Fatalf("Stat of a missing artifact returned %v, want %v", err, store.ErrArtifactNotFound)
+	}
+}
+
+func testObjects(t *testing.T, s store.Store) {
+	objects, ok := s.(store.ObjectStore)
+	if !ok {
+		t.Skip("store does not implement ObjectStore")
+	}
+
+	if _, err := objects.GetObject("dists/stable/Release"); err != store.ErrObjectNotFound {
+		t.Fatalf("GetObject of a missing object returned %v, want %v", err, store.ErrObjectNotFound)
+	}
+	for _, key := range []string{"dists/stable/Release", "dists/stable/main/Packages", "index.yaml"} {
+		if err := objects.PutObject(key, bytes.NewBufferString(key)); err != nil {
+			t.Fatalf("PutObject(%s) failed: %v", key, err)
+		}
+	}
+
+	r, err := objects.GetObject("dists/stable/Release")
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "dists/stable/Release" {
+		t.Fatalf("GetObject = %q, %v", data, err)
+	}
+
+	list, err := objects.ListObjects("dists/")
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	keys := make(map[string]int64)
+	for _, o := range list {
+		keys[o.Key] = o.Size
+	}
+	if len(keys) != 2 || keys["dists/stable/Release"] != int64(len("dists/stable/Release")) {
+		t.Fatalf("ListObjects(dists/) = %v", list)
+	}
+
+	if err := objects.DelObject("dists/stable/Release"); err != nil {
+		t.Fatalf("DelObject failed: %v", err)
+	}
+	if err := objects.DelObject("dists/stable/Release"); err != nil {
+		t.Fatalf("DelObject of a missing object failed: %v", err)
+	}
+	if _, err := objects.GetObject("dists/stable/Release"); err != store.ErrObjectNotFound {
+		t.Fatalf("GetObject after DelObject returned %v, want %v", err, store.ErrObjectNotFound)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// required lists the settings each store type can't do without.
+var required = map[string][]string{
+	"s3":   {"endpoint"},
+	"oci":  {"registry"},
+	"http": {"url"},
+	"fs":   {"root"},
+	"sftp": {"host"},
+	"exec": {"command"},
+}
+
+// Validate checks the settings of the store name in stores without
+// connecting to it or resolving secrets. If bucket is not empty it is
+// checked as well. All problems found are returned.
+func Validate(stores *viper.Viper, name, bucket string) []error {
+	cfg := stores.Sub(name)
+	if cfg == nil {
+		return []error{fmt.Errorf("no such store: %s", name)}
+	}
+
+	var problems []error
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	t := strings.ToLower(cfg.GetString("type"))
+	known := false
+	for _, typ := range Types() {
+		known = known || typ == t
+	}
+	switch {
+	case t == "":
+		add("no type specified")
+	case !known:
+		add("unknown store type: %s (supported are %s)", t, strings.Join(Types(), ", "))
+	}
+	for _, key := range required[t] {
+		if cfg.GetString(key) == "" {
+			add("%s is missing", key)
+		}
+	}
+
+	switch t {
+	case "s3":
+		if v := cfg.Get("version"); v != nil {
+			if n, err := cast.ToIntE(v); err != nil || (n != 0 && n != 2 && n != 4) {
+				add("invalid S3 protocol version %v (only 2 and 4 are supported)", v)
+			}
+		}
+		switch strings.TrimPrefix(strings.ToLower(cfg.GetString("sse")), "sse-") {
+		case "", sseS3, sseKMS, sseC:
+		default:
+			add("unknown server-side encryption mode '%s' (supported are S3, KMS and C)", cfg.GetString("sse"))
+		}
+		if p := cfg.GetString("bucket-policy"); p != "" {
+			if err := checkBucketPolicy(strings.ToLower(p)); err != nil {
+				add("%v", err)
+			}
+		}
+		if (cfg.GetString("client-cert") == "") != (cfg.GetString("client-key") == "") {
+			add("client-cert and client-key must be set together")
+		}
+		if p := cfg.GetString("proxy"); p != "" {
+			if u, err := url.Parse(p); err != nil || u.Host == "" {
+				add("invalid proxy: %s", p)
+			}
+		}
+		if bucket != "" && !bucketNameRe.MatchString(bucket) {
+			add("'%s' is not a valid bucket name", bucket)
+		}
+	case "http":
+		if u, err := url.Parse(cfg.GetString("url")); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			add("invalid url: %s", cfg.GetString("url"))
+		}
+	case "replicated":
+		members := cfg.GetStringSlice("members")
+		if len(members) == 0 {
+			add("no members specified")
+		}
+		for _, m := range members {
+			if IsURL(m) {
+				continue
+			}
+			mn := strings.SplitN(m, "/", 2)[0]
+			if strings.ToLower(mn) == strings.ToLower(name) {
+				add("the store can't be a member of itself")
+			} else if stores.Sub(mn) == nil {
+				add("member %s: no such store", m)
+			}
+		}
+		if q := cfg.GetInt("quorum"); q < 0 || q > len(members) {
+			add("invalid quorum %d for %d members", q, len(members))
+		}
+	}
+
+	if c := cfg.GetString("compression"); c != "" {
+		if err := checkEncoding(strings.ToLower(c)); err != nil {
+			add("%v", err)
+		}
+	}
+	for _, key := range cfg.AllKeys() {
+		if v, ok := cfg.Get(key).(string); ok && strings.HasPrefix(v, "exec:") && strings.TrimSpace(v[5:]) == "" {
+			add("%s: no command specified", key)
+		}
+	}
+	return problems
+}
@@ -0,0 +1,85 @@
+// Copyright © 2016 mgIT GmbH <office@mgit.at>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mgit-at/arti/store"
+)
+
+func TestValidate(t *testing.T) {
+	stores := readStores(t, `
+minio:
+  type: S3
+  endpoint: 127.0.0.1:9000
+  version: 4
+notype:
+  endpoint: 127.0.0.1:9000
+unknown:
+  type: s4
+badversion:
+  type: s3
+  endpoint: 127.0.0.1:9000
+  version: 3
+noendpoint:
+  type: s3
+  sse: aes
+badtls:
+  type: s3
+  endpoint: 127.0.0.1:9000
+  client-cert: /etc/arti/client.pem
+  proxy: "http://"
+  bucket-policy: public
+rep:
+  type: replicated
+  members: [minio, missing/bucket, rep]
+  quorum: 4
+compressed:
+  type: fs
+  root: /srv
+  compression: lzma
+  password: "exec: "
+`)
+
+	tests := []struct {
+		name, bucket string
+		problems     []string
+	}{
+		{"minio", "artifacts", nil},
+		{"minio", "a", []string{"not a valid bucket name"}},
+		{"nosuchstore", "", []string{"no such store"}},
+		{"notype", "", []string{"no type specified"}},
+		{"unknown", "", []string{"unknown store type: s4"}},
+		{"badversion", "", []string{"invalid S3 protocol version 3"}},
+		{"noendpoint", "", []string{"endpoint is missing", "unknown server-side encryption mode"}},
+		{"badtls", "", []string{"unknown bucket policy 'public'", "client-cert and client-key must be set together", "invalid proxy"}},
+		{"rep", "", []string{"member missing/bucket: no such store", "member of itself", "invalid quorum 4"}},
+		{"compressed", "", []string{"unknown encoding 'lzma'", "password: no command specified"}},
+	}
+	for _, test := range tests {
+		problems := store.Validate(stores, test.name, test.bucket)
+		if len(problems) != len(test.problems) {
+			t.Errorf("%s/%s: got problems %v, want %v", test.name, test.bucket, problems, test.problems)
+			continue
+		}
+		for i, p := range problems {
+			if !strings.Contains(p.Error(), test.problems[i]) {
+				t.Errorf("%s/%s: got %q, want %q", test.name, test.bucket, p, test.problems[i])
+			}
+		}
+	}
+}